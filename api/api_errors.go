@@ -1,9 +1,11 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -26,6 +28,8 @@ const (
 	EF3NotReady
 	EExecutionReverted
 	ENullRound
+	EMethodNotSupported
+	EConnectionRateLimited
 )
 
 var (
@@ -60,6 +64,10 @@ var (
 	_ jsonrpc.RPCErrorCodec = (*ErrExecutionReverted)(nil)
 	_ error                 = (*ErrNullRound)(nil)
 	_ jsonrpc.RPCErrorCodec = (*ErrNullRound)(nil)
+	_ error                 = (*ErrMethodNotSupported)(nil)
+	_ jsonrpc.RPCErrorCodec = (*ErrMethodNotSupported)(nil)
+	_ error                 = (*ErrConnectionRateLimited)(nil)
+	_ jsonrpc.RPCErrorCodec = (*ErrConnectionRateLimited)(nil)
 )
 
 func init() {
@@ -74,6 +82,8 @@ func init() {
 	RPCErrors.Register(EF3NotReady, new(*errF3NotReady))
 	RPCErrors.Register(EExecutionReverted, new(*ErrExecutionReverted))
 	RPCErrors.Register(ENullRound, new(*ErrNullRound))
+	RPCErrors.Register(EMethodNotSupported, new(*ErrMethodNotSupported))
+	RPCErrors.Register(EConnectionRateLimited, new(*ErrConnectionRateLimited))
 }
 
 func ErrorIsIn(err error, errorTypes []error) bool {
@@ -130,6 +140,26 @@ func (errF3NotReady) Error() string { return "f3 isn't yet ready to participate"
 type ErrExecutionReverted struct {
 	Message string
 	Data    string
+	// Reason is the decoded revert reason, if it was decoded and it is one of the standard Solidity
+	// Error(string) or Panic(uint256) reverts. When set, the `data` field is an object holding both
+	// the raw revert data and the decoded reason rather than just the raw revert data.
+	Reason *EthRevertReason
+}
+
+// EthRevertReason is a decoded standard Solidity revert reason.
+type EthRevertReason struct {
+	// Kind is either "Error", for an Error(string) revert, or "Panic", for a Panic(uint256) revert.
+	Kind string `json:"kind"`
+	// Message is the message of an Error(string) revert.
+	Message string `json:"message,omitempty"`
+	// Code is the hex encoded code of a Panic(uint256) revert.
+	Code string `json:"code,omitempty"`
+}
+
+// executionRevertedData is the `data` field of an ErrExecutionReverted with a decoded reason.
+type executionRevertedData struct {
+	Data   string           `json:"data"`
+	Reason *EthRevertReason `json:"reason"`
 }
 
 // Error returns the error message.
@@ -141,22 +171,38 @@ func (e *ErrExecutionReverted) FromJSONRPCError(jerr jsonrpc.JSONRPCError) error
 		return invalidExecutionRevertedMsg
 	}
 
-	data, ok := jerr.Data.(string)
-	if !ok {
-		return xerrors.Errorf("expected string data in execution reverted error, got %T", jerr.Data)
+	switch data := jerr.Data.(type) {
+	case string:
+		e.Data = data
+	case map[string]interface{}:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return xerrors.Errorf("re-encoding execution reverted error data: %w", err)
+		}
+		var decoded executionRevertedData
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			return xerrors.Errorf("decoding execution reverted error data: %w", err)
+		}
+		e.Data = decoded.Data
+		e.Reason = decoded.Reason
+	default:
+		return xerrors.Errorf("expected string or object data in execution reverted error, got %T", jerr.Data)
 	}
 
 	e.Message = jerr.Message
-	e.Data = data
 	return nil
 }
 
 // ToJSONRPCError converts ErrExecutionReverted to a JSONRPCError.
 func (e *ErrExecutionReverted) ToJSONRPCError() (jsonrpc.JSONRPCError, error) {
+	var data interface{} = e.Data
+	if e.Reason != nil {
+		data = executionRevertedData{Data: e.Data, Reason: e.Reason}
+	}
 	return jsonrpc.JSONRPCError{
 		Code:    EExecutionReverted,
 		Message: e.Message,
-		Data:    e.Data,
+		Data:    data,
 	}, nil
 }
 
@@ -213,3 +259,89 @@ func (e *ErrNullRound) Is(target error) bool {
 	_, ok := target.(*ErrNullRound)
 	return ok
 }
+
+// ErrMethodNotSupported signals that a method exists in the API but is not implemented by the
+// backend serving the request, for example when a gateway is proxying to an older node, or that a
+// gateway has been configured to disable it. The backend version is included where it is known.
+type ErrMethodNotSupported struct {
+	Method         string `json:"method"`
+	BackendVersion string `json:"backendVersion,omitempty"`
+	// Disabled is set if the method is disabled by the gateway rather than unknown to the backend.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func (e *ErrMethodNotSupported) Error() string {
+	if e.Disabled {
+		return fmt.Sprintf("method %s is disabled on this gateway", e.Method)
+	}
+	if e.BackendVersion != "" {
+		return fmt.Sprintf("method %s not supported by backend (version %s)", e.Method, e.BackendVersion)
+	}
+	return fmt.Sprintf("method %s not supported by backend", e.Method)
+}
+
+func (e *ErrMethodNotSupported) FromJSONRPCError(jerr jsonrpc.JSONRPCError) error {
+	if jerr.Code != EMethodNotSupported {
+		return fmt.Errorf("unexpected error code: %d", jerr.Code)
+	}
+
+	data, ok := jerr.Data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected object data in method not supported error, got %T", jerr.Data)
+	}
+
+	e.Method, _ = data["method"].(string)
+	e.BackendVersion, _ = data["backendVersion"].(string)
+	e.Disabled, _ = data["disabled"].(bool)
+	return nil
+}
+
+func (e *ErrMethodNotSupported) ToJSONRPCError() (jsonrpc.JSONRPCError, error) {
+	return jsonrpc.JSONRPCError{
+		Code:    EMethodNotSupported,
+		Message: e.Error(),
+		Data:    e,
+	}, nil
+}
+
+// ErrConnectionRateLimited signals that a request was rejected by the rate limit applied to the
+// client's connection, for example by a gateway. RetryAfter is how long the client needs to wait
+// before the request would be allowed, so that it can back off for exactly that long rather than
+// retrying blindly.
+type ErrConnectionRateLimited struct {
+	RetryAfter time.Duration
+}
+
+// connectionRateLimitedData is the `data` field of an ErrConnectionRateLimited. The wait is given in
+// milliseconds, rounded up, so that clients in any language can use it as is.
+type connectionRateLimitedData struct {
+	RetryAfterMs int64 `json:"retryAfterMs"`
+}
+
+func (e *ErrConnectionRateLimited) Error() string {
+	return fmt.Sprintf("connection limited, retry in %s", e.RetryAfter)
+}
+
+func (e *ErrConnectionRateLimited) FromJSONRPCError(jerr jsonrpc.JSONRPCError) error {
+	if jerr.Code != EConnectionRateLimited {
+		return fmt.Errorf("unexpected error code: %d", jerr.Code)
+	}
+
+	data, ok := jerr.Data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected object data in connection rate limited error, got %T", jerr.Data)
+	}
+
+	ms, _ := data["retryAfterMs"].(float64)
+	e.RetryAfter = time.Duration(ms) * time.Millisecond
+	return nil
+}
+
+func (e *ErrConnectionRateLimited) ToJSONRPCError() (jsonrpc.JSONRPCError, error) {
+	ms := (e.RetryAfter + time.Millisecond - 1) / time.Millisecond
+	return jsonrpc.JSONRPCError{
+		Code:    EConnectionRateLimited,
+		Message: e.Error(),
+		Data:    connectionRateLimitedData{RetryAfterMs: int64(ms)},
+	}, nil
+}
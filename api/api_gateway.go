@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -41,6 +42,11 @@ type Gateway interface {
 	ChainPutObj(context.Context, blocks.Block) error
 	ChainHead(ctx context.Context) (*types.TipSet, error)
 	ChainGetEvents(context.Context, cid.Cid) ([]types.Event, error)
+	// ChainGetEventsStream streams the events under an events root, as returned by ChainGetEvents,
+	// in chunks of a size limited by the gateway, so that event-heavy tipsets don't need to be
+	// returned in a single response. The events are sent in order, and the channel is closed once
+	// all of them have been sent, or early if they can't be read.
+	ChainGetEventsStream(context.Context, cid.Cid) (<-chan []types.Event, error)
 	ChainGetParentMessages(context.Context, cid.Cid) ([]Message, error)
 	ChainGetParentReceipts(context.Context, cid.Cid) ([]*types.MessageReceipt, error)
 	ChainGetMessagesInTipset(context.Context, types.TipSetKey) ([]Message, error)
@@ -59,6 +65,11 @@ type Gateway interface {
 
 	MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error)
 	MpoolPending(context.Context, types.TipSetKey) ([]*types.SignedMessage, error)
+	// MpoolPendingByAddress returns the pending messages, as returned by MpoolPending, that are
+	// sent from or to any of the given addresses. Addresses are matched as given, so a message sent
+	// from a key address isn't matched by the sender's ID address. The number of addresses, and of
+	// messages returned, is limited by the gateway.
+	MpoolPendingByAddress(ctx context.Context, addrs []address.Address, tsk types.TipSetKey) ([]*types.SignedMessage, error)
 	MpoolPush(ctx context.Context, sm *types.SignedMessage) (cid.Cid, error)
 
 	MsigGetAvailableBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (types.BigInt, error)
@@ -79,7 +90,15 @@ type Gateway interface {
 	StateGetClaims(ctx context.Context, providerAddr address.Address, tsk types.TipSetKey) (map[verifregtypes.ClaimId]verifregtypes.Claim, error)
 	StateGetNetworkParams(ctx context.Context) (*NetworkParams, error)
 	StateReadState(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*ActorState, error)
+	// StateInspectActor returns both the actor and its decoded state, as returned by StateGetActor
+	// and StateReadState, in a single call. If the actor exists but its state can't be read or
+	// decoded, the actor is returned with StateError set.
+	StateInspectActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*ActorInspection, error)
 	StateListMiners(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error)
+	// StateListMinersWithPower returns the miners, as returned by StateListMiners, that have
+	// non-zero quality adjusted power at the given tipset. Each miner's power is checked with
+	// StateMinerPower, with the number of checks made concurrently limited by the gateway.
+	StateListMinersWithPower(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error)
 	StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
 	StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (MarketBalance, error)
 	StateMarketStorageDeal(ctx context.Context, dealId abi.DealID, tsk types.TipSetKey) (*MarketDeal, error)
@@ -93,6 +112,11 @@ type Gateway interface {
 	StateSectorGetInfo(ctx context.Context, maddr address.Address, n abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error)
 	StateVerifierStatus(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*abi.StoragePower, error)
 	StateVerifiedClientStatus(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*abi.StoragePower, error)
+	// SubscribeVerifiedClientStatus streams the datacap of a verified client, as returned by
+	// StateVerifiedClientStatus, as the chain head changes. The status at the current head is sent
+	// first, then a new status each time it changes. Subscriptions count towards the gateway's limit
+	// on filters and subscriptions per connection.
+	SubscribeVerifiedClientStatus(ctx context.Context, addr address.Address) (<-chan VerifiedClientStatusChange, error)
 	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*MsgLookup, error)
 	StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*MsgLookup, error)
 
@@ -108,6 +132,11 @@ type Gateway interface {
 	EthGetBlockTransactionCountByHash(ctx context.Context, blkHash ethtypes.EthHash) (ethtypes.EthUint64, error)
 	EthGetBlockByHash(ctx context.Context, blkHash ethtypes.EthHash, fullTxInfo bool) (ethtypes.EthBlock, error)
 	EthGetBlockByNumber(ctx context.Context, blkNum string, fullTxInfo bool) (ethtypes.EthBlock, error)
+	// EthGetBlockRange returns the blocks from fromBlock to toBlock inclusive, as returned by
+	// EthGetBlockByNumber, omitting null rounds. The number of blocks that may be requested at once
+	// is limited by the gateway, and the oldest is subject to the same lookback limits as
+	// EthGetBlockByNumber.
+	EthGetBlockRange(ctx context.Context, fromBlock, toBlock ethtypes.EthUint64, fullTxInfo bool) ([]ethtypes.EthBlock, error)
 	EthGetTransactionByHash(ctx context.Context, txHash *ethtypes.EthHash) (*ethtypes.EthTx, error)
 	EthGetTransactionHashByCid(ctx context.Context, cid cid.Cid) (*ethtypes.EthHash, error)
 	EthGetMessageCidByTransactionHash(ctx context.Context, txHash *ethtypes.EthHash) (*cid.Cid, error)
@@ -117,6 +146,11 @@ type Gateway interface {
 	EthGetCode(ctx context.Context, address ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error)
 	EthGetStorageAt(ctx context.Context, address ethtypes.EthAddress, position ethtypes.EthBytes, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error)
 	EthGetBalance(ctx context.Context, address ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBigInt, error)
+	// EthGetBalanceHistory streams the balance of an address at every step'th block from fromBlock to
+	// toBlock inclusive. Each sampled block is subject to the same lookback limits as EthGetBalance,
+	// and the number of samples is limited by the gateway. The channel is closed once all samples
+	// have been sent, or early after a sample with Error set if a balance can't be retrieved.
+	EthGetBalanceHistory(ctx context.Context, address ethtypes.EthAddress, fromBlock, toBlock, step ethtypes.EthUint64) (<-chan EthBalanceSample, error)
 	EthChainId(ctx context.Context) (ethtypes.EthUint64, error)
 	EthSyncing(ctx context.Context) (ethtypes.EthSyncingResult, error)
 	NetVersion(ctx context.Context) (string, error)
@@ -129,6 +163,13 @@ type Gateway interface {
 	EthCall(ctx context.Context, tx ethtypes.EthCall, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error)
 	EthSendRawTransaction(ctx context.Context, rawTx ethtypes.EthBytes) (ethtypes.EthHash, error)
 	EthGetLogs(ctx context.Context, filter *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error)
+	// EthGetLogsPaged returns the logs matching filter a page at a time, where each page covers a
+	// bounded range of blocks. The first page is fetched with a filter and an empty cursor, with the
+	// filter's block tags such as "latest" resolved against the current head; each page then holds
+	// the cursor for the next, until the last page, whose cursor is empty. Cursors include the
+	// filter, so later pages are fetched with a nil filter and the cursor alone, and are subject to
+	// the gateway's limits on EthGetLogs just the same.
+	EthGetLogsPaged(ctx context.Context, filter *ethtypes.EthFilterSpec, cursor string) (*EthLogsPage, error)
 	EthGetFilterChanges(ctx context.Context, id ethtypes.EthFilterID) (*ethtypes.EthFilterResult, error)
 	EthGetFilterLogs(ctx context.Context, id ethtypes.EthFilterID) (*ethtypes.EthFilterResult, error)
 	EthNewFilter(ctx context.Context, filter *ethtypes.EthFilterSpec) (ethtypes.EthFilterID, error)
@@ -153,4 +194,119 @@ type Gateway interface {
 
 	Version(context.Context) (APIVersion, error)
 	Discover(context.Context) (apitypes.OpenRPCDocument, error)
+	// GatewayDeepHealth checks that the gateway can serve reads by exercising a representative read
+	// path against its backend node: fetching the head, a recent tipset and an object from the
+	// blockstore, and reading the network version. It isn't rate limited, making it suitable as a
+	// readiness check. A failing check is reported in the returned GatewayHealth rather than as an
+	// error.
+	GatewayDeepHealth(context.Context) (*GatewayHealth, error)
+	// GatewayHealth is a cheap liveness check: it fetches the head from the backend node and reports
+	// the gateway healthy if the head is no older than the gateway's maximum lookback, along with the
+	// head's height and age and how long the backend node took to return it. It's rate limited as a
+	// basic call. A failing check is reported in the returned GatewayHealth rather than as an error.
+	GatewayHealth(context.Context) (*GatewayHealth, error)
+	// RateLimitStatus reports the rate limits the gateway is enforcing, along with the tokens the
+	// calling connection and client have left under them. It doesn't reach the backend node and
+	// isn't rate limited, so that a throttled client can still find out why.
+	RateLimitStatus(context.Context) (GatewayRateLimitStatus, error)
+	// GatewayVersion returns the version of the gateway itself, which may differ from that of the
+	// node behind it as returned by Version, along with the features it has enabled and the limits
+	// it's configured with. It isn't rate limited.
+	GatewayVersion(context.Context) (GatewayInfo, error)
+}
+
+// EthBalanceSample is the balance of an address at a single block, as streamed by
+// EthGetBalanceHistory.
+type EthBalanceSample struct {
+	BlockNumber ethtypes.EthUint64
+	Balance     ethtypes.EthBigInt
+	// Error describes why the balance at BlockNumber couldn't be retrieved, if it couldn't. No
+	// samples follow one with Error set.
+	Error string `json:",omitempty"`
+}
+
+// ActorInspection is an actor along with its decoded state, as returned by StateInspectActor.
+type ActorInspection struct {
+	Actor *types.Actor
+	// State is the decoded state of the actor, or nil if StateError is set.
+	State interface{}
+	// StateError describes why the actor's state couldn't be read or decoded, if it couldn't.
+	StateError string `json:",omitempty"`
+}
+
+// GatewayHealth is the result of a GatewayDeepHealth or GatewayHealth check.
+type GatewayHealth struct {
+	Healthy bool
+	// FailedStage names the stage of the check that failed, if the check failed: one of "head",
+	// "tipset", "object" or "network-version" for GatewayDeepHealth, and "head" or "head-age" for
+	// GatewayHealth.
+	FailedStage string `json:",omitempty"`
+	// Error describes why the failed stage failed.
+	Error string `json:",omitempty"`
+	// HeadHeight and HeadAge are the height of the backend node's head and the time since its
+	// timestamp, and Latency is how long the backend node took to return it. They're only set by
+	// GatewayHealth, once the head has been fetched.
+	HeadHeight abi.ChainEpoch `json:",omitempty"`
+	HeadAge    time.Duration  `json:",omitempty"`
+	Latency    time.Duration  `json:",omitempty"`
+}
+
+// GatewayRateLimitStatus is the state of the rate limits the gateway enforces, as returned by
+// RateLimitStatus. Limits are in tokens per second, where each call costs between 1 and 3 tokens
+// depending on how expensive the method is, and a limit of 0 means there is no limit. Calls are
+// limited by each of the limits that apply to them.
+type GatewayRateLimitStatus struct {
+	// TokensPerSecond is the global rate limit, shared by all clients, as currently in effect, and
+	// TokensAvailable is the number of its tokens currently available, up to Burst.
+	TokensPerSecond float64
+	TokensAvailable float64
+	Burst           int
+	// PerConnectionTokensPerSecond is the rate limit of the calling connection, and
+	// ConnectionTokensAvailable the number of its tokens currently available. They're only set if
+	// there is a per connection rate limit.
+	PerConnectionTokensPerSecond float64 `json:",omitempty"`
+	ConnectionTokensAvailable    float64 `json:",omitempty"`
+	// PerIPTokensPerSecond is the rate limit of each client IP address, and IPTokensAvailable the
+	// number of its tokens currently available to the calling client. They're only set if there is
+	// a per IP rate limit.
+	PerIPTokensPerSecond float64 `json:",omitempty"`
+	IPTokensAvailable    float64 `json:",omitempty"`
+}
+
+// EthLogsPage is a page of the logs matching a filter, as returned by EthGetLogsPaged.
+type EthLogsPage struct {
+	// Logs are the logs of the blocks from FromBlock to ToBlock inclusive, as for EthGetLogs.
+	Logs      *ethtypes.EthFilterResult
+	FromBlock ethtypes.EthUint64
+	ToBlock   ethtypes.EthUint64
+	// Cursor fetches the next page, and is empty if this is the last.
+	Cursor string `json:",omitempty"`
+}
+
+// GatewayInfo describes a gateway, as returned by GatewayVersion.
+type GatewayInfo struct {
+	// Version is the build version of the gateway binary.
+	Version string
+	// Features names the optional features enabled on the gateway, such as "actor-cache",
+	// "failover" and "per-ip-rate-limit", sorted.
+	Features []string
+	// MaxLookback is how far back in chain history requests may look, and MaxMessageLookbackEpochs
+	// how many epochs back message searches may look.
+	MaxLookback              time.Duration
+	MaxMessageLookbackEpochs abi.ChainEpoch
+	// RateLimit is the global rate limit and PerIPRateLimit the rate limit of each client IP address,
+	// in tokens per second, where 0 means there is no limit. RateLimitTimeout is how long a call may
+	// wait on the rate limits before it's rejected.
+	RateLimit        int
+	PerIPRateLimit   int
+	RateLimitTimeout time.Duration
+}
+
+// VerifiedClientStatusChange is the datacap of a verified client as of a tipset, as sent by
+// SubscribeVerifiedClientStatus.
+type VerifiedClientStatusChange struct {
+	Height    abi.ChainEpoch
+	TipSetKey types.TipSetKey
+	// DataCap is the client's remaining datacap, or nil if the address isn't a verified client.
+	DataCap *abi.StoragePower
 }
@@ -616,6 +616,8 @@ type GatewayMethods struct {
 
 	ChainGetEvents func(p0 context.Context, p1 cid.Cid) ([]types.Event, error) ``
 
+	ChainGetEventsStream func(p0 context.Context, p1 cid.Cid) (<-chan []types.Event, error) ``
+
 	ChainGetGenesis func(p0 context.Context) (*types.TipSet, error) ``
 
 	ChainGetMessage func(p0 context.Context, p1 cid.Cid) (*types.Message, error) ``
@@ -664,10 +666,14 @@ type GatewayMethods struct {
 
 	EthGetBalance func(p0 context.Context, p1 ethtypes.EthAddress, p2 ethtypes.EthBlockNumberOrHash) (ethtypes.EthBigInt, error) ``
 
+	EthGetBalanceHistory func(p0 context.Context, p1 ethtypes.EthAddress, p2 ethtypes.EthUint64, p3 ethtypes.EthUint64, p4 ethtypes.EthUint64) (<-chan EthBalanceSample, error) ``
+
 	EthGetBlockByHash func(p0 context.Context, p1 ethtypes.EthHash, p2 bool) (ethtypes.EthBlock, error) ``
 
 	EthGetBlockByNumber func(p0 context.Context, p1 string, p2 bool) (ethtypes.EthBlock, error) ``
 
+	EthGetBlockRange func(p0 context.Context, p1 ethtypes.EthUint64, p2 ethtypes.EthUint64, p3 bool) ([]ethtypes.EthBlock, error) ``
+
 	EthGetBlockReceipts func(p0 context.Context, p1 ethtypes.EthBlockNumberOrHash) ([]*ethtypes.EthTxReceipt, error) ``
 
 	EthGetBlockTransactionCountByHash func(p0 context.Context, p1 ethtypes.EthHash) (ethtypes.EthUint64, error) ``
@@ -682,6 +688,8 @@ type GatewayMethods struct {
 
 	EthGetLogs func(p0 context.Context, p1 *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error) ``
 
+	EthGetLogsPaged func(p0 context.Context, p1 *ethtypes.EthFilterSpec, p2 string) (*EthLogsPage, error) ``
+
 	EthGetMessageCidByTransactionHash func(p0 context.Context, p1 *ethtypes.EthHash) (*cid.Cid, error) ``
 
 	EthGetStorageAt func(p0 context.Context, p1 ethtypes.EthAddress, p2 ethtypes.EthBytes, p3 ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) ``
@@ -738,6 +746,12 @@ type GatewayMethods struct {
 
 	GasEstimateMessageGas func(p0 context.Context, p1 *types.Message, p2 *MessageSendSpec, p3 types.TipSetKey) (*types.Message, error) ``
 
+	GatewayDeepHealth func(p0 context.Context) (*GatewayHealth, error) ``
+
+	GatewayHealth func(p0 context.Context) (*GatewayHealth, error) ``
+
+	GatewayVersion func(p0 context.Context) (GatewayInfo, error) ``
+
 	GetActorEventsRaw func(p0 context.Context, p1 *types.ActorEventFilter) ([]*types.ActorEvent, error) ``
 
 	MinerGetBaseInfo func(p0 context.Context, p1 address.Address, p2 abi.ChainEpoch, p3 types.TipSetKey) (*MiningBaseInfo, error) ``
@@ -746,6 +760,8 @@ type GatewayMethods struct {
 
 	MpoolPending func(p0 context.Context, p1 types.TipSetKey) ([]*types.SignedMessage, error) ``
 
+	MpoolPendingByAddress func(p0 context.Context, p1 []address.Address, p2 types.TipSetKey) ([]*types.SignedMessage, error) ``
+
 	MpoolPush func(p0 context.Context, p1 *types.SignedMessage) (cid.Cid, error) ``
 
 	MsigGetAvailableBalance func(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (types.BigInt, error) ``
@@ -760,6 +776,8 @@ type GatewayMethods struct {
 
 	NetVersion func(p0 context.Context) (string, error) ``
 
+	RateLimitStatus func(p0 context.Context) (GatewayRateLimitStatus, error) ``
+
 	StateAccountKey func(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (address.Address, error) ``
 
 	StateCall func(p0 context.Context, p1 *types.Message, p2 types.TipSetKey) (*InvocResult, error) ``
@@ -782,8 +800,12 @@ type GatewayMethods struct {
 
 	StateGetNetworkParams func(p0 context.Context) (*NetworkParams, error) ``
 
+	StateInspectActor func(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (*ActorInspection, error) ``
+
 	StateListMiners func(p0 context.Context, p1 types.TipSetKey) ([]address.Address, error) ``
 
+	StateListMinersWithPower func(p0 context.Context, p1 types.TipSetKey) ([]address.Address, error) ``
+
 	StateLookupID func(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (address.Address, error) ``
 
 	StateMarketBalance func(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (MarketBalance, error) ``
@@ -820,6 +842,8 @@ type GatewayMethods struct {
 
 	SubscribeActorEventsRaw func(p0 context.Context, p1 *types.ActorEventFilter) (<-chan *types.ActorEvent, error) ``
 
+	SubscribeVerifiedClientStatus func(p0 context.Context, p1 address.Address) (<-chan VerifiedClientStatusChange, error) ``
+
 	Version func(p0 context.Context) (APIVersion, error) ``
 
 	WalletBalance func(p0 context.Context, p1 address.Address) (types.BigInt, error) ``
@@ -4052,6 +4076,17 @@ func (s *GatewayStub) ChainGetEvents(p0 context.Context, p1 cid.Cid) ([]types.Ev
 	return *new([]types.Event), ErrNotSupported
 }
 
+func (s *GatewayStruct) ChainGetEventsStream(p0 context.Context, p1 cid.Cid) (<-chan []types.Event, error) {
+	if s.Internal.ChainGetEventsStream == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.ChainGetEventsStream(p0, p1)
+}
+
+func (s *GatewayStub) ChainGetEventsStream(p0 context.Context, p1 cid.Cid) (<-chan []types.Event, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *GatewayStruct) ChainGetGenesis(p0 context.Context) (*types.TipSet, error) {
 	if s.Internal.ChainGetGenesis == nil {
 		return nil, ErrNotSupported
@@ -4316,6 +4351,17 @@ func (s *GatewayStub) EthGetBalance(p0 context.Context, p1 ethtypes.EthAddress,
 	return *new(ethtypes.EthBigInt), ErrNotSupported
 }
 
+func (s *GatewayStruct) EthGetBalanceHistory(p0 context.Context, p1 ethtypes.EthAddress, p2 ethtypes.EthUint64, p3 ethtypes.EthUint64, p4 ethtypes.EthUint64) (<-chan EthBalanceSample, error) {
+	if s.Internal.EthGetBalanceHistory == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.EthGetBalanceHistory(p0, p1, p2, p3, p4)
+}
+
+func (s *GatewayStub) EthGetBalanceHistory(p0 context.Context, p1 ethtypes.EthAddress, p2 ethtypes.EthUint64, p3 ethtypes.EthUint64, p4 ethtypes.EthUint64) (<-chan EthBalanceSample, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *GatewayStruct) EthGetBlockByHash(p0 context.Context, p1 ethtypes.EthHash, p2 bool) (ethtypes.EthBlock, error) {
 	if s.Internal.EthGetBlockByHash == nil {
 		return *new(ethtypes.EthBlock), ErrNotSupported
@@ -4338,6 +4384,17 @@ func (s *GatewayStub) EthGetBlockByNumber(p0 context.Context, p1 string, p2 bool
 	return *new(ethtypes.EthBlock), ErrNotSupported
 }
 
+func (s *GatewayStruct) EthGetBlockRange(p0 context.Context, p1 ethtypes.EthUint64, p2 ethtypes.EthUint64, p3 bool) ([]ethtypes.EthBlock, error) {
+	if s.Internal.EthGetBlockRange == nil {
+		return *new([]ethtypes.EthBlock), ErrNotSupported
+	}
+	return s.Internal.EthGetBlockRange(p0, p1, p2, p3)
+}
+
+func (s *GatewayStub) EthGetBlockRange(p0 context.Context, p1 ethtypes.EthUint64, p2 ethtypes.EthUint64, p3 bool) ([]ethtypes.EthBlock, error) {
+	return *new([]ethtypes.EthBlock), ErrNotSupported
+}
+
 func (s *GatewayStruct) EthGetBlockReceipts(p0 context.Context, p1 ethtypes.EthBlockNumberOrHash) ([]*ethtypes.EthTxReceipt, error) {
 	if s.Internal.EthGetBlockReceipts == nil {
 		return *new([]*ethtypes.EthTxReceipt), ErrNotSupported
@@ -4415,6 +4472,17 @@ func (s *GatewayStub) EthGetLogs(p0 context.Context, p1 *ethtypes.EthFilterSpec)
 	return nil, ErrNotSupported
 }
 
+func (s *GatewayStruct) EthGetLogsPaged(p0 context.Context, p1 *ethtypes.EthFilterSpec, p2 string) (*EthLogsPage, error) {
+	if s.Internal.EthGetLogsPaged == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.EthGetLogsPaged(p0, p1, p2)
+}
+
+func (s *GatewayStub) EthGetLogsPaged(p0 context.Context, p1 *ethtypes.EthFilterSpec, p2 string) (*EthLogsPage, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *GatewayStruct) EthGetMessageCidByTransactionHash(p0 context.Context, p1 *ethtypes.EthHash) (*cid.Cid, error) {
 	if s.Internal.EthGetMessageCidByTransactionHash == nil {
 		return nil, ErrNotSupported
@@ -4723,6 +4791,39 @@ func (s *GatewayStub) GasEstimateMessageGas(p0 context.Context, p1 *types.Messag
 	return nil, ErrNotSupported
 }
 
+func (s *GatewayStruct) GatewayDeepHealth(p0 context.Context) (*GatewayHealth, error) {
+	if s.Internal.GatewayDeepHealth == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.GatewayDeepHealth(p0)
+}
+
+func (s *GatewayStub) GatewayDeepHealth(p0 context.Context) (*GatewayHealth, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *GatewayStruct) GatewayHealth(p0 context.Context) (*GatewayHealth, error) {
+	if s.Internal.GatewayHealth == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.GatewayHealth(p0)
+}
+
+func (s *GatewayStub) GatewayHealth(p0 context.Context) (*GatewayHealth, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *GatewayStruct) GatewayVersion(p0 context.Context) (GatewayInfo, error) {
+	if s.Internal.GatewayVersion == nil {
+		return *new(GatewayInfo), ErrNotSupported
+	}
+	return s.Internal.GatewayVersion(p0)
+}
+
+func (s *GatewayStub) GatewayVersion(p0 context.Context) (GatewayInfo, error) {
+	return *new(GatewayInfo), ErrNotSupported
+}
+
 func (s *GatewayStruct) GetActorEventsRaw(p0 context.Context, p1 *types.ActorEventFilter) ([]*types.ActorEvent, error) {
 	if s.Internal.GetActorEventsRaw == nil {
 		return *new([]*types.ActorEvent), ErrNotSupported
@@ -4767,6 +4868,17 @@ func (s *GatewayStub) MpoolPending(p0 context.Context, p1 types.TipSetKey) ([]*t
 	return *new([]*types.SignedMessage), ErrNotSupported
 }
 
+func (s *GatewayStruct) MpoolPendingByAddress(p0 context.Context, p1 []address.Address, p2 types.TipSetKey) ([]*types.SignedMessage, error) {
+	if s.Internal.MpoolPendingByAddress == nil {
+		return *new([]*types.SignedMessage), ErrNotSupported
+	}
+	return s.Internal.MpoolPendingByAddress(p0, p1, p2)
+}
+
+func (s *GatewayStub) MpoolPendingByAddress(p0 context.Context, p1 []address.Address, p2 types.TipSetKey) ([]*types.SignedMessage, error) {
+	return *new([]*types.SignedMessage), ErrNotSupported
+}
+
 func (s *GatewayStruct) MpoolPush(p0 context.Context, p1 *types.SignedMessage) (cid.Cid, error) {
 	if s.Internal.MpoolPush == nil {
 		return *new(cid.Cid), ErrNotSupported
@@ -4844,6 +4956,17 @@ func (s *GatewayStub) NetVersion(p0 context.Context) (string, error) {
 	return "", ErrNotSupported
 }
 
+func (s *GatewayStruct) RateLimitStatus(p0 context.Context) (GatewayRateLimitStatus, error) {
+	if s.Internal.RateLimitStatus == nil {
+		return *new(GatewayRateLimitStatus), ErrNotSupported
+	}
+	return s.Internal.RateLimitStatus(p0)
+}
+
+func (s *GatewayStub) RateLimitStatus(p0 context.Context) (GatewayRateLimitStatus, error) {
+	return *new(GatewayRateLimitStatus), ErrNotSupported
+}
+
 func (s *GatewayStruct) StateAccountKey(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (address.Address, error) {
 	if s.Internal.StateAccountKey == nil {
 		return *new(address.Address), ErrNotSupported
@@ -4965,6 +5088,17 @@ func (s *GatewayStub) StateGetNetworkParams(p0 context.Context) (*NetworkParams,
 	return nil, ErrNotSupported
 }
 
+func (s *GatewayStruct) StateInspectActor(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (*ActorInspection, error) {
+	if s.Internal.StateInspectActor == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.StateInspectActor(p0, p1, p2)
+}
+
+func (s *GatewayStub) StateInspectActor(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (*ActorInspection, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *GatewayStruct) StateListMiners(p0 context.Context, p1 types.TipSetKey) ([]address.Address, error) {
 	if s.Internal.StateListMiners == nil {
 		return *new([]address.Address), ErrNotSupported
@@ -4976,6 +5110,17 @@ func (s *GatewayStub) StateListMiners(p0 context.Context, p1 types.TipSetKey) ([
 	return *new([]address.Address), ErrNotSupported
 }
 
+func (s *GatewayStruct) StateListMinersWithPower(p0 context.Context, p1 types.TipSetKey) ([]address.Address, error) {
+	if s.Internal.StateListMinersWithPower == nil {
+		return *new([]address.Address), ErrNotSupported
+	}
+	return s.Internal.StateListMinersWithPower(p0, p1)
+}
+
+func (s *GatewayStub) StateListMinersWithPower(p0 context.Context, p1 types.TipSetKey) ([]address.Address, error) {
+	return *new([]address.Address), ErrNotSupported
+}
+
 func (s *GatewayStruct) StateLookupID(p0 context.Context, p1 address.Address, p2 types.TipSetKey) (address.Address, error) {
 	if s.Internal.StateLookupID == nil {
 		return *new(address.Address), ErrNotSupported
@@ -5174,6 +5319,17 @@ func (s *GatewayStub) SubscribeActorEventsRaw(p0 context.Context, p1 *types.Acto
 	return nil, ErrNotSupported
 }
 
+func (s *GatewayStruct) SubscribeVerifiedClientStatus(p0 context.Context, p1 address.Address) (<-chan VerifiedClientStatusChange, error) {
+	if s.Internal.SubscribeVerifiedClientStatus == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.SubscribeVerifiedClientStatus(p0, p1)
+}
+
+func (s *GatewayStub) SubscribeVerifiedClientStatus(p0 context.Context, p1 address.Address) (<-chan VerifiedClientStatusChange, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *GatewayStruct) Version(p0 context.Context) (APIVersion, error) {
 	if s.Internal.Version == nil {
 		return *new(APIVersion), ErrNotSupported
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -19,6 +22,7 @@ import (
 	"github.com/filecoin-project/lotus/api/client"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
 	lcli "github.com/filecoin-project/lotus/cli"
 	cliutil "github.com/filecoin-project/lotus/cli/util"
 	"github.com/filecoin-project/lotus/gateway"
@@ -121,16 +125,68 @@ var runCmd = &cli.Command{
 			Name:  "api-max-req-size",
 			Usage: "maximum API request size accepted by the JSON RPC server",
 		},
+		&cli.StringSliceFlag{
+			Name:  "fallback-api-info",
+			Usage: "The API info, in the FULLNODE_API_INFO format, of a node to retry calls on when the target can't be reached. May be repeated; fallbacks are tried in the order given",
+		},
+		&cli.DurationFlag{
+			Name:  "target-call-timeout",
+			Usage: "The maximum time a single call to the backend node may take before it's abandoned and the request fails with a backend timeout. Streaming methods such as ChainNotify aren't limited. Use 0 to disable",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "circuit-breaker-threshold",
+			Usage: "The number of consecutive calls that fail because the target can't be reached or times out, within the circuit breaker window, after which calls are rejected without being sent to the target until it recovers. Use 0 to disable",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "circuit-breaker-window",
+			Usage: "The window, from the first of them, within which consecutive target failures open the circuit breaker",
+			Value: gateway.DefaultCircuitBreakerWindow,
+		},
+		&cli.DurationFlag{
+			Name:  "circuit-breaker-cooldown",
+			Usage: "The interval at which the target is probed while the circuit breaker is open, to decide when to close it",
+			Value: gateway.DefaultCircuitBreakerCooldown,
+		},
 		&cli.DurationFlag{
 			Name:  "api-max-lookback",
 			Usage: "maximum duration allowable for tipset lookbacks",
 			Value: gateway.DefaultMaxLookbackDuration,
 		},
+		&cli.StringSliceFlag{
+			Name:  "method-max-lookback",
+			Usage: "Override --api-max-lookback for a method, in the form Method=duration, e.g. 'EthGetBlockByNumber=168h'. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "archive-method",
+			Usage: "Serve a method as part of the archive tier, with the longer lookback of --archive-max-lookback, e.g. 'StateGetActor'. Can be repeated",
+		},
+		&cli.DurationFlag{
+			Name:  "archive-max-lookback",
+			Usage: "The maximum lookback of the methods given with --archive-method, extending --api-max-lookback for them, at most a year. Use 0 to disable the archive tier",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "startup-grace-period",
+			Usage: "Don't enforce the lookback limit on tipset timestamps for this long after startup, while the node catches up with the chain",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "startup-grace-error",
+			Usage: "Reject requests that exceed the lookback limit during the startup grace period with a \"starting up\" error rather than serving them",
+			Value: false,
+		},
 		&cli.Int64Flag{
 			Name:  "api-wait-lookback-limit",
 			Usage: "maximum number of blocks to search back through for message inclusion",
 			Value: int64(gateway.DefaultMaxMessageLookbackEpochs),
 		},
+		&cli.Int64Flag{
+			Name:  "api-wait-replaced-lookback-limit",
+			Usage: "maximum number of blocks to search back through for message inclusion when the message may have been replaced; 0 applies only api-wait-lookback-limit",
+			Value: 0,
+		},
 		&cli.Int64Flag{
 			Name: "rate-limit",
 			Usage: fmt.Sprintf(
@@ -147,11 +203,42 @@ var runCmd = &cli.Command{
 			),
 			Value: 0,
 		},
+		&cli.Int64Flag{
+			Name: "per-ip-rate-limit",
+			Usage: fmt.Sprintf(
+				"API call throttling rate limit (per second) per client IP address, across all of its connections, weighted by relative expense of the call, with the most expensive calls counting for %d. Use 0 to disable",
+				gateway.MaxRateLimitTokens,
+			),
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "identify-by-forwarded-for",
+			Usage: "Identify clients by the last address in the X-Forwarded-For header, rather than by the remote address of the connection, for the limits that apply per client. Only enable this behind a reverse proxy that sets the header",
+			Value: false,
+		},
+		&cli.Int64Flag{
+			Name: "write-reserved-rate-limit",
+			Usage: fmt.Sprintf(
+				"API call throttling rate limit (per second) reserved for message submission, which is used by writes before the global rate limit so that reads can't starve them; weighted as for rate-limit, with the most expensive calls counting for %d. Use 0 to disable",
+				gateway.MaxRateLimitTokens,
+			),
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "per-conn-rate-limit-retry-hint",
+			Usage: "Reject requests throttled by the per-connection rate limit with a structured error carrying the exact wait needed before retrying",
+			Value: false,
+		},
 		&cli.DurationFlag{
 			Name:  "rate-limit-timeout",
 			Usage: "The maximum time to wait for the API call throttling rate limiter before returning an error to clients",
 			Value: gateway.DefaultRateLimitTimeout,
 		},
+		&cli.DurationFlag{
+			Name:  "rate-limit-queue-threshold",
+			Usage: "Tell HTTP requests throttled by the rate limiter for longer than this their queue position, in an informational response. Use 0 to disable",
+			Value: 0,
+		},
 		&cli.Int64Flag{
 			Name:  "conn-per-minute",
 			Usage: "A hard limit on the number of incoming connections (requests) to accept per remote host per minute. Use 0 to disable",
@@ -162,16 +249,386 @@ var runCmd = &cli.Command{
 			Usage: "The maximum number of filters plus subscriptions that a single websocket connection can maintain",
 			Value: gateway.DefaultEthMaxFiltersPerConn,
 		},
+		&cli.IntFlag{
+			Name:  "eth-max-filters-per-host",
+			Usage: "The maximum number of filters plus subscriptions that a single remote host can have across all of its websocket connections. Use 0 to apply only the per connection limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-subscription-replay-buffer",
+			Usage: "The number of recent notifications kept per newHeads subscription, such that a client that reconnects within a minute can resume the subscription without missing any. Use 0 to disable",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-subscription-buffer-size",
+			Usage: "The maximum number of notifications buffered per EthSubscribe subscription before a slow client's subscription is dropped. Use 0 to disable buffering",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-max-new-heads-subscriptions-per-conn",
+			Usage: "The maximum number of newHeads EthSubscribe subscriptions that a single websocket connection can maintain. Use 0 to apply only the limit on filters plus subscriptions",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-max-logs-subscriptions-per-conn",
+			Usage: "The maximum number of logs EthSubscribe subscriptions that a single websocket connection can maintain. Use 0 to apply only the limit on filters plus subscriptions",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-max-pending-tx-subscriptions-per-conn",
+			Usage: "The maximum number of newPendingTransactions EthSubscribe subscriptions that a single websocket connection can maintain. Use 0 to apply only the limit on filters plus subscriptions",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "eth-subscription-cap-action",
+			Usage: "What to do when a connection subscribes beyond one of the EthSubscribe caps by type: \"reject\" the new subscription or \"evict-oldest\" subscription of the same type",
+			Value: "reject",
+		},
+		&cli.IntFlag{
+			Name:  "batch-fanout-concurrency",
+			Usage: "The maximum number of concurrent backend calls made on behalf of batch methods, shared across all connections. Use 0 to disable the limit",
+			Value: gateway.DefaultBatchFanoutConcurrency,
+		},
+		&cli.IntFlag{
+			Name:  "miner-power-concurrency",
+			Usage: "The maximum number of concurrent StateMinerPower calls made by StateListMinersWithPower to check which miners have power, shared across all connections. Use 0 to disable the limit",
+			Value: gateway.DefaultMinerPowerConcurrency,
+		},
+		&cli.IntFlag{
+			Name:  "state-miner-info-cache-size",
+			Usage: "The number of (miner, tipset) StateMinerInfo responses to cache. Use 0 to disable the cache",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "cache-miss-jitter",
+			Usage: "The maximum random delay added to backend calls made on a cache miss, to spread out the calls that follow a head change. Use 0 to disable the delay",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "cache-memory-budget",
+			Usage: "The estimated maximum memory, in bytes, used by all caches combined, evicting the least recently used entries across caches to stay within it. Use 0 to bound caches only by their sizes",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "tipset-cache-size",
+			Usage: "The number of tipsets returned by ChainGetTipSetByHeight and ChainGetTipSetAfterHeight, at least a finality behind the head, to cache. Use 0 to disable the cache",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-block-cache-size",
+			Usage: "The number of finalized blocks returned by EthGetBlockRange, EthGetBlockByNumber and EthGetBlockByHash to cache. Use 0 to disable the cache",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "state-get-actor-cache-size",
+			Usage: "The number of StateGetActor responses to cache, each evicted when the head changes. Use 0 to disable the cache",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "state-get-actor-cache-ttl",
+			Usage: "The maximum time a cached StateGetActor response is served for (see --state-get-actor-cache-size). Use 0 to serve it until the head changes",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "eth-call-max-block-age",
+			Usage: "The maximum number of epochs behind the head that eth_call, eth_getStorageAt and eth_getCode may be executed against, in addition to the general lookback limits. Use 0 to apply only the general lookback limits",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "eth-storage-max-block-age",
+			Usage: "The maximum number of epochs behind the head that eth_getStorageAt may read storage at, in addition to the general lookback limits and --eth-call-max-block-age. Use 0 to apply only those limits",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "eth-fee-history-max-block-age",
+			Usage: "The maximum number of epochs behind the head of the newest block eth_feeHistory may report on, in addition to the general lookback limits. Use 0 to apply only the general lookback limits",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "actor-event-subscription-max-backfill",
+			Usage: "The maximum number of epochs behind the head that an actor event subscription may request historical events from, in addition to the general lookback limits. Use 0 to apply only the general lookback limits",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "eth-simulation-timeout",
+			Usage: "The maximum time eth_call and eth_estimateGas may take to execute on the backend node before being cancelled. Use 0 to disable the timeout",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-balance-history-max-samples",
+			Usage: "The maximum number of blocks a single EthGetBalanceHistory request may sample. Use 0 to disable the limit",
+			Value: gateway.DefaultEthBalanceHistoryMaxSamples,
+		},
+		&cli.IntFlag{
+			Name:  "chain-events-max",
+			Usage: "The maximum number of events ChainGetEvents and ChainGetEventsStream may return for a single events root. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "chain-events-chunk-size",
+			Usage: "The maximum number of events sent in each chunk by ChainGetEventsStream",
+			Value: gateway.DefaultChainEventsChunkSize,
+		},
+		&cli.IntFlag{
+			Name:  "eth-block-range-max-span",
+			Usage: "The maximum number of blocks a single EthGetBlockRange request may return. Use 0 to disable the limit",
+			Value: gateway.DefaultEthBlockRangeMaxSpan,
+		},
+		&cli.IntFlag{
+			Name:  "eth-logs-max-addresses",
+			Usage: "The maximum number of contract addresses in the filter of an eth_getLogs or eth_newFilter request. Use 0 to disable the limit",
+			Value: gateway.DefaultEthLogsMaxAddresses,
+		},
+		&cli.IntFlag{
+			Name:  "eth-logs-max-topics",
+			Usage: "The maximum number of topic positions in the filter of an eth_getLogs or eth_newFilter request. Use 0 to disable the limit",
+			Value: gateway.DefaultEthLogsMaxTopics,
+		},
+		&cli.IntFlag{
+			Name:  "eth-logs-max-topic-entries",
+			Usage: "The maximum number of topics across all topic positions in the filter of an eth_getLogs or eth_newFilter request, with a null position counting as one. Use 0 to disable the limit",
+			Value: gateway.DefaultEthLogsMaxTopicEntries,
+		},
+		&cli.IntFlag{
+			Name:  "eth-max-logs-block-range",
+			Usage: "The maximum number of epochs the block range of an eth_getLogs request may span, with latest, safe and finalized resolved against the current head. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-logs-page-span",
+			Usage: "The number of blocks covered by each page of logs returned by EthGetLogsPaged, capped by --eth-max-logs-block-range",
+			Value: gateway.DefaultEthLogsPageSpan,
+		},
+		&cli.IntFlag{
+			Name:  "eth-batch-max-block-params",
+			Usage: "The maximum number of distinct block params referenced by the calls in a batch request. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "chain-notify-max-subscribers",
+			Usage: "The maximum number of ChainNotify subscriptions served at once across all connections. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "chain-notify-subscriber-buffer",
+			Usage: "The number of head changes queued for each ChainNotify subscriber; a subscriber that lets its queue fill is dropped so it doesn't hold up the others",
+			Value: gateway.DefaultChainNotifySubscriberBuffer,
+		},
+		&cli.IntFlag{
+			Name:  "eth-tx-max-size",
+			Usage: "The maximum size in bytes of a raw transaction submitted with eth_sendRawTransaction. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.Uint64Flag{
+			Name:  "eth-call-max-gas",
+			Usage: "The maximum gas limit of an eth_call or eth_estimateGas request, also given to requests that don't set one. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "eth-call-reject-expensive-precompiles",
+			Usage: "Reject eth_call and eth_estimateGas requests made directly to the modexp, ecPairing and blake2f precompiles",
+			Value: false,
+		},
+		&cli.Uint64Flag{
+			Name:  "eth-tx-max-gas",
+			Usage: "The maximum gas limit of a raw transaction submitted with eth_sendRawTransaction. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "state-replay-max-result-size",
+			Usage: "The maximum size, in bytes, of a serialized StateReplay result. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "max-response-bytes",
+			Usage: "The maximum size, in bytes, of a serialized object returned by ChainReadObj, ChainGetNode, ChainGetEvents and StateReadState. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "trace-concurrency-limit",
+			Usage: "The maximum number of trace_block, trace_replayBlockTransactions, trace_transaction and trace_filter requests in flight to the backend node at once. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "batch-max-in-flight",
+			Usage: "The maximum number of calls from JSON-RPC batch requests in flight at once, across all batches and clients. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "batch-max-cost",
+			Usage: "The maximum combined rate limit cost of the calls in a JSON-RPC batch request. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "max-batch-size",
+			Usage: "The maximum number of calls in a JSON-RPC batch request. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "eth-logs-concurrency-limit",
+			Usage: "The maximum number of eth_getLogs and eth_getFilterLogs requests in flight to the backend node at once. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "message-search-concurrency-limit",
+			Usage: "The maximum number of StateSearchMsg and StateWaitMsg requests in flight to the backend node at once. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "trace-replay-max-results",
+			Usage: "The maximum number of transaction replays trace_replayBlockTransactions may return for a single block. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "trace-block-max-messages",
+			Usage: "The maximum number of messages in a tipset trace_block and trace_replayBlockTransactions may replay. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "actor-state-max-entries",
+			Usage: "The maximum number of map and array entries in the decoded actor state StateReadState may return. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "head-age-sample-interval",
+			Usage: fmt.Sprintf("The interval at which to sample the backend node's head in the background, to report its age as the gateway/head_age metric. The minimum is %s. Use 0 to disable", gateway.MinHeadAgeSampleInterval),
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "mpool-pending-max-messages",
+			Usage: "The maximum number of pending messages returned by MpoolPending; requests made while the mempool holds more are rejected. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "mpool-pending-max-addresses",
+			Usage: "The maximum number of addresses MpoolPendingByAddress may filter pending messages by. Use 0 to disable the limit",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "client-version",
+			Usage: "The version string returned to clients by web3_clientVersion, e.g. lotus-gateway/1.2.3. By default the backend node's version is returned",
+		},
+		&cli.BoolFlag{
+			Name:  "method-not-supported-errors",
+			Usage: "Translate 'method not found' errors from the backend node into structured 'method not supported by backend' errors, including the backend's version",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "eth-revert-reasons",
+			Usage: "Decode standard Error(string) and Panic(uint256) revert reasons of reverted eth_call requests and include them in the returned error",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "eth-receipt-effective-gas-price",
+			Usage: "Fill in the effective gas price of transaction receipts returned without one, from the transaction and its block's base fee",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "state-decode-params-fallback",
+			Usage: "Return the raw params, marked as undecodable, from StateDecodeParams when the backend node can't decode them, rather than failing",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "eth-expired-filter-cleanup",
+			Usage: "Stop tracking filters the backend node no longer has, telling clients polling them to recreate them",
+			Value: false,
+		},
+		&cli.StringSliceFlag{
+			Name:  "eth-tx-redact-field",
+			Usage: "Redact a field, named as in its JSON encoding, e.g. 'input', from the Eth transactions returned to clients. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "eth-call-allowed-address",
+			Usage: "Only allow eth_call and eth_estimateGas against this contract address, e.g. for a gateway that serves a single dapp. Can be repeated; if not set, calls against any address are allowed",
+		},
+		&cli.StringSliceFlag{
+			Name:  "blocked-address",
+			Usage: "Refuse queries about this address, in both its ID and robust forms, e.g. for sanctioned or abusive addresses. Can be repeated. This is best-effort, as clients can still learn about an address indirectly",
+		},
+		&cli.StringSliceFlag{
+			Name:  "deprecated-method",
+			Usage: "Mark a method as deprecated, in the form Method=message, e.g. 'EthGetBlockReceipts=removed in the next release'. Calls are served as normal but logged, counted and answered with a warning. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "daily-token-quota",
+			Usage: "Limit the rate limit tokens a client may consume over a rolling 24 hours, in the form identity=tokens, where clients are identified by their remote IP address. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "method-rate-limit",
+			Usage: "Limit calls to a method to a rate of its own, in addition to --rate-limit, in the form Method=tokens, where tokens is the number of rate limit tokens per second, e.g. 'StateReplay=10'. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "disabled-method",
+			Usage: "Disable a method, e.g. 'EthTraceBlock', such that calls to it are rejected without reaching the backend node. Can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-method",
+			Usage: "Allow only the listed methods, e.g. to serve a read-only gateway, rejecting calls to all others without reaching the backend node. A method can't be both allowed and disabled. Can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "serve-stale-on-outage",
+			Usage: "When the backend node can't be reached, serve cached responses (see --state-miner-info-cache-size, --state-get-actor-cache-size, --eth-block-cache-size and --tipset-cache-size) even if they may be out of date, rather than failing",
+			Value: false,
+		},
+		&cli.DurationFlag{
+			Name:  "max-stale-serve-age",
+			Usage: "The maximum age of a cached response served while the backend node can't be reached (see --serve-stale-on-outage). Use 0 to serve cached responses of any age",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  "slow-start-window",
+			Usage: "After the backend node recovers from being unreachable, ramp the global rate limit (see --rate-limit) back up over this window rather than allowing the full rate at once. Use 0 to disable",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "default-finalized-reads",
+			Usage: "Serve state reads that don't specify a tipset from the latest F3 finalized tipset rather than the chain head, falling back to the head when F3 is unavailable",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "require-explicit-tipset",
+			Usage: "Reject state and chain reads that don't specify a tipset, rather than reading from the chain head",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "coalesce-reads",
+			Usage: "Coalesce concurrent identical reads of content addressed data, and of state at a specific tipset, into a single backend call",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "coalesce-lookups",
+			Usage: "Coalesce concurrent identical EthGetTransactionReceipt, EthGetBlockByHash and StateGetActor lookups, against any tipset, into a single backend call",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "sanitize-errors",
+			Usage: "Replace backend errors returned to clients with a generic error and an error ID, logging the full error",
+			Value: false,
+		},
 		&cli.BoolFlag{
 			Name:  "cors",
 			Usage: "Enable CORS headers to allow cross-origin requests from web browsers",
 			Value: false,
 		},
+		&cli.StringFlag{
+			Name:  "admin-token-file",
+			Usage: "Serve the admin API, e.g. to purge caches or list connections, on /rpc/admin to callers presenting the token in this file as a bearer token. Unset by default, which doesn't serve it",
+		},
+		&cli.BoolFlag{
+			Name:  "cbor-responses",
+			Usage: "Serve results CBOR encoded over HTTP to clients that accept application/cbor, for methods whose results support it",
+			Value: false,
+		},
 		&cli.BoolFlag{
 			Name:  "request-logging",
 			Usage: "Enable logging of incoming API requests. Note: This will log POST request bodies which may impact performance due to body buffering and may expose sensitive data in logs",
 			Value: false,
 		},
+		&cli.Float64Flag{
+			Name:  "request-logging-sample-rate",
+			Usage: "The fraction of requests, between 0 and 1, logged when request logging is enabled. Failed and rate limited requests are always logged",
+			Value: 1,
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		log.Info("Starting lotus gateway")
@@ -197,15 +654,50 @@ var runCmd = &cli.Command{
 		}
 		defer closerV2()
 
+		var fallbacks []gateway.TargetAPI
+		for _, info := range cctx.StringSlice("fallback-api-info") {
+			ainfo := cliutil.ParseApiInfo(info)
+			v1Addr, err := ainfo.DialArgs("v1")
+			if err != nil {
+				return xerrors.Errorf("invalid fallback api info %q: %w", info, err)
+			}
+			v2Addr, err := ainfo.DialArgs("v2")
+			if err != nil {
+				return xerrors.Errorf("invalid fallback api info %q: %w", info, err)
+			}
+			fallbackV1, closer, err := client.NewFullNodeRPCV1(cctx.Context, v1Addr, ainfo.AuthHeader())
+			if err != nil {
+				return xerrors.Errorf("connecting to fallback %s: %w", v1Addr, err)
+			}
+			defer closer()
+			fallbackV2, closer, err := client.NewFullNodeRPCV2(cctx.Context, v2Addr, ainfo.AuthHeader())
+			if err != nil {
+				return xerrors.Errorf("connecting to fallback %s: %w", v2Addr, err)
+			}
+			defer closer()
+			fallbacks = append(fallbacks, gateway.TargetAPI{V1: fallbackV1, V2: fallbackV2})
+		}
+
 		var (
 			lookbackCap                 = cctx.Duration("api-max-lookback")
 			address                     = cctx.String("listen")
 			waitLookback                = abi.ChainEpoch(cctx.Int64("api-wait-lookback-limit"))
+			waitReplacedLookback        = abi.ChainEpoch(cctx.Int64("api-wait-replaced-lookback-limit"))
 			globalRateLimit             = cctx.Int("rate-limit")
 			perConnectionRateLimit      = cctx.Int("per-conn-rate-limit")
 			rateLimitTimeout            = cctx.Duration("rate-limit-timeout")
 			perHostConnectionsPerMinute = cctx.Int("conn-per-minute")
 			maxFiltersPerConn           = cctx.Int("eth-max-filters-per-conn")
+			maxFiltersPerHost           = cctx.Int("eth-max-filters-per-host")
+			subscriptionBufferSize      = cctx.Int("eth-subscription-buffer-size")
+			minerInfoCacheSize          = cctx.Int("state-miner-info-cache-size")
+			batchFanoutConcurrency      = cctx.Int("batch-fanout-concurrency")
+			clientVersion               = cctx.String("client-version")
+			ethCallMaxBlockAge          = abi.ChainEpoch(cctx.Int64("eth-call-max-block-age"))
+			actorEventMaxBackfill       = abi.ChainEpoch(cctx.Int64("actor-event-subscription-max-backfill"))
+			balanceHistoryMaxSamples    = cctx.Int("eth-balance-history-max-samples")
+			mpoolPendingMaxMessages     = cctx.Int("mpool-pending-max-messages")
+			traceConcurrencyLimit       = cctx.Int("trace-concurrency-limit")
 			enableCORS                  = cctx.Bool("cors")
 			enableRequestLogging        = cctx.Bool("request-logging")
 		)
@@ -227,26 +719,219 @@ var runCmd = &cli.Command{
 			return xerrors.Errorf("failed to convert endpoint address to multiaddr: %w", err)
 		}
 
-		gwapi := gateway.NewNode(
-			v1, v2,
+		nodeOpts := []gateway.Option{
 			gateway.WithV1EthSubHandler(v1SubHnd),
 			gateway.WithV2EthSubHandler(v2SubHnd),
+			gateway.WithFallbackTargets(fallbacks...),
+			gateway.WithTargetCallTimeout(cctx.Duration("target-call-timeout")),
+			gateway.WithCircuitBreaker(cctx.Int("circuit-breaker-threshold"), cctx.Duration("circuit-breaker-window"), cctx.Duration("circuit-breaker-cooldown")),
 			gateway.WithMaxLookbackDuration(lookbackCap),
+			gateway.WithStartupGracePeriod(cctx.Duration("startup-grace-period")),
+			gateway.WithStartupGraceError(cctx.Bool("startup-grace-error")),
 			gateway.WithMaxMessageLookbackEpochs(waitLookback),
+			gateway.WithMaxReplacedMessageLookbackEpochs(waitReplacedLookback),
 			gateway.WithRateLimit(globalRateLimit),
+			gateway.WithWriteReservedRateLimit(cctx.Int("write-reserved-rate-limit")),
 			gateway.WithRateLimitTimeout(rateLimitTimeout),
+			gateway.WithQueuedResponses(cctx.Duration("rate-limit-queue-threshold")),
+			gateway.WithConnectionRateLimitRetryHint(cctx.Bool("per-conn-rate-limit-retry-hint")),
+			gateway.WithPerIPRateLimit(cctx.Int("per-ip-rate-limit")),
 			gateway.WithEthMaxFiltersPerConn(maxFiltersPerConn),
-		)
+			gateway.WithEthMaxFiltersPerHost(maxFiltersPerHost),
+			gateway.WithSubscriptionBufferSize(subscriptionBufferSize),
+			gateway.WithEthSubscriptionReplayBuffer(cctx.Int("eth-subscription-replay-buffer")),
+			gateway.WithStateMinerInfoCache(minerInfoCacheSize),
+			gateway.WithEthBlockCache(cctx.Int("eth-block-cache-size")),
+			gateway.WithTipSetCache(cctx.Int("tipset-cache-size")),
+			gateway.WithActorCache(cctx.Int("state-get-actor-cache-size"), cctx.Duration("state-get-actor-cache-ttl")),
+			gateway.WithCacheMissJitter(cctx.Duration("cache-miss-jitter")),
+			gateway.WithGlobalCacheMemoryBudget(cctx.Int64("cache-memory-budget")),
+			gateway.WithBatchFanoutConcurrency(batchFanoutConcurrency),
+			gateway.WithMinerPowerConcurrency(cctx.Int("miner-power-concurrency")),
+			gateway.WithClientVersion(clientVersion),
+			gateway.WithEthCallMaxBlockAge(ethCallMaxBlockAge),
+			gateway.WithEthFeeHistoryMaxBlockAge(abi.ChainEpoch(cctx.Int64("eth-fee-history-max-block-age"))),
+			gateway.WithEthStorageMaxBlockAge(abi.ChainEpoch(cctx.Int64("eth-storage-max-block-age"))),
+			gateway.WithActorEventSubscriptionMaxBackfill(actorEventMaxBackfill),
+			gateway.WithEthSimulationTimeout(cctx.Duration("eth-simulation-timeout")),
+			gateway.WithEthBalanceHistoryMaxSamples(balanceHistoryMaxSamples),
+			gateway.WithEthBlockRangeMaxSpan(cctx.Int("eth-block-range-max-span")),
+			gateway.WithChainEventsMax(cctx.Int("chain-events-max")),
+			gateway.WithChainEventsChunkSize(cctx.Int("chain-events-chunk-size")),
+			gateway.WithEthLogsMaxAddresses(cctx.Int("eth-logs-max-addresses")),
+			gateway.WithEthLogsMaxTopics(cctx.Int("eth-logs-max-topics")),
+			gateway.WithEthLogsMaxTopicEntries(cctx.Int("eth-logs-max-topic-entries")),
+			gateway.WithEthMaxLogsBlockRange(cctx.Int("eth-max-logs-block-range")),
+			gateway.WithEthLogsPageSpan(cctx.Int("eth-logs-page-span")),
+			gateway.WithEthBatchMaxBlockParams(cctx.Int("eth-batch-max-block-params")),
+			gateway.WithEthExpiredFilterCleanup(cctx.Bool("eth-expired-filter-cleanup")),
+			gateway.WithStateDecodeParamsFallback(cctx.Bool("state-decode-params-fallback")),
+			gateway.WithChainNotifyMaxSubscribers(cctx.Int("chain-notify-max-subscribers")),
+			gateway.WithChainNotifySubscriberBuffer(cctx.Int("chain-notify-subscriber-buffer")),
+			gateway.WithEthTxMaxSize(cctx.Int("eth-tx-max-size")),
+			gateway.WithEthTxMaxGas(cctx.Uint64("eth-tx-max-gas")),
+			gateway.WithEthCallMaxGas(cctx.Uint64("eth-call-max-gas")),
+			gateway.WithStateReplayMaxResultSize(cctx.Int("state-replay-max-result-size")),
+			gateway.WithMaxResponseBytes(cctx.Int("max-response-bytes")),
+			gateway.WithMpoolPendingMaxMessages(mpoolPendingMaxMessages),
+			gateway.WithMpoolPendingMaxAddresses(cctx.Int("mpool-pending-max-addresses")),
+			gateway.WithTraceConcurrencyLimit(traceConcurrencyLimit),
+			gateway.WithEthLogsConcurrencyLimit(cctx.Int("eth-logs-concurrency-limit")),
+			gateway.WithMessageSearchConcurrencyLimit(cctx.Int("message-search-concurrency-limit")),
+			gateway.WithBatchMaxInFlight(cctx.Int("batch-max-in-flight")),
+			gateway.WithBatchMaxCost(cctx.Int("batch-max-cost")),
+			gateway.WithMaxBatchSize(cctx.Int("max-batch-size")),
+			gateway.WithTraceReplayMaxResults(cctx.Int("trace-replay-max-results")),
+			gateway.WithTraceBlockMaxMessages(cctx.Int("trace-block-max-messages")),
+			gateway.WithActorStateMaxEntries(cctx.Int("actor-state-max-entries")),
+			gateway.WithHeadAgeSampleInterval(cctx.Duration("head-age-sample-interval")),
+			gateway.WithServeStaleOnOutage(cctx.Bool("serve-stale-on-outage")),
+			gateway.WithMaxStaleServeAge(cctx.Duration("max-stale-serve-age")),
+			gateway.WithSlowStart(cctx.Duration("slow-start-window")),
+			gateway.WithDefaultFinalizedReads(cctx.Bool("default-finalized-reads")),
+			gateway.WithRequireExplicitTipset(cctx.Bool("require-explicit-tipset")),
+			gateway.WithReadCoalescing(cctx.Bool("coalesce-reads")),
+			gateway.WithErrorSanitization(cctx.Bool("sanitize-errors")),
+		}
+		if deprecated := cctx.StringSlice("deprecated-method"); len(deprecated) > 0 {
+			methods := make(map[string]string, len(deprecated))
+			for _, d := range deprecated {
+				method, msg, ok := strings.Cut(d, "=")
+				if !ok || method == "" {
+					return xerrors.Errorf("invalid deprecated method %q, expected Method=message", d)
+				}
+				methods[method] = msg
+			}
+			nodeOpts = append(nodeOpts, gateway.WithDeprecatedMethods(methods))
+		}
+		if quotas := cctx.StringSlice("daily-token-quota"); len(quotas) > 0 {
+			byIdentity := make(map[gateway.Identity]int64, len(quotas))
+			for _, q := range quotas {
+				id, tokens, ok := strings.Cut(q, "=")
+				n, err := strconv.ParseInt(tokens, 10, 64)
+				if !ok || id == "" || err != nil || n < 0 {
+					return xerrors.Errorf("invalid daily token quota %q, expected identity=tokens", q)
+				}
+				byIdentity[gateway.Identity(id)] = n
+			}
+			nodeOpts = append(nodeOpts, gateway.WithDailyTokenQuota(byIdentity))
+		}
+		if limits := cctx.StringSlice("method-rate-limit"); len(limits) > 0 {
+			byMethod := make(map[string]int, len(limits))
+			for _, l := range limits {
+				method, tokens, ok := strings.Cut(l, "=")
+				n, err := strconv.Atoi(tokens)
+				if !ok || method == "" || err != nil || n < 0 {
+					return xerrors.Errorf("invalid method rate limit %q, expected Method=tokens", l)
+				}
+				byMethod[method] = n
+			}
+			nodeOpts = append(nodeOpts, gateway.WithMethodRateLimits(byMethod))
+		}
+		if lookbacks := cctx.StringSlice("method-max-lookback"); len(lookbacks) > 0 {
+			byMethod := make(map[string]time.Duration, len(lookbacks))
+			for _, l := range lookbacks {
+				method, lookback, ok := strings.Cut(l, "=")
+				d, err := time.ParseDuration(lookback)
+				if !ok || method == "" || err != nil || d < 0 {
+					return xerrors.Errorf("invalid method lookback %q, expected Method=duration", l)
+				}
+				byMethod[method] = d
+			}
+			nodeOpts = append(nodeOpts, gateway.WithMethodLookbackDuration(byMethod))
+		}
+		if methods := cctx.StringSlice("archive-method"); len(methods) > 0 {
+			nodeOpts = append(nodeOpts, gateway.WithArchiveMethods(methods, cctx.Duration("archive-max-lookback")))
+		}
+		subscriptionPolicy := gateway.EthSubscriptionPolicy{
+			MaxNewHeads:               cctx.Int("eth-max-new-heads-subscriptions-per-conn"),
+			MaxLogs:                   cctx.Int("eth-max-logs-subscriptions-per-conn"),
+			MaxNewPendingTransactions: cctx.Int("eth-max-pending-tx-subscriptions-per-conn"),
+		}
+		switch action := cctx.String("eth-subscription-cap-action"); action {
+		case "reject":
+			subscriptionPolicy.OnCap = gateway.RejectNewSubscription
+		case "evict-oldest":
+			subscriptionPolicy.OnCap = gateway.EvictOldestSubscription
+		default:
+			return xerrors.Errorf("invalid eth subscription cap action %q, expected reject or evict-oldest", action)
+		}
+		nodeOpts = append(nodeOpts, gateway.WithEthSubscriptionPolicy(subscriptionPolicy))
+		if cctx.Bool("coalesce-lookups") {
+			nodeOpts = append(nodeOpts, gateway.WithRequestCoalescing())
+		}
+		if cctx.Bool("method-not-supported-errors") {
+			nodeOpts = append(nodeOpts, gateway.WithMethodNotSupportedErrors(true))
+		}
+		if cctx.Bool("eth-revert-reasons") {
+			nodeOpts = append(nodeOpts, gateway.WithEthRevertReasons())
+		}
+		if cctx.Bool("eth-call-reject-expensive-precompiles") {
+			nodeOpts = append(nodeOpts, gateway.WithEthCallRejectExpensivePrecompiles())
+		}
+		if allowed := cctx.StringSlice("eth-call-allowed-address"); len(allowed) > 0 {
+			addrs := make([]ethtypes.EthAddress, 0, len(allowed))
+			for _, a := range allowed {
+				addr, err := ethtypes.ParseEthAddress(a)
+				if err != nil {
+					return xerrors.Errorf("invalid eth call allowed address %q: %w", a, err)
+				}
+				addrs = append(addrs, addr)
+			}
+			nodeOpts = append(nodeOpts, gateway.WithEthCallAddressAllowlist(addrs))
+		}
+		if blocked := cctx.StringSlice("blocked-address"); len(blocked) > 0 {
+			addrs, err := parseBlockedAddresses(blocked)
+			if err != nil {
+				return err
+			}
+			nodeOpts = append(nodeOpts, gateway.WithBlockedAddresses(addrs))
+		}
+		if disabled := cctx.StringSlice("disabled-method"); len(disabled) > 0 {
+			nodeOpts = append(nodeOpts, gateway.WithDisabledMethods(disabled...))
+		}
+		if allowed := cctx.StringSlice("allowed-method"); len(allowed) > 0 {
+			nodeOpts = append(nodeOpts, gateway.WithAllowedMethods(allowed...))
+		}
+		if fields := cctx.StringSlice("eth-tx-redact-field"); len(fields) > 0 {
+			nodeOpts = append(nodeOpts, gateway.WithEthTxFieldPolicy(fields...))
+		}
+		if cctx.Bool("eth-receipt-effective-gas-price") {
+			nodeOpts = append(nodeOpts, gateway.WithEthReceiptEffectiveGasPrice())
+		}
+		var adminToken string
+		if path := cctx.String("admin-token-file"); path != "" {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return xerrors.Errorf("reading admin token: %w", err)
+			}
+			if adminToken = strings.TrimSpace(string(b)); adminToken == "" {
+				return xerrors.Errorf("admin token file %s is empty", path)
+			}
+		}
+
+		gwapi := gateway.NewNode(v1, v2, nodeOpts...)
+		if err := gwapi.Err(); err != nil {
+			return err
+		}
+		identity := gateway.RemoteIPIdentity
+		if cctx.Bool("identify-by-forwarded-for") {
+			identity = gateway.ForwardedForIdentity
+		}
 		handler, err := gateway.Handler(
 			gwapi,
 			gateway.WithPerConnectionAPIRateLimit(perConnectionRateLimit),
 			gateway.WithPerHostConnectionsPerMinute(perHostConnectionsPerMinute),
+			gateway.WithIdentityExtractor(identity),
 			gateway.WithJsonrpcServerOptions(serverOptions...),
 			gateway.WithCORS(enableCORS),
+			gateway.WithCBORResponses(cctx.Bool("cbor-responses")),
 			gateway.WithRequestLogging(enableRequestLogging),
+			gateway.WithAccessLogSampling(cctx.Float64("request-logging-sample-rate")),
+			gateway.WithAdminToken(adminToken),
 		)
 		if err != nil {
-			return xerrors.Errorf("failed to set up gateway HTTP handler")
+			return xerrors.Errorf("failed to set up gateway HTTP handler: %w", err)
 		}
 
 		stopFunc, err := node.ServeRPC(handler, "lotus-gateway", maddr)
@@ -258,7 +943,21 @@ var runCmd = &cli.Command{
 			nil,
 			node.ShutdownHandler{Component: "rpc", StopFunc: stopFunc},
 			node.ShutdownHandler{Component: "rpc-handler", StopFunc: handler.Shutdown},
+			node.ShutdownHandler{Component: "gateway", StopFunc: gwapi.Shutdown},
 		)
 		return nil
 	},
 }
+
+// parseBlockedAddresses parses the addresses given with --blocked-address.
+func parseBlockedAddresses(blocked []string) ([]address.Address, error) {
+	addrs := make([]address.Address, 0, len(blocked))
+	for _, a := range blocked {
+		addr, err := address.NewFromString(a)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid blocked address %q: %w", a, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// actorCacheResubscribeDelay is the wait before resubscribing to head changes on the target after
+// the actor cache's subscription fails or ends.
+const actorCacheResubscribeDelay = 5 * time.Second
+
+// actorCache is the cache of StateGetActor responses. Every entry is evicted whenever the head
+// changes, so that actor state from a reverted tipset is never served, and the cache is only used
+// while the gateway is subscribed to head changes on the target.
+type actorCache struct {
+	*cache[actorCacheKey, types.Actor]
+	watching atomic.Bool
+	// keepStale keeps the entries when the subscription ends, to be served stale while the target
+	// is unavailable, see WithServeStaleOnOutage; they're still evicted by the next head change
+	keepStale bool
+}
+
+func newActorCache(size int, ttl, jitter time.Duration, budget *cacheBudget) *actorCache {
+	c := &actorCache{cache: newCache[actorCacheKey, types.Actor](actorCacheName, size, jitter, budget)}
+	c.ttl = ttl
+	return c
+}
+
+// watchHeads evicts every entry from the cache on each head change on the target until ctx is done,
+// resubscribing if the subscription fails or ends. The cache isn't used while there's no
+// subscription, as its entries can't be kept in step with the head.
+func (c *actorCache) watchHeads(ctx context.Context, server v1api.FullNode) {
+	for ctx.Err() == nil {
+		notifs, err := server.ChainNotify(ctx)
+		if err != nil {
+			log.Warnw("failed to subscribe to head changes for the actor cache", "error", err)
+		} else {
+			c.watch(ctx, notifs)
+			c.watching.Store(false)
+			if !c.keepStale {
+				c.purge()
+			}
+		}
+
+		select {
+		case <-time.After(actorCacheResubscribeDelay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// watch evicts every entry from the cache on each head change in notifs until the subscription ends
+// or ctx is done.
+func (c *actorCache) watch(ctx context.Context, notifs <-chan []*api.HeadChange) {
+	for {
+		select {
+		case _, ok := <-notifs:
+			if !ok {
+				return
+			}
+			c.purge()
+			c.watching.Store(true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// getOrFetch is like cache.getOrFetch, except that fetch is called directly, without caching its
+// result, when the cache can't be used.
+func (c *actorCache) getOrFetch(ctx context.Context, key actorCacheKey, fetch func() (*types.Actor, error)) (*types.Actor, error) {
+	if !c.watching.Load() {
+		return fetch()
+	}
+	act, err := c.cache.getOrFetch(ctx, key, func() (types.Actor, error) {
+		act, err := fetch()
+		if err != nil {
+			return types.Actor{}, err
+		}
+		return *act, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &act, nil
+}
+
+// serveStale is like the serveStale function, for the actor cache, which may be nil.
+func (c *actorCache) serveStale(ctx context.Context, gw *Node, key actorCacheKey, err error) (*types.Actor, error) {
+	if c == nil {
+		return nil, err
+	}
+	act, err := serveStale(ctx, gw, c.cache, key, err)
+	if err != nil {
+		return nil, err
+	}
+	return &act, nil
+}
@@ -0,0 +1,50 @@
+package gateway
+
+import "reflect"
+
+// exceedsEntries returns true if v holds more than max entries, counted across every map, slice and
+// array reachable from it. Byte slices and strings count as a single value rather than as entries.
+// The walk stops as soon as the count exceeds max, so an oversized state isn't walked in full.
+func exceedsEntries(v interface{}, max int) bool {
+	remaining := max
+	return !walkEntries(reflect.ValueOf(v), &remaining)
+}
+
+// walkEntries subtracts the entries of v from remaining, returning false once it drops below zero.
+func walkEntries(v reflect.Value, remaining *int) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return true
+		}
+		return walkEntries(v.Elem(), remaining)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !walkEntries(v.Field(i), remaining) {
+				return false
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return true
+		}
+		if *remaining -= v.Len(); *remaining < 0 {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !walkEntries(v.Index(i), remaining) {
+				return false
+			}
+		}
+	case reflect.Map:
+		if *remaining -= v.Len(); *remaining < 0 {
+			return false
+		}
+		for iter := v.MapRange(); iter.Next(); {
+			if !walkEntries(iter.Value(), remaining) {
+				return false
+			}
+		}
+	}
+	return true
+}
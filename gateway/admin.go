@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+// AdminPath is the path the admin API is served on by Handler, see WithAdminToken.
+const AdminPath = "/rpc/admin"
+
+// AdminStruct is the gateway's admin API, for operators to manage a running gateway. Every method
+// requires the admin permission, which is granted to callers presenting the admin token. It can be
+// used as a client of the admin API by passing its Internal field to jsonrpc.NewClient.
+type AdminStruct struct {
+	Internal struct {
+		PurgeCaches func(ctx context.Context, names []string) (int, error) `perm:"admin"`
+		Connections func(ctx context.Context) ([]ConnectionStats, error)   `perm:"admin"`
+	}
+}
+
+// PurgeCaches calls Node.PurgeCaches.
+func (s *AdminStruct) PurgeCaches(ctx context.Context, names []string) (int, error) {
+	return s.Internal.PurgeCaches(ctx, names)
+}
+
+// Connections calls Node.Connections.
+func (s *AdminStruct) Connections(ctx context.Context) ([]ConnectionStats, error) {
+	return s.Internal.Connections(ctx)
+}
+
+// adminAPI implements the admin API on the gateway.
+type adminAPI struct {
+	gw *Node
+}
+
+func (a adminAPI) PurgeCaches(ctx context.Context, names []string) (int, error) {
+	return a.gw.PurgeCaches(ctx, names...)
+}
+
+func (a adminAPI) Connections(context.Context) ([]ConnectionStats, error) {
+	return a.gw.Connections(), nil
+}
+
+// adminHandler serves the admin API of gw to callers presenting token as a bearer token.
+func adminHandler(gw *Node, token string, rpcopts ...jsonrpc.ServerOption) http.Handler {
+	var admin AdminStruct
+	auth.PermissionedProxy([]auth.Permission{api.PermAdmin}, nil, adminAPI{gw: gw}, &admin.Internal)
+
+	rpcServer := jsonrpc.NewServer(rpcopts...)
+	rpcServer.Register("Filecoin", &admin)
+	return &auth.Handler{
+		Verify: func(_ context.Context, t string) ([]auth.Permission, error) {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+				return nil, xerrors.New("invalid admin token")
+			}
+			return []auth.Permission{api.PermAdmin}, nil
+		},
+		Next: rpcServer.ServeHTTP,
+	}
+}
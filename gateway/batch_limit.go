@@ -0,0 +1,301 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// ErrBatchTooExpensive is returned for a JSON-RPC batch request whose calls would together consume
+// more rate limit tokens than the gateway allows for a single batch.
+var ErrBatchTooExpensive = errors.New("batch request too expensive")
+
+// ErrBatchTooLarge is returned for a JSON-RPC batch request made up of more calls than the gateway
+// allows for a single batch.
+var ErrBatchTooLarge = errors.New("batch request too large")
+
+// methodRateLimitTokens are the rate limit tokens consumed by each method that consumes fewer than
+// MaxRateLimitTokens, used to estimate the cost of a batch request before any of its calls are
+// made. Methods that aren't listed are estimated to consume MaxRateLimitTokens, so the table must
+// be kept in step with the methods' implementations for the estimate not to be too low.
+var methodRateLimitTokens = map[string]int{
+	"ChainGetBlock":                        chainRateLimitTokens,
+	"ChainGetBlockMessages":                chainRateLimitTokens,
+	"ChainGetEvents":                       chainRateLimitTokens,
+	"ChainGetEventsStream":                 chainRateLimitTokens,
+	"ChainGetGenesis":                      chainRateLimitTokens,
+	"ChainGetMessage":                      chainRateLimitTokens,
+	"ChainGetMessagesInTipset":             chainRateLimitTokens,
+	"ChainGetNode":                         chainRateLimitTokens,
+	"ChainGetParentMessages":               chainRateLimitTokens,
+	"ChainGetParentReceipts":               chainRateLimitTokens,
+	"ChainGetPath":                         chainRateLimitTokens,
+	"ChainGetTipSet":                       chainRateLimitTokens,
+	"ChainGetTipSetAfterHeight":            chainRateLimitTokens,
+	"ChainGetTipSetByHeight":               chainRateLimitTokens,
+	"ChainHasObj":                          chainRateLimitTokens,
+	"ChainHead":                            chainRateLimitTokens,
+	"ChainNotify":                          chainRateLimitTokens,
+	"ChainReadObj":                         chainRateLimitTokens,
+	"EthBlockNumber":                       chainRateLimitTokens,
+	"EthChainId":                           basicRateLimitTokens,
+	"EthGasPrice":                          chainRateLimitTokens,
+	"EthGetBlockTransactionCountByHash":    chainRateLimitTokens,
+	"EthGetMessageCidByTransactionHash":    chainRateLimitTokens,
+	"EthGetTransactionByBlockHashAndIndex": chainRateLimitTokens,
+	"EthMaxPriorityFeePerGas":              chainRateLimitTokens,
+	"EthSyncing":                           basicRateLimitTokens,
+	"F3GetCertificate":                     basicRateLimitTokens,
+	"F3GetLatestCertificate":               basicRateLimitTokens,
+	"F3GetPowerTableByInstance":            basicRateLimitTokens,
+	"GasEstimateGasPremium":                chainRateLimitTokens,
+	"MsigGetAvailableBalance":              walletRateLimitTokens,
+	"MsigGetPending":                       walletRateLimitTokens,
+	"MsigGetVested":                        walletRateLimitTokens,
+	"MsigGetVestingSchedule":               walletRateLimitTokens,
+	"NetListening":                         basicRateLimitTokens,
+	"StateMinerSectorCount":                chainRateLimitTokens,
+	"StateReplay":                          chainRateLimitTokens,
+	"Version":                              basicRateLimitTokens,
+	"Web3ClientVersion":                    basicRateLimitTokens,
+}
+
+// ethMethodAliases maps the Ethereum JSON-RPC names of methods, such as eth_call, to the names
+// they're registered under.
+var ethMethodAliases = func() methodAliases {
+	aliases := make(methodAliases)
+	api.CreateEthRPCAliases(aliases)
+	return aliases
+}()
+
+type methodAliases map[string]string
+
+func (a methodAliases) AliasMethod(alias, original string) {
+	a[alias] = original
+}
+
+// methodCost returns the estimated rate limit tokens consumed by a call to the JSON-RPC method.
+func methodCost(method string) int {
+	if original, ok := ethMethodAliases[method]; ok {
+		method = original
+	}
+	if tokens, ok := methodRateLimitTokens[strings.TrimPrefix(method, "Filecoin.")]; ok {
+		return tokens
+	}
+	return MaxRateLimitTokens
+}
+
+// batchRequestHandler marks the context of JSON-RPC batch requests made over HTTP, so that the
+// calls they're made up of are limited by the gateway's batch call limit, and admits or rejects
+// each batch request as a whole before any of its calls are made: batches of more than maxSize
+// calls, or whose estimated cost exceeds maxCost, are rejected, unless the limit is 0, and the rest
+// are charged their estimated cost against the global rate limit up front. Websocket connections
+// are passed through untouched as their requests aren't batched.
+type batchRequestHandler struct {
+	next    http.Handler
+	gw      *Node
+	maxCost int
+	maxSize int
+}
+
+func (h batchRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	// a batch request is a JSON array; leading whitespace is insignificant so it's dropped
+	br := bufio.NewReader(r.Body)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			break
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			_ = br.UnreadByte()
+			if b == '[' {
+				r = r.WithContext(context.WithValue(r.Context(), batchRequestKey, true))
+			}
+			break
+		}
+	}
+	var body io.Reader = br
+	if isBatchCall(r.Context()) {
+		buf, err := io.ReadAll(br)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.admit(r.Context(), buf); err != nil {
+			writeBatchError(w, err)
+			return
+		}
+		body = bytes.NewReader(buf)
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	h.next.ServeHTTP(w, r)
+}
+
+// admit returns ErrBatchTooLarge if the batch request has more than maxSize calls, or
+// ErrBatchTooExpensive if their estimated cost exceeds maxCost, and otherwise charges the cost
+// against the global rate limit, see limitBatch. Requests that can't be decoded are left for the
+// JSON-RPC server to reject.
+func (h batchRequestHandler) admit(ctx context.Context, batch []byte) error {
+	var reqs []struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(batch, &reqs) != nil {
+		return nil
+	}
+	if h.maxSize > 0 && len(reqs) > h.maxSize {
+		return xerrors.Errorf("%w: %d calls, the maximum is %d", ErrBatchTooLarge, len(reqs), h.maxSize)
+	}
+	var cost int
+	for _, req := range reqs {
+		cost += methodCost(req.Method)
+	}
+	if h.maxCost > 0 && cost > h.maxCost {
+		return xerrors.Errorf("%w: the %d calls would consume %d rate limit tokens, the maximum is %d", ErrBatchTooExpensive, len(reqs), cost, h.maxCost)
+	}
+	return h.gw.limitBatch(ctx, cost)
+}
+
+// limitBatch charges the estimated cost of a whole JSON-RPC batch request against the global rate
+// limit, such that the batch is admitted or rejected as a whole rather than running until one of
+// its calls times out waiting on the limit; the calls that make up an admitted batch then skip the
+// global rate limit. Most batches cost more than the limiter's burst, which a single WaitN can't
+// wait for, so the cost is reserved in burst sized parts, all of which are given back if the batch
+// can't be admitted within the rate limit timeout.
+func (gw *Node) limitBatch(ctx context.Context, tokens int) error {
+	if gw.rateLimiter.Limit() == rate.Inf {
+		return nil
+	}
+
+	now := time.Now()
+	deadline := now.Add(gw.currentSettings().rateLimitTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for i := len(reservations) - 1; i >= 0; i-- {
+			reservations[i].CancelAt(now)
+		}
+	}
+	var delay time.Duration
+	for remaining := tokens; remaining > 0; remaining -= gw.rateLimiter.Burst() {
+		r := gw.rateLimiter.ReserveN(now, min(remaining, gw.rateLimiter.Burst()))
+		if !r.OK() {
+			cancel()
+			return rateLimitRejection{fmt.Errorf("server busy. rate: the batch request's %d tokens can't be reserved", tokens)}
+		}
+		reservations = append(reservations, r)
+		if delay = r.DelayFrom(now); now.Add(delay).After(deadline) {
+			cancel()
+			stats.Record(ctx, metrics.RateLimitCount.M(1))
+			return rateLimitRejection{fmt.Errorf("server busy. rate: the batch request's %d tokens would exceed the rate limit timeout", tokens)}
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return rateLimitRejection{fmt.Errorf("server busy. %w", ctx.Err())}
+	}
+}
+
+// writeBatchError responds to a batch request that's rejected as a whole with a single JSON-RPC
+// error, as the JSON-RPC server does for batches it can't process.
+func writeBatchError(w http.ResponseWriter, err error) {
+	type rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Jsonrpc string      `json:"jsonrpc"`
+		ID      interface{} `json:"id"`
+		Error   rpcError    `json:"error"`
+	}{
+		Jsonrpc: "2.0",
+		Error:   rpcError{Code: -32600, Message: err.Error()}, // invalid request
+	})
+}
+
+func (h batchRequestHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
+
+func isBatchCall(ctx context.Context) bool {
+	batch, _ := ctx.Value(batchRequestKey).(bool)
+	return batch
+}
+
+// limitBatchCallsV1 wraps the v1 gateway API such that calls made as part of a JSON-RPC batch
+// request over HTTP wait for one of the limited number of batch calls that may be in flight at
+// once, so that many concurrent batches can't collectively exhaust the gateway and the target. A
+// call that can't be started within the rate limit timeout is rejected. Calls made on their own
+// aren't affected, and the target calls batch methods fan out to are bounded separately, see
+// WithBatchFanoutConcurrency.
+func limitBatchCallsV1(gw *Node, v1 api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	gw.limitBatchCalls(v1, &out)
+	return &out
+}
+
+// limitBatchCallsV2 wraps the v2 gateway API such that calls made as part of a JSON-RPC batch
+// request wait for one of the limited number of batch calls that may be in flight at once, as for
+// limitBatchCallsV1.
+func limitBatchCallsV2(gw *Node, v2 v2api.Gateway) v2api.Gateway {
+	var out v2api.GatewayStruct
+	gw.limitBatchCalls(v2, &out)
+	return &out
+}
+
+func (gw *Node) limitBatchCalls(in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if fn.Type().NumIn() == 0 || fn.Type().In(0) != contextType || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := contextArg(args)
+			if !isBatchCall(ctx) {
+				return fn.Call(args)
+			}
+			release, err := gw.acquireSlot(ctx, gw.batchCalls, "batch")
+			if err != nil {
+				return errorResults(fn.Type(), err)
+			}
+			defer release()
+			return fn.Call(args)
+		})
+	})
+}
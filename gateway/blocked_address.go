@@ -0,0 +1,144 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrForbiddenAddress is returned for calls about an address the gateway is configured to refuse
+// queries about, see WithBlockedAddresses.
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+var addressType = reflect.TypeOf(address.Address{})
+
+// blockedAddressResolveInterval is how often blocked addresses whose other form couldn't be looked
+// up are tried again, such as those of actors created after the gateway started.
+const blockedAddressResolveInterval = 10 * time.Minute
+
+// blockedAddresses is the set of addresses queries are refused about. Each listed address is
+// blocked in both its ID and robust forms, once the other form has been looked up on the target.
+type blockedAddresses struct {
+	lk         sync.RWMutex
+	blocked    map[address.Address]bool
+	unresolved []address.Address // listed addresses whose other form hasn't been found yet
+}
+
+func newBlockedAddresses(addrs []address.Address) *blockedAddresses {
+	b := &blockedAddresses{blocked: make(map[address.Address]bool, len(addrs))}
+	for _, addr := range addrs {
+		if b.blocked[addr] {
+			continue
+		}
+		b.blocked[addr] = true
+		b.unresolved = append(b.unresolved, addr)
+	}
+	return b
+}
+
+func (b *blockedAddresses) isBlocked(addr address.Address) bool {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	return b.blocked[addr]
+}
+
+// resolve looks up the other form of each listed address that hasn't been found yet: the ID
+// address of a robust address, and the robust address of an ID address. Addresses that can't be
+// looked up, such as those of actors that don't exist yet, are kept to be tried again, and
+// resolve reports whether there are any left.
+func (b *blockedAddresses) resolve(ctx context.Context, server v1api.FullNode) bool {
+	b.lk.RLock()
+	unresolved := append([]address.Address(nil), b.unresolved...)
+	b.lk.RUnlock()
+
+	var remaining []address.Address
+	for _, addr := range unresolved {
+		var other address.Address
+		var err error
+		if addr.Protocol() == address.ID {
+			other, err = server.StateLookupRobustAddress(ctx, addr, types.EmptyTSK)
+		} else {
+			other, err = server.StateLookupID(ctx, addr, types.EmptyTSK)
+		}
+		if err != nil {
+			log.Debugw("couldn't resolve blocked address", "address", addr, "error", err)
+			remaining = append(remaining, addr)
+			continue
+		}
+
+		b.lk.Lock()
+		b.blocked[other] = true
+		b.lk.Unlock()
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.unresolved = remaining
+	return len(remaining) > 0
+}
+
+// run resolves the listed addresses, then again every interval while any are left unresolved,
+// until ctx is done.
+func (b *blockedAddresses) run(ctx context.Context, server v1api.FullNode, interval time.Duration) {
+	for b.resolve(ctx, server) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// blockAddressesV1 wraps the v1 gateway API such that calls with a blocked address as an argument
+// are rejected with ErrForbiddenAddress.
+func blockAddressesV1(v1 api.Gateway, blocked *blockedAddresses) api.Gateway {
+	var out api.GatewayStruct
+	rejectBlockedAddresses(v1, &out, blocked)
+	return &out
+}
+
+// blockAddressesV2 wraps the v2 gateway API such that calls with a blocked address as an argument
+// are rejected with ErrForbiddenAddress.
+func blockAddressesV2(v2 v2api.Gateway, blocked *blockedAddresses) v2api.Gateway {
+	var out v2api.GatewayStruct
+	rejectBlockedAddresses(v2, &out, blocked)
+	return &out
+}
+
+func rejectBlockedAddresses(in interface{}, outstr interface{}, blocked *blockedAddresses) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+		var params []int
+		for i := 0; i < fn.Type().NumIn(); i++ {
+			if fn.Type().In(i) == addressType {
+				params = append(params, i)
+			}
+		}
+		if len(params) == 0 {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			for _, i := range params {
+				if addr := args[i].Interface().(address.Address); blocked.isBlocked(addr) {
+					return errorResults(fn.Type(), xerrors.Errorf("%w: %s", ErrForbiddenAddress, addr))
+				}
+			}
+			return fn.Call(args)
+		})
+	})
+}
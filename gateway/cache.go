@@ -0,0 +1,330 @@
+package gateway
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+const (
+	minerInfoCacheName = "StateMinerInfo"
+	ethBlockCacheName  = "EthBlock"
+	actorCacheName     = "StateGetActor"
+	tipSetCacheName    = "ChainGetTipSetByHeight"
+)
+
+// cache is a size bounded LRU cache of successful target responses. Each cache has a name which is
+// used to tag its hit, miss and hit ratio metrics.
+type cache[K comparable, V any] struct {
+	name    string
+	lru     *lru.Cache[K, cacheEntry[V]]
+	flights flightGroup[K, V]
+	// jitter is the maximum random delay before fetching a value on a cache miss
+	jitter time.Duration
+	// budget, if set, bounds the memory used by this and the gateway's other caches
+	budget *cacheBudget
+	// ttl, if set, is the age beyond which entries are no longer served, other than stale
+	ttl time.Duration
+	// generation is incremented by each purge, so that values fetched before it aren't cached;
+	// purgeLk makes comparing it and adding a value atomic with respect to purges
+	generation atomic.Uint64
+	purgeLk    sync.Mutex
+	// hits and lookups count lookups since the cache was created, for its hit ratio
+	hits    atomic.Int64
+	lookups atomic.Int64
+}
+
+type cacheEntry[V any] struct {
+	value  V
+	added  time.Time
+	budget *budgetEntry
+}
+
+func newCache[K comparable, V any](name string, size int, jitter time.Duration, budget *cacheBudget) *cache[K, V] {
+	c := &cache[K, V]{name: name, jitter: jitter, budget: budget}
+	var err error
+	c.lru, err = lru.NewWithEvict[K, cacheEntry[V]](size, func(_ K, e cacheEntry[V]) {
+		if e.budget != nil {
+			c.budget.remove(e.budget)
+		}
+	})
+	if err != nil {
+		// only returned for a non-positive size, which we don't construct caches for
+		panic(err)
+	}
+	return c
+}
+
+func (c *cache[K, V]) get(ctx context.Context, key K) (V, bool) {
+	e, ok := c.lru.Get(key)
+	ok = ok && !c.expired(e)
+	m := metrics.GatewayCacheMiss
+	if ok {
+		m = metrics.GatewayCacheHit
+		if e.budget != nil {
+			c.budget.touch(e.budget)
+		}
+	}
+	c.record(ctx, m)
+	c.recordHitRatio(ctx, ok)
+	return e.value, ok
+}
+
+// expired returns true if e is older than the cache's ttl.
+func (c *cache[K, V]) expired(e cacheEntry[V]) bool {
+	return c.ttl > 0 && time.Since(e.added) > c.ttl
+}
+
+// getStale returns the cached value for key along with the time it was added to the cache, unless
+// it was added more than maxAge ago, where a maxAge of 0 allows entries of any age. It's used when
+// the target is unavailable, and records a stale hit rather than a hit or miss.
+func (c *cache[K, V]) getStale(ctx context.Context, key K, maxAge time.Duration) (V, time.Time, bool) {
+	e, ok := c.lru.Get(key)
+	if ok && maxAge > 0 && time.Since(e.added) > maxAge {
+		var zero V
+		return zero, time.Time{}, false
+	}
+	if ok {
+		c.record(ctx, metrics.GatewayCacheStaleHit)
+	}
+	return e.value, e.added, ok
+}
+
+func (c *cache[K, V]) add(key K, v V) {
+	e := cacheEntry[V]{value: v, added: time.Now()}
+	if c.budget != nil {
+		size := cacheEntrySize(v)
+		if size > c.budget.max {
+			return // too big to cache at all
+		}
+		// replacing an entry doesn't evict it, so it's released from the budget here
+		if old, ok := c.lru.Peek(key); ok && old.budget != nil {
+			c.budget.remove(old.budget)
+		}
+		e.budget = c.budget.add(size, func() { c.lru.Remove(key) })
+	}
+	c.lru.Add(key, e)
+}
+
+// purge evicts every entry from the cache, returning the number of entries evicted. Fetches in
+// flight are neither cached nor joined by later misses, as their results may predate the purge.
+func (c *cache[K, V]) purge() int {
+	c.purgeLk.Lock()
+	defer c.purgeLk.Unlock()
+
+	c.generation.Add(1)
+	c.flights.forget()
+	n := c.lru.Len()
+	c.lru.Purge()
+	return n
+}
+
+func (c *cache[K, V]) record(ctx context.Context, m *stats.Int64Measure) {
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.CacheName, c.name)}, m.M(1))
+}
+
+// recordHitRatio counts a lookup, a hit if hit is set, and records the cache's hit ratio.
+func (c *cache[K, V]) recordHitRatio(ctx context.Context, hit bool) {
+	hits := c.hits.Load()
+	if hit {
+		hits = c.hits.Add(1)
+	}
+	lookups := c.lookups.Add(1)
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.CacheName, c.name)}, metrics.GatewayCacheHitRatio.M(float64(hits)/float64(lookups)))
+}
+
+// getOrFetch returns the cached value for key, calling fetch and caching its result on a miss. Only
+// successful results are cached. Concurrent misses for the same key are coalesced into a single
+// call to fetch, which is delayed by up to the cache's jitter so that the misses for many keys that
+// follow a head change reach the target spread out rather than all at once. A result fetched while
+// the cache is purged is returned but not cached.
+func (c *cache[K, V]) getOrFetch(ctx context.Context, key K, fetch func() (V, error)) (V, error) {
+	return c.getOrFetchIf(ctx, key, fetch, nil)
+}
+
+// getOrFetchIf is like getOrFetch, except that a fetched value is only cached if keep, when set,
+// returns true for it.
+func (c *cache[K, V]) getOrFetchIf(ctx context.Context, key K, fetch func() (V, error), keep func(V) bool) (V, error) {
+	if v, ok := c.get(ctx, key); ok {
+		return v, nil
+	}
+	return c.flights.do(ctx, key, func() (V, error) {
+		gen := c.generation.Load()
+		if e, ok := c.lru.Peek(key); ok && !c.expired(e) {
+			return e.value, nil // added by a call that completed since the miss
+		}
+		if c.jitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(c.jitter)))):
+			case <-ctx.Done():
+				var zero V
+				return zero, ctx.Err()
+			}
+		}
+		v, err := fetch()
+		if err != nil {
+			return v, err
+		}
+		if keep != nil && !keep(v) {
+			return v, nil
+		}
+		c.purgeLk.Lock()
+		if c.generation.Load() == gen {
+			c.add(key, v)
+		}
+		c.purgeLk.Unlock()
+		return v, nil
+	})
+}
+
+// purgeable is implemented by each of the gateway's caches.
+type purgeable interface {
+	purge() int
+}
+
+// PurgeCaches evicts every entry from the named caches, or from all of the gateway's caches if no
+// names are given, returning the total number of entries evicted. Cache names are those used to tag
+// the cache metrics, e.g. "StateMinerInfo"; naming a cache that isn't enabled is an error, and
+// nothing is purged. It's served to operators by the admin API, see WithAdminToken.
+func (gw *Node) PurgeCaches(ctx context.Context, names ...string) (int, error) {
+	caches := make(map[string]purgeable)
+	if gw.minerInfoCache != nil {
+		caches[gw.minerInfoCache.name] = gw.minerInfoCache
+	}
+	if gw.ethBlockCache != nil {
+		caches[gw.ethBlockCache.name] = gw.ethBlockCache
+	}
+	if gw.actorCache != nil {
+		caches[gw.actorCache.name] = gw.actorCache
+	}
+	if gw.tipSetCache != nil {
+		caches[gw.tipSetCache.name] = gw.tipSetCache
+	}
+
+	if len(names) == 0 {
+		for name := range caches {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if _, ok := caches[name]; !ok {
+			return 0, xerrors.Errorf("unknown or disabled cache %q", name)
+		}
+	}
+
+	var evicted int
+	for _, name := range names {
+		c, ok := caches[name]
+		if !ok {
+			continue // named more than once
+		}
+		n := c.purge()
+		log.Infow("purged gateway cache", "cache", name, "evicted", n)
+		evicted += n
+		delete(caches, name)
+	}
+	return evicted, nil
+}
+
+type minerInfoCacheKey struct {
+	miner address.Address
+	tsk   types.TipSetKey
+}
+
+type minerInfoCache = cache[minerInfoCacheKey, api.MinerInfo]
+
+// ethBlockCacheKey identifies a block in the Ethereum block cache by either its number, for
+// EthGetBlockRange and EthGetBlockByNumber, or its hash, for EthGetBlockByHash.
+type ethBlockCacheKey struct {
+	number     ethtypes.EthUint64
+	hash       ethtypes.EthHash
+	fullTxInfo bool
+}
+
+type ethBlockCache = cache[ethBlockCacheKey, ethtypes.EthBlock]
+
+// ethFinalBlock returns the block identified by key from the Ethereum block cache, if it's enabled
+// and the block is cached, or else calls fetch for it. A fetched block is cached if it's final,
+// that is at least policy.ChainFinality epochs behind the head returned by head, which is only
+// called once the block has been fetched, to decide whether to cache it. Misses are coalesced and
+// jittered as by cache.getOrFetch, and served stale while the target is unavailable.
+func (gw *Node) ethFinalBlock(ctx context.Context, key ethBlockCacheKey, fetch func() (ethtypes.EthBlock, error), head func() (*types.TipSet, error)) (ethtypes.EthBlock, error) {
+	c := gw.ethBlockCache
+	if c == nil {
+		return fetch()
+	}
+	blk, err := c.getOrFetchIf(ctx, key, fetch, func(blk ethtypes.EthBlock) bool {
+		ts, err := head()
+		return err == nil && abi.ChainEpoch(blk.Number) <= ts.Height()-policy.ChainFinality
+	})
+	if err != nil {
+		return serveStale(ctx, gw, c, key, err)
+	}
+	return blk, nil
+}
+
+// ethBlockNumberKey returns the Ethereum block cache key for blkNum, if it's a block number rather
+// than a block tag such as "latest", "pending" or "finalized", which refer to a different block as
+// the chain advances and so are never cached.
+func ethBlockNumberKey(blkNum string, fullTxInfo bool) (ethBlockCacheKey, bool) {
+	var num ethtypes.EthUint64
+	if err := num.UnmarshalJSON([]byte(`"` + blkNum + `"`)); err != nil {
+		return ethBlockCacheKey{}, false
+	}
+	return ethBlockCacheKey{number: num, fullTxInfo: fullTxInfo}, true
+}
+
+// tipSetCacheKey identifies a ChainGetTipSetByHeight, or if after is set
+// ChainGetTipSetAfterHeight, result in the tipset cache by the requested height and the tipset the
+// lookup was anchored at.
+type tipSetCacheKey struct {
+	height abi.ChainEpoch
+	anchor types.TipSetKey
+	after  bool
+}
+
+type tipSetCache = cache[tipSetCacheKey, *types.TipSet]
+
+// finalTipSetAtHeight returns the tipset identified by key from the tipset cache, if it's enabled
+// and the tipset is cached, or else calls fetch for it. A fetched tipset is cached if both it and
+// the requested height are at least policy.ChainFinality epochs behind the head, such that it can't
+// be replaced by a reorg. Misses are coalesced and jittered as by cache.getOrFetch, and served
+// stale while the target is unavailable.
+func (gw *Node) finalTipSetAtHeight(ctx context.Context, key tipSetCacheKey, fetch func() (*types.TipSet, error)) (*types.TipSet, error) {
+	c := gw.tipSetCache
+	if c == nil {
+		return fetch()
+	}
+	ts, err := c.getOrFetchIf(ctx, key, fetch, func(ts *types.TipSet) bool {
+		if ts == nil {
+			return false
+		}
+		head, err := gw.v1Proxy.server.ChainHead(ctx)
+		return err == nil && max(key.height, ts.Height()) <= head.Height()-policy.ChainFinality
+	})
+	if err != nil {
+		return serveStale(ctx, gw, c, key, err)
+	}
+	return ts, nil
+}
+
+type actorCacheKey struct {
+	actor address.Address
+	tsk   types.TipSetKey
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+)
+
+// cacheBudget bounds the estimated memory used by the entries of all of the gateway's caches. The
+// entries of every cache are tracked in a single least-recently-used order, so that when adding an
+// entry takes the total over budget the entries evicted to make room are the least recently used
+// ones across all caches, rather than only those of the cache being added to.
+type cacheBudget struct {
+	lk      sync.Mutex
+	max     int64
+	used    int64
+	entries *list.List // of *budgetEntry, most recently used first
+}
+
+// budgetEntry is the budget's record of a cache entry.
+type budgetEntry struct {
+	size  int64
+	evict func() // removes the entry from its cache
+	elem  *list.Element
+}
+
+func newCacheBudget(maxBytes int64) *cacheBudget {
+	return &cacheBudget{max: maxBytes, entries: list.New()}
+}
+
+// add records a cache entry of size bytes as the most recently used, evicting the least recently
+// used entries of any cache until the total is back within budget.
+func (b *cacheBudget) add(size int64, evict func()) *budgetEntry {
+	e := &budgetEntry{size: size, evict: evict}
+
+	b.lk.Lock()
+	e.elem = b.entries.PushFront(e)
+	b.used += size
+	var evicted []*budgetEntry
+	for b.used > b.max && b.entries.Len() > 0 {
+		victim := b.entries.Back().Value.(*budgetEntry)
+		b.removeLocked(victim)
+		evicted = append(evicted, victim)
+	}
+	b.lk.Unlock()
+
+	// evict outside of the lock, as a cache calls back into the budget when an entry is removed
+	for _, victim := range evicted {
+		victim.evict()
+	}
+	return e
+}
+
+// touch marks a cache entry as the most recently used.
+func (b *cacheBudget) touch(e *budgetEntry) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if e.elem != nil {
+		b.entries.MoveToFront(e.elem)
+	}
+}
+
+// remove stops tracking a cache entry once it's been removed from its cache. An entry evicted by the
+// budget itself is already untracked.
+func (b *cacheBudget) remove(e *budgetEntry) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.removeLocked(e)
+}
+
+func (b *cacheBudget) removeLocked(e *budgetEntry) {
+	if e.elem == nil {
+		return
+	}
+	b.entries.Remove(e.elem)
+	e.elem = nil
+	b.used -= e.size
+}
+
+// usedBytes returns the estimated memory used by the entries of all caches.
+func (b *cacheBudget) usedBytes() int64 {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.used
+}
+
+// cacheEntrySize estimates the memory used by a cached value from the size of its JSON encoding,
+// which tracks the size of the variable length data, such as transactions, that dominates it.
+func cacheEntrySize(v any) int64 {
+	const overhead = 64 // for the entry, its key and its place in the LRU order
+	b, err := json.Marshal(v)
+	if err != nil {
+		return overhead
+	}
+	return int64(len(b)) + overhead
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// CBORContentType is the media type of CBOR encoded responses. A client lists it in the Accept
+// header of a request to have the result served CBOR encoded, see WithCBORResponses.
+const CBORContentType = "application/cbor"
+
+var cborMarshalerType = reflect.TypeOf((*cbg.CBORMarshaler)(nil)).Elem()
+
+// cborResultTypes returns the result type of each method of the API interface iface that supports
+// CBOR encoding, keyed by its JSON-RPC method name.
+func cborResultTypes(iface reflect.Type) map[string]reflect.Type {
+	results := make(map[string]reflect.Type)
+	for i := 0; i < iface.NumMethod(); i++ {
+		m := iface.Method(i)
+		if m.Type.NumOut() != 2 {
+			continue
+		}
+		out := m.Type.Out(0)
+		if out.Kind() == reflect.Ptr && out.Implements(cborMarshalerType) {
+			results["Filecoin."+m.Name] = out
+		}
+	}
+	return results
+}
+
+// cborResponseHandler serves the result of a JSON-RPC request over HTTP CBOR encoded, rather than
+// JSON encoded, when the client accepts CBORContentType and the method's result type supports CBOR
+// encoding. The response body is then the CBOR encoding of the result alone. Anything else,
+// including errors, batch requests, null results and websocket connections, is served as JSON.
+type cborResponseHandler struct {
+	next    http.Handler
+	methods map[string]map[string]reflect.Type // by path, then method
+}
+
+func newCBORResponseHandler(next http.Handler) *cborResponseHandler {
+	return &cborResponseHandler{next: next, methods: map[string]map[string]reflect.Type{
+		"/rpc/v1": cborResultTypes(reflect.TypeOf((*api.Gateway)(nil)).Elem()),
+		"/rpc/v2": cborResultTypes(reflect.TypeOf((*v2api.Gateway)(nil)).Elem()),
+	}}
+}
+
+func (h *cborResponseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	methods, ok := h.methods[strings.TrimSuffix(r.URL.Path, "/")]
+	if r.Method != http.MethodPost || !ok || !acceptsCBOR(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		req.Method = "" // a batch request
+	}
+	resultType, ok := methods[req.Method]
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	buf := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+	h.next.ServeHTTP(buf, r)
+
+	w.Header().Add("Vary", "Accept")
+	for key, values := range buf.header {
+		w.Header()[key] = values
+	}
+	if encoded, ok := cborResult(buf, resultType); ok {
+		w.Header().Set("Content-Type", CBORContentType)
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(encoded)
+		return
+	}
+	w.WriteHeader(buf.status)
+	_, _ = w.Write(buf.body.Bytes())
+}
+
+func (h *cborResponseHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
+
+// cborResult returns the CBOR encoding of the result of the JSON-RPC response buffered in buf,
+// decoded as resultType, or false if the response isn't a successful one with a non-null result.
+func cborResult(buf *bufferedResponseWriter, resultType reflect.Type) ([]byte, bool) {
+	if buf.status != http.StatusOK {
+		return nil, false
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(buf.body.Bytes(), &resp) != nil || resp.Error != nil || resp.Result == nil {
+		return nil, false
+	}
+
+	result := reflect.New(resultType)
+	if err := json.Unmarshal(resp.Result, result.Interface()); err != nil || result.Elem().IsNil() {
+		return nil, false
+	}
+	var encoded bytes.Buffer
+	if err := result.Elem().Interface().(cbg.CBORMarshaler).MarshalCBOR(&encoded); err != nil {
+		log.Warnw("failed to CBOR encode response, serving JSON", "error", err)
+		return nil, false
+	}
+	return encoded.Bytes(), true
+}
+
+// acceptsCBOR returns true if the Accept header of r lists CBORContentType.
+func acceptsCBOR(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && mediaType == CBORContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bufferedResponseWriter buffers a response so that it can be re-encoded before it's written.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	amt4 "github.com/filecoin-project/go-amt-ipld/v4"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrTooManyEvents is returned by ChainGetEvents and ChainGetEventsStream when an events root holds
+// more events than the gateway is configured to return.
+var ErrTooManyEvents = errors.New("too many events")
+
+// checkChainEventsCount enforces the maximum number of events returned for a single events root.
+func (gw *Node) checkChainEventsCount(n uint64) error {
+	if gw.chainEventsMax <= 0 || n <= uint64(gw.chainEventsMax) {
+		return nil
+	}
+	return xerrors.Errorf("%w: %d events, the maximum is %d", ErrTooManyEvents, n, gw.chainEventsMax)
+}
+
+// streamChainEvents sends the events under an events AMT root in chunks of at most chunkSize
+// events, reading the AMT from the target's blockstore as it goes so that only a single chunk is
+// held in memory at a time. The AMT root is loaded, and the event count checked, before returning,
+// so that an unknown root or too many events can still be reported as an error.
+func streamChainEvents(ctx context.Context, gw *Node, cio blockstore.ChainIO, root cid.Cid) (<-chan []types.Event, error) {
+	store := cbor.NewCborStore(blockstore.NewAPIBlockstore(cio))
+	evtArr, err := amt4.LoadAMT(ctx, store, root, amt4.UseTreeBitWidth(types.EventAMTBitwidth))
+	if err != nil {
+		return nil, xerrors.Errorf("load events amt: %w", err)
+	}
+	if err := gw.checkChainEventsCount(evtArr.Len()); err != nil {
+		return nil, err
+	}
+
+	chunkSize := gw.chainEventsChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChainEventsChunkSize
+	}
+
+	out := make(chan []types.Event)
+	go func() {
+		defer close(out)
+
+		send := func(chunk []types.Event) error {
+			select {
+			case out <- chunk:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		chunk := make([]types.Event, 0, chunkSize)
+		var sent bool
+		err := evtArr.ForEach(ctx, func(_ uint64, deferred *cbg.Deferred) error {
+			if len(chunk) == 0 && sent {
+				// the first chunk is paid for by the call, further chunks cost the same again
+				if err := gw.limit(ctx, chainRateLimitTokens); err != nil {
+					return err
+				}
+			}
+			var evt types.Event
+			if err := evt.UnmarshalCBOR(bytes.NewReader(deferred.Raw)); err != nil {
+				return err
+			}
+			chunk = append(chunk, evt)
+			if len(chunk) < chunkSize {
+				return nil
+			}
+			if err := send(chunk); err != nil {
+				return err
+			}
+			sent = true
+			chunk = make([]types.Event, 0, chunkSize)
+			return nil
+		})
+		if err == nil && len(chunk) > 0 {
+			err = send(chunk)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Warnw("failed to stream chain events", "root", root, "error", err)
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrTooManyChainNotifySubscribers is returned when a client calls ChainNotify while the gateway
+// already serves the maximum number of ChainNotify subscribers.
+var ErrTooManyChainNotifySubscribers = errors.New("too many ChainNotify subscribers")
+
+// chainNotifyHub serves every client ChainNotify subscription from a single ChainNotify
+// subscription on the target, which is made when the first client subscribes and closed once the
+// last one has gone.
+type chainNotifyHub struct {
+	server         v1api.FullNode
+	maxSubscribers int
+	// bufferSize is the number of head changes queued for each subscriber before it's considered not
+	// to be keeping up and is dropped, so that a slow client can't hold up delivery to the others
+	bufferSize int
+
+	lk      sync.Mutex
+	backend *chainNotifyBackend // nil while there are no subscribers
+}
+
+// chainNotifyBackend is a ChainNotify subscription on the target along with the clients it serves.
+type chainNotifyBackend struct {
+	cancel context.CancelFunc
+	head   *types.TipSet // the latest head, nil until the target's first notification
+	subs   map[chan []*api.HeadChange]struct{}
+}
+
+func newChainNotifyHub(server v1api.FullNode, maxSubscribers, bufferSize int) *chainNotifyHub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultChainNotifySubscriberBuffer
+	}
+	return &chainNotifyHub{server: server, maxSubscribers: maxSubscribers, bufferSize: bufferSize}
+}
+
+// subscribe returns a channel of head changes that, like ChainNotify on the target, starts with the
+// current head. The channel is closed when ctx is done, when the target subscription ends or when
+// the client falls too far behind.
+func (h *chainNotifyHub) subscribe(ctx context.Context) (<-chan []*api.HeadChange, error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	b := h.backend
+	if b == nil {
+		bctx, cancel := context.WithCancel(context.Background())
+		notifs, err := h.server.ChainNotify(bctx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		b = &chainNotifyBackend{cancel: cancel, subs: make(map[chan []*api.HeadChange]struct{})}
+		h.backend = b
+		go h.run(b, notifs)
+	} else if h.maxSubscribers > 0 && len(b.subs) >= h.maxSubscribers {
+		return nil, xerrors.Errorf("%w: the maximum is %d", ErrTooManyChainNotifySubscribers, h.maxSubscribers)
+	}
+
+	ch := make(chan []*api.HeadChange, h.bufferSize)
+	if b.head != nil {
+		// the target's first notification has been and gone, so the subscriber gets its own
+		ch <- []*api.HeadChange{{Type: store.HCCurrent, Val: b.head}}
+	}
+	b.subs[ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		h.unsubscribe(b, ch)
+	}()
+	return ch, nil
+}
+
+// unsubscribe removes a subscriber, closing the target subscription if it was the last one.
+func (h *chainNotifyHub) unsubscribe(b *chainNotifyBackend, ch chan []*api.HeadChange) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if _, ok := b.subs[ch]; !ok {
+		return // already dropped
+	}
+	delete(b.subs, ch)
+	close(ch)
+	if len(b.subs) == 0 && h.backend == b {
+		b.cancel()
+		h.backend = nil
+	}
+}
+
+// close closes the target subscription, if there is one. The subscribers' channels are closed
+// once the target has ended the subscription.
+func (h *chainNotifyHub) close() {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.backend != nil {
+		h.backend.cancel()
+		h.backend = nil
+	}
+}
+
+// run fans out the target's head changes to the subscribers of b until the target subscription
+// ends, at which point the remaining subscribers' channels are closed.
+func (h *chainNotifyHub) run(b *chainNotifyBackend, notifs <-chan []*api.HeadChange) {
+	for changes := range notifs {
+		h.lk.Lock()
+		for _, change := range changes {
+			if change.Type == store.HCCurrent || change.Type == store.HCApply {
+				b.head = change.Val
+			}
+		}
+		for ch := range b.subs {
+			select {
+			case ch <- changes:
+			default:
+				log.Warnw("dropping ChainNotify subscriber: not keeping up with head changes", "bufferSize", h.bufferSize)
+				delete(b.subs, ch)
+				close(ch)
+			}
+		}
+		if len(b.subs) == 0 && h.backend == b {
+			// every subscriber was dropped, there's no one left to serve
+			b.cancel()
+			h.backend = nil
+		}
+		h.lk.Unlock()
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+	if h.backend == b {
+		b.cancel()
+		h.backend = nil
+	}
+}
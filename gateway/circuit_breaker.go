@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// circuitBreakers are the circuit breakers on calls to the target, one for writes and one for
+// everything else, so that reads aren't held up by failing writes and recover on their own.
+type circuitBreakers struct {
+	reads  *circuitBreaker
+	writes *circuitBreaker
+}
+
+func newCircuitBreakers(threshold int, window, cooldown time.Duration) *circuitBreakers {
+	return &circuitBreakers{
+		reads:  newCircuitBreaker("reads", threshold, window, cooldown),
+		writes: newCircuitBreaker("writes", threshold, window, cooldown),
+	}
+}
+
+// wrapV1 wraps the v1 target such that calls are short-circuited while their circuit breaker is
+// open, and their results are observed by it otherwise.
+func (b *circuitBreakers) wrapV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	b.wrap(server, &out)
+	return &out
+}
+
+// wrapV2 wraps the v2 target such that calls are short-circuited while their circuit breaker is
+// open, and their results are observed by it otherwise.
+func (b *circuitBreakers) wrapV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	b.wrap(server, &out)
+	return &out
+}
+
+func (b *circuitBreakers) wrap(in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		breaker := b.reads
+		if targetWriteMethods[method] {
+			breaker = b.writes
+		}
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			if breaker.isOpen() {
+				return errorResults(fn.Type(), xerrors.Errorf("%w: %s short-circuited after repeated target failures", ErrBackendUnavailable, method))
+			}
+			results := fn.Call(args)
+			err, _ := results[errOut].Interface().(error)
+			breaker.observe(err)
+			return results
+		})
+	})
+}
+
+// run probes the target on behalf of both breakers until ctx is done, see circuitBreaker.run.
+func (b *circuitBreakers) run(ctx context.Context, gw *Node, server v1api.FullNode) {
+	probe := func(ctx context.Context) error {
+		_, err := server.ChainHead(ctx)
+		return err
+	}
+	gw.goBackground(func() { b.reads.run(ctx, probe) })
+	gw.goBackground(func() { b.writes.run(ctx, probe) })
+}
+
+// circuitBreaker opens after threshold consecutive calls to the target fail because it couldn't be
+// reached or didn't respond in time, within a window starting at the first of them, after which
+// calls are short-circuited with ErrBackendUnavailable instead of adding to the load on a target
+// that's struggling. Every cooldown while open the target is probed with ChainHead, and the breaker
+// closes once a probe succeeds.
+type circuitBreaker struct {
+	name      string // for logs and metrics
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+	tripped   chan struct{} // signalled when the breaker opens
+
+	lk           sync.Mutex
+	open         bool
+	failures     int
+	firstFailure time.Time
+}
+
+func newCircuitBreaker(name string, threshold int, window, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{
+		name:      name,
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       time.Now,
+		tripped:   make(chan struct{}, 1),
+	}
+	b.record(false)
+	return b
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.open
+}
+
+// observe notes the result of a call to the target. An error returned by the target, or success,
+// shows that the target is working and ends a run of failures.
+func (b *circuitBreaker) observe(err error) {
+	switch {
+	case isBackendUnavailable(err), errors.Is(err, ErrBackendTimeout), errors.Is(err, context.DeadlineExceeded):
+	case errors.Is(err, context.Canceled):
+		return // says nothing about the target
+	default:
+		b.lk.Lock()
+		b.failures = 0
+		b.lk.Unlock()
+		return
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.open {
+		return
+	}
+	now := b.now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures, b.firstFailure = 0, now
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+
+	log.Warnw("target failing, opening circuit breaker", "breaker", b.name, "failures", b.failures, "cooldown", b.cooldown, "error", err)
+	b.open = true
+	b.record(true)
+	select {
+	case b.tripped <- struct{}{}:
+	default:
+	}
+}
+
+// run probes the target with probe every cooldown while the breaker is open, closing it once a
+// probe succeeds, until ctx is done.
+func (b *circuitBreaker) run(ctx context.Context, probe func(context.Context) error) {
+	for {
+		select {
+		case <-b.tripped:
+		case <-ctx.Done():
+			return
+		}
+
+		for b.isOpen() {
+			select {
+			case <-time.After(b.cooldown):
+			case <-ctx.Done():
+				return
+			}
+
+			pctx, cancel := context.WithTimeout(ctx, b.cooldown)
+			err := probe(pctx)
+			cancel()
+			if err != nil {
+				log.Debugw("circuit breaker probe failed", "breaker", b.name, "error", err)
+				continue
+			}
+
+			b.lk.Lock()
+			b.open, b.failures = false, 0
+			b.record(false)
+			b.lk.Unlock()
+			log.Infow("target recovered, closing circuit breaker", "breaker", b.name)
+		}
+	}
+}
+
+// record sets the metrics.GatewayCircuitBreakerOpen gauge for the breaker.
+func (b *circuitBreaker) record(open bool) {
+	var v int64
+	if open {
+		v = 1
+	}
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(metrics.CircuitBreaker, b.name)}, metrics.GatewayCircuitBreakerOpen.M(v))
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"errors"
+	"reflect"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// ErrGatewayClosed is returned for every method called after the gateway has been closed.
+var ErrGatewayClosed = errors.New("gateway closed")
+
+// Close stops the gateway's background work, such as sampling the age of the head, dropping
+// unused per IP rate limiters and watching head changes for the actor cache, and waits for it to
+// exit. The target subscription serving ChainNotify clients is closed too. Methods called after
+// Close return ErrGatewayClosed. Calling it more than once is safe.
+func (gw *Node) Close() error {
+	gw.closed.Store(true)
+	gw.cancel()
+	gw.chainNotify.close()
+	gw.background.Wait()
+	return nil
+}
+
+// goBackground runs fn in a goroutine that Close waits for. fn must return once the gateway's
+// background context is cancelled.
+func (gw *Node) goBackground(fn func()) {
+	gw.background.Add(1)
+	go func() {
+		defer gw.background.Done()
+		fn()
+	}()
+}
+
+// closedV1 wraps the v1 gateway API such that methods are rejected once the gateway is closed.
+func closedV1(gw *Node, v1 api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	rejectClosed(gw, v1, &out)
+	return &out
+}
+
+// closedV2 wraps the v2 gateway API such that methods are rejected once the gateway is closed.
+func closedV2(gw *Node, v2 v2api.Gateway) v2api.Gateway {
+	var out v2api.GatewayStruct
+	rejectClosed(gw, v2, &out)
+	return &out
+}
+
+func rejectClosed(gw *Node, in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			if gw.closed.Load() {
+				return errorResults(fn.Type(), xerrors.Errorf("%w: %s can't be called", ErrGatewayClosed, method))
+			}
+			return fn.Call(args)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// contentAddressedReads are the v1 methods whose result is determined by the CIDs they're called
+// with, so can always be coalesced.
+var contentAddressedReads = map[string]bool{
+	"ChainGetBlock":          true,
+	"ChainGetBlockMessages":  true,
+	"ChainGetEvents":         true,
+	"ChainGetMessage":        true,
+	"ChainGetParentMessages": true,
+	"ChainGetParentReceipts": true,
+	"ChainReadObj":           true,
+}
+
+// coalescedReadsV1 wraps the v1 target such that concurrent identical calls to deterministic reads
+// are coalesced into a single target call, whose result is shared by every caller. Reads are
+// deterministic if they're of content addressed data, or are state, chain or multisig reads against
+// an explicit tipset; reads that default to the head aren't coalesced as the head may change while
+// they're in flight.
+func coalescedReadsV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	coalesceCalls(server, &out, coalescable)
+	return &out
+}
+
+// coalescedLookups are the target methods coalesced by coalescedLookupsV1 and coalescedLookupsV2.
+// Clients commonly poll them for the same transaction, block or actor at once.
+var coalescedLookups = map[string]bool{
+	"EthGetTransactionReceiptLimited": true,
+	"EthGetBlockByHash":               true,
+	"StateGetActor":                   true,
+}
+
+// coalescedLookupsV1 wraps the v1 target such that concurrent identical calls to the
+// coalescedLookups are coalesced into a single target call, whose result is shared by every caller,
+// whether or not they're against an explicit tipset. Each caller could have been served the result
+// of any of the calls, as they were all in flight at once.
+func coalescedLookupsV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	coalesceCalls(server, &out, coalescableLookup)
+	return &out
+}
+
+// coalescedLookupsV2 wraps the v2 target such that concurrent identical calls to the
+// coalescedLookups are coalesced, as for coalescedLookupsV1.
+func coalescedLookupsV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	coalesceCalls(server, &out, coalescableLookup)
+	return &out
+}
+
+// coalesceCalls fills the internal structs of outstr with methods that coalesce concurrent
+// identical calls to the methods of in for which coalescable returns true, along with the indexes
+// of the tipset key arguments that must be non-empty for a call to be coalesced.
+func coalesceCalls(in interface{}, outstr interface{}, coalescable func(method string, t reflect.Type) ([]int, bool)) {
+	var flights flightGroup[string, []reflect.Value]
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		tskArgs, ok := coalescable(method, fn.Type())
+		if !ok {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			key, ok := coalesceKey(method, args, tskArgs)
+			if !ok {
+				return fn.Call(args)
+			}
+			ctx := contextArg(args)
+			results, err := flights.do(ctx, key, func() ([]reflect.Value, error) {
+				return fn.Call(args), nil
+			})
+			if err != nil {
+				return errorResults(fn.Type(), err)
+			}
+			// a call cancelled by the caller that made it shouldn't fail the others that shared it
+			if err, _ := results[len(results)-1].Interface().(error); err != nil && ctx.Err() == nil &&
+				(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+				return fn.Call(args)
+			}
+			return results
+		})
+	})
+}
+
+// coalescable returns whether calls to method, of type t, may be coalesced, along with the indexes
+// of its tipset key arguments, which must be non-empty for a call to be coalesced.
+func coalescable(method string, t reflect.Type) ([]int, bool) {
+	errOut := t.NumOut() - 1
+	if errOut < 0 || t.Out(errOut) != errorType {
+		return nil, false
+	}
+	for o := 0; o < errOut; o++ {
+		if t.Out(o).Kind() == reflect.Chan {
+			return nil, false
+		}
+	}
+	if contentAddressedReads[method] {
+		return nil, true
+	}
+
+	if !strings.HasPrefix(method, "State") && !strings.HasPrefix(method, "Chain") && !strings.HasPrefix(method, "Msig") {
+		return nil, false
+	}
+	var tskArgs []int
+	for i := 0; i < t.NumIn(); i++ {
+		if t.In(i) == tipSetKeyType {
+			tskArgs = append(tskArgs, i)
+		}
+	}
+	return tskArgs, len(tskArgs) > 0
+}
+
+// coalescableLookup returns whether calls to method may be coalesced by coalescedLookupsV1 and
+// coalescedLookupsV2, which coalesce calls against any tipset.
+func coalescableLookup(method string, _ reflect.Type) ([]int, bool) {
+	return nil, coalescedLookups[method]
+}
+
+// coalesceKey returns the key identifying calls to method with args, or false if the call can't be
+// coalesced.
+func coalesceKey(method string, args []reflect.Value, tskArgs []int) (string, bool) {
+	for _, i := range tskArgs {
+		if args[i].Interface().(types.TipSetKey).IsEmpty() {
+			return "", false
+		}
+	}
+	params := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if _, ok := arg.Interface().(context.Context); ok {
+			continue
+		}
+		params = append(params, arg.Interface())
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return "", false
+	}
+	return method + string(b), true
+}
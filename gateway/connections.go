@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// ConnectionStats describes an open websocket connection to the gateway and the requests made on it,
+// as returned by Node.Connections.
+type ConnectionStats struct {
+	ID       uint64
+	Identity Identity
+	Path     string // the API path the connection was made to, e.g. "/rpc/v1"
+	Opened   time.Time
+
+	Requests       uint64 // API calls made
+	TokensConsumed uint64 // rate limit tokens consumed by the API calls made
+	Throttled      uint64 // API calls rejected by the connection or gateway rate limit
+	ActiveFilters  int    // Eth filters and subscriptions currently held
+}
+
+// connectionStats are the counters kept for each connection by its statefulCallTracker.
+type connectionStats struct {
+	id        uint64
+	path      string
+	opened    time.Time
+	requests  atomic.Uint64
+	tokens    atomic.Uint64
+	throttled atomic.Uint64
+}
+
+// connectionRegistry tracks the open websocket connections of a gateway.
+type connectionRegistry struct {
+	lk     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*statefulCallTracker
+}
+
+func newConnectionRegistry() *connectionRegistry {
+	return &connectionRegistry{conns: make(map[uint64]*statefulCallTracker)}
+}
+
+// add registers the connection tracked by ft, returning a function to deregister it once closed.
+func (r *connectionRegistry) add(ft *statefulCallTracker, path string) func() {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	r.nextID++
+	ft.stats.id, ft.stats.path, ft.stats.opened = r.nextID, path, time.Now()
+	r.conns[ft.stats.id] = ft
+	return func() {
+		r.lk.Lock()
+		defer r.lk.Unlock()
+		delete(r.conns, ft.stats.id)
+	}
+}
+
+// Connections lists the gateway's open websocket connections along with their stats, ordered by the
+// time they were opened, to help identify misbehaving clients. It's served to operators by the
+// admin API, see WithAdminToken.
+func (gw *Node) Connections() []ConnectionStats {
+	gw.connections.lk.Lock()
+	trackers := make([]*statefulCallTracker, 0, len(gw.connections.conns))
+	for _, ft := range gw.connections.conns {
+		trackers = append(trackers, ft)
+	}
+	gw.connections.lk.Unlock()
+
+	out := make([]ConnectionStats, 0, len(trackers))
+	for _, ft := range trackers {
+		ft.lk.Lock()
+		active := ft.active()
+		ft.lk.Unlock()
+		out = append(out, ConnectionStats{
+			ID:             ft.stats.id,
+			Identity:       Identity(ft.host),
+			Path:           ft.stats.path,
+			Opened:         ft.stats.opened,
+			Requests:       ft.stats.requests.Load(),
+			TokensConsumed: ft.stats.tokens.Load(),
+			Throttled:      ft.stats.throttled.Load(),
+			ActiveFilters:  active,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// connectionTracker returns the tracker of the connection a call was made on, or nil if there is
+// none, as when the API is called directly.
+func connectionTracker(ctx context.Context) *statefulCallTracker {
+	if ft, ok := ctx.Value(statefulCallTrackerKeyV1).(*statefulCallTracker); ok {
+		return ft
+	}
+	if ft, ok := ctx.Value(statefulCallTrackerKeyV2).(*statefulCallTracker); ok {
+		return ft
+	}
+	return nil
+}
+
+// countRequestsV1 wraps the v1 gateway API such that each call is counted against the connection
+// it was made on.
+func countRequestsV1(gw api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	countRequests(gw, &out)
+	return &out
+}
+
+// countRequestsV2 wraps the v2 gateway API such that each call is counted against the connection
+// it was made on.
+func countRequestsV2(gw v2api.Gateway) v2api.Gateway {
+	var out v2api.GatewayStruct
+	countRequests(gw, &out)
+	return &out
+}
+
+func countRequests(in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(_ string, fn reflect.Value) reflect.Value {
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			if ft := connectionTracker(contextArg(args)); ft != nil {
+				ft.stats.requests.Add(1)
+			}
+			return fn.Call(args)
+		})
+	})
+}
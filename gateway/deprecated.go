@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// deprecatedV1 wraps the v1 gateway API such that calls to deprecated methods are logged, counted
+// and warned about, but otherwise served as normal.
+func deprecatedV1(gw api.Gateway, deprecated map[string]string) api.Gateway {
+	var out api.GatewayStruct
+	warnDeprecated(gw, &out, deprecated)
+	return &out
+}
+
+// deprecatedV2 wraps the v2 gateway API such that calls to deprecated methods are logged, counted
+// and warned about, but otherwise served as normal.
+func deprecatedV2(gw v2api.Gateway, deprecated map[string]string) v2api.Gateway {
+	var out v2api.GatewayStruct
+	warnDeprecated(gw, &out, deprecated)
+	return &out
+}
+
+func warnDeprecated(in interface{}, outstr interface{}, deprecated map[string]string) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		msg, ok := deprecated[method]
+		if !ok {
+			return fn
+		}
+
+		// an HTTP warning header, see https://www.rfc-editor.org/rfc/rfc7234#section-5.5
+		warning := fmt.Sprintf("299 lotus-gateway %s", strconv.Quote(fmt.Sprintf("%s is deprecated: %s", method, msg)))
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := contextArg(args)
+			log.Warnw("deprecated method called", "method", method, "deprecation", msg)
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.Endpoint, method)}, metrics.GatewayDeprecatedMethodCalls.M(1))
+			addResponseHeader(ctx, "Warning", warning)
+			return fn.Call(args)
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// resolveDisabledMethods returns the set of methods to disable: those in disabled and, if allowed
+// isn't nil, every method that isn't in allowed. A method in both lists is an error, and is
+// disabled.
+func resolveDisabledMethods(disabled []string, allowed *[]string) (map[string]bool, error) {
+	methods := make(map[string]bool, len(disabled))
+	for _, method := range disabled {
+		methods[method] = true
+	}
+	if allowed == nil {
+		return methods, nil
+	}
+
+	isAllowed := make(map[string]bool, len(*allowed))
+	var conflicts []string
+	for _, method := range *allowed {
+		if methods[method] {
+			conflicts = append(conflicts, method)
+			continue
+		}
+		isAllowed[method] = true
+	}
+	for method := range gatewayMethods() {
+		if !isAllowed[method] {
+			methods[method] = true
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return methods, xerrors.Errorf("methods both allowed and denied: %s", strings.Join(conflicts, ", "))
+	}
+	return methods, nil
+}
+
+// gatewayMethods returns the names of the methods of the v1 and v2 gateway APIs.
+func gatewayMethods() map[string]bool {
+	methods := make(map[string]bool)
+	for _, iface := range []reflect.Type{
+		reflect.TypeOf((*api.Gateway)(nil)).Elem(),
+		reflect.TypeOf((*v2api.Gateway)(nil)).Elem(),
+	} {
+		for i := 0; i < iface.NumMethod(); i++ {
+			methods[iface.Method(i).Name] = true
+		}
+	}
+	return methods
+}
+
+// disabledV1 wraps the v1 gateway API such that calls to disabled methods are rejected with an
+// api.ErrMethodNotSupported.
+func disabledV1(v1 api.Gateway, disabled map[string]bool) api.Gateway {
+	var out api.GatewayStruct
+	rejectDisabled(v1, &out, disabled)
+	return &out
+}
+
+// disabledV2 wraps the v2 gateway API such that calls to disabled methods are rejected with an
+// api.ErrMethodNotSupported.
+func disabledV2(v2 v2api.Gateway, disabled map[string]bool) v2api.Gateway {
+	var out v2api.GatewayStruct
+	rejectDisabled(v2, &out, disabled)
+	return &out
+}
+
+func rejectDisabled(in interface{}, outstr interface{}, disabled map[string]bool) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if !disabled[method] || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		// returned as is, so that clients receive it with its registered JSON-RPC error code
+		err := &api.ErrMethodNotSupported{Method: method, Disabled: true}
+		return reflect.MakeFunc(fn.Type(), func([]reflect.Value) []reflect.Value {
+			return errorResults(fn.Type(), err)
+		})
+	})
+}
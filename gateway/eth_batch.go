@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+// ErrTooManyBlockParams is returned when a batch request references more distinct Eth block params
+// than the gateway allows.
+var ErrTooManyBlockParams = errors.New("too many distinct block params in batch request")
+
+// blockParamKey identifies an Eth block param, whether it's given as an EthBlockNumberOrHash or as
+// a string, so that the same block referenced in either form is only checked once per batch.
+type blockParamKey struct {
+	predefined string
+	number     ethtypes.EthUint64
+	hash       ethtypes.EthHash
+}
+
+func ethBlockParamKey(blkParam ethtypes.EthBlockNumberOrHash) blockParamKey {
+	switch {
+	case blkParam.PredefinedBlock != nil:
+		return blockParamKey{predefined: *blkParam.PredefinedBlock}
+	case blkParam.BlockNumber != nil:
+		return blockParamKey{number: *blkParam.BlockNumber}
+	case blkParam.BlockHash != nil:
+		return blockParamKey{hash: *blkParam.BlockHash}
+	}
+	return blockParamKey{}
+}
+
+func blkParamKey(blkParam string) blockParamKey {
+	var num ethtypes.EthUint64
+	if err := num.UnmarshalJSON([]byte(`"` + blkParam + `"`)); err == nil {
+		return blockParamKey{number: num}
+	}
+	return blockParamKey{predefined: blkParam}
+}
+
+// blockParamBatch records the outcome of checking each block param referenced by the calls in a
+// single HTTP request, which may be a JSON-RPC batch of many calls. Checking a block param may
+// involve resolving it to a tipset, so each distinct block param is resolved at most once and the
+// number of distinct block params is capped.
+type blockParamBatch struct {
+	lk      sync.Mutex
+	max     int
+	checked map[blockParamKey]map[ethtypes.EthUint64]error // by lookback
+}
+
+// blockParamBatchFrom returns the batch the call with ctx belongs to, or nil if there is none, as
+// for websocket connections, on which each call is checked on its own.
+func blockParamBatchFrom(ctx context.Context) *blockParamBatch {
+	b, _ := ctx.Value(blockParamBatchKey).(*blockParamBatch)
+	return b
+}
+
+// check returns the outcome of checking key with lookback, calling check the first time it's seen.
+// check is given a context outside of the batch so that it can call back into the usual block param
+// checks.
+func (b *blockParamBatch) check(ctx context.Context, key blockParamKey, lookback ethtypes.EthUint64, check func(ctx context.Context) error) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	byLookback, ok := b.checked[key]
+	if !ok {
+		if b.max > 0 && len(b.checked) >= b.max {
+			return xerrors.Errorf("%w: the maximum is %d", ErrTooManyBlockParams, b.max)
+		}
+		byLookback = make(map[ethtypes.EthUint64]error)
+		b.checked[key] = byLookback
+	}
+	if err, ok := byLookback[lookback]; ok {
+		return err
+	}
+	err := check(context.WithValue(ctx, blockParamBatchKey, (*blockParamBatch)(nil)))
+	byLookback[lookback] = err
+	return err
+}
+
+// blockParamBatchHandler scopes a blockParamBatch to each HTTP request. Websocket connections are
+// passed through untouched as their requests aren't batched.
+type blockParamBatchHandler struct {
+	next           http.Handler
+	maxBlockParams int
+}
+
+func (h blockParamBatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	b := &blockParamBatch{max: h.maxBlockParams, checked: make(map[blockParamKey]map[ethtypes.EthUint64]error)}
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), blockParamBatchKey, b)))
+}
+
+func (h blockParamBatchHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+// ErrInvalidLogsCursor is returned by EthGetLogsPaged for a cursor it didn't issue.
+var ErrInvalidLogsCursor = errors.New("invalid logs cursor")
+
+// encodeLogsCursor returns the cursor for the page of logs matching filter, whose fromBlock and
+// toBlock are block numbers. The cursor is the filter itself, so paging holds no state on the
+// gateway, and a cursor can be used with any gateway in front of the same chain.
+func encodeLogsCursor(filter *ethtypes.EthFilterSpec) (string, error) {
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return "", xerrors.Errorf("encoding logs cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeLogsCursor returns the filter encoded in a cursor by encodeLogsCursor.
+func decodeLogsCursor(cursor string) (*ethtypes.EthFilterSpec, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, xerrors.Errorf("%w: %s", ErrInvalidLogsCursor, err)
+	}
+	var filter ethtypes.EthFilterSpec
+	if err := json.Unmarshal(b, &filter); err != nil {
+		return nil, xerrors.Errorf("%w: %s", ErrInvalidLogsCursor, err)
+	}
+	if filter.BlockHash != nil || filter.FromBlock == nil || filter.ToBlock == nil {
+		return nil, xerrors.Errorf("%w: not a block range", ErrInvalidLogsCursor)
+	}
+	return &filter, nil
+}
+
+func (pv1 *reverseProxyV1) EthGetLogsPaged(ctx context.Context, filter *ethtypes.EthFilterSpec, cursor string) (*api.EthLogsPage, error) {
+	if cursor != "" {
+		var err error
+		if filter, err = decodeLogsCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	if filter == nil {
+		return nil, xerrors.New("either a filter or a cursor is required")
+	}
+
+	// a single block hash is a single page
+	if filter.BlockHash != nil {
+		logs, err := pv1.getLogsPage(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		return &api.EthLogsPage{Logs: logs}, nil
+	}
+
+	head, err := pv1.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from, ok := resolveLogsBlockParam(filter.FromBlock, head)
+	if !ok {
+		return nil, xerrors.Errorf("invalid fromBlock %q", *filter.FromBlock)
+	}
+	to, ok := resolveLogsBlockParam(filter.ToBlock, head)
+	if !ok {
+		return nil, xerrors.Errorf("invalid toBlock %q", *filter.ToBlock)
+	}
+	if to < from {
+		return nil, xerrors.New("fromBlock must not be after toBlock")
+	}
+
+	span := abi.ChainEpoch(pv1.gateway.ethLogsPageSpan)
+	if span <= 0 {
+		span = DefaultEthLogsPageSpan
+	}
+	if maxRange := abi.ChainEpoch(pv1.gateway.ethMaxLogsBlockRange); maxRange > 0 && maxRange < span {
+		span = maxRange
+	}
+
+	for {
+		end := from + span - 1
+		if end > to {
+			end = to
+		}
+		page := pageFilter(filter, from, end)
+		logs, err := pv1.getLogsPage(ctx, page)
+		if errors.Is(err, ErrResponseTooLarge) && end > from {
+			// too many logs for a page of this span, try again with half as many blocks
+			span = (end - from + 1) / 2
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res := &api.EthLogsPage{
+			Logs:      logs,
+			FromBlock: ethtypes.EthUint64(from),
+			ToBlock:   ethtypes.EthUint64(end),
+		}
+		if end < to {
+			if res.Cursor, err = encodeLogsCursor(pageFilter(filter, end+1, to)); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+}
+
+// getLogsPage fetches the logs matching a page's filter through EthGetLogs, so that they're subject
+// to its limits, and enforces the maximum response size on them.
+func (pv1 *reverseProxyV1) getLogsPage(ctx context.Context, filter *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error) {
+	logs, err := pv1.EthGetLogs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkResponseSize(logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// pageFilter returns a copy of filter for the blocks from from to to inclusive.
+func pageFilter(filter *ethtypes.EthFilterSpec, from, to abi.ChainEpoch) *ethtypes.EthFilterSpec {
+	fromBlock, toBlock := ethtypes.EthUint64(from).Hex(), ethtypes.EthUint64(to).Hex()
+	page := *filter
+	page.FromBlock, page.ToBlock = &fromBlock, &toBlock
+	return &page
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// ethReceiptTarget is the part of the v1 and v2 targets needed to enrich transaction receipts.
+type ethReceiptTarget interface {
+	EthGetTransactionByHashLimited(ctx context.Context, txHash *ethtypes.EthHash, limit abi.ChainEpoch) (*ethtypes.EthTx, error)
+	EthGetBlockByHash(ctx context.Context, blkHash ethtypes.EthHash, fullTxInfo bool) (ethtypes.EthBlock, error)
+}
+
+// enrichEthReceipt sets the effective gas price of receipt, if the gateway is configured to and the
+// target didn't, from the transaction and the base fee of the block it was included in. The
+// receipt is returned as it is if the transaction or block can't be fetched.
+func (gw *Node) enrichEthReceipt(ctx context.Context, server ethReceiptTarget, receipt *ethtypes.EthTxReceipt, limit abi.ChainEpoch) *ethtypes.EthTxReceipt {
+	if !gw.ethReceiptGasPrice || receipt == nil || (receipt.EffectiveGasPrice.Int != nil && receipt.EffectiveGasPrice.Sign() != 0) {
+		return receipt
+	}
+
+	tx, err := server.EthGetTransactionByHashLimited(ctx, &receipt.TransactionHash, limit)
+	if err != nil || tx == nil {
+		log.Debugw("failed to get transaction to compute effective gas price", "tx", receipt.TransactionHash, "error", err)
+		return receipt
+	}
+	blk, err := server.EthGetBlockByHash(ctx, receipt.BlockHash, false)
+	if err != nil {
+		log.Debugw("failed to get block to compute effective gas price", "block", receipt.BlockHash, "error", err)
+		return receipt
+	}
+	price, err := effectiveGasPrice(tx, blk.BaseFeePerGas, receipt.GasUsed)
+	if err != nil {
+		log.Debugw("failed to compute effective gas price", "tx", receipt.TransactionHash, "error", err)
+		return receipt
+	}
+
+	enriched := *receipt
+	enriched.EffectiveGasPrice = price
+	return &enriched
+}
+
+// effectiveGasPrice returns the price per unit of gas paid by tx, given the base fee of the block it
+// was included in and the gas it used. It's computed as the target computes it, from the total
+// paid, including the overestimation burn, divided by the gas used.
+func effectiveGasPrice(tx *ethtypes.EthTx, baseFee ethtypes.EthBigInt, gasUsed ethtypes.EthUint64) (ethtypes.EthBigInt, error) {
+	gasFeeCap, err := tx.GasFeeCap()
+	if err != nil {
+		return ethtypes.EthBigInt{}, err
+	}
+	gasPremium, err := tx.GasPremium()
+	if err != nil {
+		return ethtypes.EthBigInt{}, err
+	}
+	if gasUsed == 0 {
+		return ethtypes.EthBigInt(big.Zero()), nil
+	}
+
+	out := vm.ComputeGasOutputs(int64(gasUsed), int64(tx.Gas), big.Int(baseFee), big.Int(gasFeeCap), big.Int(gasPremium), true)
+	totalSpent := big.Sum(out.BaseFeeBurn, out.MinerTip, out.OverEstimationBurn)
+	return ethtypes.EthBigInt(big.Div(totalSpent, big.NewInt(int64(gasUsed)))), nil
+}
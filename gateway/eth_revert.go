@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+var (
+	errorFunctionSelector = []byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicFunctionSelector = []byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// decodeRevertReason adds the decoded revert reason to an execution reverted error returned by the
+// target for an EthCall, where the revert data is a standard Solidity Error(string) or
+// Panic(uint256) revert. Any other error, including a revert with a custom error, is returned
+// unchanged so the client still gets the raw revert data.
+func decodeRevertReason(err error) error {
+	var reverted *api.ErrExecutionReverted
+	if !errors.As(err, &reverted) || reverted.Reason != nil {
+		return err
+	}
+	data, derr := hex.DecodeString(strings.TrimPrefix(reverted.Data, "0x"))
+	if derr != nil {
+		return err
+	}
+	reason := parseRevertReason(data)
+	if reason == nil {
+		return err
+	}
+	return &api.ErrExecutionReverted{
+		Message: reverted.Message,
+		Data:    reverted.Data,
+		Reason:  reason,
+	}
+}
+
+// parseRevertReason decodes ABI encoded revert data as an Error(string) or Panic(uint256) revert,
+// returning nil if it's neither.
+//
+// See https://docs.soliditylang.org/en/latest/control-structures.html#panic-via-assert-and-error-via-require
+func parseRevertReason(data []byte) *api.EthRevertReason {
+	if len(data) < 4+32 {
+		return nil
+	}
+	selector, args := data[:4], data[4:]
+	switch {
+	case bytes.Equal(selector, panicFunctionSelector):
+		return &api.EthRevertReason{
+			Kind: "Panic",
+			Code: fmt.Sprintf("0x%x", new(big.Int).SetBytes(args[:32])),
+		}
+	case bytes.Equal(selector, errorFunctionSelector):
+		offset, ok := abiUint64(args, 0)
+		if !ok {
+			return nil
+		}
+		length, ok := abiUint64(args, offset)
+		if !ok {
+			return nil
+		}
+		start := offset + 32
+		if uint64(len(args))-start < length {
+			return nil
+		}
+		return &api.EthRevertReason{
+			Kind:    "Error",
+			Message: string(args[start : start+length]),
+		}
+	}
+	return nil
+}
+
+// abiUint64 reads the 32 byte ABI word at offset in args, returning false if it's out of range or
+// doesn't fit in a uint64.
+func abiUint64(args []byte, offset uint64) (uint64, bool) {
+	if offset > uint64(len(args)) || uint64(len(args))-offset < 32 {
+		return 0, false
+	}
+	word := args[offset : offset+32]
+	for _, b := range word[:24] {
+		if b != 0 {
+			return 0, false
+		}
+	}
+	return binary.BigEndian.Uint64(word[24:]), true
+}
@@ -2,25 +2,44 @@ package gateway
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
 
 	"github.com/filecoin-project/go-jsonrpc"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/lotus/metrics"
 )
 
+// ErrSubscriptionBufferFull is returned, and the subscription is dropped, when a client does not
+// consume EthSubscribe notifications fast enough to keep its notification buffer from overflowing.
+var ErrSubscriptionBufferFull = errors.New("subscription dropped: client is not keeping up with notifications")
+
+// subscriptionCleanupTimeout bounds the calls made to clean up a subscription on the target, and to
+// tell the client about it, once the request that created the subscription has returned.
+const subscriptionCleanupTimeout = 10 * time.Second
+
 type EthSubHandler struct {
-	queued map[ethtypes.EthSubscriptionID][]ethtypes.EthSubscriptionResponse
-	sinks  map[ethtypes.EthSubscriptionID]func(context.Context, *ethtypes.EthSubscriptionResponse) error
+	queued  map[ethtypes.EthSubscriptionID][]ethtypes.EthSubscriptionResponse
+	sinks   map[ethtypes.EthSubscriptionID]func(context.Context, *ethtypes.EthSubscriptionResponse) error
+	buffers map[ethtypes.EthSubscriptionID]*subscriptionBuffer
+	created map[ethtypes.EthSubscriptionID]time.Time
+	replays map[ethtypes.EthSubscriptionID]*subscriptionReplay
 
 	lk sync.Mutex
 }
 
 func NewEthSubHandler() *EthSubHandler {
 	return &EthSubHandler{
-		queued: make(map[ethtypes.EthSubscriptionID][]ethtypes.EthSubscriptionResponse),
-		sinks:  make(map[ethtypes.EthSubscriptionID]func(context.Context, *ethtypes.EthSubscriptionResponse) error),
+		queued:  make(map[ethtypes.EthSubscriptionID][]ethtypes.EthSubscriptionResponse),
+		sinks:   make(map[ethtypes.EthSubscriptionID]func(context.Context, *ethtypes.EthSubscriptionResponse) error),
+		buffers: make(map[ethtypes.EthSubscriptionID]*subscriptionBuffer),
+		created: make(map[ethtypes.EthSubscriptionID]time.Time),
+		replays: make(map[ethtypes.EthSubscriptionID]*subscriptionReplay),
 	}
 }
 
@@ -36,6 +55,46 @@ func (e *EthSubHandler) AddSub(ctx context.Context, id ethtypes.EthSubscriptionI
 	}
 	delete(e.queued, id)
 	e.sinks[id] = sink
+	e.created[id] = time.Now()
+	return nil
+}
+
+// AddBufferedSub is like AddSub, but decouples delivery to the sink from the target by queueing up
+// to size notifications for the subscription. Queued notifications are delivered until the
+// subscription is removed with RemoveSub, not just for the lifetime of ctx, which is usually that
+// of the EthSubscribe request. When the queue overflows the subscription is removed from the
+// handler and onOverflow is called so the caller can clean up the subscription on the target.
+func (e *EthSubHandler) AddBufferedSub(ctx context.Context, id ethtypes.EthSubscriptionID, size int, sink func(context.Context, *ethtypes.EthSubscriptionResponse) error, onOverflow func()) error {
+	buf := newSubscriptionBuffer(size, sink)
+	overflowSink := func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+		if buf.push(response) {
+			return nil
+		}
+
+		buf.dropOnce.Do(func() {
+			log.Warnw("dropping eth subscription: notification buffer full", "subscription", id, "bufferSize", size)
+			stats.Record(ctx, metrics.GatewayEthSubscriptionsDropped.M(1))
+			buf.stop()
+			// we may be called with e.lk held while queued notifications are replayed, so clean up
+			// asynchronously
+			go func() {
+				e.RemoveSub(id)
+				if onOverflow != nil {
+					onOverflow()
+				}
+			}()
+		})
+		return ErrSubscriptionBufferFull
+	}
+
+	e.lk.Lock()
+	e.buffers[id] = buf
+	e.lk.Unlock()
+
+	if err := e.AddSub(ctx, id, overflowSink); err != nil {
+		e.RemoveSub(id)
+		return err
+	}
 	return nil
 }
 
@@ -45,6 +104,29 @@ func (e *EthSubHandler) RemoveSub(id ethtypes.EthSubscriptionID) {
 
 	delete(e.sinks, id)
 	delete(e.queued, id)
+	delete(e.created, id)
+	if replay, ok := e.replays[id]; ok {
+		replay.stop()
+		delete(e.replays, id)
+	}
+	if buf, ok := e.buffers[id]; ok {
+		buf.stop()
+		delete(e.buffers, id)
+	}
+}
+
+// oldest returns the subscription among ids that was added first. ids must not be empty.
+func (e *EthSubHandler) oldest(ids []ethtypes.EthSubscriptionID) ethtypes.EthSubscriptionID {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	oldest := ids[0]
+	for _, id := range ids[1:] {
+		if e.created[id].Before(e.created[oldest]) {
+			oldest = id
+		}
+	}
+	return oldest
 }
 
 func (e *EthSubHandler) EthSubscription(ctx context.Context, r jsonrpc.RawParams) error {
@@ -69,3 +151,69 @@ func (e *EthSubHandler) EthSubscription(ctx context.Context, r jsonrpc.RawParams
 }
 
 var _ api.EthSubscriber = (*EthSubHandler)(nil)
+
+// subscriptionBuffer holds notifications for a single subscription until they can be delivered to
+// the client, so that a slow client can't hold up delivery from the target. Notifications are
+// delivered until the buffer is stopped.
+type subscriptionBuffer struct {
+	queue    chan *ethtypes.EthSubscriptionResponse
+	done     chan struct{}
+	cancel   context.CancelFunc // cancels deliveries in progress when stopped
+	stopOnce sync.Once
+	dropOnce sync.Once
+}
+
+func newSubscriptionBuffer(size int, sink func(context.Context, *ethtypes.EthSubscriptionResponse) error) *subscriptionBuffer {
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := &subscriptionBuffer{
+		queue:  make(chan *ethtypes.EthSubscriptionResponse, size),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go buf.run(ctx, sink)
+	return buf
+}
+
+func (b *subscriptionBuffer) run(ctx context.Context, sink func(context.Context, *ethtypes.EthSubscriptionResponse) error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.done:
+			return
+		case response := <-b.queue:
+			// don't deliver anything else once the subscription has been dropped
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			if err := sink(ctx, response); err != nil {
+				log.Warnf("error delivering notification for subscription %s: %v", response.SubscriptionID, err)
+			}
+		}
+	}
+}
+
+// push queues a notification for delivery, returning false if the buffer is full or stopped.
+func (b *subscriptionBuffer) push(response *ethtypes.EthSubscriptionResponse) bool {
+	select {
+	case <-b.done:
+		return false
+	default:
+	}
+
+	select {
+	case b.queue <- response:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *subscriptionBuffer) stop() {
+	b.stopOnce.Do(func() {
+		close(b.done)
+		b.cancel()
+	})
+}
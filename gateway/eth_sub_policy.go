@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+// The EthSubscribe event types that can be capped separately by an EthSubscriptionPolicy.
+const (
+	ethSubscribeNewHeads               = "newHeads"
+	ethSubscribeLogs                   = "logs"
+	ethSubscribeNewPendingTransactions = "newPendingTransactions"
+)
+
+// ErrTooManySubscriptionsOfType is returned by EthSubscribe when the connection already holds as
+// many subscriptions of the requested type as the EthSubscriptionPolicy allows and the policy
+// rejects new subscriptions at the cap.
+var ErrTooManySubscriptionsOfType = errors.New("too many subscriptions of this type per connection")
+
+// EthSubscriptionCapAction is what the gateway does when a client subscribes to a type of
+// EthSubscribe events it already holds the maximum number of subscriptions to.
+type EthSubscriptionCapAction int
+
+const (
+	// RejectNewSubscription rejects the new subscription with ErrTooManySubscriptionsOfType.
+	RejectNewSubscription EthSubscriptionCapAction = iota
+	// EvictOldestSubscription unsubscribes the connection's oldest subscription of the same type to
+	// make room for the new one. The client is sent a final notification for the evicted
+	// subscription, with an EthSubscriptionEvicted result.
+	EvictOldestSubscription
+)
+
+// EthSubscriptionPolicy caps the number of EthSubscribe subscriptions of each type that a single
+// websocket connection can hold, see WithEthSubscriptionPolicy. A cap of 0 leaves subscriptions of
+// the type limited only by the number of filters and subscriptions per connection.
+type EthSubscriptionPolicy struct {
+	MaxNewHeads               int
+	MaxLogs                   int
+	MaxNewPendingTransactions int
+	// OnCap is applied when a client subscribes beyond one of the caps.
+	OnCap EthSubscriptionCapAction
+}
+
+// max returns the cap on subscriptions of eventType, or 0 if there is none.
+func (p EthSubscriptionPolicy) max(eventType string) int {
+	switch eventType {
+	case ethSubscribeNewHeads:
+		return p.MaxNewHeads
+	case ethSubscribeLogs:
+		return p.MaxLogs
+	case ethSubscribeNewPendingTransactions:
+		return p.MaxNewPendingTransactions
+	default:
+		return 0
+	}
+}
+
+// EthSubscriptionEvicted is the result of the final notification sent for a subscription that was
+// unsubscribed by the gateway, to make room for a newer subscription of the same type or because the
+// client wasn't keeping up with its notifications. No further notifications are sent for the
+// subscription.
+type EthSubscriptionEvicted struct {
+	Unsubscribed bool   `json:"unsubscribed"`
+	Reason       string `json:"reason"`
+}
+
+// reserveSubscription counts a new subscription of eventType against the limits of connection ft,
+// returning a function to stop counting it if it isn't created after all. If the subscription
+// policy makes room for it by eviction, the subscription to evict once the new one has been
+// created is returned too; the new subscription takes over its place in the per connection and per
+// host counts. A resumed subscription brings its place in the host's count with it from the
+// connection it was detached from, so only the per connection limits apply to it. ft.lk must be
+// held.
+func (gw *Node) reserveSubscription(ft *statefulCallTracker, subs *EthSubHandler, eventType string, resumed bool) (*ethtypes.EthSubscriptionID, func(), error) {
+	if max := gw.ethSubscriptionPolicy.max(eventType); max > 0 {
+		var held []ethtypes.EthSubscriptionID
+		for id, typ := range ft.subscriptionTypes {
+			if typ == eventType {
+				held = append(held, id)
+			}
+		}
+		if len(held) >= max {
+			if gw.ethSubscriptionPolicy.OnCap != EvictOldestSubscription {
+				return nil, nil, xerrors.Errorf("%w: at most %d %s subscriptions are allowed", ErrTooManySubscriptionsOfType, max, eventType)
+			}
+			oldest := subs.oldest(held)
+			return &oldest, func() {}, nil
+		}
+	}
+
+	if ft.active() >= gw.currentSettings().ethMaxFiltersPerConn {
+		return nil, nil, ErrTooManyFilters
+	}
+	if resumed {
+		return nil, func() {}, nil
+	}
+	if !ft.hostFilters.reserve(ft.host) {
+		return nil, nil, ErrTooManyFiltersPerHost
+	}
+	return nil, func() { ft.hostFilters.release(ft.host, 1) }, nil
+}
+
+// evictSubscription unsubscribes subscription id of connection ft, on the target with unsubscribe
+// and in subs, and tells the client with a final notification through ethCb. The host's count is
+// left as it is, as a new subscription takes over the evicted one's place. ft.lk must be held.
+func evictSubscription(
+	ctx context.Context,
+	ft *statefulCallTracker,
+	subs *EthSubHandler,
+	id ethtypes.EthSubscriptionID,
+	unsubscribe func(context.Context, ethtypes.EthSubscriptionID) (bool, error),
+	ethCb api.EthSubscriberMethods,
+) {
+	if _, ok := ft.userSubscriptions[id]; !ok {
+		return
+	}
+
+	if _, err := unsubscribe(ctx, id); err != nil {
+		log.Warnf("error unsubscribing evicted subscription: %v", err)
+	}
+	delete(ft.userSubscriptions, id)
+	delete(ft.subscriptionTypes, id)
+	subs.RemoveSub(id)
+
+	notifyUnsubscribed(ctx, ethCb, id, "evicted to make room for a newer subscription of the same type")
+}
+
+// notifyUnsubscribed sends the final notification for subscription id, which the gateway has
+// unsubscribed for the given reason, through ethCb.
+func notifyUnsubscribed(ctx context.Context, ethCb api.EthSubscriberMethods, id ethtypes.EthSubscriptionID, reason string) {
+	outParam, err := json.Marshal(ethtypes.EthSubscriptionResponse{
+		SubscriptionID: id,
+		Result:         EthSubscriptionEvicted{Unsubscribed: true, Reason: reason},
+	})
+	if err != nil {
+		log.Warnf("error encoding unsubscribe notification: %v", err)
+		return
+	}
+	if err := ethCb.EthSubscription(ctx, outParam); err != nil {
+		log.Warnf("error notifying client of unsubscribed subscription %s: %v", id, err)
+	}
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+// ethSubscriptionReplayRetention is how long a newHeads subscription with replay enabled is kept
+// running after its connection ends, for the client to resume it from a new connection.
+const ethSubscriptionReplayRetention = time.Minute
+
+// ErrCannotResumeSubscription is returned by EthSubscribe when a client asks to resume a newHeads
+// subscription that can't be resumed without missing notifications, because it has expired or isn't
+// known, because it was subscribed by another client, or because notifications the client hasn't
+// seen are no longer buffered. The client should subscribe afresh and fill the gap by other means.
+var ErrCannotResumeSubscription = errors.New("cannot resume subscription")
+
+// ethSubscribeResume is the resume token a client passes as the params of a newHeads EthSubscribe to
+// resume an earlier subscription, such as ["newHeads", {"resume": "0x...", "sinceSequence": 17}],
+// where resume is the ID of the subscription and sinceSequence the sequence number of the last
+// notification the client received for it.
+type ethSubscribeResume struct {
+	Resume        *ethtypes.EthSubscriptionID `json:"resume"`
+	SinceSequence uint64                      `json:"sinceSequence"`
+}
+
+// decodeSubscribeResume returns the resume token in the EthSubscribe params p, or nil if there is
+// none.
+func decodeSubscribeResume(p jsonrpc.RawParams) (*ethSubscribeResume, error) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(p, &params); err != nil || len(params) < 2 {
+		return nil, err
+	}
+	var resume ethSubscribeResume
+	if err := json.Unmarshal(params[1], &resume); err != nil {
+		return nil, xerrors.Errorf("decoding resume token: %w", err)
+	}
+	if resume.Resume == nil {
+		return nil, nil
+	}
+	return &resume, nil
+}
+
+// SequencedEthSubscriptionResponse is a notification of a newHeads subscription with replay
+// enabled, see WithEthSubscriptionReplayBuffer. Notifications are numbered in sequence from 1, and a
+// client that loses its connection can resume the subscription from the last sequence number it
+// received.
+type SequencedEthSubscriptionResponse struct {
+	ethtypes.EthSubscriptionResponse
+	Sequence uint64 `json:"sequence"`
+}
+
+// subscriptionReplay numbers the notifications of a subscription and keeps the most recent of them,
+// so that they can be replayed to a client that resumes the subscription after losing its
+// connection. While no client is attached, notifications are only kept.
+type subscriptionReplay struct {
+	size int
+	// owner is the identity of the client that subscribed, the only one that may resume it
+	owner string
+
+	lk      sync.Mutex
+	next    uint64
+	recent  []SequencedEthSubscriptionResponse // oldest first
+	deliver func(context.Context, *SequencedEthSubscriptionResponse) error
+	expiry  *time.Timer // set while detached
+}
+
+func newSubscriptionReplay(size int, owner string) *subscriptionReplay {
+	return &subscriptionReplay{size: size, owner: owner, next: 1}
+}
+
+// record numbers a notification and keeps it, delivering it to the attached client, if any.
+func (r *subscriptionReplay) record(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	n := SequencedEthSubscriptionResponse{EthSubscriptionResponse: *response, Sequence: r.next}
+	r.next++
+	r.recent = append(r.recent, n)
+	if len(r.recent) > r.size {
+		r.recent = append(r.recent[:0:0], r.recent[len(r.recent)-r.size:]...)
+	}
+	if r.deliver == nil {
+		return nil
+	}
+	return r.deliver(ctx, &n)
+}
+
+// detach stops delivering notifications until a client resumes the subscription, calling onExpire
+// if none has after retention.
+func (r *subscriptionReplay) detach(retention time.Duration, onExpire func()) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	r.deliver = nil
+	r.expiry = time.AfterFunc(retention, onExpire)
+}
+
+// resume attaches the client identified by owner to a detached subscription, first delivering the
+// notifications after sequence number since to it.
+func (r *subscriptionReplay) resume(ctx context.Context, owner string, since uint64, deliver func(context.Context, *SequencedEthSubscriptionResponse) error) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if owner != r.owner {
+		return xerrors.Errorf("%w: the subscription belongs to another client", ErrCannotResumeSubscription)
+	}
+	if r.expiry == nil {
+		return xerrors.Errorf("%w: the subscription is still in use by another connection", ErrCannotResumeSubscription)
+	}
+	if oldest := r.next - uint64(len(r.recent)); since+1 < oldest {
+		return xerrors.Errorf("%w: notifications after sequence %d are no longer buffered, the oldest is %d", ErrCannotResumeSubscription, since, oldest)
+	}
+	if !r.expiry.Stop() {
+		return xerrors.Errorf("%w: the subscription has expired", ErrCannotResumeSubscription)
+	}
+	r.expiry = nil
+
+	for i := range r.recent {
+		if n := &r.recent[i]; n.Sequence > since {
+			if err := deliver(ctx, n); err != nil {
+				log.Warnf("error replaying notification for subscription %s: %v", n.SubscriptionID, err)
+			}
+		}
+	}
+	r.deliver = deliver
+	return nil
+}
+
+func (r *subscriptionReplay) stop() {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	r.deliver = nil
+	if r.expiry != nil {
+		r.expiry.Stop()
+	}
+}
+
+// sequencedSink returns a function delivering sequenced notifications to the client through ethCb.
+func sequencedSink(ethCb api.EthSubscriberMethods) func(context.Context, *SequencedEthSubscriptionResponse) error {
+	return func(ctx context.Context, response *SequencedEthSubscriptionResponse) error {
+		outParam, err := json.Marshal(response)
+		if err != nil {
+			return err
+		}
+		return ethCb.EthSubscription(ctx, outParam)
+	}
+}
+
+// addReplayableSub is like AddSub, for a subscription whose notifications are numbered and the most
+// recent size of them kept, such that the client can resume the subscription with resumeSub after
+// losing its connection. Notifications are delivered to the client through ethCb, and only the
+// client identified by owner may resume the subscription.
+func (e *EthSubHandler) addReplayableSub(ctx context.Context, id ethtypes.EthSubscriptionID, size int, owner string, ethCb api.EthSubscriberMethods) (*subscriptionReplay, error) {
+	replay := newSubscriptionReplay(size, owner)
+	replay.deliver = sequencedSink(ethCb)
+
+	e.lk.Lock()
+	e.replays[id] = replay
+	e.lk.Unlock()
+
+	if err := e.AddSub(ctx, id, replay.record); err != nil {
+		e.RemoveSub(id)
+		return nil, err
+	}
+	return replay, nil
+}
+
+// resumeSub attaches the client identified by owner and reachable through ethCb to the detached
+// subscription id, replaying the notifications after sequence number since to it first.
+func (e *EthSubHandler) resumeSub(ctx context.Context, id ethtypes.EthSubscriptionID, owner string, since uint64, ethCb api.EthSubscriberMethods) (*subscriptionReplay, error) {
+	e.lk.Lock()
+	replay, ok := e.replays[id]
+	e.lk.Unlock()
+	if !ok {
+		return nil, xerrors.Errorf("%w: unknown or expired subscription %s", ErrCannotResumeSubscription, id)
+	}
+	if err := replay.resume(ctx, owner, since, sequencedSink(ethCb)); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// detachSubscription keeps the replayable subscription id of connection ft running on the target
+// once the connection has ended, in case the client resumes it from a new connection, and
+// unsubscribes it with unsubscribe if the client doesn't within the retention period. The
+// subscription keeps its place in the host's count until then, and hands it over to the connection
+// resuming it. ft.lk must be held.
+func (gw *Node) detachSubscription(
+	ft *statefulCallTracker,
+	subs *EthSubHandler,
+	id ethtypes.EthSubscriptionID,
+	replay *subscriptionReplay,
+	unsubscribe func(context.Context, ethtypes.EthSubscriptionID) (bool, error),
+) {
+	ft.detached++
+	replay.detach(gw.subscriptionReplayRetention, func() {
+		subs.RemoveSub(id)
+		ft.hostFilters.release(ft.host, 1)
+		if _, err := unsubscribe(context.Background(), id); err != nil {
+			log.Warnf("error unsubscribing expired subscription: %v", err)
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+	v1mocks "github.com/filecoin-project/lotus/api/mocks"
+	"github.com/filecoin-project/lotus/api/v2api/v2mocks"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+func TestEthSubHandlerBufferedSubOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var subID ethtypes.EthSubscriptionID
+	subID[0] = 1
+
+	notify := func(h *EthSubHandler, n int) error {
+		params, err := json.Marshal(ethtypes.EthSubscriptionResponse{SubscriptionID: subID, Result: n})
+		require.NoError(t, err)
+		return h.EthSubscription(ctx, params)
+	}
+
+	h := NewEthSubHandler()
+
+	// a deliberately slow consumer that doesn't receive anything until we let it
+	unblock := make(chan struct{})
+	received := make(chan struct{}, 10)
+	sink := func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+		}
+		received <- struct{}{}
+		return nil
+	}
+	dropped := make(chan struct{})
+	const bufferSize = 2
+	require.NoError(t, h.AddBufferedSub(ctx, subID, bufferSize, sink, func() { close(dropped) }))
+
+	// the first notification is taken by the delivery goroutine and blocks in the sink, wait for
+	// that to happen so the buffer is empty before we fill it
+	require.NoError(t, notify(h, 0))
+	require.Eventually(t, func() bool {
+		h.lk.Lock()
+		defer h.lk.Unlock()
+		return len(h.buffers[subID].queue) == 0
+	}, time.Second, time.Millisecond)
+
+	for i := 1; i <= bufferSize; i++ {
+		require.NoError(t, notify(h, i), "notification %d should have been buffered", i)
+	}
+
+	// the buffer is now full, the next notification should drop the subscription
+	require.ErrorIs(t, notify(h, bufferSize+1), ErrSubscriptionBufferFull)
+
+	select {
+	case <-dropped:
+	case <-time.After(time.Second):
+		t.Fatal("overflow callback wasn't called")
+	}
+
+	h.lk.Lock()
+	_, hasSink := h.sinks[subID]
+	_, hasBuffer := h.buffers[subID]
+	h.lk.Unlock()
+	require.False(t, hasSink, "dropped subscription should have been removed from the handler")
+	require.False(t, hasBuffer, "dropped subscription buffer should have been removed from the handler")
+
+	// let the consumer go; only the notification that was in flight is delivered
+	close(unblock)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("in flight notification wasn't delivered")
+	}
+	select {
+	case <-received:
+		t.Fatal("notifications buffered for a dropped subscription shouldn't be delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEthSubHandlerBufferedSubOutlivesRequest(t *testing.T) {
+	var subID ethtypes.EthSubscriptionID
+	subID[0] = 1
+
+	h := NewEthSubHandler()
+	received := make(chan int, 3)
+	sink := func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+		require.NoError(t, ctx.Err())
+		received <- int(response.Result.(float64))
+		return nil
+	}
+
+	// go-jsonrpc cancels the context of EthSubscribe as soon as it returns
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, h.AddBufferedSub(ctx, subID, 2, sink, nil))
+	cancel()
+
+	for i := 1; i <= 3; i++ {
+		params, err := json.Marshal(ethtypes.EthSubscriptionResponse{SubscriptionID: subID, Result: i})
+		require.NoError(t, err)
+		require.NoError(t, h.EthSubscription(context.Background(), params))
+		select {
+		case n := <-received:
+			require.Equal(t, i, n)
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d wasn't delivered", i)
+		}
+	}
+
+	// until the subscription is removed
+	h.RemoveSub(subID)
+	params, err := json.Marshal(ethtypes.EthSubscriptionResponse{SubscriptionID: subID, Result: 4})
+	require.NoError(t, err)
+	require.NoError(t, h.EthSubscription(context.Background(), params))
+	select {
+	case <-received:
+		t.Fatal("notification delivered after the subscription was removed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEthSubscriptionDropped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	a := NewNode(mockV1, v2mocks.NewMockFullNode(ctrl))
+
+	var id ethtypes.EthSubscriptionID
+	id[0] = 1
+	hosts := newHostFilterCounter(1)
+	ft := newStatefulCallTracker("host", hosts)
+	require.True(t, hosts.reserve("host"))
+	ft.userSubscriptions[id] = func() {}
+	ft.subscriptionTypes[id] = ethSubscribeLogs
+
+	// the target is unsubscribed with a live context, long after the subscribe request has returned
+	mockV1.EXPECT().EthUnsubscribe(gomock.Any(), id).DoAndReturn(func(ctx context.Context, _ ethtypes.EthSubscriptionID) (bool, error) {
+		require.NoError(t, ctx.Err())
+		return true, nil
+	})
+	var notified []ethtypes.EthSubscriptionResponse
+	ethCb := api.EthSubscriberMethods{EthSubscription: func(_ context.Context, p jsonrpc.RawParams) error {
+		var response ethtypes.EthSubscriptionResponse
+		require.NoError(t, json.Unmarshal(p, &response))
+		notified = append(notified, response)
+		return nil
+	}}
+	a.v1Proxy.dropSubscription(ft, id, ethCb)
+
+	// and the client is told why
+	require.Len(t, notified, 1)
+	require.Equal(t, map[string]interface{}{"unsubscribed": true, "reason": ErrSubscriptionBufferFull.Error()}, notified[0].Result)
+	require.NotContains(t, ft.userSubscriptions, id)
+	require.True(t, hosts.reserve("host"), "the host's count should have been released")
+}
+
+func TestEthSubscriptionPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	subscribe := func(gw *Node, ft *statefulCallTracker, h *EthSubHandler, eventType string, n byte) (*ethtypes.EthSubscriptionID, error) {
+		evict, _, err := gw.reserveSubscription(ft, h, eventType, false)
+		if err != nil {
+			return nil, err
+		}
+		var id ethtypes.EthSubscriptionID
+		id[0] = n
+		require.NoError(t, h.AddSub(ctx, id, func(context.Context, *ethtypes.EthSubscriptionResponse) error { return nil }))
+		ft.userSubscriptions[id] = func() {}
+		ft.subscriptionTypes[id] = eventType
+		return evict, nil
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+
+	policy := EthSubscriptionPolicy{MaxNewHeads: 2, MaxLogs: 1}
+
+	t.Run("reject", func(t *testing.T) {
+		gw := NewNode(mockV1, mockV2, WithEthMaxFiltersPerConn(4), WithEthSubscriptionPolicy(policy))
+		ft, h := newStatefulCallTracker("", nil), NewEthSubHandler()
+
+		for i := byte(1); i <= 2; i++ {
+			evict, err := subscribe(gw, ft, h, ethSubscribeNewHeads, i)
+			require.NoError(t, err)
+			require.Nil(t, evict)
+		}
+		_, err := subscribe(gw, ft, h, ethSubscribeNewHeads, 3)
+		require.ErrorIs(t, err, ErrTooManySubscriptionsOfType)
+
+		// other types have caps of their own, within the per connection limit
+		_, err = subscribe(gw, ft, h, ethSubscribeLogs, 4)
+		require.NoError(t, err)
+		_, err = subscribe(gw, ft, h, ethSubscribeLogs, 5)
+		require.ErrorIs(t, err, ErrTooManySubscriptionsOfType)
+		_, err = subscribe(gw, ft, h, ethSubscribeNewPendingTransactions, 6)
+		require.NoError(t, err)
+		_, err = subscribe(gw, ft, h, ethSubscribeNewPendingTransactions, 7)
+		require.ErrorIs(t, err, ErrTooManyFilters)
+	})
+
+	t.Run("evict oldest", func(t *testing.T) {
+		policy := policy
+		policy.OnCap = EvictOldestSubscription
+		gw := NewNode(mockV1, mockV2, WithEthMaxFiltersPerConn(4), WithEthSubscriptionPolicy(policy))
+		ft, h := newStatefulCallTracker("", nil), NewEthSubHandler()
+
+		var first ethtypes.EthSubscriptionID
+		first[0] = 1
+		for i := byte(1); i <= 2; i++ {
+			_, err := subscribe(gw, ft, h, ethSubscribeNewHeads, i)
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond) // so that creation times differ
+		}
+
+		evict, err := subscribe(gw, ft, h, ethSubscribeNewHeads, 3)
+		require.NoError(t, err)
+		require.NotNil(t, evict)
+		require.Equal(t, first, *evict)
+
+		var unsubscribed []ethtypes.EthSubscriptionID
+		unsubscribe := func(_ context.Context, id ethtypes.EthSubscriptionID) (bool, error) {
+			unsubscribed = append(unsubscribed, id)
+			return true, nil
+		}
+		var notified []ethtypes.EthSubscriptionResponse
+		ethCb := api.EthSubscriberMethods{EthSubscription: func(_ context.Context, p jsonrpc.RawParams) error {
+			var response ethtypes.EthSubscriptionResponse
+			require.NoError(t, json.Unmarshal(p, &response))
+			notified = append(notified, response)
+			return nil
+		}}
+		evictSubscription(ctx, ft, h, *evict, unsubscribe, ethCb)
+
+		require.Equal(t, []ethtypes.EthSubscriptionID{first}, unsubscribed)
+		require.Len(t, notified, 1)
+		require.Equal(t, first, notified[0].SubscriptionID)
+		require.Equal(t, map[string]interface{}{
+			"unsubscribed": true,
+			"reason":       "evicted to make room for a newer subscription of the same type",
+		}, notified[0].Result)
+		require.NotContains(t, ft.userSubscriptions, first)
+		require.NotContains(t, ft.subscriptionTypes, first)
+		require.NotContains(t, h.sinks, first)
+		require.Len(t, ft.userSubscriptions, 2)
+	})
+}
+
+func TestEthSubscriptionReplay(t *testing.T) {
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	gw := NewNode(v1mocks.NewMockFullNode(ctrl), v2mocks.NewMockFullNode(ctrl), WithEthSubscriptionReplayBuffer(2), WithEthMaxFiltersPerHost(1))
+	gw.subscriptionReplayRetention = time.Hour
+	h := NewEthSubHandler()
+	// the subscription is counted against the host as it would be by EthSubscribe
+	connect := func() *statefulCallTracker {
+		return newStatefulCallTracker("host", gw.hostFilters)
+	}
+	ft := connect()
+	require.True(t, ft.hostFilters.reserve(ft.host))
+
+	var id ethtypes.EthSubscriptionID
+	id[0] = 1
+	notify := func(head int) {
+		p, err := json.Marshal(ethtypes.EthSubscriptionResponse{SubscriptionID: id, Result: head})
+		require.NoError(t, err)
+		require.NoError(t, h.EthSubscription(ctx, p))
+	}
+	client := func() (api.EthSubscriberMethods, *[]uint64) {
+		var received []uint64
+		return api.EthSubscriberMethods{EthSubscription: func(_ context.Context, p jsonrpc.RawParams) error {
+			var response SequencedEthSubscriptionResponse
+			require.NoError(t, json.Unmarshal(p, &response))
+			require.Equal(t, id, response.SubscriptionID)
+			require.Equal(t, float64(response.Sequence), response.Result, "heads are numbered in sequence")
+			received = append(received, response.Sequence)
+			return nil
+		}}, &received
+	}
+	unsubscribed := make(chan ethtypes.EthSubscriptionID, 1)
+	unsubscribe := func(_ context.Context, id ethtypes.EthSubscriptionID) (bool, error) {
+		unsubscribed <- id
+		return true, nil
+	}
+
+	first, received := client()
+	replay, err := h.addReplayableSub(ctx, id, 2, ft.host, first)
+	require.NoError(t, err)
+	ft.userSubscriptions[id] = func() { gw.detachSubscription(ft, h, id, replay, unsubscribe) }
+	notify(1)
+	require.Equal(t, []uint64{1}, *received)
+
+	// heads are kept while the connection is gone, and replayed to the client resuming; the
+	// detached subscription is still counted against the host
+	ft.cleanup()
+	require.False(t, gw.hostFilters.reserve("host"))
+	notify(2)
+	notify(3)
+	second, received := client()
+	_, err = h.resumeSub(ctx, id, "another host", 1, second)
+	require.ErrorIs(t, err, ErrCannotResumeSubscription, "only the client that subscribed may resume")
+	require.Empty(t, *received)
+	_, err = h.resumeSub(ctx, id, "host", 1, second)
+	require.NoError(t, err)
+	notify(4)
+	require.Equal(t, []uint64{2, 3, 4}, *received)
+	require.False(t, gw.hostFilters.reserve("host"), "the resuming connection takes over the host's count")
+
+	// a subscription in use can't be taken over
+	_, err = h.resumeSub(ctx, id, "host", 4, first)
+	require.ErrorIs(t, err, ErrCannotResumeSubscription)
+
+	// nor resumed once heads the client missed are no longer kept
+	ft = connect()
+	gw.detachSubscription(ft, h, id, replay, unsubscribe)
+	for head := 5; head <= 7; head++ {
+		notify(head)
+	}
+	third, received := client()
+	_, err = h.resumeSub(ctx, id, "host", 4, third)
+	require.ErrorIs(t, err, ErrCannotResumeSubscription)
+	_, err = h.resumeSub(ctx, id, "host", 5, third)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{6, 7}, *received)
+
+	// subscriptions not resumed in time are unsubscribed, and stop being counted against the host
+	gw.subscriptionReplayRetention = time.Millisecond
+	ft = connect()
+	gw.detachSubscription(ft, h, id, replay, unsubscribe)
+	require.Equal(t, id, <-unsubscribed)
+	_, err = h.resumeSub(ctx, id, "host", 7, third)
+	require.ErrorIs(t, err, ErrCannotResumeSubscription)
+	require.NotContains(t, h.sinks, id)
+	require.True(t, gw.hostFilters.reserve("host"))
+
+	// resume tokens
+	resume, err := decodeSubscribeResume(jsonrpc.RawParams(`["newHeads"]`))
+	require.NoError(t, err)
+	require.Nil(t, resume)
+	p, err := json.Marshal([]interface{}{"newHeads", map[string]interface{}{"resume": id, "sinceSequence": 3}})
+	require.NoError(t, err)
+	resume, err = decodeSubscribeResume(p)
+	require.NoError(t, err)
+	require.Equal(t, &ethSubscribeResume{Resume: &id, SinceSequence: 3}, resume)
+}
@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+var (
+	ethTxType         = reflect.TypeOf(ethtypes.EthTx{})
+	ethTxPtrType      = reflect.TypeOf(&ethtypes.EthTx{})
+	ethBlockType      = reflect.TypeOf(ethtypes.EthBlock{})
+	ethBlockSliceType = reflect.TypeOf([]ethtypes.EthBlock{})
+)
+
+// ethTxRedactor clears configured fields of the Eth transactions returned to clients.
+type ethTxRedactor struct {
+	fields []int                  // indices of the EthTx fields to clear
+	zero   map[string]interface{} // the JSON of the cleared fields, for transactions decoded as JSON
+}
+
+// newEthTxRedactor returns a redactor for the EthTx fields with the given JSON names, e.g. "input".
+// Unknown names are logged and ignored.
+func newEthTxRedactor(names []string) *ethTxRedactor {
+	byName := make(map[string]int, ethTxType.NumField())
+	for i := 0; i < ethTxType.NumField(); i++ {
+		name, _, _ := strings.Cut(ethTxType.Field(i).Tag.Get("json"), ",")
+		byName[name] = i
+	}
+
+	var zero map[string]interface{}
+	if b, err := json.Marshal(ethtypes.EthTx{}); err == nil {
+		_ = json.Unmarshal(b, &zero)
+	}
+
+	r := &ethTxRedactor{zero: make(map[string]interface{}, len(names))}
+	for _, name := range names {
+		i, ok := byName[name]
+		if !ok {
+			log.Errorw("ignoring unknown Eth transaction field in field policy", "field", name)
+			continue
+		}
+		r.fields = append(r.fields, i)
+		r.zero[name] = zero[name] // nil for omitted, optional fields
+	}
+	return r
+}
+
+func (r *ethTxRedactor) tx(tx ethtypes.EthTx) ethtypes.EthTx {
+	v := reflect.ValueOf(&tx).Elem()
+	for _, i := range r.fields {
+		v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+	}
+	return tx
+}
+
+// block returns blk with its full transactions, if it has them, redacted. Blocks may be shared with
+// a cache, so the transactions are copied rather than redacted in place.
+func (r *ethTxRedactor) block(blk ethtypes.EthBlock) ethtypes.EthBlock {
+	txs := make([]interface{}, len(blk.Transactions))
+	for i, tx := range blk.Transactions {
+		switch tx := tx.(type) {
+		case ethtypes.EthTx:
+			txs[i] = r.tx(tx)
+		case *ethtypes.EthTx:
+			redacted := r.tx(*tx)
+			txs[i] = &redacted
+		case map[string]interface{}:
+			// a transaction as decoded from the target's JSON response
+			redacted := make(map[string]interface{}, len(tx))
+			for k, v := range tx {
+				redacted[k] = v
+			}
+			for k, v := range r.zero {
+				if v == nil {
+					delete(redacted, k)
+				} else {
+					redacted[k] = v
+				}
+			}
+			txs[i] = redacted
+		default:
+			txs[i] = tx // a transaction hash
+		}
+	}
+	blk.Transactions = txs
+	return blk
+}
+
+// result returns a method result with any transactions it carries redacted.
+func (r *ethTxRedactor) result(v reflect.Value) reflect.Value {
+	switch v.Type() {
+	case ethTxPtrType:
+		if v.IsNil() {
+			return v
+		}
+		tx := r.tx(*v.Interface().(*ethtypes.EthTx))
+		return reflect.ValueOf(&tx)
+	case ethBlockType:
+		return reflect.ValueOf(r.block(v.Interface().(ethtypes.EthBlock)))
+	case ethBlockSliceType:
+		blks := v.Interface().([]ethtypes.EthBlock)
+		if blks == nil {
+			return v
+		}
+		redacted := make([]ethtypes.EthBlock, len(blks))
+		for i, blk := range blks {
+			redacted[i] = r.block(blk)
+		}
+		return reflect.ValueOf(redacted)
+	}
+	return v
+}
+
+// ethTxFieldPolicyV1 wraps the v1 gateway API such that the transactions returned by its methods,
+// alone or as part of blocks, are redacted by r.
+func ethTxFieldPolicyV1(gw api.Gateway, r *ethTxRedactor) api.Gateway {
+	var out api.GatewayStruct
+	redactResults(gw, &out, r)
+	return &out
+}
+
+// ethTxFieldPolicyV2 wraps the v2 gateway API such that the transactions returned by its methods,
+// alone or as part of blocks, are redacted by r.
+func ethTxFieldPolicyV2(gw v2api.Gateway, r *ethTxRedactor) v2api.Gateway {
+	var out v2api.GatewayStruct
+	redactResults(gw, &out, r)
+	return &out
+}
+
+func redactResults(in interface{}, outstr interface{}, r *ethTxRedactor) {
+	wrapMethods(in, outstr, func(_ string, fn reflect.Value) reflect.Value {
+		if fn.Type().NumOut() != 2 {
+			return fn
+		}
+		switch fn.Type().Out(0) {
+		case ethTxPtrType, ethBlockType, ethBlockSliceType:
+		default:
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			results := fn.Call(args)
+			results[0] = r.result(results[0])
+			return results
+		})
+	})
+}
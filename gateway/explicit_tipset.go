@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrExplicitTipSetRequired is returned when the gateway requires clients to name the tipset they're
+// reading from, and a state or chain read is called with an empty tipset key.
+var ErrExplicitTipSetRequired = errors.New("an explicit tipset key is required")
+
+// explicitTipSetV1 wraps the v1 gateway API such that state, chain and multisig reads called with an
+// empty tipset key, which would otherwise default to the chain head, are rejected. Eth methods,
+// which have their own block param semantics, are unaffected.
+func explicitTipSetV1(gw api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	wrapMethods(gw, &out, func(method string, fn reflect.Value) reflect.Value {
+		if !strings.HasPrefix(method, "State") && !strings.HasPrefix(method, "Chain") && !strings.HasPrefix(method, "Msig") {
+			return fn
+		}
+		var tskArgs []int
+		for i := 0; i < fn.Type().NumIn(); i++ {
+			if fn.Type().In(i) == tipSetKeyType {
+				tskArgs = append(tskArgs, i)
+			}
+		}
+		errOut := fn.Type().NumOut() - 1
+		if len(tskArgs) == 0 || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			for _, i := range tskArgs {
+				if tsk := args[i].Interface().(types.TipSetKey); tsk.IsEmpty() {
+					return errorResults(fn.Type(), xerrors.Errorf("%w: %s called without a tipset key", ErrExplicitTipSetRequired, method))
+				}
+			}
+			return fn.Call(args)
+		})
+	})
+	return &out
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// TargetAPI is a node the gateway can send calls to, through both versions of its API.
+type TargetAPI struct {
+	V1 v1api.FullNode
+	V2 v2api.FullNode
+}
+
+// targetWriteMethods are the target methods that write to the chain, by submitting messages.
+var targetWriteMethods = map[string]bool{
+	"MpoolPush":                      true,
+	"MpoolPushUntrusted":             true,
+	"MpoolPushMessage":               true,
+	"MpoolBatchPush":                 true,
+	"MpoolBatchPushUntrusted":        true,
+	"MpoolBatchPushMessage":          true,
+	"EthSendRawTransaction":          true,
+	"EthSendRawTransactionUntrusted": true,
+}
+
+// targetFilterMethods are the target methods that create or use Ethereum filters and subscriptions.
+var targetFilterMethods = map[string]bool{
+	"EthNewFilter":                   true,
+	"EthNewBlockFilter":              true,
+	"EthNewPendingTransactionFilter": true,
+	"EthGetFilterChanges":            true,
+	"EthGetFilterLogs":               true,
+	"EthUninstallFilter":             true,
+	"EthSubscribe":                   true,
+	"EthUnsubscribe":                 true,
+}
+
+// checkFallbackTargets returns an error naming the fallback targets that don't set both versions of
+// the API, which can't be failed over to.
+func checkFallbackTargets(targets []TargetAPI) error {
+	var incomplete []string
+	for i, t := range targets {
+		if t.V1 == nil || t.V2 == nil {
+			incomplete = append(incomplete, strconv.Itoa(i))
+		}
+	}
+	if len(incomplete) > 0 {
+		return xerrors.Errorf("fallback targets without both API versions: %s", strings.Join(incomplete, ", "))
+	}
+	return nil
+}
+
+// failoverV1 wraps the v1 target such that calls that fail because the target couldn't be reached
+// are retried on each of the fallbacks in turn, until one can be reached. Writes are never retried,
+// as the target that couldn't be reached may have accepted them all the same, and neither are the
+// filter and subscription methods, as filters and subscriptions only exist on the target they were
+// created on.
+func failoverV1(server v1api.FullNode, fallbacks []TargetAPI) v1api.FullNode {
+	others := make([]interface{}, len(fallbacks))
+	for i, fb := range fallbacks {
+		others[i] = fb.V1
+	}
+	var out v1api.FullNodeStruct
+	failover(server, others, &out)
+	return &out
+}
+
+// failoverV2 wraps the v2 target such that calls that fail because the target couldn't be reached
+// are retried on each of the fallbacks in turn, until one can be reached, except for writes and the
+// filter and subscription methods, as for failoverV1.
+func failoverV2(server v2api.FullNode, fallbacks []TargetAPI) v2api.FullNode {
+	others := make([]interface{}, len(fallbacks))
+	for i, fb := range fallbacks {
+		others[i] = fb.V2
+	}
+	var out v2api.FullNodeStruct
+	failover(server, others, &out)
+	return &out
+}
+
+func failover(in interface{}, fallbacks []interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if targetWriteMethods[method] || targetFilterMethods[method] || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		fallbackFns := make([]reflect.Value, len(fallbacks))
+		for i, fb := range fallbacks {
+			fallbackFns[i] = reflect.ValueOf(fb).MethodByName(method)
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := contextArg(args)
+			results := fn.Call(args)
+			for i, fallback := range fallbackFns {
+				err, _ := results[errOut].Interface().(error)
+				if err == nil || !isBackendUnavailable(err) || ctx.Err() != nil {
+					break
+				}
+				backend := fmt.Sprintf("fallback-%d", i+1)
+				log.Debugw("target unavailable, failing over", "method", method, "backend", backend, "error", err)
+				_ = stats.RecordWithTags(ctx, []tag.Mutator{
+					tag.Upsert(metrics.Endpoint, method),
+					tag.Upsert(metrics.Backend, backend),
+				}, metrics.GatewayFailovers.M(1))
+				results = fallback.Call(args)
+			}
+			return results
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// fanout calls fn concurrently for each of the n sub-calls of a batch method. The number of
+// sub-calls in flight across all batch methods is bounded by the gateway's batch fan-out
+// concurrency, so a batch can't be used to get around the limits placed on individual calls. The
+// first error returned by a sub-call cancels the context passed to the remaining sub-calls and is
+// returned.
+func (gw *Node) fanout(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	return fanoutWith(ctx, gw.batchFanout, n, fn)
+}
+
+// fanoutWith is like fanout, but with the sub-calls in flight bounded by sem, or unbounded if sem
+// is nil.
+func fanoutWith(ctx context.Context, sem *semaphore.Weighted, n int, fn func(ctx context.Context, i int) error) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < n; i++ {
+		if sem != nil {
+			if err := sem.Acquire(ctx, 1); err != nil {
+				// prefer the sub-call error that caused the cancellation, if there was one
+				if werr := eg.Wait(); werr != nil {
+					return werr
+				}
+				return err
+			}
+		}
+		eg.Go(func() error {
+			if sem != nil {
+				defer sem.Release(1)
+			}
+			return fn(ctx, i)
+		})
+	}
+	return eg.Wait()
+}
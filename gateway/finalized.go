@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// UnfinalizedResponseHeader is set on the HTTP response to a request for which at least one state
+// read defaulted to the chain head rather than the latest finalized tipset, because no F3
+// finality certificate was available.
+const UnfinalizedResponseHeader = "X-Lotus-Gateway-Unfinalized"
+
+var tipSetKeyType = reflect.TypeOf(types.TipSetKey{})
+
+// finalizedReadsV1 wraps the v1 target such that state methods called with an empty tipset key,
+// which would otherwise read from the chain head, read from the latest finalized tipset instead.
+// Message searches are left reading from the head, as a search from the finalized tipset wouldn't
+// find recently executed messages.
+func finalizedReadsV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	wrapMethods(server, &out, func(method string, fn reflect.Value) reflect.Value {
+		if !strings.HasPrefix(method, "State") || method == "StateSearchMsg" {
+			return fn
+		}
+		tskArg := -1
+		for i := 0; i < fn.Type().NumIn(); i++ {
+			if fn.Type().In(i) == tipSetKeyType {
+				tskArg = i
+			}
+		}
+		if tskArg < 0 {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			if tsk := args[tskArg].Interface().(types.TipSetKey); tsk.IsEmpty() {
+				args[tskArg] = reflect.ValueOf(finalizedTipSetKey(contextArg(args), server))
+			}
+			return fn.Call(args)
+		})
+	})
+	return &out
+}
+
+// finalizedTipSetKey returns the key of the tipset finalized by the latest F3 certificate. If F3
+// is unavailable, the empty key is returned so that the read falls back to the head, and the
+// response is annotated with UnfinalizedResponseHeader.
+func finalizedTipSetKey(ctx context.Context, server v1api.FullNode) types.TipSetKey {
+	cert, err := server.F3GetLatestCertificate(ctx)
+	if err == nil && cert != nil && !cert.ECChain.IsZero() {
+		var tsk types.TipSetKey
+		if tsk, err = types.TipSetKeyFromBytes(cert.ECChain.Head().Key); err == nil {
+			return tsk
+		}
+	}
+	log.Debugw("no finalized tipset available, reading state from the head", "error", err)
+	setResponseHeader(ctx, UnfinalizedResponseHeader, "true")
+	return types.EmptyTSK
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls made for the same key into a single call, sharing its
+// result with every caller, such that a burst of identical requests results in one target call.
+type flightGroup[K comparable, V any] struct {
+	lk      sync.Mutex
+	flights map[K]*flight[V]
+}
+
+type flight[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// do calls fn and returns its result, unless a call for key is already in flight, in which case it
+// waits for that call to complete and returns its result instead. A caller waiting on another's
+// call stops waiting if ctx is done.
+func (g *flightGroup[K, V]) do(ctx context.Context, key K, fn func() (V, error)) (V, error) {
+	g.lk.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.lk.Unlock()
+		select {
+		case <-f.done:
+			return f.value, f.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	if g.flights == nil {
+		g.flights = make(map[K]*flight[V])
+	}
+	f := &flight[V]{done: make(chan struct{})}
+	g.flights[key] = f
+	g.lk.Unlock()
+
+	defer func() {
+		g.lk.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.lk.Unlock()
+		close(f.done)
+	}()
+	f.value, f.err = fn()
+	return f.value, f.err
+}
+
+// forget stops later calls from waiting for the calls in flight, for when their results are no
+// longer current. The calls in flight still complete, and return their results to those already
+// waiting for them.
+func (g *flightGroup[K, V]) forget() {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	g.flights = nil
+}
@@ -1,19 +1,23 @@
 package gateway
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
 	"github.com/gorilla/mux"
 	promclient "github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-jsonrpc"
 
@@ -26,11 +30,27 @@ import (
 
 type perConnectionAPIRateLimiterKeyType string
 type filterTrackerKeyType string
+type responseHeadersKeyType string
+type blockParamBatchKeyType string
+type targetFailedKeyType string
+type batchRequestKeyType string
+type queueNotifyKeyType string
+type rateLimitMethodKeyType string
+type lookbackMethodKeyType string
+type requestFailedKeyType string
 
 const (
 	perConnectionAPIRateLimiterKey   perConnectionAPIRateLimiterKeyType = "limiter"
 	statefulCallTrackerKeyV1         filterTrackerKeyType               = "statefulCallTrackerV1"
 	statefulCallTrackerKeyV2         filterTrackerKeyType               = "statefulCallTrackerV2"
+	responseHeadersKey               responseHeadersKeyType             = "responseHeaders"
+	blockParamBatchKey               blockParamBatchKeyType             = "blockParamBatch"
+	targetFailedKey                  targetFailedKeyType                = "targetFailed"
+	batchRequestKey                  batchRequestKeyType                = "batchRequest"
+	queueNotifyKey                   queueNotifyKeyType                 = "queueNotify"
+	rateLimitMethodKey               rateLimitMethodKeyType             = "rateLimitMethod"
+	lookbackMethodKey                lookbackMethodKeyType              = "lookbackMethod"
+	requestFailedKey                 requestFailedKeyType               = "requestFailed"
 	connectionLimiterCleanupInterval                                    = 30 * time.Second
 )
 
@@ -42,9 +62,15 @@ type ShutdownHandler interface {
 }
 
 var _ ShutdownHandler = (*statefulCallHandler)(nil)
+var _ ShutdownHandler = (*responseHeaderHandler)(nil)
+var _ ShutdownHandler = (*blockParamBatchHandler)(nil)
 var _ ShutdownHandler = (*RateLimitHandler)(nil)
 var _ ShutdownHandler = (*CORSHandler)(nil)
 var _ ShutdownHandler = (*LoggingHandler)(nil)
+var _ ShutdownHandler = (*identityHandler)(nil)
+var _ ShutdownHandler = (*cborResponseHandler)(nil)
+var _ ShutdownHandler = (*batchRequestHandler)(nil)
+var _ ShutdownHandler = (*queueNotifyHandler)(nil)
 
 // handlerOptions holds the options for the Handler function.
 type handlerOptions struct {
@@ -53,6 +79,10 @@ type handlerOptions struct {
 	jsonrpcServerOptions        []jsonrpc.ServerOption
 	enableCORS                  bool
 	enableRequestLogging        bool
+	accessLogSampleRate         float64
+	identityExtractor           IdentityExtractor
+	enableCBORResponses         bool
+	adminToken                  string
 }
 
 // HandlerOption is a functional option for configuring the Handler.
@@ -101,11 +131,58 @@ func WithRequestLogging(enable bool) HandlerOption {
 	}
 }
 
+// WithAccessLogSampling sets the fraction, between 0 and 1, of requests that are logged when request
+// logging is enabled. Requests that fail, with an HTTP error status or with a JSON-RPC error from
+// any of the gateway methods they call, including rate limit rejections, are always logged. The
+// default of 1 logs every request.
+func WithAccessLogSampling(rate float64) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.accessLogSampleRate = rate
+	}
+}
+
+// WithIdentityExtractor sets how the client making a request is identified, for the features that
+// apply per client: the per host connection rate limit, the per IP rate limit and the per host
+// filter limit. By default clients are identified by their remote IP address, see
+// RemoteIPIdentity; deployments behind a proxy may instead want to identify clients by a forwarded
+// address, see ForwardedForIdentity, an API key header or a TLS client certificate. Requests for
+// which the extractor returns an empty Identity are identified by their remote IP address.
+func WithIdentityExtractor(extractor IdentityExtractor) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.identityExtractor = extractor
+	}
+}
+
+// WithCBORResponses sets whether to serve results CBOR encoded to clients that list CBORContentType
+// in the Accept header of an HTTP request. Only methods whose result type supports CBOR encoding,
+// such as ChainGetBlock and ChainGetMessage, are served CBOR encoded; the response body is then the
+// CBOR encoding of the result alone. Other methods, errors, batch requests and websocket connections
+// are always served as JSON-RPC over JSON.
+func WithCBORResponses(enable bool) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.enableCBORResponses = enable
+	}
+}
+
+// WithAdminToken serves the admin API (see AdminStruct) on AdminPath to callers presenting token as
+// a bearer token. An empty token (the default) doesn't serve it.
+func WithAdminToken(token string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.adminToken = token
+	}
+}
+
 // Handler returns a gateway http.Handler, to be mounted as-is on the server. The handler is
 // returned as a ShutdownHandler which allows for graceful shutdown of the handler via its
-// Shutdown method.
+// Shutdown method. An error is returned if the gateway was created with options that conflict.
 func Handler(gateway *Node, options ...HandlerOption) (ShutdownHandler, error) {
-	opts := &handlerOptions{}
+	if err := gateway.Err(); err != nil {
+		return nil, err
+	}
+	opts := &handlerOptions{
+		accessLogSampleRate: 1,
+		identityExtractor:   RemoteIPIdentity,
+	}
 	for _, option := range options {
 		option(opts)
 	}
@@ -123,8 +200,8 @@ func Handler(gateway *Node, options ...HandlerOption) (ShutdownHandler, error) {
 		m.Handle(path, rpcServer)
 	}
 
-	v2Gateway := proxy.MetricedGatewayV2API(gateway.V2ReverseProxy())
-	v1Gateway := proxy.MetricedGatewayAPI(gateway.V1ReverseProxy())
+	v2Gateway := proxy.MetricedGatewayV2API(countRequestsV2(gateway.V2ReverseProxy()))
+	v1Gateway := proxy.MetricedGatewayAPI(countRequestsV1(gateway.V1ReverseProxy()))
 	v0Gateway := lapi.Wrap(new(v1api.FullNodeStruct), new(v0api.WrapperV1Full), v1Gateway)
 	serveRpc("/rpc/v2", v2Gateway)
 	serveRpc("/rpc/v1", v1Gateway)
@@ -141,13 +218,28 @@ func Handler(gateway *Node, options ...HandlerOption) (ShutdownHandler, error) {
 	m.Handle("/debug/metrics", exporter)
 	m.Handle("/health/livez", node.NewLiveHandler(gateway.v1Proxy.server))
 	m.Handle("/health/readyz", node.NewReadyHandler(gateway.v1Proxy.server))
+	if opts.adminToken != "" {
+		m.Handle(AdminPath, adminHandler(gateway, opts.adminToken, jsonrpc.WithServerErrors(lapi.RPCErrors)))
+	}
 	m.PathPrefix("/").Handler(http.DefaultServeMux)
 
-	var handler http.Handler = &statefulCallHandler{m}
+	var handler http.Handler = &statefulCallHandler{next: m, hostFilters: gateway.hostFilters, connections: gateway.connections}
 
-	// Apply logging middleware if enabled
-	if opts.enableRequestLogging {
-		handler = NewLoggingHandler(handler)
+	// Check each distinct block param referenced by a batch request once
+	handler = &blockParamBatchHandler{next: handler, maxBlockParams: gateway.ethBatchMaxBlockParams}
+
+	// Mark batch requests so that their calls are limited, limit their size and cost, and charge
+	// them against the rate limit as a whole
+	handler = &batchRequestHandler{next: handler, gw: gateway, maxCost: gateway.batchMaxCost, maxSize: gateway.batchMaxSize}
+
+	// Re-encode results as CBOR for clients that ask for it, if enabled
+	if opts.enableCBORResponses {
+		handler = newCBORResponseHandler(handler)
+	}
+
+	// Set response headers for stale and deprecated responses
+	if gateway.serveStaleOnOutage || gateway.defaultFinalizedReads || len(gateway.deprecatedMethods) > 0 {
+		handler = &responseHeaderHandler{next: handler}
 	}
 
 	// Apply CORS wrapper if enabled
@@ -165,18 +257,39 @@ func Handler(gateway *Node, options ...HandlerOption) (ShutdownHandler, error) {
 		)
 	}
 
+	// Apply logging middleware if enabled, outside of rate limiting so that rate limited requests
+	// are logged
+	if opts.enableRequestLogging {
+		handler = NewSampledLoggingHandler(handler, opts.accessLogSampleRate)
+	}
+
+	// Send queue positions for requests queued by the rate limit, if enabled, outside of the other
+	// handlers so that their informational responses aren't mistaken for final responses
+	if gateway.queueThreshold > 0 {
+		handler = &queueNotifyHandler{next: handler}
+	}
+
+	// Identify the client before anything else so it's available throughout
+	handler = &identityHandler{next: handler, extract: opts.identityExtractor}
+
 	return handler.(ShutdownHandler), nil
 }
 
 type statefulCallHandler struct {
-	next http.Handler
+	next        http.Handler
+	hostFilters *hostFilterCounter
+	connections *connectionRegistry
 }
 
 func (h statefulCallHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	tracker := newStatefulCallTracker()
+	tracker := newStatefulCallTracker(string(requestIdentity(r)), h.hostFilters)
 	defer func() {
 		go tracker.cleanup()
 	}()
+	if h.connections != nil && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// a websocket connection is served for as long as it's open
+		defer h.connections.add(tracker, r.URL.Path)()
+	}
 	if strings.HasPrefix(r.URL.Path, "/rpc/v2") {
 		// Scope v2 handling of stateful calls separately to avoid any accidental
 		// cross-contamination in request handling between the two.
@@ -191,6 +304,90 @@ func (h statefulCallHandler) Shutdown(ctx context.Context) error {
 	return shutdown(ctx, h.next)
 }
 
+// responseHeaderHandler sets headers added by the gateway while serving a request, such as the
+// StaleResponseHeader, on its HTTP response. Websocket connections are passed through untouched as
+// they have no per-request response headers.
+type responseHeaderHandler struct {
+	next http.Handler
+}
+
+func (h responseHeaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	ctx, headers := withResponseHeaders(r.Context())
+	h.next.ServeHTTP(&responseHeaderWriter{ResponseWriter: w, headers: headers}, r.WithContext(ctx))
+}
+
+func (h responseHeaderHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
+
+type responseHeaderWriter struct {
+	http.ResponseWriter
+	headers     *responseHeaders
+	wroteHeader bool
+}
+
+func (w *responseHeaderWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		for key, values := range w.headers.header() {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// responseHeaders collects the headers to be set on the HTTP response to a request while it's
+// being served.
+type responseHeaders struct {
+	lk sync.Mutex
+	h  http.Header
+}
+
+// withResponseHeaders returns a context that collects the headers added with addResponseHeader and
+// setResponseHeader for the request it's used for.
+func withResponseHeaders(ctx context.Context) (context.Context, *responseHeaders) {
+	headers := &responseHeaders{h: make(http.Header)}
+	return context.WithValue(ctx, responseHeadersKey, headers), headers
+}
+
+// addResponseHeader adds a header to the HTTP response of the request ctx belongs to, if it has one.
+func addResponseHeader(ctx context.Context, key, value string) {
+	if headers, ok := ctx.Value(responseHeadersKey).(*responseHeaders); ok {
+		headers.lk.Lock()
+		headers.h.Add(key, value)
+		headers.lk.Unlock()
+	}
+}
+
+// setResponseHeader sets a header on the HTTP response of the request ctx belongs to, if it has
+// one, replacing any existing values for it.
+func setResponseHeader(ctx context.Context, key, value string) {
+	if headers, ok := ctx.Value(responseHeadersKey).(*responseHeaders); ok {
+		headers.lk.Lock()
+		headers.h.Set(key, value)
+		headers.lk.Unlock()
+	}
+}
+
+func (h *responseHeaders) header() http.Header {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	return h.h.Clone()
+}
+
 type hostLimiter struct {
 	limiter    *rate.Limiter
 	lastAccess time.Time
@@ -198,7 +395,7 @@ type hostLimiter struct {
 
 type RateLimitHandler struct {
 	cancelFunc                   context.CancelFunc
-	limiters                     map[string]*hostLimiter
+	limiters                     map[Identity]*hostLimiter
 	limitersLk                   sync.Mutex
 	perConnectionAPILimit        rate.Limit
 	perHostConnectionsLimit      rate.Limit
@@ -223,7 +420,7 @@ func NewRateLimitHandler(
 	ctx, cancel := context.WithCancel(context.Background())
 	h := &RateLimitHandler{
 		cancelFunc:              cancel,
-		limiters:                make(map[string]*hostLimiter),
+		limiters:                make(map[Identity]*hostLimiter),
 		perConnectionAPILimit:   rate.Inf,
 		perHostConnectionsLimit: rate.Inf,
 		next:                    next,
@@ -243,11 +440,7 @@ func NewRateLimitHandler(
 
 func (h *RateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.perHostConnectionsLimit != rate.Inf {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		host := requestIdentity(r)
 
 		h.limitersLk.Lock()
 		entry, exists := h.limiters[host]
@@ -353,12 +546,22 @@ func (h *CORSHandler) Shutdown(ctx context.Context) error {
 
 // LoggingHandler logs incoming HTTP requests with details
 type LoggingHandler struct {
-	next http.Handler
+	next       http.Handler
+	sampleRate float64
 }
 
 // NewLoggingHandler creates a new LoggingHandler that logs request details
 func NewLoggingHandler(next http.Handler) *LoggingHandler {
-	return &LoggingHandler{next: next}
+	return NewSampledLoggingHandler(next, 1)
+}
+
+// NewSampledLoggingHandler creates a new LoggingHandler that logs the details of a random sample of
+// requests, at the given rate between 0 and 1. Requests that fail are always logged: those with an
+// HTTP error status, and those for which a gateway method returned an error. go-jsonrpc returns the
+// errors of methods, such as rate limit rejections, in the body of an HTTP 200 response, so these
+// are noted on the request's context as the gateway API returns them.
+func NewSampledLoggingHandler(next http.Handler, sampleRate float64) *LoggingHandler {
+	return &LoggingHandler{next: next, sampleRate: sampleRate}
 }
 
 func (h *LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -369,34 +572,66 @@ func (h *LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"url", r.URL.String(),
 	}
 
-	// For POST requests, try to read and log up to maxLogBodyBytes of the body
-	const maxLogBodyBytes = 1024
-	if r.Method == http.MethodPost {
-		limited := &io.LimitedReader{R: r.Body, N: maxLogBodyBytes + 1}
-		buf, err := io.ReadAll(limited)
-		if err == nil {
-			var bodyStr string
-			if int64(len(buf)) > maxLogBodyBytes {
-				bodyStr = string(buf[:maxLogBodyBytes]) + "...[truncated]"
-			} else {
-				bodyStr = string(buf)
+	sampled := h.sampleRate >= 1 || rand.Float64() < h.sampleRate
+	if sampled {
+		// For POST requests, try to read and log up to maxLogBodyBytes of the body
+		const maxLogBodyBytes = 1024
+		if r.Method == http.MethodPost {
+			limited := &io.LimitedReader{R: r.Body, N: maxLogBodyBytes + 1}
+			buf, err := io.ReadAll(limited)
+			if err == nil {
+				var bodyStr string
+				if int64(len(buf)) > maxLogBodyBytes {
+					bodyStr = string(buf[:maxLogBodyBytes]) + "...[truncated]"
+				} else {
+					bodyStr = string(buf)
+				}
+				logFields = append(logFields, "body", bodyStr)
+				// Reconstruct the body for downstream handlers: combine what we read and the rest
+				rest := io.MultiReader(bytes.NewReader(buf), r.Body)
+				r.Body = io.NopCloser(rest)
 			}
-			logFields = append(logFields, "body", bodyStr)
-			// Reconstruct the body for downstream handlers: combine what we read and the rest
-			rest := io.MultiReader(bytes.NewReader(buf), r.Body)
-			r.Body = io.NopCloser(rest)
 		}
-	}
 
-	log.Infow("request", logFields...)
+		log.Infow("request", logFields...)
+		h.next.ServeHTTP(w, r)
+		return
+	}
 
-	h.next.ServeHTTP(w, r)
+	// Requests that aren't sampled are only logged if they fail
+	var failed atomic.Bool
+	r = r.WithContext(context.WithValue(r.Context(), requestFailedKey, &failed))
+	sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(sw, r)
+	if sw.status >= http.StatusBadRequest || failed.Load() {
+		log.Infow("request", append(logFields, "status", sw.status, "failed", failed.Load())...)
+	}
 }
 
 func (h *LoggingHandler) Shutdown(ctx context.Context) error {
 	return shutdown(ctx, h.next)
 }
 
+// statusRecorder records the status code written to an http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Hijack allows websocket connections to be upgraded through the statusRecorder.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, xerrors.New("response writer does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
 // getRemoteIP returns the remote IP address from the request.
 func getRemoteIP(r *http.Request) string {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
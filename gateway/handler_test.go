@@ -1,13 +1,42 @@
 package gateway_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang/mock/gomock"
+	logging "github.com/ipfs/go-log/v2"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
+	v1mocks "github.com/filecoin-project/lotus/api/mocks"
+	"github.com/filecoin-project/lotus/api/v2api/v2mocks"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filecoin-project/lotus/chain/types/mock"
 	"github.com/filecoin-project/lotus/gateway"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
 )
 
 func TestRequestRateLimiterHandler(t *testing.T) {
@@ -40,3 +69,1017 @@ func TestRequestRateLimiterHandler(t *testing.T) {
 	runRequest("boop", http.StatusTooManyRequests, 4)
 	runRequest("beep", http.StatusTooManyRequests, 4)
 }
+
+func TestEthMaxFiltersPerHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	var (
+		filterLk  sync.Mutex
+		filters   = make(map[ethtypes.EthFilterID]struct{})
+		nextID    byte
+		installed = func() int {
+			filterLk.Lock()
+			defer filterLk.Unlock()
+			return len(filters)
+		}
+	)
+	mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).DoAndReturn(func(context.Context) (ethtypes.EthFilterID, error) {
+		filterLk.Lock()
+		defer filterLk.Unlock()
+		nextID++
+		id := ethtypes.EthFilterID{nextID}
+		filters[id] = struct{}{}
+		return id, nil
+	}).AnyTimes()
+	mockV1.EXPECT().EthUninstallFilter(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id ethtypes.EthFilterID) (bool, error) {
+		filterLk.Lock()
+		defer filterLk.Unlock()
+		delete(filters, id)
+		return true, nil
+	}).AnyTimes()
+
+	// the health handlers watch the chain in the background
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	const perConn, perHost = 2, 3
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithEthMaxFiltersPerConn(perConn), gateway.WithEthMaxFiltersPerHost(perHost))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	connect := func() (lapi.Gateway, func()) {
+		c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", nil)
+		require.NoError(t, err)
+		return c, closer
+	}
+
+	// every connection is from the same host, so they share the per host limit
+	conn1, close1 := connect()
+	conn2, close2 := connect()
+	defer close2()
+	conn3, close3 := connect()
+	defer close3()
+
+	_, err = conn1.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	id, err := conn1.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	_, err = conn1.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFilters.Error(), "per connection limit should still apply")
+
+	_, err = conn2.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	_, err = conn2.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFiltersPerHost.Error())
+	_, err = conn3.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFiltersPerHost.Error())
+	require.Equal(t, perHost, installed())
+
+	// uninstalling a filter makes room for another on any connection
+	ok, err := conn1.EthUninstallFilter(ctx, id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = conn3.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	_, err = conn2.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFiltersPerHost.Error())
+
+	// as does closing a connection
+	close1()
+	require.Eventually(t, func() bool {
+		_, err := conn2.EthNewBlockFilter(ctx)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, perHost, installed())
+}
+
+func TestEthMaxFiltersPerConnReconfigure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	var nextID atomic.Uint32
+	mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).DoAndReturn(func(context.Context) (ethtypes.EthFilterID, error) {
+		return ethtypes.EthFilterID{byte(nextID.Add(1))}, nil
+	}).AnyTimes()
+	mockV1.EXPECT().EthUninstallFilter(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	mockV1.EXPECT().EthGetFilterChanges(gomock.Any(), gomock.Any()).Return(&ethtypes.EthFilterResult{}, nil).AnyTimes()
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithEthMaxFiltersPerConn(3))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", nil)
+	require.NoError(t, err)
+	defer closer()
+
+	ids := make([]ethtypes.EthFilterID, 3)
+	for i := range ids {
+		ids[i], err = c.EthNewBlockFilter(ctx)
+		require.NoError(t, err)
+	}
+
+	// lowering the limit leaves the filters already installed in place
+	require.NoError(t, gw.Reconfigure(gateway.WithEthMaxFiltersPerConn(1)))
+	for _, id := range ids {
+		_, err = c.EthGetFilterChanges(ctx, id)
+		require.NoError(t, err)
+	}
+
+	// while new filters are rejected until the connection is back under the new limit
+	_, err = c.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFilters.Error())
+	for _, id := range ids[:2] {
+		ok, err := c.EthUninstallFilter(ctx, id)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	_, err = c.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFilters.Error())
+	ok, err := c.EthUninstallFilter(ctx, ids[2])
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = c.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+}
+
+func TestEthExpiredFilterCleanup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	dropped, replacement := ethtypes.EthFilterID{1}, ethtypes.EthFilterID{2}
+	gomock.InOrder(
+		mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).Return(dropped, nil),
+		mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).Return(replacement, nil),
+	)
+	// the target drops the filter, e.g. because it timed out
+	mockV1.EXPECT().EthGetFilterChanges(gomock.Any(), dropped).Return(nil, xerrors.New("filter not found"))
+	mockV1.EXPECT().EthUninstallFilter(gomock.Any(), replacement).Return(true, nil).AnyTimes()
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2,
+		gateway.WithEthMaxFiltersPerConn(1),
+		gateway.WithEthExpiredFilterCleanup(true),
+		gateway.WithErrorSanitization(true),
+	)
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", nil)
+	require.NoError(t, err)
+	defer closer()
+
+	id, err := c.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	require.Equal(t, dropped, id)
+
+	// the client is told to recreate the filter, even with errors sanitized
+	_, err = c.EthGetFilterChanges(ctx, id)
+	require.ErrorContains(t, err, gateway.ErrFilterExpired.Error())
+
+	// and the gateway no longer tracks it, so it isn't polled on the target again and no longer
+	// counts towards the connection's filter limit
+	_, err = c.EthGetFilterChanges(ctx, id)
+	require.ErrorContains(t, err, "filter not found")
+	ok, err := c.EthUninstallFilter(ctx, id)
+	require.NoError(t, err)
+	require.False(t, ok)
+	id, err = c.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	require.Equal(t, replacement, id)
+}
+
+func TestIdentityExtractor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).Return(ethtypes.EthFilterID{1}, nil).AnyTimes()
+	mockV1.EXPECT().EthUninstallFilter(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	// identify clients by a header set by a trusted proxy in front of the gateway
+	const clientHeader = "X-Client-Id"
+	extractor := func(ctx context.Context) gateway.Identity {
+		r, ok := gateway.HTTPRequest(ctx)
+		require.True(t, ok)
+		return gateway.Identity(r.Header.Get(clientHeader))
+	}
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithEthMaxFiltersPerHost(1))
+	h, err := gateway.Handler(gw, gateway.WithPerHostConnectionsPerMinute(2), gateway.WithIdentityExtractor(extractor))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// the connection rate limit applies per identity rather than per remote address
+	request := func(id, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/nope", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(clientHeader, id)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.NotEqual(t, http.StatusTooManyRequests, request("alice", "10.0.0.1:1234"))
+	require.NotEqual(t, http.StatusTooManyRequests, request("alice", "10.0.0.2:1234"))
+	require.Equal(t, http.StatusTooManyRequests, request("alice", "10.0.0.3:1234"), "alice should be limited from any address")
+	require.NotEqual(t, http.StatusTooManyRequests, request("bob", "10.0.0.1:1234"), "bob should have a separate limit")
+	// without an identity, clients fall back to being identified by their address
+	require.NotEqual(t, http.StatusTooManyRequests, request("", "10.0.0.4:1234"))
+	require.NotEqual(t, http.StatusTooManyRequests, request("", "10.0.0.4:1234"))
+	require.Equal(t, http.StatusTooManyRequests, request("", "10.0.0.4:1234"))
+
+	// as does the filter limit, though all connections come from the same address
+	connect := func(id string) lapi.Gateway {
+		c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", http.Header{clientHeader: []string{id}})
+		require.NoError(t, err)
+		t.Cleanup(closer)
+		return c
+	}
+	carol1, carol2, dave := connect("carol"), connect("carol"), connect("dave")
+	_, err = carol1.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	_, err = carol2.EthNewBlockFilter(ctx)
+	require.ErrorContains(t, err, gateway.ErrTooManyFiltersPerHost.Error())
+	_, err = dave.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+}
+
+func TestLoggingHandlerSampling(t *testing.T) {
+	require.NoError(t, logging.SetLogLevel("gateway", "info"))
+	defer func() { _ = logging.SetLogLevel("gateway", "error") }()
+
+	pipe := logging.NewPipeReader(logging.PipeFormat(logging.JSONOutput), logging.PipeLevel(logging.LevelInfo))
+	logged := make(map[string]int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			var entry struct {
+				Logger string `json:"logger"`
+				Msg    string `json:"msg"`
+				URL    string `json:"url"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Logger == "gateway" && entry.Msg == "request" {
+				logged[entry.URL]++
+			}
+		}
+	}()
+
+	const sampleRate = 0.1
+	h := gateway.NewSampledLoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}), sampleRate)
+
+	const requests = 2000
+	for _, path := range []string{"/ok", "/fail", "/limited"} {
+		for i := 0; i < requests; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+	}
+	require.NoError(t, pipe.Close())
+	<-done
+
+	// successful requests are sampled, within a generous margin
+	require.InDelta(t, requests*sampleRate, logged["/ok"], requests*sampleRate/2)
+	// while failed and rate limited requests are always logged
+	require.Equal(t, requests, logged["/fail"])
+	require.Equal(t, requests, logged["/limited"])
+}
+
+func TestLoggingHandlerLogsJSONRPCErrors(t *testing.T) {
+	require.NoError(t, logging.SetLogLevel("gateway", "info"))
+	defer func() { _ = logging.SetLogLevel("gateway", "error") }()
+
+	pipe := logging.NewPipeReader(logging.PipeFormat(logging.JSONOutput), logging.PipeLevel(logging.LevelInfo))
+	var logged []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			var entry struct {
+				Logger string `json:"logger"`
+				Msg    string `json:"msg"`
+				Status int    `json:"status"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Logger == "gateway" && entry.Msg == "request" {
+				logged = append(logged, entry.Status)
+			}
+		}
+	}()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	mockV1.EXPECT().StateNetworkName(gomock.Any()).Return(dtypes.NetworkName("testnet"), nil).AnyTimes()
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	// the first call uses up the burst, and the rest are throttled
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithRateLimit(1), gateway.WithRateLimitTimeout(time.Millisecond))
+	h, err := gateway.Handler(gw, gateway.WithRequestLogging(true), gateway.WithAccessLogSampling(0))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	call := func() string {
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Filecoin.StateNetworkName","params":[]}`))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		// method errors are returned in the body of a successful HTTP response
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	require.Contains(t, call(), "testnet")
+	require.Contains(t, call(), "server busy")
+	require.Contains(t, call(), "server busy")
+	require.NoError(t, pipe.Close())
+	<-done
+
+	// so the throttled requests are logged, though only by their JSON-RPC error
+	require.Equal(t, []int{http.StatusOK, http.StatusOK}, logged)
+}
+
+func TestSubscribeVerifiedClientStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// a chain on which the client's datacap changes at some epochs but not others
+	var (
+		tss     []*types.TipSet
+		dataCap = make(map[types.TipSetKey]*abi.StoragePower)
+		parent  *types.TipSet
+	)
+	for i, dc := range []int64{100, 100, 80, 80, -1, 50} {
+		ts := mock.TipSet(mock.MkBlock(parent, 1, uint64(i)))
+		tss, parent = append(tss, ts), ts
+		if dc >= 0 {
+			v := big.NewInt(dc)
+			dataCap[ts.Key()] = &v
+		}
+	}
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	mockV1.EXPECT().StateVerifiedClientStatus(gomock.Any(), addr, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ address.Address, tsk types.TipSetKey) (*abi.StoragePower, error) {
+			return dataCap[tsk], nil
+		}).AnyTimes()
+
+	// the health handlers watch the chain in the background too, they're given a chain that never
+	// changes
+	var subscribing atomic.Bool
+	heads := make(chan []*lapi.HeadChange)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).DoAndReturn(func(context.Context) (<-chan []*lapi.HeadChange, error) {
+		if subscribing.Load() {
+			return heads, nil
+		}
+		return make(chan []*lapi.HeadChange), nil
+	}).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithEthMaxFiltersPerConn(1))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", nil)
+	require.NoError(t, err)
+	defer closer()
+
+	subscribing.Store(true)
+	subCtx, subCancel := context.WithCancel(ctx)
+	sub, err := c.SubscribeVerifiedClientStatus(subCtx, addr)
+	require.NoError(t, err)
+
+	// the subscription counts towards the per connection limit
+	_, err = c.SubscribeVerifiedClientStatus(ctx, addr)
+	require.ErrorContains(t, err, gateway.ErrTooManyFilters.Error())
+
+	go func() {
+		heads <- []*lapi.HeadChange{{Type: store.HCCurrent, Val: tss[0]}}
+		for _, ts := range tss[1:] {
+			heads <- []*lapi.HeadChange{{Type: store.HCApply, Val: ts}}
+		}
+	}()
+
+	// only the initial status and changes to it are sent
+	for _, expected := range []struct {
+		ts      *types.TipSet
+		dataCap *abi.StoragePower
+	}{
+		{tss[0], dataCap[tss[0].Key()]},
+		{tss[2], dataCap[tss[2].Key()]},
+		{tss[4], nil},
+		{tss[5], dataCap[tss[5].Key()]},
+	} {
+		select {
+		case change := <-sub:
+			require.Equal(t, lapi.VerifiedClientStatusChange{Height: expected.ts.Height(), TipSetKey: expected.ts.Key(), DataCap: expected.dataCap}, change)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a status change")
+		}
+	}
+
+	// ending the subscription makes room for another
+	subCancel()
+	require.Eventually(t, func() bool {
+		_, err := c.SubscribeVerifiedClientStatus(ctx, addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGatewayConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().EthNewBlockFilter(gomock.Any()).Return(ethtypes.EthFilterID{1}, nil)
+
+	// the burst covers a single state call, after which calls are throttled
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithRateLimit(1), gateway.WithRateLimitTimeout(time.Millisecond))
+	h, err := gateway.Handler(gw, gateway.WithAdminToken("secret"))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, closer, err := client.NewGatewayRPCV1(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/rpc/v1", nil)
+	require.NoError(t, err)
+
+	_, err = c.EthNewBlockFilter(ctx)
+	require.NoError(t, err)
+	_, err = c.ChainHead(ctx)
+	require.ErrorContains(t, err, "server busy")
+
+	conns := gw.Connections()
+	require.Len(t, conns, 1)
+	require.Equal(t, "/rpc/v1", conns[0].Path)
+	require.Equal(t, uint64(2), conns[0].Requests)
+	require.Equal(t, uint64(3), conns[0].TokensConsumed)
+	require.Equal(t, uint64(1), conns[0].Throttled)
+	require.Equal(t, 1, conns[0].ActiveFilters)
+
+	// operators get the same list from the admin API
+	var admin gateway.AdminStruct
+	adminCloser, err := jsonrpc.NewClient(ctx, srv.URL+gateway.AdminPath, "Filecoin", &admin.Internal, http.Header{"Authorization": []string{"Bearer secret"}})
+	require.NoError(t, err)
+	defer adminCloser()
+	listed, err := admin.Connections(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, conns[0].ID, listed[0].ID)
+	require.Equal(t, conns[0].Requests, listed[0].Requests)
+	require.Equal(t, conns[0].TokensConsumed, listed[0].TokensConsumed)
+	require.Equal(t, conns[0].ActiveFilters, listed[0].ActiveFilters)
+
+	// the connection is no longer listed once it's closed
+	mockV1.EXPECT().EthUninstallFilter(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	closer()
+	require.Eventually(t, func() bool { return len(gw.Connections()) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGatewayErrorSanitization(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, logging.SetLogLevel("gateway", "warn"))
+	defer func() { _ = logging.SetLogLevel("gateway", "error") }()
+
+	pipe := logging.NewPipeReader(logging.PipeFormat(logging.JSONOutput), logging.PipeLevel(logging.LevelWarn))
+	logged := make(map[string]string) // error id -> error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			var entry struct {
+				Logger  string `json:"logger"`
+				Msg     string `json:"msg"`
+				ErrorID string `json:"errorId"`
+				Error   string `json:"error"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Logger == "gateway" && entry.Msg == "target error" {
+				logged[entry.ErrorID] = entry.Error
+			}
+		}
+	}()
+
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const detail = "failed to open /var/lib/lotus/datastore/chain: peer 12D3KooWExample"
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(nil, xerrors.New(detail))
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithErrorSanitization(true))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, closer, err := client.NewGatewayRPCV1(ctx, srv.URL+"/rpc/v1", nil)
+	require.NoError(t, err)
+	defer closer()
+
+	_, err = c.ChainHead(ctx)
+	require.ErrorContains(t, err, gateway.ErrRequestFailed.Error())
+	require.NotContains(t, err.Error(), detail)
+
+	require.NoError(t, pipe.Close())
+	<-done
+
+	// the error returned to the client identifies the full error in the logs
+	require.Len(t, logged, 1)
+	for id, logErr := range logged {
+		require.Contains(t, err.Error(), id)
+		require.Contains(t, logErr, detail)
+	}
+}
+
+func TestGatewayEthBatchBlockParams(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	// a recent head, and two recent tipsets referenced by block hash
+	tipSet := func(height abi.ChainEpoch, nonce uint64) *types.TipSet {
+		blk := mock.MkBlock(nil, 1, nonce)
+		blk.Height = height
+		blk.Timestamp = uint64(time.Now().Unix())
+		return mock.TipSet(blk)
+	}
+	head := tipSet(10, 0)
+	var hashes []string
+	for i, ts := range []*types.TipSet{tipSet(8, 1), tipSet(9, 2)} {
+		tskCid, err := ts.Key().Cid()
+		require.NoError(t, err)
+		hash, err := ethtypes.EthHashFromCid(tskCid)
+		require.NoError(t, err)
+		hashes = append(hashes, hash.String())
+
+		var buf bytes.Buffer
+		require.NoError(t, ts.Key().MarshalCBOR(&buf))
+		// each distinct block param is resolved once per batch, however many calls reference it; the
+		// first block hash is referenced by both batches below
+		mockV1.EXPECT().ChainReadObj(gomock.Any(), tskCid).Return(buf.Bytes(), nil).Times(2 - i)
+		mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).Times(2 - i)
+	}
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).Times(3)
+	mockV1.EXPECT().EthGetBalance(gomock.Any(), gomock.Any(), gomock.Any()).Return(ethtypes.EthBigInt(big.NewInt(1)), nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithEthBatchMaxBlockParams(3))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	batch := func(blkParams ...string) []string {
+		var reqs []string
+		for i, blkParam := range blkParams {
+			reqs = append(reqs, `{"jsonrpc":"2.0","id":`+strconv.Itoa(i)+`,"method":"Filecoin.EthGetBalance","params":["0x0000000000000000000000000000000000000001","`+blkParam+`"]}`)
+		}
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader("["+strings.Join(reqs, ",")+"]"))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		var results []struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+		require.Len(t, results, len(blkParams))
+		errs := make([]string, len(results))
+		for i, result := range results {
+			if result.Error != nil {
+				errs[i] = result.Error.Message
+			}
+		}
+		return errs
+	}
+
+	// three distinct block params, each referenced repeatedly, are within the limit
+	errs := batch(hashes[0], "0x5", hashes[0], "0x5", hashes[1], hashes[0])
+	require.Equal(t, []string{"", "", "", "", "", ""}, errs)
+
+	// a fourth distinct block param is rejected, while those already checked are still served
+	errs = batch(hashes[0], "0x5", "0x6", "0x7", "0x5")
+	require.Equal(t, []string{"", "", ""}, errs[:3])
+	require.Contains(t, errs[3], gateway.ErrTooManyBlockParams.Error())
+	require.Empty(t, errs[4])
+}
+func TestGatewayCBORResponses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	blk := mock.MkBlock(nil, 1, 1)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().ChainGetBlock(gomock.Any(), blk.Cid()).Return(blk, nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2)
+	h, err := gateway.Handler(gw, gateway.WithCBORResponses(true))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	getBlock := func(accept string) (string, []byte) {
+		body := `{"jsonrpc":"2.0","id":1,"method":"Filecoin.ChainGetBlock","params":[{"/":"` + blk.Cid().String() + `"}]}`
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/rpc/v1", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.Header.Get("Content-Type"), respBody
+	}
+
+	// a client accepting CBOR gets the block CBOR encoded
+	contentType, body := getBlock("application/json;q=0.5, " + gateway.CBORContentType)
+	require.Equal(t, gateway.CBORContentType, contentType)
+	var cborBlk types.BlockHeader
+	require.NoError(t, cborBlk.UnmarshalCBOR(bytes.NewReader(body)))
+	require.Equal(t, blk.Cid(), cborBlk.Cid())
+
+	// a default client gets the same block as JSON-RPC over JSON
+	contentType, body = getBlock("")
+	require.NotEqual(t, gateway.CBORContentType, contentType)
+	var jsonResp struct {
+		Result types.BlockHeader `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(body, &jsonResp))
+	require.Equal(t, blk.Cid(), jsonResp.Result.Cid())
+
+	// methods whose results don't support CBOR are served as JSON regardless
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/rpc/v1", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Filecoin.EthChainId","params":[]}`))
+	require.NoError(t, err)
+	req.Header.Set("Accept", gateway.CBORContentType)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.NotEqual(t, gateway.CBORContentType, resp.Header.Get("Content-Type"))
+	var chainIDResp struct {
+		Result ethtypes.EthUint64 `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&chainIDResp))
+	require.Equal(t, ethtypes.EthUint64(314), chainIDResp.Result)
+}
+
+func TestGatewayBatchMaxInFlight(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	var inFlight, maxInFlight atomic.Int32
+	release := make(chan struct{})
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().EthChainId(gomock.Any()).DoAndReturn(func(context.Context) (ethtypes.EthUint64, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			prev := maxInFlight.Load()
+			if n <= prev || maxInFlight.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		<-release
+		return 314, nil
+	}).AnyTimes()
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(mock.TipSet(mock.MkBlock(nil, 1, 1)), nil)
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithBatchMaxInFlight(2), gateway.WithRateLimitTimeout(100*time.Millisecond))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(body string) string {
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// several large batches are submitted at once; the calls in each are served in turn, so without
+	// the limit there would be a call from every batch in flight
+	const batches, batchSize = 4, 3
+	var reqs []string
+	for i := 0; i < batchSize; i++ {
+		reqs = append(reqs, `{"jsonrpc":"2.0","id":`+strconv.Itoa(i)+`,"method":"Filecoin.EthChainId","params":[]}`)
+	}
+	batch := "\n [" + strings.Join(reqs, ",") + "]"
+	results := make(chan string, batches)
+	for i := 0; i < batches; i++ {
+		go func() { results <- post(batch) }()
+	}
+
+	// only two of the batches get their calls in flight, the other two time out waiting for a slot
+	// and are rejected
+	require.Eventually(t, func() bool { return inFlight.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	for i := 0; i < batches-2; i++ {
+		result := <-results
+		require.Equal(t, batchSize, strings.Count(result, "too many concurrent batch requests"), result)
+	}
+
+	// calls made on their own aren't limited while the batches hold every slot
+	require.NotContains(t, post(`{"jsonrpc":"2.0","id":1,"method":"Filecoin.ChainHead","params":[]}`), "error")
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		result := <-results
+		require.NotContains(t, result, "error", result)
+		require.Equal(t, batchSize, strings.Count(result, `"result":"0x13a"`), result)
+	}
+	require.Equal(t, int32(2), maxInFlight.Load())
+}
+
+func TestGatewayBatchMaxCost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil).Times(10)
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithBatchMaxCost(10))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	batch := func(method string, n int) string {
+		var reqs []string
+		for i := 0; i < n; i++ {
+			reqs = append(reqs, `{"jsonrpc":"2.0","id":`+strconv.Itoa(i)+`,"method":"`+method+`","params":[]}`)
+		}
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader("["+strings.Join(reqs, ",")+"]"))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// a large batch of cheap calls is within the limit
+	result := batch("eth_chainId", 10)
+	require.NotContains(t, result, "error")
+	require.Equal(t, 10, strings.Count(result, `"result":"0x13a"`))
+
+	// while a small batch of expensive calls isn't, and none of its calls are made
+	for _, method := range []string{"eth_getBalance", "Filecoin.StateGetActor"} {
+		result = batch(method, 4)
+		require.Contains(t, result, gateway.ErrBatchTooExpensive.Error())
+		require.Contains(t, result, "12 rate limit tokens")
+	}
+}
+
+func TestGatewayBatchRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	var calls atomic.Int32
+	mockV1.EXPECT().EthChainId(gomock.Any()).DoAndReturn(func(context.Context) (ethtypes.EthUint64, error) {
+		calls.Add(1)
+		return 314, nil
+	}).AnyTimes()
+
+	// a rate of 10 tokens a second with a burst of 3, so that 10 eth_chainId calls can be served
+	// within a second of waiting, while 20 can't
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithRateLimit(10), gateway.WithRateLimitTimeout(time.Second), gateway.WithMaxBatchSize(20))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	batch := func(n int) string {
+		var reqs []string
+		for i := 0; i < n; i++ {
+			reqs = append(reqs, `{"jsonrpc":"2.0","id":`+strconv.Itoa(i)+`,"method":"eth_chainId","params":[]}`)
+		}
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader("["+strings.Join(reqs, ",")+"]"))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// a batch costing more than the burst is admitted as a whole once its tokens are available,
+	// without its calls waiting on the rate limit again
+	result := batch(10)
+	require.NotContains(t, result, "error")
+	require.Equal(t, 10, strings.Count(result, `"result":"0x13a"`))
+
+	// one that can't be admitted within the rate limit timeout is rejected without making any calls
+	calls.Store(0)
+	result = batch(20)
+	require.Contains(t, result, "server busy")
+	require.Zero(t, calls.Load())
+
+	// and oversized batches are rejected up front
+	result = batch(21)
+	require.Contains(t, result, gateway.ErrBatchTooLarge.Error())
+	require.Contains(t, result, "21 calls, the maximum is 20")
+	require.Zero(t, calls.Load())
+}
+
+func TestGatewayEthLogsMaxTopics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2)
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	getLogs := func(topics string) string {
+		body := `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"topics":` + topics + `}]}`
+		resp, err := http.Post(srv.URL+"/rpc/v1", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+	hash := `"0x` + strings.Repeat("ab", 32) + `"`
+
+	// a topic position nested deeper than an array of hashes is rejected without reaching the target
+	require.Contains(t, getLogs(`[[[`+hash+`]]]`), `"error"`)
+
+	// as is a filter with more topic positions than a log can have
+	result := getLogs(`[` + strings.Repeat(hash+`,`, 4) + hash + `]`)
+	require.Contains(t, result, gateway.ErrTooManyLogTopics.Error())
+	require.Contains(t, result, "5 topic positions, the maximum is 4")
+
+	// while a filter using all four positions, with hashes, arrays of hashes and wildcards, is served
+	res := &ethtypes.EthFilterResult{}
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, filter *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error) {
+		require.Len(t, filter.Topics, 4)
+		return res, nil
+	})
+	result = getLogs(`[` + hash + `,[` + hash + `,` + hash + `],null,[]]`)
+	require.NotContains(t, result, `"error"`)
+}
+
+func TestGatewayQueuedResponses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil).AnyTimes()
+
+	// 2 tokens a second, with a burst of 3
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithRateLimit(2), gateway.WithQueuedResponses(50*time.Millisecond))
+	h, err := gateway.Handler(gw)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	chainID := func() []string {
+		var positions []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				require.Equal(t, http.StatusProcessing, code)
+				positions = append(positions, header.Get(gateway.QueuePositionHeader))
+				return nil
+			},
+		}
+		body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodPost, srv.URL+"/rpc/v1", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		require.Empty(t, resp.Header.Get(gateway.QueuePositionHeader))
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(b), `"result":"0x13a"`)
+		return positions
+	}
+
+	// requests within the burst are served without waiting
+	for i := 0; i < 3; i++ {
+		require.Empty(t, chainID())
+	}
+
+	// while a heavily throttled request is told its queue position before it's served
+	require.Equal(t, []string{"1"}, chainID())
+}
+
+func TestAdminAPI(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(make(chan []*lapi.HeadChange), nil).AnyTimes()
+
+	gw := gateway.NewNode(mockV1, mockV2, gateway.WithStateMinerInfoCache(10))
+	h, err := gateway.Handler(gw, gateway.WithAdminToken("secret"))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	connect := func(token string) *gateway.AdminStruct {
+		var admin gateway.AdminStruct
+		header := http.Header{}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		closer, err := jsonrpc.NewClient(ctx, srv.URL+gateway.AdminPath, "Filecoin", &admin.Internal, header)
+		require.NoError(t, err)
+		t.Cleanup(closer)
+		return &admin
+	}
+
+	// callers presenting the admin token can manage the gateway
+	evicted, err := connect("secret").PurgeCaches(ctx, []string{"StateMinerInfo"})
+	require.NoError(t, err)
+	require.Zero(t, evicted)
+	_, err = connect("secret").PurgeCaches(ctx, []string{"EthBlock"})
+	require.ErrorContains(t, err, `unknown or disabled cache "EthBlock"`)
+
+	// while anyone else is turned away
+	_, err = connect("").PurgeCaches(ctx, nil)
+	require.ErrorContains(t, err, "missing permission to invoke 'PurgeCaches' (need 'admin')")
+	_, err = connect("wrong").PurgeCaches(ctx, nil)
+	require.ErrorContains(t, err, "401")
+
+	// and without a token the admin API isn't served at all
+	h, err = gateway.Handler(gw)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, gateway.AdminPath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Filecoin.PurgeCaches","params":[null]}`)))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
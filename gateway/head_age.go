@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// MinHeadAgeSampleInterval is the shortest interval at which the age of the target's head is
+// sampled, so that sampling can't be configured to hammer the target.
+const MinHeadAgeSampleInterval = time.Second
+
+// sampleHeadAge records the age of the target's head, the time since its timestamp, as the
+// metrics.GatewayHeadAge gauge every interval until ctx is cancelled. The first sample is taken
+// straight away. Samples are taken one at a time, so a slow target never has more than a single
+// ChainHead call from the sampler in flight.
+func sampleHeadAge(ctx context.Context, server v1api.FullNode, interval time.Duration) {
+	if interval < MinHeadAgeSampleInterval {
+		interval = MinHeadAgeSampleInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		recordHeadAge(ctx, server, interval)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func recordHeadAge(ctx context.Context, server v1api.FullNode, timeout time.Duration) {
+	ctx2, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	head, err := server.ChainHead(ctx2)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warnw("failed to sample the age of the head", "error", err)
+		}
+		return
+	}
+	age := time.Since(time.Unix(int64(head.MinTimestamp()), 0))
+	stats.Record(ctx, metrics.GatewayHeadAge.M(age.Seconds()))
+}
+
+// Shutdown stops the gateway's background work, such as sampling the age of the head, without
+// waiting for it to exit; see Close.
+func (gw *Node) Shutdown(context.Context) error {
+	gw.cancel()
+	return nil
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// deepHealthTimeout bounds the whole of a GatewayDeepHealth check, such that a backend node that's
+// too slow to serve reads is reported as unhealthy rather than holding up the check.
+const deepHealthTimeout = 5 * time.Second
+
+// healthTimeout bounds the head fetch of a GatewayHealth check.
+const healthTimeout = 5 * time.Second
+
+// deepHealth runs the stages of a GatewayDeepHealth check against server in order, stopping at the
+// first to fail.
+func deepHealth(ctx context.Context, server v1api.FullNode) *api.GatewayHealth {
+	ctx, cancel := context.WithTimeout(ctx, deepHealthTimeout)
+	defer cancel()
+
+	var head, ts *types.TipSet
+	stages := []struct {
+		name string
+		run  func() error
+	}{
+		{"head", func() (err error) {
+			head, err = server.ChainHead(ctx)
+			return err
+		}},
+		{"tipset", func() (err error) {
+			// the head's parent, which unlike the head itself has to be looked up
+			tsk := head.Parents()
+			if tsk.IsEmpty() {
+				tsk = head.Key()
+			}
+			ts, err = server.ChainGetTipSet(ctx, tsk)
+			return err
+		}},
+		{"object", func() error {
+			_, err := server.ChainReadObj(ctx, ts.ParentState())
+			return err
+		}},
+		{"network-version", func() error {
+			_, err := server.StateNetworkVersion(ctx, ts.Key())
+			return err
+		}},
+	}
+	for _, stage := range stages {
+		if err := stage.run(); err != nil {
+			log.Warnw("deep health check failed", "stage", stage.name, "error", err)
+			return &api.GatewayHealth{FailedStage: stage.name, Error: err.Error()}
+		}
+	}
+	return &api.GatewayHealth{Healthy: true}
+}
+
+// health runs a GatewayHealth check against server: the head is fetched, and the gateway is
+// reported healthy if the head is no older than the maximum lookback.
+func (gw *Node) health(ctx context.Context, server v1api.FullNode) *api.GatewayHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	head, err := server.ChainHead(ctx)
+	latency := time.Since(start)
+	if err == nil && head == nil {
+		err = xerrors.New("no head returned")
+	}
+	if err != nil {
+		log.Warnw("health check failed", "stage", "head", "error", err)
+		return &api.GatewayHealth{FailedStage: "head", Error: err.Error(), Latency: latency}
+	}
+
+	health := &api.GatewayHealth{
+		Healthy:    true,
+		HeadHeight: head.Height(),
+		HeadAge:    time.Since(time.Unix(int64(head.MinTimestamp()), 0)),
+		Latency:    latency,
+	}
+	if maxAge := gw.currentSettings().maxLookbackDuration; health.HeadAge > maxAge {
+		health.Healthy = false
+		health.FailedStage = "head-age"
+		health.Error = fmt.Sprintf("head at height %d is %s old, more than the maximum of %s", head.Height(), health.HeadAge.Truncate(time.Second), maxAge)
+	}
+	return health
+}
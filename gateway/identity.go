@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+)
+
+type identityKeyType string
+
+const (
+	httpRequestKey identityKeyType = "httpRequest"
+	identityKey    identityKeyType = "identity"
+)
+
+// Identity identifies a client of the gateway. Features that apply per client, such as the per
+// host connection rate limit and the per host filter limit, key their state by Identity.
+type Identity string
+
+// IdentityExtractor returns the Identity of the client making a request. It's called with the
+// context of the incoming HTTP request, from which the request itself can be retrieved with
+// HTTPRequest, once for each HTTP request or websocket connection.
+type IdentityExtractor func(ctx context.Context) Identity
+
+// RemoteIPIdentity is the default IdentityExtractor, identifying clients by the IP address the
+// request was received from.
+func RemoteIPIdentity(ctx context.Context) Identity {
+	r, ok := HTTPRequest(ctx)
+	if !ok {
+		return ""
+	}
+	return Identity(getRemoteIP(r))
+}
+
+// HTTPRequest returns the incoming HTTP request that ctx belongs to, for use by an
+// IdentityExtractor.
+func HTTPRequest(ctx context.Context) (*http.Request, bool) {
+	r, ok := ctx.Value(httpRequestKey).(*http.Request)
+	return r, ok
+}
+
+// identityHandler establishes the Identity of the client making each request, making it available
+// to the handlers and API methods that follow.
+type identityHandler struct {
+	next    http.Handler
+	extract IdentityExtractor
+}
+
+func (h identityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), httpRequestKey, r)
+	ctx = context.WithValue(ctx, identityKey, h.extract(ctx))
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (h identityHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
+
+// requestIdentity returns the Identity of the client making r, falling back to its remote IP
+// address when no identityHandler has established one, as when the handlers are used
+// individually.
+func requestIdentity(r *http.Request) Identity {
+	if id, ok := r.Context().Value(identityKey).(Identity); ok && id != "" {
+		return id
+	}
+	return Identity(getRemoteIP(r))
+}
+
+// contextIdentity returns the Identity of the client making the request ctx belongs to, like
+// requestIdentity, or an empty Identity for calls not made over HTTP.
+func contextIdentity(ctx context.Context) Identity {
+	if id, ok := ctx.Value(identityKey).(Identity); ok && id != "" {
+		return id
+	}
+	if r, ok := HTTPRequest(ctx); ok {
+		return Identity(getRemoteIP(r))
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"sort"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+)
+
+// info describes the gateway as currently configured, for GatewayVersion.
+func (gw *Node) info() api.GatewayInfo {
+	gw.lk.RLock()
+	opts, current := gw.options, gw.settings
+	gw.lk.RUnlock()
+
+	return api.GatewayInfo{
+		Version:                  string(build.NodeUserVersion()),
+		Features:                 gw.features(&opts),
+		MaxLookback:              current.maxLookbackDuration,
+		MaxMessageLookbackEpochs: current.maxMessageLookbackEpochs,
+		RateLimit:                opts.rateLimit,
+		PerIPRateLimit:           opts.perIPRateLimit,
+		RateLimitTimeout:         current.rateLimitTimeout,
+	}
+}
+
+// features returns the names of the optional features enabled on the gateway, sorted.
+func (gw *Node) features(opts *options) []string {
+	enabled := map[string]bool{
+		"miner-info-cache":          gw.minerInfoCache != nil,
+		"eth-block-cache":           gw.ethBlockCache != nil,
+		"actor-cache":               gw.actorCache != nil,
+		"tipset-cache":              gw.tipSetCache != nil,
+		"failover":                  opts.fallbackTargets != nil && len(*opts.fallbackTargets) > 0,
+		"circuit-breaker":           opts.circuitBreakerThreshold > 0,
+		"serve-stale-on-outage":     gw.serveStaleOnOutage,
+		"per-ip-rate-limit":         gw.ipRateLimiters != nil,
+		"daily-quota":               gw.dailyQuota != nil,
+		"write-reserved-rate-limit": opts.writeReservedRateLimit > 0,
+		"slow-start":                gw.slowStart != nil,
+		"read-coalescing":           opts.coalesceReads,
+		"lookup-coalescing":         opts.coalesceLookups,
+		"blocked-addresses":         gw.blockedAddresses != nil,
+		"eth-call-allowlist":        gw.ethCallAllowlist != nil,
+		"archive-methods":           gw.archiveLookbacks != nil,
+		"eth-call-max-gas":          gw.ethCallMaxGas > 0,
+		"eth-call-precompile-guard": gw.ethCallNoPrecompiles,
+	}
+	features := make([]string, 0, len(enabled))
+	for name, on := range enabled {
+		if on {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ForwardedForIdentity is an IdentityExtractor for gateways behind a reverse proxy, identifying
+// clients by the address the proxy received the request from, as the last address it appended to
+// the X-Forwarded-For header. Addresses earlier in the header are set by the client, so can't be
+// trusted. Requests without the header are identified by their remote IP address.
+func ForwardedForIdentity(ctx context.Context) Identity {
+	r, ok := HTTPRequest(ctx)
+	if !ok {
+		return ""
+	}
+	if ip := forwardedFor(r); ip != "" {
+		return Identity(ip)
+	}
+	return Identity(getRemoteIP(r))
+}
+
+// forwardedFor returns the last address in the X-Forwarded-For header of r, if it's an IP address.
+func forwardedFor(r *http.Request) string {
+	values := r.Header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		return ""
+	}
+	addrs := strings.Split(values[len(values)-1], ",")
+	ip := strings.TrimSpace(addrs[len(addrs)-1])
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
+
+// ipRateLimiters holds a rate limiter for each client, by Identity, for the per IP rate limit, so
+// that a client can't get around the per connection rate limit by opening many connections. Clients
+// are identified by their remote IP address unless the handler is given another IdentityExtractor,
+// such as ForwardedForIdentity. Limiters that haven't been used for a while are dropped, such that
+// clients that have gone away don't hold on to memory.
+type ipRateLimiters struct {
+	limit rate.Limit
+
+	lk       sync.Mutex
+	limiters map[Identity]*hostLimiter
+}
+
+func newIPRateLimiters(tokensPerSecond int) *ipRateLimiters {
+	return &ipRateLimiters{
+		limit:    rateLimit(tokensPerSecond),
+		limiters: make(map[Identity]*hostLimiter),
+	}
+}
+
+// limiter returns the rate limiter for id, creating it if id has none.
+func (l *ipRateLimiters) limiter(id Identity) *rate.Limiter {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	entry, ok := l.limiters[id]
+	if !ok {
+		// allow for a burst of MaxRateLimitTokens
+		entry = &hostLimiter{limiter: rate.NewLimiter(l.limit, MaxRateLimitTokens)}
+		l.limiters[id] = entry
+	}
+	entry.lastAccess = time.Now()
+	return entry.limiter
+}
+
+// peek returns the rate limiter for id, if id has one, without creating it or counting as a use.
+func (l *ipRateLimiters) peek(id Identity) (*rate.Limiter, bool) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	entry, ok := l.limiters[id]
+	if !ok {
+		return nil, false
+	}
+	return entry.limiter, true
+}
+
+// cleanup drops the limiters of clients that haven't made a request for 5 intervals, every interval
+// until ctx is done.
+func (l *ipRateLimiters) cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.expire(now.Add(-5 * interval))
+		}
+	}
+}
+
+// expire drops the limiters last used before cutoff.
+func (l *ipRateLimiters) expire(cutoff time.Time) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	for id, entry := range l.limiters {
+		if entry.lastAccess.Before(cutoff) {
+			delete(l.limiters, id)
+		}
+	}
+}
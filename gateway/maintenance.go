@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"errors"
+	"reflect"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// ErrUnderMaintenance is returned for every method other than the health and status methods while
+// the gateway is in maintenance mode.
+var ErrUnderMaintenance = errors.New("gateway is under maintenance")
+
+// maintenanceExemptMethods are the health and status methods that are still served in maintenance
+// mode, so that operators and load balancers can tell the gateway itself is up.
+var maintenanceExemptMethods = map[string]bool{
+	"Version":           true,
+	"Discover":          true,
+	"GatewayDeepHealth": true,
+	"GatewayHealth":     true,
+	"RateLimitStatus":   true,
+	"GatewayVersion":    true,
+}
+
+// SetMaintenanceMode enables or disables maintenance mode, in which every proxied request is
+// rejected with ErrUnderMaintenance rather than left to fail against an unavailable target, while
+// the health and status methods keep working.
+func (gw *Node) SetMaintenanceMode(enabled bool) {
+	if gw.maintenance.Swap(enabled) != enabled {
+		log.Infow("gateway maintenance mode changed", "enabled", enabled)
+	}
+}
+
+// maintenanceV1 wraps the v1 gateway API such that methods are rejected while the gateway is in
+// maintenance mode.
+func maintenanceV1(gw *Node, v1 api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	rejectInMaintenance(gw, v1, &out)
+	return &out
+}
+
+// maintenanceV2 wraps the v2 gateway API such that methods are rejected while the gateway is in
+// maintenance mode.
+func maintenanceV2(gw *Node, v2 v2api.Gateway) v2api.Gateway {
+	var out v2api.GatewayStruct
+	rejectInMaintenance(gw, v2, &out)
+	return &out
+}
+
+func rejectInMaintenance(gw *Node, in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if maintenanceExemptMethods[method] || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			if gw.maintenance.Load() {
+				return errorResults(fn.Type(), xerrors.Errorf("%w: %s is unavailable, try again later", ErrUnderMaintenance, method))
+			}
+			return fn.Call(args)
+		})
+	})
+}
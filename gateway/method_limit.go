@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"golang.org/x/time/rate"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// newMethodRateLimiters returns a rate limiter for each method with a rate limit override, keyed by
+// the name the method is registered under. Overrides may name a method by its Ethereum JSON-RPC
+// name, such as eth_call, or with its "Filecoin." prefix; overrides for methods the gateway doesn't
+// serve are logged and ignored, as they're most likely typos. A rate limit of 0 doesn't limit the
+// method beyond the global rate limit.
+func newMethodRateLimiters(overrides map[string]int) map[string]*rate.Limiter {
+	known := gatewayMethods()
+	limiters := make(map[string]*rate.Limiter, len(overrides))
+	for name, tokensPerSecond := range overrides {
+		method, ok := resolveGatewayMethod(known, name)
+		if !ok {
+			log.Warnw("ignoring rate limit override for unknown method", "method", name)
+			continue
+		}
+		limiters[method] = rate.NewLimiter(rateLimit(tokensPerSecond), MaxRateLimitTokens)
+	}
+	return limiters
+}
+
+// resolveGatewayMethod returns the name the method called name over JSON-RPC is registered under,
+// resolving Ethereum names and stripping the "Filecoin." prefix, if it's one of the known methods.
+func resolveGatewayMethod(known map[string]bool, name string) (string, bool) {
+	method := name
+	if original, ok := ethMethodAliases[method]; ok {
+		method = original
+	}
+	method = strings.TrimPrefix(method, "Filecoin.")
+	return method, known[method]
+}
+
+// methodRateLimitsV1 wraps the v1 gateway API such that calls to methods with a rate limit override
+// are limited by the method's own rate limiter as well as by the global rate limit, for methods that
+// should be throttled harder than others such as StateReplay or EthTraceBlock. The method's rate
+// limit is waited on first.
+func methodRateLimitsV1(v1 api.Gateway, limiters map[string]*rate.Limiter) api.Gateway {
+	var out api.GatewayStruct
+	markRateLimitMethods(v1, &out, limiters)
+	return &out
+}
+
+// methodRateLimitsV2 wraps the v2 gateway API such that calls to methods with a rate limit override
+// are limited by the method's own rate limiter as well as by the global rate limit.
+func methodRateLimitsV2(v2 v2api.Gateway, limiters map[string]*rate.Limiter) v2api.Gateway {
+	var out v2api.GatewayStruct
+	markRateLimitMethods(v2, &out, limiters)
+	return &out
+}
+
+func markRateLimitMethods(in interface{}, outstr interface{}, limiters map[string]*rate.Limiter) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		if _, ok := limiters[method]; !ok || fn.Type().NumIn() == 0 || fn.Type().In(0) != contextType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := context.WithValue(contextArg(args), rateLimitMethodKey, method)
+			args[0] = reflect.ValueOf(ctx)
+			return fn.Call(args)
+		})
+	})
+}
+
+// methodRateLimiter returns the name and rate limiter of the method called with ctx, if the method
+// has a rate limit override.
+func (gw *Node) methodRateLimiter(ctx context.Context) (string, *rate.Limiter, bool) {
+	method, _ := ctx.Value(rateLimitMethodKey).(string)
+	limiter, ok := gw.methodRateLimiters[method]
+	return method, limiter, ok
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// newMethodLookbacks returns the maximum lookback of each method with a lookback override, keyed by
+// the name the method is registered under. Methods are named as for newMethodRateLimiters, and
+// overrides for methods the gateway doesn't serve are likewise logged and ignored.
+func newMethodLookbacks(overrides map[string]time.Duration) map[string]time.Duration {
+	known := gatewayMethods()
+	lookbacks := make(map[string]time.Duration, len(overrides))
+	for name, lookback := range overrides {
+		method, ok := resolveGatewayMethod(known, name)
+		if !ok {
+			log.Warnw("ignoring lookback override for unknown method", "method", name)
+			continue
+		}
+		if lookback > 0 {
+			lookbacks[method] = lookback
+		}
+	}
+	return lookbacks
+}
+
+// MaxArchiveLookbackDuration is the longest lookback archive methods may be given, see
+// WithArchiveMethods.
+const MaxArchiveLookbackDuration = 365 * 24 * time.Hour
+
+// newArchiveLookbacks returns the lookback of each of the archive methods, keyed by the name the
+// method is registered under, capping it at MaxArchiveLookbackDuration. Methods are named as for
+// newMethodLookbacks.
+func newArchiveLookbacks(methods []string, lookback time.Duration) map[string]time.Duration {
+	if lookback > MaxArchiveLookbackDuration {
+		log.Warnw("capping archive lookback", "lookback", lookback, "max", MaxArchiveLookbackDuration)
+		lookback = MaxArchiveLookbackDuration
+	}
+	overrides := make(map[string]time.Duration, len(methods))
+	for _, method := range methods {
+		overrides[method] = lookback
+	}
+	return newMethodLookbacks(overrides)
+}
+
+// methodLookbacksV1 wraps the v1 gateway API such that calls to methods with a lookback override
+// are checked against the method's own maximum lookback rather than the global one.
+func methodLookbacksV1(v1 api.Gateway, lookbacks map[string]time.Duration) api.Gateway {
+	var out api.GatewayStruct
+	markLookbackMethods(v1, &out, lookbacks)
+	return &out
+}
+
+// methodLookbacksV2 wraps the v2 gateway API such that calls to methods with a lookback override
+// are checked against the method's own maximum lookback rather than the global one.
+func methodLookbacksV2(v2 v2api.Gateway, lookbacks map[string]time.Duration) v2api.Gateway {
+	var out v2api.GatewayStruct
+	markLookbackMethods(v2, &out, lookbacks)
+	return &out
+}
+
+func markLookbackMethods(in interface{}, outstr interface{}, lookbacks map[string]time.Duration) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		if _, ok := lookbacks[method]; !ok || fn.Type().NumIn() == 0 || fn.Type().In(0) != contextType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := context.WithValue(contextArg(args), lookbackMethodKey, method)
+			args[0] = reflect.ValueOf(ctx)
+			return fn.Call(args)
+		})
+	})
+}
+
+// maxLookback returns the maximum lookback for the method called with ctx, and the error to reject
+// lookbacks exceeding it with: the method's own, if it has a lookback override, or else the archive
+// lookback for archive methods, if that's longer than the global maximum lookback, or else the
+// global one.
+func (gw *Node) maxLookback(ctx context.Context) (time.Duration, error) {
+	method, _ := ctx.Value(lookbackMethodKey).(string)
+	if lookback, ok := gw.methodLookbacks[method]; ok {
+		return lookback, lookbackError(lookback)
+	}
+	settings := gw.currentSettings()
+	if lookback, ok := gw.archiveLookbacks[method]; ok && lookback > settings.maxLookbackDuration {
+		return lookback, lookbackError(lookback)
+	}
+	return settings.maxLookbackDuration, settings.errLookback
+}
+
+// lookbackError returns the error rejecting lookbacks of more than maxLookback.
+func lookbackError(maxLookback time.Duration) error {
+	return lookbackRejection{fmt.Errorf("lookbacks of more than %s are disallowed", maxLookback)}
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// rpcMethodNotFound is the JSON-RPC 2.0 error code returned by the target when it doesn't know
+// about a method we're proxying to it.
+const rpcMethodNotFound = -32601
+
+// methodNotSupportedV1 wraps the v1 target such that "method not found" errors from the target are
+// translated into api.ErrMethodNotSupported.
+func methodNotSupportedV1(server v1api.FullNode, version func(context.Context) string) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	translateErrors(server, &out, translateMethodNotFound(version))
+	return &out
+}
+
+// methodNotSupportedV2 wraps the v2 target such that "method not found" errors from the target are
+// translated into api.ErrMethodNotSupported.
+func methodNotSupportedV2(server v2api.FullNode, version func(context.Context) string) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	translateErrors(server, &out, translateMethodNotFound(version))
+	return &out
+}
+
+func translateMethodNotFound(version func(context.Context) string) func(ctx context.Context, method string, err error) error {
+	return func(ctx context.Context, method string, err error) error {
+		if !isMethodNotFound(err) {
+			return err
+		}
+
+		merr := &api.ErrMethodNotSupported{Method: method}
+		if version != nil {
+			merr.BackendVersion = version(ctx)
+		}
+		log.Debugw("method not supported by backend", "method", method, "error", err)
+		return merr
+	}
+}
+
+func isMethodNotFound(err error) bool {
+	var jerr *jsonrpc.JSONRPCError
+	return errors.As(err, &jerr) && jerr.Code == rpcMethodNotFound
+}
+
+// backendVersion returns the version string reported by the target, or an empty string if it
+// can't be determined.
+func backendVersion(server v1api.FullNode) func(context.Context) string {
+	return func(ctx context.Context) string {
+		v, err := server.Version(ctx)
+		if err != nil {
+			log.Debugw("failed to get backend version", "error", err)
+			return ""
+		}
+		return v.Version
+	}
+}
@@ -2,20 +2,30 @@ package gateway
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	logger "github.com/ipfs/go-log/v2"
 	"go.opencensus.io/stats"
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/v1api"
 	"github.com/filecoin-project/lotus/api/v2api"
 	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
 	_ "github.com/filecoin-project/lotus/lib/sigs/bls"
 	_ "github.com/filecoin-project/lotus/lib/sigs/delegated"
 	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
@@ -24,11 +34,27 @@ import (
 
 var log = logger.Logger("gateway")
 
+// ErrStartingUp is returned, when configured with WithStartupGraceError, for requests that would be
+// rejected for exceeding the lookback limits during the startup grace period.
+var ErrStartingUp = errors.New("gateway is starting up")
+
 const (
-	DefaultMaxLookbackDuration      = time.Hour * 24     // Default duration that a gateway request can look back in chain history
-	DefaultMaxMessageLookbackEpochs = abi.ChainEpoch(20) // Default number of epochs that a gateway message lookup can look back in chain history
-	DefaultRateLimitTimeout         = time.Second * 5    // Default timeout for rate limiting requests; where a request would take longer to wait than this value, it will be rejected
-	DefaultEthMaxFiltersPerConn     = 16                 // Default maximum number of ETH filters and subscriptions per websocket connection
+	DefaultMaxLookbackDuration         = time.Hour * 24     // Default duration that a gateway request can look back in chain history
+	DefaultMaxMessageLookbackEpochs    = abi.ChainEpoch(20) // Default number of epochs that a gateway message lookup can look back in chain history
+	DefaultRateLimitTimeout            = time.Second * 5    // Default timeout for rate limiting requests; where a request would take longer to wait than this value, it will be rejected
+	DefaultEthMaxFiltersPerConn        = 16                 // Default maximum number of ETH filters and subscriptions per websocket connection
+	DefaultBatchFanoutConcurrency      = 16                 // Default maximum number of concurrent target calls made on behalf of batch methods
+	DefaultEthBalanceHistoryMaxSamples = 100                // Default maximum number of blocks sampled by a single EthGetBalanceHistory request
+	DefaultEthBlockRangeMaxSpan        = 100                // Default maximum number of blocks returned by a single EthGetBlockRange request
+	DefaultEthLogsPageSpan             = 100                // Default number of blocks covered by each page returned by EthGetLogsPaged
+	DefaultChainEventsChunkSize        = 500                // Default maximum number of events sent in a single ChainGetEventsStream chunk
+	DefaultMinerPowerConcurrency       = 8                  // Default maximum number of concurrent StateMinerPower checks made by StateListMinersWithPower
+	DefaultChainNotifySubscriberBuffer = 32                 // Default number of head changes queued for a ChainNotify subscriber before it's dropped for not keeping up
+	DefaultEthLogsMaxTopics            = 4                  // Default maximum number of topic positions in an Eth log filter, the number an Ethereum log can have
+	DefaultEthLogsMaxTopicEntries      = 1000               // Default maximum number of topics across all topic positions of an Eth log filter
+	DefaultEthLogsMaxAddresses         = 1000               // Default maximum number of contract addresses in an Eth log filter
+	DefaultCircuitBreakerWindow        = time.Minute        // Default window within which consecutive target failures open a circuit breaker
+	DefaultCircuitBreakerCooldown      = 10 * time.Second   // Default interval at which the target is probed while a circuit breaker is open
 
 	basicRateLimitTokens  = 1
 	walletRateLimitTokens = 1
@@ -38,25 +64,216 @@ const (
 	MaxRateLimitTokens = stateRateLimitTokens // Number of tokens consumed for the most expensive types of operations
 )
 
+// Node proxies the gateway API to a full node. Its methods outside the gateway API, such as Close
+// and SetMaintenanceMode, are for the process running the gateway and aren't served to clients.
 type Node struct {
-	v1Proxy                  *reverseProxyV1
-	v2Proxy                  *reverseProxyV2
-	maxLookbackDuration      time.Duration
-	maxMessageLookbackEpochs abi.ChainEpoch
-	rateLimiter              *rate.Limiter
-	rateLimitTimeout         time.Duration
-	ethMaxFiltersPerConn     int
-	errLookback              error
+	v1Proxy                     *reverseProxyV1
+	v2Proxy                     *reverseProxyV2
+	v1API                       api.Gateway   // v1Proxy, as served to clients
+	v2API                       v2api.Gateway // v2Proxy, as served to clients
+	rateLimiter                 *rate.Limiter
+	writeRateLimiter            *rate.Limiter // reserved for writes, with no burst if there is no reservation
+	slowStart                   *slowStart    // nil if the rate limit isn't ramped up after an outage
+	queueThreshold              time.Duration // 0 if requests aren't told their rate limit queue position
+	rateLimitQueue              atomic.Int64  // requests waiting on the global rate limit, when queueThreshold is set
+	dailyQuota                  *dailyQuota   // nil if no client has a daily quota
+	methodRateLimiters          map[string]*rate.Limiter
+	methodLookbacks             map[string]time.Duration
+	archiveLookbacks            map[string]time.Duration // the lookback of each archive method
+	connRateLimitRetryHint      bool
+	ipRateLimiters              *ipRateLimiters // nil if there is no per IP rate limit
+	ethFeeHistoryMaxBlockAge    abi.ChainEpoch
+	ethStorageMaxBlockAge       abi.ChainEpoch
+	ethCallAllowlist            map[ethtypes.EthAddress]struct{} // nil if calls to any address are allowed
+	blockedAddresses            *blockedAddresses                // nil if no addresses are blocked
+	subscriptionBufferSize      int
+	subscriptionReplayBuffer    int
+	subscriptionReplayRetention time.Duration
+	ethSubscriptionPolicy       EthSubscriptionPolicy
+	minerInfoCache              *minerInfoCache
+	ethBlockCache               *ethBlockCache
+	actorCache                  *actorCache
+	tipSetCache                 *tipSetCache
+	batchFanout                 *semaphore.Weighted
+	minerPowerFanout            *semaphore.Weighted
+	hostFilters                 *hostFilterCounter
+	clientVersion               string
+	ethBalanceHistoryMaxSamples int
+	ethBlockRangeMaxSpan        int
+	ethLogsPageSpan             int
+	ethLogsMaxAddresses         int
+	ethLogsMaxTopics            int
+	ethLogsMaxTopicEntries      int
+	ethMaxLogsBlockRange        int
+	ethBatchMaxBlockParams      int
+	ethTxMaxSize                int
+	ethTxMaxGas                 uint64
+	replayMaxResultSize         int
+	maxResponseBytes            int
+	chainEventsMax              int
+	chainEventsChunkSize        int
+	ethRevertReasons            bool
+	ethCallMaxGas               uint64
+	ethCallNoPrecompiles        bool // reject direct calls to expensive precompiles
+	ethReceiptGasPrice          bool
+	ethExpiredFilterCleanup     bool
+	decodeParamsFallback        bool
+	mpoolPendingMaxMessages     int
+	mpoolPendingMaxAddresses    int
+	serveStaleOnOutage          bool
+	maxStaleServeAge            time.Duration
+	traceConcurrency            *semaphore.Weighted
+	logsConcurrency             *semaphore.Weighted
+	msgSearchConcurrency        *semaphore.Weighted
+	batchCalls                  *semaphore.Weighted // nil if calls in batch requests aren't limited
+	batchMaxCost                int
+	batchMaxSize                int
+	traceReplayMaxResults       int
+	traceBlockMaxMessages       int
+	actorStateMaxEntries        int
+	deprecatedMethods           map[string]string
+	disabledMethods             map[string]bool
+	ethSimulationTimeout        time.Duration
+	defaultFinalizedReads       bool
+	connections                 *connectionRegistry
+	chainNotify                 *chainNotifyHub
+	actorEventMaxBackfill       abi.ChainEpoch
+	started                     time.Time
+	startupGracePeriod          time.Duration
+	startupGraceError           bool
+	cancel                      context.CancelFunc // stops background work
+	background                  sync.WaitGroup     // background work, waited for by Close
+	err                         error              // for options that can't be applied, returned by Handler
+	closed                      atomic.Bool
+	maintenance                 atomic.Bool
+
+	lk       sync.RWMutex
+	options  options  // as currently configured, used by Reconfigure
+	settings settings // derived from options
+}
+
+// settings holds the options that can be changed at runtime with Reconfigure.
+type settings struct {
+	maxLookbackDuration       time.Duration
+	maxMessageLookbackEpochs  abi.ChainEpoch
+	maxReplacedLookbackEpochs abi.ChainEpoch
+	rateLimitTimeout          time.Duration
+	ethMaxFiltersPerConn      int
+	ethCallMaxBlockAge        abi.ChainEpoch
+	errLookback               error
+}
+
+func newSettings(opts *options) settings {
+	maxMessageLookback := opts.maxMessageLookbackEpochs
+	if opts.messageLookbackEpochs != 0 {
+		maxMessageLookback = opts.messageLookbackEpochs
+	}
+	return settings{
+		maxLookbackDuration:       opts.maxLookbackDuration,
+		maxMessageLookbackEpochs:  maxMessageLookback,
+		maxReplacedLookbackEpochs: opts.maxReplacedLookbackEpochs,
+		rateLimitTimeout:          opts.rateLimitTimeout,
+		ethMaxFiltersPerConn:      opts.ethMaxFiltersPerConn,
+		ethCallMaxBlockAge:        opts.ethCallMaxBlockAge,
+		errLookback:               lookbackError(opts.maxLookbackDuration),
+	}
 }
 
 type options struct {
-	v1SubHandler             *EthSubHandler
-	v2SubHandler             *EthSubHandler
-	maxLookbackDuration      time.Duration
-	maxMessageLookbackEpochs abi.ChainEpoch
-	rateLimit                int
-	rateLimitTimeout         time.Duration
-	ethMaxFiltersPerConn     int
+	v1SubHandler                  *EthSubHandler
+	v2SubHandler                  *EthSubHandler
+	maxLookbackDuration           time.Duration
+	maxMessageLookbackEpochs      abi.ChainEpoch
+	maxReplacedLookbackEpochs     abi.ChainEpoch
+	messageLookbackEpochs         abi.ChainEpoch
+	rateLimit                     int
+	rateLimitTimeout              time.Duration
+	queueThreshold                time.Duration
+	dailyTokenQuota               *map[Identity]int64
+	methodRateLimits              *map[string]int           // a pointer to keep options comparable
+	methodLookbacks               *map[string]time.Duration // a pointer to keep options comparable
+	archiveMethods                *[]string                 // a pointer to keep options comparable
+	archiveLookback               time.Duration             // of the archiveMethods
+	fallbackTargets               *[]TargetAPI              // a pointer to keep options comparable
+	targetCallTimeout             time.Duration
+	circuitBreakerThreshold       int
+	circuitBreakerWindow          time.Duration
+	circuitBreakerCooldown        time.Duration
+	ethMaxFiltersPerConn          int
+	subscriptionBufferSize        int
+	subscriptionReplayBuffer      int
+	ethSubscriptionPolicy         EthSubscriptionPolicy
+	methodNotSupported            bool
+	methodNotSupportedWithVersion bool
+	minerInfoCacheSize            int
+	ethBlockCacheSize             int
+	tipSetCacheSize               int
+	actorCacheSize                int
+	actorCacheTTL                 time.Duration
+	cacheMissJitter               time.Duration
+	cacheMemoryBudget             int64
+	batchFanoutConcurrency        int
+	minerPowerConcurrency         int
+	clientVersion                 string
+	ethCallMaxBlockAge            abi.ChainEpoch
+	ethBalanceHistoryMaxSamples   int
+	ethBlockRangeMaxSpan          int
+	ethLogsPageSpan               int
+	ethLogsMaxAddresses           int
+	ethLogsMaxTopics              int
+	ethLogsMaxTopicEntries        int
+	ethMaxLogsBlockRange          int
+	ethBatchMaxBlockParams        int
+	ethTxMaxSize                  int
+	ethTxMaxGas                   uint64
+	replayMaxResultSize           int
+	maxResponseBytes              int
+	ethMaxFiltersPerHost          int
+	ethRevertReasons              bool
+	ethCallMaxGas                 uint64
+	ethCallNoPrecompiles          bool
+	ethReceiptGasPrice            bool
+	ethExpiredFilterCleanup       bool
+	decodeParamsFallback          bool
+	mpoolPendingMaxMessages       int
+	mpoolPendingMaxAddresses      int
+	serveStaleOnOutage            bool
+	maxStaleServeAge              time.Duration
+	slowStartWindow               time.Duration
+	traceConcurrencyLimit         int
+	batchMaxInFlight              int
+	batchMaxCost                  int
+	batchMaxSize                  int
+	traceReplayMaxResults         int
+	traceBlockMaxMessages         int
+	actorStateMaxEntries          int
+	logsConcurrencyLimit          int
+	msgSearchConcurrencyLimit     int
+	headAgeSampleInterval         time.Duration
+	deprecatedMethods             *map[string]string     // a pointer to keep options comparable
+	ethTxRedactFields             *[]string              // a pointer to keep options comparable
+	disabledMethods               *[]string              // a pointer to keep options comparable
+	allowedMethods                *[]string              // a pointer to keep options comparable
+	ethCallAllowlist              *[]ethtypes.EthAddress // a pointer to keep options comparable
+	blockedAddresses              *[]address.Address     // a pointer to keep options comparable
+	ethSimulationTimeout          time.Duration
+	defaultFinalizedReads         bool
+	actorEventMaxBackfill         abi.ChainEpoch
+	requireExplicitTipSet         bool
+	coalesceReads                 bool
+	coalesceLookups               bool
+	sanitizeErrors                bool
+	startupGracePeriod            time.Duration
+	startupGraceError             bool
+	chainNotifyMaxSubscribers     int
+	chainNotifySubscriberBuffer   int
+	writeReservedRateLimit        int
+	connRateLimitRetryHint        bool
+	perIPRateLimit                int
+	ethFeeHistoryMaxBlockAge      abi.ChainEpoch
+	ethStorageMaxBlockAge         abi.ChainEpoch
+	chainEventsMax                int
+	chainEventsChunkSize          int
 }
 
 type Option func(*options)
@@ -84,21 +301,144 @@ func WithMaxLookbackDuration(maxLookbackDuration time.Duration) Option {
 	}
 }
 
-// WithMaxMessageLookbackEpochs sets the maximum lookback (epochs) for state queries.
+// WithMethodLookbackDuration sets the maximum lookback duration for each of the named methods,
+// replacing WithMaxLookbackDuration for them, such that methods whose historical queries are cheap
+// can reach further back than state queries, or expensive ones less far. Methods are named as for
+// WithMethodRateLimits. A lookback of 0 leaves the method subject to the global maximum lookback,
+// which, unlike the per-method lookbacks, can be changed with Reconfigure. Each call replaces the
+// lookbacks set by any earlier one.
+func WithMethodLookbackDuration(lookbacks map[string]time.Duration) Option {
+	methods := make(map[string]time.Duration, len(lookbacks))
+	for method, lookback := range lookbacks {
+		methods[method] = lookback
+	}
+	return func(opts *options) {
+		opts.methodLookbacks = &methods
+	}
+}
+
+// WithArchiveMethods serves the named methods as an archive tier with the longer maximum lookback
+// given, for gateways backed by an archival node that want to offer history for some methods while
+// keeping the usual limits for the rest. The archive lookback is capped at
+// MaxArchiveLookbackDuration, so that a mistaken value can't open up scans of the whole chain. It
+// only ever extends the global maximum lookback: should that be reconfigured beyond the archive
+// lookback, archive methods get the global one like any other. A lookback set for a method with
+// WithMethodLookbackDuration takes precedence over the archive lookback. Methods are named as for
+// WithMethodRateLimits, and a lookback of 0 (the default) disables the tier.
+func WithArchiveMethods(methods []string, lookback time.Duration) Option {
+	archive := append([]string(nil), methods...)
+	return func(opts *options) {
+		opts.archiveMethods = &archive
+		opts.archiveLookback = lookback
+	}
+}
+
+// WithMaxMessageLookbackEpochs sets the maximum lookback (epochs) for message searches, unless
+// overridden by WithMessageLookbackEpochs.
 func WithMaxMessageLookbackEpochs(maxMessageLookbackEpochs abi.ChainEpoch) Option {
 	return func(opts *options) {
 		opts.maxMessageLookbackEpochs = maxMessageLookbackEpochs
 	}
 }
 
+// WithMessageLookbackEpochs sets the maximum lookback (epochs) for every message search method:
+// StateSearchMsg and StateWaitMsg, and the Eth transaction and receipt lookups by hash along with
+// EthGetBlockReceipts, including their Limited variants. Searches requesting a deeper lookback are
+// clamped to it. It overrides WithMaxMessageLookbackEpochs for these methods, so that the lookback
+// of the whole class can be tuned in one place. A value of 0 (the default) leaves the maximum
+// message lookback in effect; api.LookbackNoLimit removes the limit.
+func WithMessageLookbackEpochs(epochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.messageLookbackEpochs = epochs
+	}
+}
+
+// WithMaxReplacedMessageLookbackEpochs sets the maximum lookback (epochs) for message searches that
+// allow for the message to have been replaced, which are more expensive for the target to serve.
+// This is applied in addition to the maximum message lookback. A value of 0 (the default) applies
+// only the maximum message lookback.
+func WithMaxReplacedMessageLookbackEpochs(maxReplacedLookbackEpochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.maxReplacedLookbackEpochs = maxReplacedLookbackEpochs
+	}
+}
+
 // WithRateLimit sets the maximum number of requests per second globally that will be allowed
-// before the gateway starts to rate limit requests.
+// before the gateway starts to rate limit requests. JSON-RPC batch requests over HTTP are charged
+// the estimated cost of all of their calls at once, and rejected as a whole if it can't be met
+// within the rate limit timeout.
 func WithRateLimit(rateLimit int) Option {
 	return func(opts *options) {
 		opts.rateLimit = rateLimit
 	}
 }
 
+// WithWriteReservedRateLimit reserves a pool of rate limit tokens, replenished at writesPerSecond
+// requests per second, for the methods that submit messages: MpoolPush, EthSendRawTransaction and
+// EthSendRawTransactionUntrusted. Writes are served from the pool before waiting on the global rate
+// limit, so they still get through promptly when reads are saturating the gateway, as a dropped
+// message hurts users more than a delayed read. The pool is in addition to the global rate limit. A
+// value of 0 (the default) reserves nothing, and writes share the global rate limit with reads.
+func WithWriteReservedRateLimit(writesPerSecond int) Option {
+	return func(opts *options) {
+		opts.writeReservedRateLimit = writesPerSecond
+	}
+}
+
+// WithPerIPRateLimit sets the rate limit for each client IP address, in tokens per second. A value
+// of 0 (the default) removes the limit.
+func WithPerIPRateLimit(tokensPerSecond int) Option {
+	return func(opts *options) {
+		opts.perIPRateLimit = tokensPerSecond
+	}
+}
+
+// WithConnectionRateLimitRetryHint sets whether requests rejected by the per-connection rate limit,
+// because they would have to wait longer than the rate limit timeout, are rejected with an
+// api.ErrConnectionRateLimited carrying the exact wait needed before the request would be allowed,
+// so that clients can back off precisely rather than retrying blindly.
+func WithConnectionRateLimitRetryHint(enabled bool) Option {
+	return func(opts *options) {
+		opts.connRateLimitRetryHint = enabled
+	}
+}
+
+// WithDailyTokenQuota sets the number of rate limit tokens (see WithRateLimit) each client may
+// consume over a rolling 24 hour window, by Identity (see WithIdentityExtractor), for clients that
+// are billed or limited by volume rather than just by rate. Requests from a client that has used up
+// its quota are rejected with ErrQuotaExceeded, giving the time at which enough of the client's
+// consumption will have rolled out of the window for the request to be allowed. Clients without a
+// quota are only subject to the rate limits.
+func WithDailyTokenQuota(quotas map[Identity]int64) Option {
+	return func(opts *options) {
+		opts.dailyTokenQuota = &quotas
+	}
+}
+
+// WithMethodRateLimits sets a rate limit, in tokens per second, for each of the named methods on
+// top of the global rate limit. Each call replaces the rate limits set by any earlier one.
+func WithMethodRateLimits(limits map[string]int) Option {
+	methods := make(map[string]int, len(limits))
+	for method, tokensPerSecond := range limits {
+		methods[method] = tokensPerSecond
+	}
+	return func(opts *options) {
+		opts.methodRateLimits = &methods
+	}
+}
+
+// WithQueuedResponses sets the wait on the global rate limit (see WithRateLimit) beyond which a
+// request is told its position in the rate limit queue, rather than waiting silently until it's
+// served or times out. Over HTTP the position is sent in the QueuePositionHeader of an
+// informational 102 (Processing) response ahead of the final response, which clients that don't
+// handle informational responses ignore; websocket connections have no such responses, so their
+// requests wait silently. A value of 0 (the default) disables queue positions.
+func WithQueuedResponses(threshold time.Duration) Option {
+	return func(opts *options) {
+		opts.queueThreshold = threshold
+	}
+}
+
 // WithRateLimitTimeout sets the timeout for rate limiting requests such that when rate limiting is
 // being applied, if the timeout is reached the request will be allowed.
 func WithRateLimitTimeout(rateLimitTimeout time.Duration) Option {
@@ -108,140 +448,1735 @@ func WithRateLimitTimeout(rateLimitTimeout time.Duration) Option {
 }
 
 // WithEthMaxFiltersPerConn sets the maximum number of Ethereum filters and subscriptions that can
-// be maintained per websocket connection.
+// be maintained per websocket connection. SubscribeVerifiedClientStatus subscriptions count towards
+// the same limit. The limit is checked as each filter or subscription is created, so lowering it
+// with Reconfigure leaves those already created in place until they're closed.
 func WithEthMaxFiltersPerConn(ethMaxFiltersPerConn int) Option {
 	return func(opts *options) {
 		opts.ethMaxFiltersPerConn = ethMaxFiltersPerConn
 	}
 }
 
-// NewNode creates a new gateway node.
-func NewNode(v1 v1api.FullNode, v2 v2api.FullNode, opts ...Option) *Node {
-	options := &options{
-		maxLookbackDuration:      DefaultMaxLookbackDuration,
-		maxMessageLookbackEpochs: DefaultMaxMessageLookbackEpochs,
-		rateLimitTimeout:         DefaultRateLimitTimeout,
-		ethMaxFiltersPerConn:     DefaultEthMaxFiltersPerConn,
+// WithEthMaxFiltersPerHost sets the maximum number of Ethereum filters and subscriptions that can
+// be maintained by a single remote host across all of its websocket connections. A value of 0 (the
+// default) applies only the per connection limit.
+func WithEthMaxFiltersPerHost(ethMaxFiltersPerHost int) Option {
+	return func(opts *options) {
+		opts.ethMaxFiltersPerHost = ethMaxFiltersPerHost
 	}
-	for _, opt := range opts {
-		opt(options)
+}
+
+// WithChainNotifyMaxSubscribers sets the maximum number of ChainNotify subscriptions the gateway
+// serves at once, across all connections. The subscriptions are all served from a single
+// subscription on the target, but each still costs the gateway a goroutine and a notification
+// buffer. Subscriptions beyond the limit are rejected with ErrTooManyChainNotifySubscribers. A value
+// of 0 (the default) removes the limit.
+func WithChainNotifyMaxSubscribers(n int) Option {
+	return func(opts *options) {
+		opts.chainNotifyMaxSubscribers = n
 	}
+}
 
-	limit := rate.Inf
-	if options.rateLimit > 0 {
-		limit = rate.Every(time.Second / time.Duration(options.rateLimit))
+// WithChainNotifySubscriberBuffer sets the number of head changes queued for each ChainNotify
+// subscriber. Every subscriber is fed from the single subscription on the target through its own
+// buffer, so a client that lets its buffer fill is dropped, closing its subscription, while the
+// others carry on receiving head changes. A larger buffer tolerates clients that fall further
+// behind, at the cost of memory per subscriber. A value of 0 or less uses
+// DefaultChainNotifySubscriberBuffer.
+func WithChainNotifySubscriberBuffer(n int) Option {
+	return func(opts *options) {
+		opts.chainNotifySubscriberBuffer = n
 	}
-	gateway := &Node{
-		maxLookbackDuration:      options.maxLookbackDuration,
-		maxMessageLookbackEpochs: options.maxMessageLookbackEpochs,
-		rateLimiter:              rate.NewLimiter(limit, MaxRateLimitTokens), // allow for a burst of MaxRateLimitTokens
-		rateLimitTimeout:         options.rateLimitTimeout,
-		errLookback:              fmt.Errorf("lookbacks of more than %s are disallowed", options.maxLookbackDuration),
-		ethMaxFiltersPerConn:     options.ethMaxFiltersPerConn,
+}
+
+// WithSubscriptionBufferSize sets the maximum number of EthSubscribe notifications that will be
+// buffered for a single subscription while waiting for the client to receive them. When the buffer
+// overflows, the subscription is dropped. A value of 0 (the default) disables buffering, in which
+// case notifications are delivered to the client synchronously.
+func WithSubscriptionBufferSize(subscriptionBufferSize int) Option {
+	return func(opts *options) {
+		opts.subscriptionBufferSize = subscriptionBufferSize
 	}
-	gateway.v1Proxy = &reverseProxyV1{
-		gateway:       gateway,
-		server:        v1,
-		subscriptions: options.v1SubHandler,
+}
+
+// WithEthSubscriptionReplayBuffer makes newHeads subscriptions resumable: their notifications are
+// numbered in sequence, as SequencedEthSubscriptionResponse, and the last n of them are kept. When a
+// client's connection ends, its newHeads subscriptions are kept running for a minute, still counted
+// against the client's host, and the same client subscribing again with ["newHeads", {"resume":
+// <subscription ID>, "sinceSequence": <sequence number>}] within that time takes over the
+// subscription, being sent the notifications it missed first. If any of those are no longer kept,
+// or the subscription is another client's, EthSubscribe fails with ErrCannotResumeSubscription.
+// Notifications of resumable subscriptions are delivered synchronously, regardless of
+// WithSubscriptionBufferSize. A value of 0 (the default) disables resumption.
+func WithEthSubscriptionReplayBuffer(n int) Option {
+	return func(opts *options) {
+		opts.subscriptionReplayBuffer = n
 	}
-	gateway.v2Proxy = &reverseProxyV2{
-		gateway:       gateway,
-		server:        v2,
-		subscriptions: options.v2SubHandler,
+}
+
+// WithTargetCallTimeout bounds each call the gateway makes to the target by timeout, after which
+// the call is abandoned and fails with ErrBackendTimeout, such that a slow target can't hold client
+// requests open indefinitely. Methods that stream their results, such as ChainNotify and
+// SubscribeActorEventsRaw, aren't bounded. A value of 0 (the default) applies no timeout beyond
+// that of the request itself.
+func WithTargetCallTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.targetCallTimeout = timeout
 	}
-	return gateway
 }
 
-func (gw *Node) V1ReverseProxy() api.Gateway { return gw.v1Proxy }
+// WithFallbackTargets sets nodes that calls are retried on, in order, when the target can't be
+// reached. Only connection errors lead to a retry; an error returned by the target, such as for an
+// actor that doesn't exist, is returned to the client as it is. Writes, such as MpoolPushUntrusted,
+// are never retried, as the target may have accepted them before the connection failed, and
+// neither are calls on Ethereum filters and subscriptions, which only exist on the node they were
+// created on. Both versions of the API must be set for every fallback, or Node.Err returns an error
+// and none are used.
+func WithFallbackTargets(targets ...TargetAPI) Option {
+	return func(opts *options) {
+		opts.fallbackTargets = &targets
+	}
+}
 
-func (gw *Node) V2ReverseProxy() v2api.Gateway { return gw.v2Proxy }
+// WithCircuitBreaker rejects calls with ErrBackendUnavailable once threshold consecutive calls within
+// window fail to reach the target, until it recovers. A threshold of 0 (the default) disables it.
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration) Option {
+	return func(opts *options) {
+		opts.circuitBreakerThreshold = threshold
+		opts.circuitBreakerWindow = window
+		opts.circuitBreakerCooldown = cooldown
+	}
+}
 
-func (gw *Node) checkTipSetKey(ctx context.Context, tsk types.TipSetKey) error {
-	if tsk.IsEmpty() {
-		return nil
+// WithEthSubscriptionPolicy caps the number of newHeads, logs and newPendingTransactions
+// EthSubscribe subscriptions that a single websocket connection can hold, separately for each type,
+// and sets what happens when a client subscribes beyond a cap: the new subscription is either
+// rejected, or the connection's oldest subscription of the same type is unsubscribed to make room
+// for it. The caps apply within the limit set by WithEthMaxFiltersPerConn. By default there are no
+// caps by type.
+func WithEthSubscriptionPolicy(policy EthSubscriptionPolicy) Option {
+	return func(opts *options) {
+		opts.ethSubscriptionPolicy = policy
 	}
+}
 
-	ts, err := gw.v1Proxy.ChainGetTipSet(ctx, tsk)
-	if err != nil {
-		return err
+// WithMethodNotSupportedErrors enables translation of "method not found" errors returned by the
+// target into structured api.ErrMethodNotSupported errors, so that clients calling methods that the
+// target doesn't implement yet get a clear error. If includeBackendVersion is true, the target's
+// Version is included in the error.
+func WithMethodNotSupportedErrors(includeBackendVersion bool) Option {
+	return func(opts *options) {
+		opts.methodNotSupported = true
+		opts.methodNotSupportedWithVersion = includeBackendVersion
 	}
+}
 
-	return gw.checkTipSet(ts)
+// WithStateMinerInfoCache enables caching of successful StateMinerInfo responses for up to size
+// (miner, tipset) pairs. Requests against the current head (an empty tipset key) are not cached. A
+// size of 0 (the default) disables the cache.
+func WithStateMinerInfoCache(size int) Option {
+	return func(opts *options) {
+		opts.minerInfoCacheSize = size
+	}
 }
 
-func (gw *Node) checkTipSet(ts *types.TipSet) error {
-	at := time.Unix(int64(ts.Blocks()[0].Timestamp), 0)
-	if err := gw.checkTimestamp(at); err != nil {
-		return fmt.Errorf("bad tipset: %w", err)
+// WithEthBlockCache enables caching of the blocks returned by EthGetBlockRange, EthGetBlockByNumber
+// and EthGetBlockByHash, for up to size blocks. Only finalized blocks are cached, with the rest of a
+// requested range, and blocks requested by a tag such as "latest", always fetched fresh. Blocks
+// with and without full transactions are cached separately. A size of 0 (the default) disables the
+// cache.
+func WithEthBlockCache(size int) Option {
+	return func(opts *options) {
+		opts.ethBlockCacheSize = size
 	}
-	return nil
 }
 
-func (gw *Node) checkKeyedTipSetHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) error {
-	var ts *types.TipSet
-	if tsk.IsEmpty() {
-		head, err := gw.v1Proxy.ChainHead(ctx)
-		if err != nil {
-			return err
-		}
-		ts = head
-	} else {
-		gts, err := gw.v1Proxy.ChainGetTipSet(ctx, tsk)
-		if err != nil {
-			return err
-		}
-		ts = gts
+// WithTipSetCache enables caching of the tipsets returned by ChainGetTipSetByHeight and
+// ChainGetTipSetAfterHeight, for up to size (height, anchor tipset) pairs. Only tipsets at least
+// policy.ChainFinality epochs behind the head are cached, as more recent ones may yet be replaced by
+// a reorg. A size of 0 (the default) disables the cache.
+func WithTipSetCache(size int) Option {
+	return func(opts *options) {
+		opts.tipSetCacheSize = size
 	}
+}
 
-	// Check if the tipset key refers to gw tipset that's too far in the past
-	if err := gw.checkTipSet(ts); err != nil {
-		return err
+// WithActorCache enables caching of successful StateGetActor responses for up to size (actor,
+// tipset) pairs, each for up to ttl. Every entry is evicted whenever the head changes, as seen
+// through a ChainNotify subscription on the target, so that actor state from a reverted tipset is
+// never served; while the subscription is down the cache isn't used. A ttl of 0 keeps entries until
+// they're evicted. A size of 0 (the default) disables the cache.
+func WithActorCache(size int, ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.actorCacheSize = size
+		opts.actorCacheTTL = ttl
 	}
+}
 
-	// Check if the height is too far in the past
-	if err := gw.checkTipSetHeight(ts, h); err != nil {
-		return err
+// WithCacheMissJitter delays the target call made on a miss in any of the gateway's caches by a
+// random duration of up to maxJitter. After a head change many clients tend to request the same new
+// data at once; concurrent misses for the same key are always coalesced into a single target call,
+// and the jitter additionally spreads out the calls for different keys, smoothing the load on the
+// target. A value of 0 (the default) adds no delay.
+func WithCacheMissJitter(maxJitter time.Duration) Option {
+	return func(opts *options) {
+		opts.cacheMissJitter = maxJitter
 	}
+}
 
-	return nil
+// WithGlobalCacheMemoryBudget bounds the memory used by the entries of all of the gateway's caches
+// combined to an estimated maxBytes. When adding an entry to any cache would exceed the budget, the
+// least recently used entries across all caches are evicted to make room, so that a busy cache
+// can't blow the budget, and caches that are used less don't keep entries that a busy cache could
+// make better use of. Each cache is still bounded by its own size too. A value of 0 (the default)
+// leaves the caches bounded only by their sizes.
+func WithGlobalCacheMemoryBudget(maxBytes int64) Option {
+	return func(opts *options) {
+		opts.cacheMemoryBudget = maxBytes
+	}
 }
 
-func (gw *Node) checkTipSetHeight(ts *types.TipSet, h abi.ChainEpoch) error {
-	if h > ts.Height() {
-		return fmt.Errorf("tipset height in future")
+// WithBatchFanoutConcurrency sets the maximum number of concurrent target calls that may be made on
+// behalf of batch methods. The bound is shared by all batch methods and connections. A value of 0
+// or less removes the bound.
+func WithBatchFanoutConcurrency(n int) Option {
+	return func(opts *options) {
+		opts.batchFanoutConcurrency = n
 	}
-	tsBlock := ts.Blocks()[0]
-	heightDelta := time.Duration(uint64(tsBlock.Height-h)*buildconstants.BlockDelaySecs) * time.Second
-	timeAtHeight := time.Unix(int64(tsBlock.Timestamp), 0).Add(-heightDelta)
+}
 
-	if err := gw.checkTimestamp(timeAtHeight); err != nil {
-		return fmt.Errorf("bad tipset height: %w", err)
+// WithMinerPowerConcurrency sets the maximum number of concurrent StateMinerPower calls that
+// StateListMinersWithPower makes to the target to check which miners have power. The bound is
+// shared by all connections, so that listing the miners with power, which checks every miner, can't
+// be used to hammer the target. A value of 0 or less removes the bound.
+func WithMinerPowerConcurrency(n int) Option {
+	return func(opts *options) {
+		opts.minerPowerConcurrency = n
 	}
-	return nil
 }
 
-func (gw *Node) checkTimestamp(at time.Time) error {
-	if time.Since(at) > gw.maxLookbackDuration {
-		return gw.errLookback
+// WithClientVersion sets the version string returned to clients by Web3ClientVersion in place of
+// the target's, e.g. "lotus-gateway/1.2.3". When empty (the default), the target's version string is
+// returned.
+func WithClientVersion(clientVersion string) Option {
+	return func(opts *options) {
+		opts.clientVersion = clientVersion
 	}
-	return nil
 }
 
-func (gw *Node) limit(ctx context.Context, tokens int) error {
-	ctx2, cancel := context.WithTimeout(ctx, gw.rateLimitTimeout)
-	defer cancel()
+// WithEthCallMaxBlockAge sets the maximum age, in epochs behind the current head, of the block that
+// EthCall, EthGetStorageAt and EthGetCode may be executed against. This is enforced in addition to
+// the general lookback limits, allowing simulation calls to be restricted more tightly than other
+// reads. A value of 0 (the default) applies only the general lookback limits.
+func WithEthCallMaxBlockAge(epochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.ethCallMaxBlockAge = epochs
+	}
+}
 
-	if perConnLimiter, ok := getPerConnectionAPIRateLimiter(ctx); ok {
-		err := perConnLimiter.WaitN(ctx2, tokens)
-		if err != nil {
-			return fmt.Errorf("connection limited. %w", err)
-		}
+// WithEthStorageMaxBlockAge sets the maximum age, in epochs behind the current head, of the block
+// that EthGetStorageAt may read storage at. Storage reads at depth are particularly heavy on the
+// target's historical state, so this allows them to be restricted more tightly than EthCall; where
+// both are set the tighter of this and WithEthCallMaxBlockAge applies. A value of 0 (the default)
+// applies only the EthCall and general lookback limits.
+func WithEthStorageMaxBlockAge(epochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.ethStorageMaxBlockAge = epochs
 	}
+}
 
-	err := gw.rateLimiter.WaitN(ctx2, tokens)
-	if err != nil {
-		stats.Record(ctx, metrics.RateLimitCount.M(1))
-		return fmt.Errorf("server busy. %w", err)
+// WithEthFeeHistoryMaxBlockAge sets the maximum age, in epochs behind the current head, of the
+// newest block that EthFeeHistory may report on, as fee history for old blocks has to be computed
+// from historical state. This is enforced in addition to the general lookback limits, which the
+// newest block is always subject to. A value of 0 (the default) applies only the general lookback
+// limits.
+func WithEthFeeHistoryMaxBlockAge(epochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.ethFeeHistoryMaxBlockAge = epochs
+	}
+}
+
+// WithActorEventSubscriptionMaxBackfill sets the maximum number of epochs behind the current head
+// that a SubscribeActorEventsRaw filter may request historical events from before streaming live
+// events. Subscriptions requesting more history are rejected with ErrActorEventBackfillTooLong.
+// This is enforced in addition to the general lookback limits. A value of 0 (the default) applies
+// only the general lookback limits.
+func WithActorEventSubscriptionMaxBackfill(epochs abi.ChainEpoch) Option {
+	return func(opts *options) {
+		opts.actorEventMaxBackfill = epochs
+	}
+}
+
+// WithEthBalanceHistoryMaxSamples sets the maximum number of blocks that a single
+// EthGetBalanceHistory request may sample. A value of 0 or less removes the limit.
+func WithEthBalanceHistoryMaxSamples(maxSamples int) Option {
+	return func(opts *options) {
+		opts.ethBalanceHistoryMaxSamples = maxSamples
+	}
+}
+
+// WithEthBlockRangeMaxSpan sets the maximum number of blocks that a single EthGetBlockRange request
+// may return. A value of 0 or less removes the limit.
+func WithEthBlockRangeMaxSpan(maxSpan int) Option {
+	return func(opts *options) {
+		opts.ethBlockRangeMaxSpan = maxSpan
+	}
+}
+
+// WithEthLogsMaxAddresses sets the maximum number of contract addresses that the filter passed to
+// EthGetLogs or EthNewFilter may match, as each address adds to the cost of matching logs on the
+// target. Filters with more addresses are rejected with ErrTooManyLogAddresses. EthGetFilterLogs
+// is covered too, as it's only served for filters installed through the gateway with EthNewFilter.
+// The default is DefaultEthLogsMaxAddresses, and a value of 0 removes the limit.
+func WithEthLogsMaxAddresses(maxAddresses int) Option {
+	return func(opts *options) {
+		opts.ethLogsMaxAddresses = maxAddresses
+	}
+}
+
+// WithEthLogsMaxTopics sets the maximum number of topic positions in the filter passed to
+// EthGetLogs or EthNewFilter. Ethereum logs have at most 4 topics, so a filter with more positions
+// can't match anything and is rejected with ErrTooManyLogTopics rather than forwarded to the
+// target; topic positions that aren't a hash, an array of hashes or null are rejected when the
+// filter is decoded. The default is DefaultEthLogsMaxTopics, and a value of 0 removes the limit.
+func WithEthLogsMaxTopics(maxTopics int) Option {
+	return func(opts *options) {
+		opts.ethLogsMaxTopics = maxTopics
+	}
+}
+
+// WithEthLogsMaxTopicEntries sets the maximum number of topics across all topic positions of the
+// filter passed to EthGetLogs or EthNewFilter, as each alternative topic in a position adds to the
+// cost of matching logs on the target. A null position, which matches any topic, counts as a single
+// entry. Filters with more entries are rejected with ErrTooManyLogTopicEntries. The default is
+// DefaultEthLogsMaxTopicEntries, and a value of 0 removes the limit.
+func WithEthLogsMaxTopicEntries(maxEntries int) Option {
+	return func(opts *options) {
+		opts.ethLogsMaxTopicEntries = maxEntries
+	}
+}
+
+// WithEthMaxLogsBlockRange sets the maximum number of epochs that the block range of an EthGetLogs
+// request may span, as each epoch in the range adds to the cost of matching logs on the target. The
+// "latest", "pending", "safe", "finalized" and "earliest" forms of fromBlock and toBlock are
+// resolved against the current head, with a missing fromBlock or toBlock meaning "latest", and a
+// filter for a single block hash always spans one epoch. Requests with a wider range are rejected
+// with ErrLogsBlockRangeTooLarge. A value of 0 (the default) removes the limit.
+func WithEthMaxLogsBlockRange(epochs int) Option {
+	return func(opts *options) {
+		opts.ethMaxLogsBlockRange = epochs
+	}
+}
+
+// WithEthLogsPageSpan sets the number of blocks covered by each page of logs returned by
+// EthGetLogsPaged, which is also capped by WithEthMaxLogsBlockRange. Pages whose logs exceed the
+// maximum response size are narrowed until they fit, down to a single block. A value of 0 or less
+// uses DefaultEthLogsPageSpan.
+func WithEthLogsPageSpan(blocks int) Option {
+	return func(opts *options) {
+		opts.ethLogsPageSpan = blocks
+	}
+}
+
+// WithStateReplayMaxResultSize sets the maximum size, in bytes, of the JSON encoded result that
+// StateReplay may return. A replay's result carries the full execution trace of the message, which
+// for some messages is too large for clients to reasonably handle. Larger results are rejected with
+// ErrReplayResultTooLarge. A value of 0 (the default) removes the limit.
+func WithStateReplayMaxResultSize(n int) Option {
+	return func(opts *options) {
+		opts.replayMaxResultSize = n
+	}
+}
+
+// WithMaxResponseBytes sets the maximum size, in bytes, of the JSON encoded object that
+// ChainReadObj, ChainGetNode, ChainGetEvents and StateReadState may return. IPLD objects can be
+// arbitrarily large, and the limit keeps pathological ones from being held in memory by the gateway
+// while they're sent and by the clients receiving them. The size is checked once the target has
+// returned the object; larger objects are rejected with ErrResponseTooLarge. A value of 0 (the
+// default) removes the limit.
+func WithMaxResponseBytes(n int) Option {
+	return func(opts *options) {
+		opts.maxResponseBytes = n
+	}
+}
+
+// WithChainEventsMax sets the maximum number of events that ChainGetEvents and
+// ChainGetEventsStream may return for a single events root. Requests for roots with more events
+// are rejected with ErrTooManyEvents. A value of 0 (the default) removes the limit.
+func WithChainEventsMax(n int) Option {
+	return func(opts *options) {
+		opts.chainEventsMax = n
+	}
+}
+
+// WithChainEventsChunkSize sets the maximum number of events sent in each chunk by
+// ChainGetEventsStream. The events of a chunk are read from the target's blockstore as the chunk is
+// built, so the gateway only holds a chunk's worth of events in memory at a time, rather than the
+// whole slice that ChainGetEvents returns. The default, also used for values of 0 or less, is
+// DefaultChainEventsChunkSize.
+func WithChainEventsChunkSize(n int) Option {
+	return func(opts *options) {
+		opts.chainEventsChunkSize = n
+	}
+}
+
+// WithEthBatchMaxBlockParams sets the maximum number of distinct Eth block params that the calls in
+// a single HTTP request, such as a JSON-RPC batch, may reference, as each may need resolving to a
+// tipset. Calls referencing further block params are rejected with ErrTooManyBlockParams. A block
+// param referenced by several calls counts once, and is only resolved once. A value of 0 (the
+// default) removes the limit.
+func WithEthBatchMaxBlockParams(n int) Option {
+	return func(opts *options) {
+		opts.ethBatchMaxBlockParams = n
+	}
+}
+
+// WithEthTxMaxSize sets the maximum size, in bytes, of a raw transaction submitted with
+// EthSendRawTransaction. Larger transactions are rejected with ErrEthTxTooLarge without being sent
+// to the target. A value of 0 (the default) removes the limit.
+func WithEthTxMaxSize(n int) Option {
+	return func(opts *options) {
+		opts.ethTxMaxSize = n
+	}
+}
+
+// WithEthTxMaxGas sets the maximum gas limit of a raw transaction submitted with
+// EthSendRawTransaction. Transactions with a higher gas limit are rejected with ErrEthTxGasTooHigh
+// without being sent to the target. A value of 0 (the default) removes the limit.
+func WithEthTxMaxGas(g uint64) Option {
+	return func(opts *options) {
+		opts.ethTxMaxGas = g
+	}
+}
+
+// WithEthCallMaxGas sets the maximum gas limit of the calls simulated by EthCall and
+// EthEstimateGas, bounding the compute a single request can consume on the target's EVM, such as
+// with deeply recursive calls. Calls with a higher gas limit are rejected with ErrEthCallGasTooHigh
+// without being sent to the target, and calls that don't set one are given the maximum, rather than
+// the target's own, much higher, default. A value of 0 (the default) removes the limit.
+func WithEthCallMaxGas(g uint64) Option {
+	return func(opts *options) {
+		opts.ethCallMaxGas = g
+	}
+}
+
+// WithEthCallRejectExpensivePrecompiles rejects EthCall and EthEstimateGas requests made directly
+// to the precompiles whose cost grows fastest with their input, modexp, ecPairing and blake2f, with
+// ErrEthCallPrecompileNotAllowed. Calls to them made by contracts are bounded by the gas limit only,
+// see WithEthCallMaxGas.
+func WithEthCallRejectExpensivePrecompiles() Option {
+	return func(opts *options) {
+		opts.ethCallNoPrecompiles = true
+	}
+}
+
+// WithEthRevertReasons enables decoding of the standard Solidity Error(string) and Panic(uint256)
+// revert reasons of reverted EthCall requests. The decoded reason is added to the
+// api.ErrExecutionReverted returned to the client, alongside the raw revert data. Reverts with
+// custom errors are returned with only the raw revert data, as they can't be decoded without the
+// contract's ABI.
+func WithEthRevertReasons() Option {
+	return func(opts *options) {
+		opts.ethRevertReasons = true
+	}
+}
+
+// WithStateDecodeParamsFallback sets whether StateDecodeParams returns the params it was given, as
+// UndecodedParams, rather than failing when the target can't decode them, e.g. for an actor or
+// method it doesn't know. This lets clients such as explorers display something for any message.
+// Errors that aren't about the params, such as the target being unavailable, still fail.
+func WithStateDecodeParamsFallback(enable bool) Option {
+	return func(opts *options) {
+		opts.decodeParamsFallback = enable
+	}
+}
+
+// WithEthExpiredFilterCleanup sets whether the gateway stops tracking a filter once the target
+// reports, in response to EthGetFilterChanges, that it no longer has it, e.g. because the filter
+// timed out on the target. The filter then no longer counts towards the connection's and host's
+// filter limits, and the client is told with ErrFilterExpired to recreate it, rather than polling
+// a filter that will never return changes again.
+func WithEthExpiredFilterCleanup(enable bool) Option {
+	return func(opts *options) {
+		opts.ethExpiredFilterCleanup = enable
+	}
+}
+
+// WithEthReceiptEffectiveGasPrice enables filling in the effective gas price of transaction
+// receipts that the target returns without one. The price is computed from the transaction's fee
+// cap and premium and the base fee of the block it was included in, which are fetched from the
+// target as needed.
+func WithEthReceiptEffectiveGasPrice() Option {
+	return func(opts *options) {
+		opts.ethReceiptGasPrice = true
+	}
+}
+
+// WithMpoolPendingMaxMessages sets the maximum number of pending messages that MpoolPending will
+// return. Requests made while the mempool holds more messages than this are rejected with
+// ErrTooManyPendingMessages. A value of 0 (the default) removes the limit. The limit also applies
+// to the messages matched by MpoolPendingByAddress, rather than to the whole mempool.
+func WithMpoolPendingMaxMessages(maxMessages int) Option {
+	return func(opts *options) {
+		opts.mpoolPendingMaxMessages = maxMessages
+	}
+}
+
+// WithMpoolPendingMaxAddresses sets the maximum number of addresses that MpoolPendingByAddress may
+// filter the pending messages by. Requests with more addresses are rejected with
+// ErrTooManyPendingAddresses. A value of 0 (the default) removes the limit.
+func WithMpoolPendingMaxAddresses(maxAddresses int) Option {
+	return func(opts *options) {
+		opts.mpoolPendingMaxAddresses = maxAddresses
+	}
+}
+
+// WithServeStaleOnOutage enables a degraded mode for when the target can't be reached, in which read
+// methods with a cache are served from their cache even if the cached entry may be out of date,
+// rather than failing. Those are StateMinerInfo (see WithStateMinerInfoCache), StateGetActor (see
+// WithActorCache, whose entries are then kept when its head change subscription ends),
+// EthGetBlockByNumber and EthGetBlockByHash (see WithEthBlockCache), and ChainGetTipSetByHeight
+// and ChainGetTipSetAfterHeight (see WithTipSetCache). Responses to HTTP requests that were served
+// this way have the StaleResponseHeader set. Requests that can't be served from cache fail with
+// ErrBackendUnavailable.
+func WithServeStaleOnOutage(serveStale bool) Option {
+	return func(opts *options) {
+		opts.serveStaleOnOutage = serveStale
+	}
+}
+
+// WithMaxStaleServeAge bounds how long ago a cached entry may have been added to its cache and
+// still be served while the target is unavailable (see WithServeStaleOnOutage). Older entries are
+// treated as cache misses, failing with ErrBackendUnavailable, rather than serving data that may be
+// dangerously out of date. A value of 0 (the default) serves cached entries of any age.
+func WithMaxStaleServeAge(maxAge time.Duration) Option {
+	return func(opts *options) {
+		opts.maxStaleServeAge = maxAge
+	}
+}
+
+// WithSlowStart ramps the global rate limit (see WithRateLimit) back up over the given window after
+// the target recovers from an outage, in which it couldn't be reached, rather than letting the full
+// rate of requests through as soon as it's reachable again. The ramp starts from a tenth of the
+// rate limit and rises linearly, giving the target time to warm up rather than being overwhelmed
+// and failing again. A value of 0 (the default) disables the ramp, as does having no rate limit.
+func WithSlowStart(window time.Duration) Option {
+	return func(opts *options) {
+		opts.slowStartWindow = window
+	}
+}
+
+// WithTraceConcurrencyLimit sets the maximum number of EthTraceBlock,
+// EthTraceReplayBlockTransactions, EthTraceTransaction and EthTraceFilter requests that may be in
+// flight to the target at once. The limit is shared by all four methods, on both the v1 and v2
+// APIs, as they all compete for the target's execution engine. Requests that can't be started within
+// the rate limit timeout are rejected. A value of 0 (the default) removes the limit.
+func WithTraceConcurrencyLimit(n int) Option {
+	return func(opts *options) {
+		opts.traceConcurrencyLimit = n
+	}
+}
+
+// WithMessageSearchConcurrencyLimit sets the maximum number of StateSearchMsg and StateWaitMsg
+// requests that may be in flight to the target at once. The limit is shared by both methods as they
+// both scan back through the chain's history for the message, and is separate from the limits of
+// other methods. Requests that can't be started within the rate limit timeout are rejected. A value
+// of 0 (the default) removes the limit.
+func WithMessageSearchConcurrencyLimit(n int) Option {
+	return func(opts *options) {
+		opts.msgSearchConcurrencyLimit = n
+	}
+}
+
+// WithBatchMaxInFlight sets the maximum number of calls in JSON-RPC batch requests that may be in
+// flight at once, across all clients. A value of 0 (the default) removes the limit.
+func WithBatchMaxInFlight(n int) Option {
+	return func(opts *options) {
+		opts.batchMaxInFlight = n
+	}
+}
+
+// WithBatchMaxCost sets the maximum combined cost, in rate limit tokens, of the calls in a JSON-RPC
+// batch request over HTTP. The cost of a batch is estimated from the methods it calls before any of
+// them are made, so that a batch of a few expensive calls is bounded as well as one of many cheap
+// calls, and batches over the limit are rejected as a whole with ErrBatchTooExpensive. A value of 0
+// (the default) removes the limit.
+func WithBatchMaxCost(tokens int) Option {
+	return func(opts *options) {
+		opts.batchMaxCost = tokens
+	}
+}
+
+// WithMaxBatchSize sets the maximum number of calls in a JSON-RPC batch request over HTTP. Larger
+// batches are rejected as a whole with ErrBatchTooLarge before any of their calls are made. A value
+// of 0 (the default) removes the limit.
+func WithMaxBatchSize(n int) Option {
+	return func(opts *options) {
+		opts.batchMaxSize = n
+	}
+}
+
+// WithEthLogsConcurrencyLimit sets the maximum number of EthGetLogs and EthGetFilterLogs requests
+// that may be in flight to the target at once, on both the v1 and v2 APIs, to protect the target's
+// log index from many concurrent log queries. The limit is separate from that of the trace methods,
+// and other methods aren't affected by it. Requests that can't be started within the rate limit
+// timeout are rejected. A value of 0 (the default) removes the limit.
+func WithEthLogsConcurrencyLimit(n int) Option {
+	return func(opts *options) {
+		opts.logsConcurrencyLimit = n
+	}
+}
+
+// WithTraceReplayMaxResults sets the maximum number of transaction replays, one per transaction in
+// the block, that EthTraceReplayBlockTransactions may return. Replaying a dense block produces a
+// large array of traces, so requests for blocks with more transactions are rejected with
+// ErrTooManyTraceResults. A value of 0 (the default) removes the limit.
+func WithTraceReplayMaxResults(n int) Option {
+	return func(opts *options) {
+		opts.traceReplayMaxResults = n
+	}
+}
+
+// WithTraceBlockMaxMessages sets the maximum number of messages in the tipset that EthTraceBlock
+// and EthTraceReplayBlockTransactions may replay. Both execute every message in the tipset, so the
+// messages are counted before the request is served and larger tipsets are rejected with
+// ErrTooManyTraceMessages. A value of 0 (the default) removes the limit.
+func WithTraceBlockMaxMessages(n int) Option {
+	return func(opts *options) {
+		opts.traceBlockMaxMessages = n
+	}
+}
+
+// WithActorStateMaxEntries sets the maximum number of entries, counted across every map and array
+// in the decoded state, that StateReadState may return. Decoding the state of an actor such as the
+// market actor yields a very large structure, so larger states are rejected with
+// ErrActorStateTooComplex. A value of 0 (the default) removes the limit.
+func WithActorStateMaxEntries(n int) Option {
+	return func(opts *options) {
+		opts.actorStateMaxEntries = n
+	}
+}
+
+// WithHeadAgeSampleInterval sets the interval at which the gateway samples the target's head in the
+// background, recording the time since its timestamp as the metrics.GatewayHeadAge gauge, so that
+// operators can tell how far behind the target is without a client request. Intervals shorter than
+// MinHeadAgeSampleInterval are raised to it. A value of 0 (the default) disables sampling.
+func WithHeadAgeSampleInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.headAgeSampleInterval = d
+	}
+}
+
+// WithDeprecatedMethods marks gateway methods as deprecated. deprecated maps a method name, e.g.
+// "StateMinerInfo" or "EthGetBalance", to a message for clients calling it; such as when it will be
+// removed and what to use instead. Deprecated methods are served as normal, but each call is logged
+// and counted, and an HTTP Warning header carrying the message is set on the response to HTTP
+// requests that call them.
+func WithDeprecatedMethods(deprecated map[string]string) Option {
+	methods := make(map[string]string, len(deprecated))
+	for method, msg := range deprecated {
+		methods[method] = msg
+	}
+	return func(opts *options) {
+		opts.deprecatedMethods = &methods
+	}
+}
+
+// WithEthTxFieldPolicy redacts the named fields, e.g. "input", from the Eth transactions returned to
+// clients by every method that returns them, whether alone or as the full transactions of a block.
+// Fields are named as in their JSON encoding. Redacted fields are cleared to their zero value, or
+// left out where they're optional. Unknown field names are logged and ignored.
+func WithEthTxFieldPolicy(redact ...string) Option {
+	fields := append([]string(nil), redact...)
+	return func(opts *options) {
+		opts.ethTxRedactFields = &fields
+	}
+}
+
+// WithDisabledMethods disables the named gateway methods, e.g. "EthTraceBlock", such that calls to
+// them are rejected with an api.ErrMethodNotSupported, with Disabled set, without reaching the
+// target. Each call replaces the methods disabled by any earlier one.
+func WithDisabledMethods(methods ...string) Option {
+	disabled := append([]string(nil), methods...)
+	return func(opts *options) {
+		opts.disabledMethods = &disabled
+	}
+}
+
+// WithDeniedMethods is WithDisabledMethods, for use alongside WithAllowedMethods.
+func WithDeniedMethods(methods ...string) Option {
+	return WithDisabledMethods(methods...)
+}
+
+// WithAllowedMethods disables every gateway method other than the named ones, e.g. to serve a
+// read-only gateway that doesn't allow MpoolPush or EthSendRawTransaction. Calls to other methods
+// are rejected as by WithDisabledMethods without reaching the target. A method that's both allowed
+// and disabled (see WithDisabledMethods) is disabled and makes Node.Err return an error naming it.
+// Each call replaces the methods allowed by any earlier one.
+func WithAllowedMethods(methods ...string) Option {
+	allowed := append([]string(nil), methods...)
+	return func(opts *options) {
+		opts.allowedMethods = &allowed
+	}
+}
+
+// WithEthCallAddressAllowlist restricts EthCall and EthEstimateGas to calls to the given contract
+// addresses, for gateways that only serve a specific dapp. Calls to any other address, and calls
+// that would deploy a contract, are rejected with ErrEthCallAddressNotAllowed. An empty allowlist
+// (the default) allows calls to any address.
+func WithEthCallAddressAllowlist(addrs []ethtypes.EthAddress) Option {
+	allowed := append([]ethtypes.EthAddress(nil), addrs...)
+	return func(opts *options) {
+		opts.ethCallAllowlist = &allowed
+	}
+}
+
+// WithBlockedAddresses refuses queries about the given addresses, such as sanctioned or abusive
+// ones: calls to methods that take one of them as an argument, like StateGetActor, WalletBalance
+// and StateMinerInfo, are rejected with ErrForbiddenAddress. Each address is blocked in both its ID
+// and robust forms, where the other form can be looked up on the target; for actors that don't
+// exist yet this is retried periodically. Blocking is best-effort, as clients can still learn about
+// the addresses indirectly, e.g. through messages, events or Ethereum methods.
+func WithBlockedAddresses(addrs []address.Address) Option {
+	blocked := append([]address.Address(nil), addrs...)
+	return func(opts *options) {
+		opts.blockedAddresses = &blocked
+	}
+}
+
+// WithEthSimulationTimeout sets the maximum time that EthCall and EthEstimateGas may spend being
+// executed by the target, after which they're cancelled and fail with ErrSimulationTimedOut. A value
+// of 0 (the default) applies no timeout beyond that of the request itself.
+func WithEthSimulationTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.ethSimulationTimeout = timeout
+	}
+}
+
+// WithDefaultFinalizedReads sets whether state methods called with an empty tipset key read from the
+// tipset finalized by the latest F3 certificate rather than from the chain head. Where F3 is
+// unavailable they fall back to the head, and the response carries UnfinalizedResponseHeader.
+func WithDefaultFinalizedReads(enabled bool) Option {
+	return func(opts *options) {
+		opts.defaultFinalizedReads = enabled
+	}
+}
+
+// WithRequireExplicitTipset sets whether the v1 state, chain and multisig methods that take a tipset
+// key reject calls with an empty key, rather than defaulting to the chain head, with
+// ErrExplicitTipSetRequired. This surfaces clients that read from the head unintentionally. Eth
+// methods are unaffected.
+func WithRequireExplicitTipset(required bool) Option {
+	return func(opts *options) {
+		opts.requireExplicitTipSet = required
+	}
+}
+
+// WithReadCoalescing sets whether concurrent identical v1 reads are coalesced into a single target
+// call, with the result shared by every client that made the read. Only deterministic reads are
+// coalesced: reads of content addressed data, such as ChainReadObj, and state and chain reads
+// against an explicit tipset. Each client's call is still rate limited as normal.
+func WithReadCoalescing(enabled bool) Option {
+	return func(opts *options) {
+		opts.coalesceReads = enabled
+	}
+}
+
+// WithRequestCoalescing enables coalescing of the lookups clients commonly poll for at once:
+// concurrent identical EthGetTransactionReceipt, EthGetBlockByHash and StateGetActor calls to the
+// target, on either API version, share a single call and its result, whatever tipset they're
+// against. Calls are identical if all of their parameters are, so receipt lookups are keyed by the
+// transaction hash and the lookback limit applied. A client that gives up on a shared call doesn't
+// fail the others waiting on it. Each client's call is still rate limited as normal.
+func WithRequestCoalescing() Option {
+	return func(opts *options) {
+		opts.coalesceLookups = true
+	}
+}
+
+// WithErrorSanitization sets whether errors returned by the target are replaced with
+// ErrRequestFailed and an error ID before reaching clients, so that internal details such as file
+// paths and peer IDs aren't exposed. The full error is logged along with the error ID. Errors
+// raised by the gateway itself, such as rate limiting, are unaffected.
+func WithErrorSanitization(enabled bool) Option {
+	return func(opts *options) {
+		opts.sanitizeErrors = enabled
+	}
+}
+
+// WithStartupGracePeriod sets a period after the gateway starts during which the lookback limits
+// on tipset timestamps aren't enforced, so that requests are still served, rather than rejected,
+// while the target catches up with the chain and its head is stale. Once the period is over the
+// lookback limits apply as normal. See also WithStartupGraceError.
+func WithStartupGracePeriod(d time.Duration) Option {
+	return func(opts *options) {
+		opts.startupGracePeriod = d
+	}
+}
+
+// WithStartupGraceError sets whether requests that exceed the lookback limits during the startup
+// grace period are rejected with ErrStartingUp, so that clients can tell to retry, rather than
+// served.
+func WithStartupGraceError(enabled bool) Option {
+	return func(opts *options) {
+		opts.startupGraceError = enabled
+	}
+}
+
+// NewNode creates a new gateway node. Options that can't be applied are reported by Err, which
+// callers should check before serving the node.
+func NewNode(v1 v1api.FullNode, v2 v2api.FullNode, opts ...Option) *Node {
+	options := &options{
+		maxLookbackDuration:         DefaultMaxLookbackDuration,
+		maxMessageLookbackEpochs:    DefaultMaxMessageLookbackEpochs,
+		rateLimitTimeout:            DefaultRateLimitTimeout,
+		ethMaxFiltersPerConn:        DefaultEthMaxFiltersPerConn,
+		batchFanoutConcurrency:      DefaultBatchFanoutConcurrency,
+		minerPowerConcurrency:       DefaultMinerPowerConcurrency,
+		ethBalanceHistoryMaxSamples: DefaultEthBalanceHistoryMaxSamples,
+		ethBlockRangeMaxSpan:        DefaultEthBlockRangeMaxSpan,
+		ethLogsPageSpan:             DefaultEthLogsPageSpan,
+		chainEventsChunkSize:        DefaultChainEventsChunkSize,
+		ethLogsMaxTopics:            DefaultEthLogsMaxTopics,
+		ethLogsMaxTopicEntries:      DefaultEthLogsMaxTopicEntries,
+		ethLogsMaxAddresses:         DefaultEthLogsMaxAddresses,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.targetCallTimeout > 0 {
+		v1, v2 = targetTimeoutV1(v1, options.targetCallTimeout), targetTimeoutV2(v2, options.targetCallTimeout)
+	}
+	var optionsErr error
+	if options.fallbackTargets != nil && len(*options.fallbackTargets) > 0 {
+		fallbacks := *options.fallbackTargets
+		if optionsErr = checkFallbackTargets(fallbacks); optionsErr != nil {
+			fallbacks = nil
+		} else if options.targetCallTimeout > 0 {
+			fallbacks = make([]TargetAPI, len(*options.fallbackTargets))
+			for i, fb := range *options.fallbackTargets {
+				fallbacks[i] = TargetAPI{V1: targetTimeoutV1(fb.V1, options.targetCallTimeout), V2: targetTimeoutV2(fb.V2, options.targetCallTimeout)}
+			}
+		}
+		if len(fallbacks) > 0 {
+			v1, v2 = failoverV1(v1, fallbacks), failoverV2(v2, fallbacks)
+		}
+	}
+	var breakers *circuitBreakers
+	probeTarget := v1
+	if options.circuitBreakerThreshold > 0 {
+		// a window or cooldown of 0 or less leaves it to the defaults
+		if options.circuitBreakerWindow <= 0 {
+			options.circuitBreakerWindow = DefaultCircuitBreakerWindow
+		}
+		if options.circuitBreakerCooldown <= 0 {
+			options.circuitBreakerCooldown = DefaultCircuitBreakerCooldown
+		}
+		breakers = newCircuitBreakers(options.circuitBreakerThreshold, options.circuitBreakerWindow, options.circuitBreakerCooldown)
+		v1, v2 = breakers.wrapV1(v1), breakers.wrapV2(v2)
+	}
+	if options.methodNotSupported {
+		var version func(context.Context) string
+		if options.methodNotSupportedWithVersion {
+			version = backendVersion(v1)
+		}
+		v1, v2 = methodNotSupportedV1(v1, version), methodNotSupportedV2(v2, version)
+	}
+	rateLimiter := rate.NewLimiter(rateLimit(options.rateLimit), MaxRateLimitTokens) // allow for a burst of MaxRateLimitTokens
+	var slow *slowStart
+	if options.slowStartWindow > 0 {
+		slow = newSlowStart(options.slowStartWindow, rateLimiter)
+		v1, v2 = slow.observeV1(v1), slow.observeV2(v2)
+	}
+	if options.serveStaleOnOutage {
+		v1, v2 = backendUnavailableV1(v1), backendUnavailableV2(v2)
+	}
+	if options.sanitizeErrors {
+		v1, v2 = sanitizeErrorsV1(v1), sanitizeErrorsV2(v2)
+	}
+	if options.defaultFinalizedReads {
+		v1 = finalizedReadsV1(v1)
+	}
+	if options.coalesceReads {
+		v1 = coalescedReadsV1(v1)
+	}
+	if options.coalesceLookups {
+		v1, v2 = coalescedLookupsV1(v1), coalescedLookupsV2(v2)
+	}
+	v1, v2 = trackTargetErrorsV1(v1), trackTargetErrorsV2(v2)
+
+	gateway := &Node{
+		err:                         optionsErr,
+		rateLimiter:                 rateLimiter,
+		queueThreshold:              options.queueThreshold,
+		slowStart:                   slow,
+		subscriptionBufferSize:      options.subscriptionBufferSize,
+		subscriptionReplayBuffer:    options.subscriptionReplayBuffer,
+		subscriptionReplayRetention: ethSubscriptionReplayRetention,
+		ethSubscriptionPolicy:       options.ethSubscriptionPolicy,
+		clientVersion:               options.clientVersion,
+		ethBalanceHistoryMaxSamples: options.ethBalanceHistoryMaxSamples,
+		ethBlockRangeMaxSpan:        options.ethBlockRangeMaxSpan,
+		ethLogsPageSpan:             options.ethLogsPageSpan,
+		ethLogsMaxAddresses:         options.ethLogsMaxAddresses,
+		ethLogsMaxTopics:            options.ethLogsMaxTopics,
+		ethLogsMaxTopicEntries:      options.ethLogsMaxTopicEntries,
+		ethMaxLogsBlockRange:        options.ethMaxLogsBlockRange,
+		ethBatchMaxBlockParams:      options.ethBatchMaxBlockParams,
+		ethTxMaxSize:                options.ethTxMaxSize,
+		ethTxMaxGas:                 options.ethTxMaxGas,
+		replayMaxResultSize:         options.replayMaxResultSize,
+		maxResponseBytes:            options.maxResponseBytes,
+		traceReplayMaxResults:       options.traceReplayMaxResults,
+		traceBlockMaxMessages:       options.traceBlockMaxMessages,
+		actorStateMaxEntries:        options.actorStateMaxEntries,
+		connRateLimitRetryHint:      options.connRateLimitRetryHint,
+		ethFeeHistoryMaxBlockAge:    options.ethFeeHistoryMaxBlockAge,
+		ethStorageMaxBlockAge:       options.ethStorageMaxBlockAge,
+		chainEventsMax:              options.chainEventsMax,
+		chainEventsChunkSize:        options.chainEventsChunkSize,
+		ethRevertReasons:            options.ethRevertReasons,
+		ethCallMaxGas:               options.ethCallMaxGas,
+		ethCallNoPrecompiles:        options.ethCallNoPrecompiles,
+		ethReceiptGasPrice:          options.ethReceiptGasPrice,
+		ethExpiredFilterCleanup:     options.ethExpiredFilterCleanup,
+		decodeParamsFallback:        options.decodeParamsFallback,
+		batchMaxCost:                options.batchMaxCost,
+		batchMaxSize:                options.batchMaxSize,
+		mpoolPendingMaxMessages:     options.mpoolPendingMaxMessages,
+		mpoolPendingMaxAddresses:    options.mpoolPendingMaxAddresses,
+		serveStaleOnOutage:          options.serveStaleOnOutage,
+		maxStaleServeAge:            options.maxStaleServeAge,
+		ethSimulationTimeout:        options.ethSimulationTimeout,
+		defaultFinalizedReads:       options.defaultFinalizedReads,
+		actorEventMaxBackfill:       options.actorEventMaxBackfill,
+		started:                     time.Now(),
+		startupGracePeriod:          options.startupGracePeriod,
+		startupGraceError:           options.startupGraceError,
+		connections:                 newConnectionRegistry(),
+		chainNotify:                 newChainNotifyHub(v1, options.chainNotifyMaxSubscribers, options.chainNotifySubscriberBuffer),
+		options:                     *options,
+		settings:                    newSettings(options),
+	}
+	if options.dailyTokenQuota != nil && len(*options.dailyTokenQuota) > 0 {
+		gateway.dailyQuota = newDailyQuota(*options.dailyTokenQuota)
+	}
+	gateway.writeRateLimiter = rate.NewLimiter(writeReservedLimit(options.writeReservedRateLimit))
+	if options.perIPRateLimit > 0 {
+		gateway.ipRateLimiters = newIPRateLimiters(options.perIPRateLimit)
+	}
+	if options.ethMaxFiltersPerHost > 0 {
+		gateway.hostFilters = newHostFilterCounter(options.ethMaxFiltersPerHost)
+	}
+	if options.batchFanoutConcurrency > 0 {
+		gateway.batchFanout = semaphore.NewWeighted(int64(options.batchFanoutConcurrency))
+	}
+	if options.minerPowerConcurrency > 0 {
+		gateway.minerPowerFanout = semaphore.NewWeighted(int64(options.minerPowerConcurrency))
+	}
+	if options.traceConcurrencyLimit > 0 {
+		gateway.traceConcurrency = semaphore.NewWeighted(int64(options.traceConcurrencyLimit))
+	}
+	if options.logsConcurrencyLimit > 0 {
+		gateway.logsConcurrency = semaphore.NewWeighted(int64(options.logsConcurrencyLimit))
+	}
+	if options.msgSearchConcurrencyLimit > 0 {
+		gateway.msgSearchConcurrency = semaphore.NewWeighted(int64(options.msgSearchConcurrencyLimit))
+	}
+	if options.batchMaxInFlight > 0 {
+		gateway.batchCalls = semaphore.NewWeighted(int64(options.batchMaxInFlight))
+	}
+	var budget *cacheBudget
+	if options.cacheMemoryBudget > 0 {
+		budget = newCacheBudget(options.cacheMemoryBudget)
+	}
+	if options.minerInfoCacheSize > 0 {
+		gateway.minerInfoCache = newCache[minerInfoCacheKey, api.MinerInfo](minerInfoCacheName, options.minerInfoCacheSize, options.cacheMissJitter, budget)
+	}
+	if options.ethBlockCacheSize > 0 {
+		gateway.ethBlockCache = newCache[ethBlockCacheKey, ethtypes.EthBlock](ethBlockCacheName, options.ethBlockCacheSize, options.cacheMissJitter, budget)
+	}
+	if options.tipSetCacheSize > 0 {
+		gateway.tipSetCache = newCache[tipSetCacheKey, *types.TipSet](tipSetCacheName, options.tipSetCacheSize, options.cacheMissJitter, budget)
+	}
+	if options.actorCacheSize > 0 {
+		gateway.actorCache = newActorCache(options.actorCacheSize, options.actorCacheTTL, options.cacheMissJitter, budget)
+		gateway.actorCache.keepStale = options.serveStaleOnOutage
+	}
+	gateway.v1Proxy = &reverseProxyV1{
+		gateway:       gateway,
+		server:        v1,
+		subscriptions: options.v1SubHandler,
+	}
+	gateway.v2Proxy = &reverseProxyV2{
+		gateway:       gateway,
+		server:        v2,
+		subscriptions: options.v2SubHandler,
+	}
+	gateway.v1API, gateway.v2API = gateway.v1Proxy, gateway.v2Proxy
+	if options.deprecatedMethods != nil && len(*options.deprecatedMethods) > 0 {
+		gateway.deprecatedMethods = *options.deprecatedMethods
+		gateway.v1API = deprecatedV1(gateway.v1Proxy, gateway.deprecatedMethods)
+		gateway.v2API = deprecatedV2(gateway.v2Proxy, gateway.deprecatedMethods)
+	}
+	if options.requireExplicitTipSet {
+		gateway.v1API = explicitTipSetV1(gateway.v1API)
+	}
+	if options.ethCallAllowlist != nil && len(*options.ethCallAllowlist) > 0 {
+		gateway.ethCallAllowlist = make(map[ethtypes.EthAddress]struct{}, len(*options.ethCallAllowlist))
+		for _, addr := range *options.ethCallAllowlist {
+			gateway.ethCallAllowlist[addr] = struct{}{}
+		}
+	}
+	if options.blockedAddresses != nil && len(*options.blockedAddresses) > 0 {
+		gateway.blockedAddresses = newBlockedAddresses(*options.blockedAddresses)
+		gateway.v1API, gateway.v2API = blockAddressesV1(gateway.v1API, gateway.blockedAddresses), blockAddressesV2(gateway.v2API, gateway.blockedAddresses)
+	}
+	if options.ethTxRedactFields != nil && len(*options.ethTxRedactFields) > 0 {
+		r := newEthTxRedactor(*options.ethTxRedactFields)
+		gateway.v1API, gateway.v2API = ethTxFieldPolicyV1(gateway.v1API, r), ethTxFieldPolicyV2(gateway.v2API, r)
+	}
+	if (options.disabledMethods != nil && len(*options.disabledMethods) > 0) || options.allowedMethods != nil {
+		var disabled []string
+		if options.disabledMethods != nil {
+			disabled = *options.disabledMethods
+		}
+		var err error
+		gateway.disabledMethods, err = resolveDisabledMethods(disabled, options.allowedMethods)
+		gateway.err = errors.Join(gateway.err, err)
+		gateway.v1API, gateway.v2API = disabledV1(gateway.v1API, gateway.disabledMethods), disabledV2(gateway.v2API, gateway.disabledMethods)
+	}
+	if options.methodRateLimits != nil && len(*options.methodRateLimits) > 0 {
+		gateway.methodRateLimiters = newMethodRateLimiters(*options.methodRateLimits)
+		gateway.v1API = methodRateLimitsV1(gateway.v1API, gateway.methodRateLimiters)
+		gateway.v2API = methodRateLimitsV2(gateway.v2API, gateway.methodRateLimiters)
+	}
+	if options.methodLookbacks != nil && len(*options.methodLookbacks) > 0 {
+		gateway.methodLookbacks = newMethodLookbacks(*options.methodLookbacks)
+		gateway.v1API = methodLookbacksV1(gateway.v1API, gateway.methodLookbacks)
+		gateway.v2API = methodLookbacksV2(gateway.v2API, gateway.methodLookbacks)
+	}
+	if options.archiveMethods != nil && len(*options.archiveMethods) > 0 && options.archiveLookback > 0 {
+		gateway.archiveLookbacks = newArchiveLookbacks(*options.archiveMethods, options.archiveLookback)
+		gateway.v1API = methodLookbacksV1(gateway.v1API, gateway.archiveLookbacks)
+		gateway.v2API = methodLookbacksV2(gateway.v2API, gateway.archiveLookbacks)
+	}
+	if gateway.batchCalls != nil {
+		gateway.v1API, gateway.v2API = limitBatchCallsV1(gateway, gateway.v1API), limitBatchCallsV2(gateway, gateway.v2API)
+	}
+	gateway.v1API, gateway.v2API = maintenanceV1(gateway, gateway.v1API), maintenanceV2(gateway, gateway.v2API)
+	gateway.v1API, gateway.v2API = closedV1(gateway, gateway.v1API), closedV2(gateway, gateway.v2API)
+	gateway.v1API, gateway.v2API = recordOutcomesV1(gateway.v1API), recordOutcomesV2(gateway.v2API)
+
+	var ctx context.Context
+	ctx, gateway.cancel = context.WithCancel(context.Background())
+	if options.headAgeSampleInterval > 0 {
+		gateway.goBackground(func() { sampleHeadAge(ctx, v1, options.headAgeSampleInterval) })
+	}
+	if gateway.ipRateLimiters != nil {
+		gateway.goBackground(func() { gateway.ipRateLimiters.cleanup(ctx, connectionLimiterCleanupInterval) })
+	}
+	if gateway.actorCache != nil {
+		gateway.goBackground(func() { gateway.actorCache.watchHeads(ctx, v1) })
+	}
+	if gateway.blockedAddresses != nil {
+		gateway.goBackground(func() { gateway.blockedAddresses.run(ctx, v1, blockedAddressResolveInterval) })
+	}
+	if breakers != nil {
+		breakers.run(ctx, gateway, probeTarget)
+	}
+	if gateway.err != nil {
+		log.Errorw("invalid gateway options", "error", gateway.err)
+	}
+	return gateway
+}
+
+// Err returns the error for options NewNode couldn't apply, such as a method that's both allowed
+// and disabled, or nil. Handler fails with the same error.
+func (gw *Node) Err() error {
+	if gw.err != nil {
+		return xerrors.Errorf("invalid gateway options: %w", gw.err)
+	}
+	return nil
+}
+
+// Reconfigure applies opts to the running gateway node without interrupting in-flight requests or
+// open connections. Only the rate limit, the reserved write rate limit and the rate limit timeout,
+// the lookback limits, the EthCall maximum block age and the maximum number of filters per
+// connection can be changed this way; if opts would change any other option an error is returned
+// and nothing is changed. Existing filters and subscriptions are kept even where they exceed a
+// lowered limit; the new limit applies to those created afterwards.
+func (gw *Node) Reconfigure(opts ...Option) error {
+	gw.lk.Lock()
+	defer gw.lk.Unlock()
+
+	updated := gw.options
+	for _, opt := range opts {
+		opt(&updated)
+	}
+
+	unchanged := updated
+	unchanged.maxLookbackDuration = gw.options.maxLookbackDuration
+	unchanged.maxMessageLookbackEpochs = gw.options.maxMessageLookbackEpochs
+	unchanged.maxReplacedLookbackEpochs = gw.options.maxReplacedLookbackEpochs
+	unchanged.messageLookbackEpochs = gw.options.messageLookbackEpochs
+	unchanged.rateLimit = gw.options.rateLimit
+	unchanged.writeReservedRateLimit = gw.options.writeReservedRateLimit
+	unchanged.rateLimitTimeout = gw.options.rateLimitTimeout
+	unchanged.ethMaxFiltersPerConn = gw.options.ethMaxFiltersPerConn
+	unchanged.ethCallMaxBlockAge = gw.options.ethCallMaxBlockAge
+	if unchanged != gw.options {
+		return xerrors.New("only rate limits, lookback limits and the maximum number of filters per connection can be reconfigured without restarting the gateway")
+	}
+
+	gw.options = updated
+	gw.settings = newSettings(&updated)
+	if gw.slowStart != nil {
+		gw.slowStart.setLimit(rateLimit(updated.rateLimit))
+	} else {
+		gw.rateLimiter.SetLimit(rateLimit(updated.rateLimit))
+	}
+	// a reservation made at runtime starts out empty and fills up at its rate
+	limit, burst := writeReservedLimit(updated.writeReservedRateLimit)
+	gw.writeRateLimiter.SetBurst(burst)
+	gw.writeRateLimiter.SetLimit(limit)
+	return nil
+}
+
+// currentSettings returns the settings currently in effect.
+func (gw *Node) currentSettings() settings {
+	gw.lk.RLock()
+	defer gw.lk.RUnlock()
+	return gw.settings
+}
+
+// acquireTraceSlot waits for one of the limited number of trace requests that may be in flight to
+// become available, returning a function to release it once the request is complete.
+func (gw *Node) acquireTraceSlot(ctx context.Context) (func(), error) {
+	return gw.acquireSlot(ctx, gw.traceConcurrency, "trace")
+}
+
+// acquireLogsSlot is like acquireTraceSlot, but for the limited number of log queries.
+func (gw *Node) acquireLogsSlot(ctx context.Context) (func(), error) {
+	return gw.acquireSlot(ctx, gw.logsConcurrency, "log")
+}
+
+// acquireMessageSearchSlot is like acquireTraceSlot, but for the limited number of message searches.
+func (gw *Node) acquireMessageSearchSlot(ctx context.Context) (func(), error) {
+	return gw.acquireSlot(ctx, gw.msgSearchConcurrency, "message search")
+}
+
+func (gw *Node) acquireSlot(ctx context.Context, sem *semaphore.Weighted, kind string) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, gw.currentSettings().rateLimitTimeout)
+	defer cancel()
+
+	if err := sem.Acquire(ctx2, 1); err != nil {
+		stats.Record(ctx, metrics.RateLimitCount.M(1))
+		return nil, rateLimitRejection{fmt.Errorf("server busy, too many concurrent %s requests. %w", kind, err)}
+	}
+	return func() { sem.Release(1) }, nil
+}
+
+// simulate calls fn, which executes a simulation such as EthCall on the target, with a context
+// bounded by the simulation timeout if one is configured.
+func simulate[T any](ctx context.Context, gw *Node, fn func(ctx context.Context) (T, error)) (T, error) {
+	if gw.ethSimulationTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, gw.ethSimulationTimeout)
+	defer cancel()
+
+	res, err := fn(sctx)
+	if err != nil && errors.Is(sctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return res, xerrors.Errorf("%w after %s", ErrSimulationTimedOut, gw.ethSimulationTimeout)
+	}
+	return res, err
+}
+
+// messageLookbackLimit clamps the lookback limit of a message search to the maximum message
+// lookback, and, for searches that allow for replaced messages, the maximum replaced message
+// lookback.
+func (gw *Node) messageLookbackLimit(limit abi.ChainEpoch, allowReplaced bool) abi.ChainEpoch {
+	s := gw.currentSettings()
+	maxLookback := s.maxMessageLookbackEpochs
+	if allowReplaced && s.maxReplacedLookbackEpochs > 0 &&
+		(maxLookback == api.LookbackNoLimit || s.maxReplacedLookbackEpochs < maxLookback) {
+		maxLookback = s.maxReplacedLookbackEpochs
+	}
+	if limit == api.LookbackNoLimit {
+		limit = maxLookback
+	}
+	if maxLookback != api.LookbackNoLimit && limit > maxLookback {
+		limit = maxLookback
+	}
+	return limit
+}
+
+// rateLimit converts a number of requests per second into a rate.Limit, where 0 means no limit.
+func rateLimit(requestsPerSecond int) rate.Limit {
+	if requestsPerSecond > 0 {
+		return rate.Every(time.Second / time.Duration(requestsPerSecond))
+	}
+	return rate.Inf
+}
+
+// writeReservedLimit returns the limit and burst of the reserved write pool for writesPerSecond. A
+// pool with nothing reserved has no burst, so it never allows a write and writes fall through to the
+// global rate limit.
+func writeReservedLimit(writesPerSecond int) (rate.Limit, int) {
+	if writesPerSecond > 0 {
+		return rateLimit(writesPerSecond), MaxRateLimitTokens
+	}
+	return 0, 0
+}
+
+func (gw *Node) V1ReverseProxy() api.Gateway { return gw.v1API }
+
+func (gw *Node) V2ReverseProxy() v2api.Gateway { return gw.v2API }
+
+func (gw *Node) checkTipSetKey(ctx context.Context, tsk types.TipSetKey) error {
+	if tsk.IsEmpty() {
+		return nil
+	}
+
+	ts, err := gw.v1Proxy.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return err
+	}
+
+	return gw.checkTipSet(ctx, ts)
+}
+
+func (gw *Node) checkTipSet(ctx context.Context, ts *types.TipSet) error {
+	at := time.Unix(int64(ts.Blocks()[0].Timestamp), 0)
+	if err := gw.checkTimestamp(ctx, at); err != nil {
+		return fmt.Errorf("bad tipset: %w", err)
+	}
+	return nil
+}
+
+func (gw *Node) checkKeyedTipSetHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) error {
+	var ts *types.TipSet
+	if tsk.IsEmpty() {
+		head, err := gw.v1Proxy.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		ts = head
+	} else {
+		gts, err := gw.v1Proxy.ChainGetTipSet(ctx, tsk)
+		if err != nil {
+			return err
+		}
+		ts = gts
+	}
+
+	// Check if the tipset key refers to gw tipset that's too far in the past
+	if err := gw.checkTipSet(ctx, ts); err != nil {
+		return err
+	}
+
+	// Check if the height is too far in the past
+	if err := gw.checkTipSetHeight(ctx, ts, h); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (gw *Node) checkTipSetHeight(ctx context.Context, ts *types.TipSet, h abi.ChainEpoch) error {
+	if h > ts.Height() {
+		return fmt.Errorf("tipset height in future")
+	}
+	tsBlock := ts.Blocks()[0]
+	heightDelta := time.Duration(uint64(tsBlock.Height-h)*buildconstants.BlockDelaySecs) * time.Second
+	timeAtHeight := time.Unix(int64(tsBlock.Timestamp), 0).Add(-heightDelta)
+
+	if err := gw.checkTimestamp(ctx, timeAtHeight); err != nil {
+		return fmt.Errorf("bad tipset height: %w", err)
+	}
+	return nil
+}
+
+func (gw *Node) checkEthBlockAge(head *types.TipSet, h abi.ChainEpoch, maxAge abi.ChainEpoch) error {
+	if maxAge > 0 && head.Height()-h > maxAge {
+		return lookbackRejection{fmt.Errorf("bad block param: blocks more than %d epochs behind the head are disallowed for this method", maxAge)}
+	}
+	return nil
+}
+
+// ethStorageBlockAgeLimit returns the maximum block age for EthGetStorageAt, which is subject to the
+// tighter of the EthCall and storage maximums.
+func (gw *Node) ethStorageBlockAgeLimit() abi.ChainEpoch {
+	maxAge := gw.currentSettings().ethCallMaxBlockAge
+	if gw.ethStorageMaxBlockAge > 0 && (maxAge == 0 || gw.ethStorageMaxBlockAge < maxAge) {
+		maxAge = gw.ethStorageMaxBlockAge
+	}
+	return maxAge
+}
+
+// checkEthFeeHistoryBlockAge checks the newest block param of an EthFeeHistory request against the
+// maximum age configured with WithEthFeeHistoryMaxBlockAge.
+func (gw *Node) checkEthFeeHistoryBlockAge(head *types.TipSet, blkParam string) error {
+	maxAge := gw.ethFeeHistoryMaxBlockAge
+	if maxAge <= 0 {
+		return nil
+	}
+
+	h := head.Height()
+	switch blkParam {
+	case "pending", "latest":
+	case "safe":
+		h -= ethtypes.SafeEpochDelay
+	case "finalized":
+		h -= policy.ChainFinality
+	default:
+		var num ethtypes.EthUint64
+		if err := num.UnmarshalJSON([]byte(`"` + blkParam + `"`)); err != nil {
+			return fmt.Errorf("cannot parse block number: %v", err)
+		}
+		h = abi.ChainEpoch(num)
+	}
+	if head.Height()-h > maxAge {
+		return lookbackRejection{fmt.Errorf("bad newest block: blocks more than %d epochs behind the head are disallowed for this method", maxAge)}
+	}
+	return nil
+}
+
+// checkActorEventBackfill checks that an actor event subscription filter doesn't request historical
+// events from further back than the gateway allows. A filter for a specific tipset is subject to the
+// general lookback limits too, as a filter with a FromHeight already is.
+func (gw *Node) checkActorEventBackfill(ctx context.Context, filter *types.ActorEventFilter) error {
+	if filter == nil {
+		return nil
+	}
+
+	var from abi.ChainEpoch
+	switch {
+	case filter.FromHeight != nil:
+		from = *filter.FromHeight
+	case filter.TipSetKey != nil && !filter.TipSetKey.IsEmpty():
+		ts, err := gw.v1Proxy.ChainGetTipSet(ctx, *filter.TipSetKey)
+		if err != nil {
+			return err
+		}
+		if err := gw.checkTipSet(ctx, ts); err != nil {
+			return err
+		}
+		from = ts.Height()
+	default:
+		return nil // live events only
+	}
+
+	if gw.actorEventMaxBackfill <= 0 {
+		return nil
+	}
+	head, err := gw.v1Proxy.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+	if backfill := head.Height() - from; backfill > gw.actorEventMaxBackfill {
+		return xerrors.Errorf("%w: requested %d epochs, at most %d are allowed", ErrActorEventBackfillTooLong, backfill, gw.actorEventMaxBackfill)
+	}
+	return nil
+}
+
+// checkReplayResultSize enforces the maximum serialized size of a StateReplay result.
+func (gw *Node) checkReplayResultSize(res *api.InvocResult) error {
+	if gw.replayMaxResultSize <= 0 {
+		return nil
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return xerrors.Errorf("failed to serialize replay result: %w", err)
+	}
+	if len(b) > gw.replayMaxResultSize {
+		return xerrors.Errorf("%w: %d bytes, the maximum is %d", ErrReplayResultTooLarge, len(b), gw.replayMaxResultSize)
+	}
+	return nil
+}
+
+// checkResponseSize enforces the maximum JSON encoded size of an object returned to the client.
+func (gw *Node) checkResponseSize(res interface{}) error {
+	if gw.maxResponseBytes <= 0 {
+		return nil
+	}
+	var size int
+	if b, ok := res.([]byte); ok {
+		size = base64.StdEncoding.EncodedLen(len(b)) + 2 // encoded as a quoted base64 string
+	} else {
+		b, err := json.Marshal(res)
+		if err != nil {
+			return xerrors.Errorf("failed to serialize response: %w", err)
+		}
+		size = len(b)
+	}
+	if size > gw.maxResponseBytes {
+		return xerrors.Errorf("%w: %d bytes, the maximum is %d", ErrResponseTooLarge, size, gw.maxResponseBytes)
+	}
+	return nil
+}
+
+// checkEthCallAddress enforces the allowlist of contract addresses that EthCall and EthEstimateGas
+// may be called against.
+func (gw *Node) checkEthCallAddress(tx ethtypes.EthCall) error {
+	if gw.ethCallAllowlist == nil {
+		return nil
+	}
+	if tx.To == nil {
+		return xerrors.Errorf("%w: contract creation", ErrEthCallAddressNotAllowed)
+	}
+	if _, ok := gw.ethCallAllowlist[*tx.To]; !ok {
+		return xerrors.Errorf("%w: %s", ErrEthCallAddressNotAllowed, tx.To)
+	}
+	return nil
+}
+
+// expensivePrecompiles are the precompiles rejected by guardEthCall, see
+// WithEthCallRejectExpensivePrecompiles.
+var expensivePrecompiles = map[ethtypes.EthAddress]string{
+	{19: 0x05}: "modexp",
+	{19: 0x08}: "ecPairing",
+	{19: 0x09}: "blake2f",
+}
+
+// guardEthCall enforces the maximum gas limit of an EthCall or EthEstimateGas request, giving calls
+// without a gas limit the maximum, and rejects direct calls to expensive precompiles if configured
+// to. It returns true if the call's gas limit was set.
+func (gw *Node) guardEthCall(tx *ethtypes.EthCall) (bool, error) {
+	if gw.ethCallNoPrecompiles && tx.To != nil {
+		if name, ok := expensivePrecompiles[*tx.To]; ok {
+			return false, xerrors.Errorf("%w: %s", ErrEthCallPrecompileNotAllowed, name)
+		}
+	}
+	if gw.ethCallMaxGas == 0 {
+		return false, nil
+	}
+	if tx.Gas == 0 {
+		tx.Gas = ethtypes.EthUint64(gw.ethCallMaxGas)
+		return true, nil
+	}
+	if uint64(tx.Gas) > gw.ethCallMaxGas {
+		return false, xerrors.Errorf("%w: gas limit %d, the maximum is %d", ErrEthCallGasTooHigh, tx.Gas, gw.ethCallMaxGas)
+	}
+	return false, nil
+}
+
+// checkTraceReplayResults enforces the maximum number of transaction replays returned by
+// EthTraceReplayBlockTransactions.
+func (gw *Node) checkTraceReplayResults(res []*ethtypes.EthTraceReplayBlockTransaction) error {
+	if gw.traceReplayMaxResults <= 0 || len(res) <= gw.traceReplayMaxResults {
+		return nil
+	}
+	return xerrors.Errorf("%w: %d transactions, the maximum is %d", ErrTooManyTraceResults, len(res), gw.traceReplayMaxResults)
+}
+
+// checkTraceBlockMessages enforces the maximum number of messages EthTraceBlock and
+// EthTraceReplayBlockTransactions may replay.
+func (gw *Node) checkTraceBlockMessages(n int) error {
+	if gw.traceBlockMaxMessages <= 0 || n <= gw.traceBlockMaxMessages {
+		return nil
+	}
+	return xerrors.Errorf("%w: %d messages, the maximum is %d", ErrTooManyTraceMessages, n, gw.traceBlockMaxMessages)
+}
+
+// checkActorStateEntries enforces the maximum number of entries in the decoded state returned by
+// StateReadState.
+func (gw *Node) checkActorStateEntries(state *api.ActorState) error {
+	if gw.actorStateMaxEntries <= 0 || state == nil {
+		return nil
+	}
+	if exceedsEntries(state.State, gw.actorStateMaxEntries) {
+		return xerrors.Errorf("%w: more than the maximum of %d entries, query the specific state needed instead", ErrActorStateTooComplex, gw.actorStateMaxEntries)
+	}
+	return nil
+}
+
+// checkEthFilter enforces the maximum number of addresses, topic positions and topic entries in an
+// Eth log filter.
+func (gw *Node) checkEthFilter(filter *ethtypes.EthFilterSpec) error {
+	if filter == nil {
+		return nil
+	}
+	if gw.ethLogsMaxAddresses > 0 && len(filter.Address) > gw.ethLogsMaxAddresses {
+		return xerrors.Errorf("%w: %d addresses, the maximum is %d", ErrTooManyLogAddresses, len(filter.Address), gw.ethLogsMaxAddresses)
+	}
+	if gw.ethLogsMaxTopics > 0 && len(filter.Topics) > gw.ethLogsMaxTopics {
+		return xerrors.Errorf("%w: %d topic positions, the maximum is %d", ErrTooManyLogTopics, len(filter.Topics), gw.ethLogsMaxTopics)
+	}
+	if gw.ethLogsMaxTopicEntries > 0 {
+		var entries int
+		for _, position := range filter.Topics {
+			entries += max(len(position), 1) // a null position is a wildcard
+		}
+		if entries > gw.ethLogsMaxTopicEntries {
+			return xerrors.Errorf("%w: %d topic entries, the maximum is %d", ErrTooManyLogTopicEntries, entries, gw.ethLogsMaxTopicEntries)
+		}
+	}
+	return nil
+}
+
+// checkEthLogsBlockRange enforces the maximum block range of an EthGetLogs filter, resolving its
+// fromBlock and toBlock against head. Block params that can't be parsed, and ranges that end before
+// they start, are left for the target to reject.
+func (gw *Node) checkEthLogsBlockRange(filter *ethtypes.EthFilterSpec, head *types.TipSet) error {
+	if gw.ethMaxLogsBlockRange <= 0 || filter == nil || filter.BlockHash != nil {
+		return nil
+	}
+	from, ok := resolveLogsBlockParam(filter.FromBlock, head)
+	if !ok {
+		return nil
+	}
+	to, ok := resolveLogsBlockParam(filter.ToBlock, head)
+	if !ok || to < from {
+		return nil
+	}
+	if span := to - from + 1; span > abi.ChainEpoch(gw.ethMaxLogsBlockRange) {
+		return xerrors.Errorf("%w: %d epochs, the maximum is %d", ErrLogsBlockRangeTooLarge, span, gw.ethMaxLogsBlockRange)
+	}
+	return nil
+}
+
+// resolveLogsBlockParam returns the height that the fromBlock or toBlock of a log filter refers to,
+// given the current head.
+func resolveLogsBlockParam(blkParam *string, head *types.TipSet) (abi.ChainEpoch, bool) {
+	if blkParam == nil {
+		return head.Height(), true
+	}
+	var height abi.ChainEpoch
+	switch *blkParam {
+	case "latest", "pending":
+		height = head.Height()
+	case "safe":
+		height = head.Height() - ethtypes.SafeEpochDelay
+	case "finalized":
+		height = head.Height() - policy.ChainFinality
+	case "earliest":
+		height = 0
+	default:
+		var num ethtypes.EthUint64
+		if err := num.UnmarshalJSON([]byte(`"` + *blkParam + `"`)); err != nil {
+			return 0, false
+		}
+		return abi.ChainEpoch(num), true
+	}
+	if height < 0 {
+		height = 0
+	}
+	return height, true
+}
+
+// checkEthRawTx enforces the maximum size and gas limit of a raw Eth transaction. The transaction
+// is only decoded when there's a gas limit to check.
+func (gw *Node) checkEthRawTx(rawTx ethtypes.EthBytes) error {
+	if gw.ethTxMaxSize > 0 && len(rawTx) > gw.ethTxMaxSize {
+		return xerrors.Errorf("%w: %d bytes, the maximum is %d", ErrEthTxTooLarge, len(rawTx), gw.ethTxMaxSize)
+	}
+	if gw.ethTxMaxGas == 0 {
+		return nil
+	}
+
+	tx, err := ethtypes.ParseEthTransaction(rawTx)
+	if err != nil {
+		return xerrors.Errorf("failed to parse transaction: %w", err)
+	}
+	msg, err := tx.ToUnsignedFilecoinMessage(address.Undef)
+	if err != nil {
+		return xerrors.Errorf("failed to convert transaction: %w", err)
+	}
+	if msg.GasLimit < 0 || uint64(msg.GasLimit) > gw.ethTxMaxGas {
+		return xerrors.Errorf("%w: gas limit %d, the maximum is %d", ErrEthTxGasTooHigh, msg.GasLimit, gw.ethTxMaxGas)
+	}
+	return nil
+}
+
+func (gw *Node) checkTimestamp(ctx context.Context, at time.Time) error {
+	maxLookback, errLookback := gw.maxLookback(ctx)
+	if time.Since(at) > maxLookback {
+		if remaining := gw.startupGracePeriod - time.Since(gw.started); remaining > 0 {
+			if gw.startupGraceError {
+				return xerrors.Errorf("%w, retry in %s", ErrStartingUp, remaining.Round(time.Second))
+			}
+			return nil
+		}
+		return errLookback
+	}
+	return nil
+}
+
+func (gw *Node) limit(ctx context.Context, tokens int) error {
+	return gw.limitWith(ctx, tokens, nil)
+}
+
+// limitWrite is like limit, but for methods that submit messages. Writes are first served from the
+// reserved write pool, if there is one, so that they aren't held up by reads saturating the
+// gateway, and only wait on the shared rate limit once the pool is exhausted.
+func (gw *Node) limitWrite(ctx context.Context, tokens int) error {
+	return gw.limitWith(ctx, tokens, gw.writeRateLimiter)
+}
+
+// limitWith waits for the rate limits in effect for the call made with ctx for at most the rate
+// limit timeout, or until the client's own deadline if that's sooner, so that a client that wants
+// to fail fast isn't held up. A call that runs out of time waiting for the per IP rate limit is
+// rejected with an "IP limited" error. A call whose client has already given up is rejected without
+// taking any tokens.
+func (gw *Node) limitWith(ctx context.Context, tokens int, reserved *rate.Limiter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(gw.currentSettings().rateLimitTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	ctx2, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	giveBack := func() {}
+	if gw.dailyQuota != nil {
+		var err error
+		if giveBack, err = gw.dailyQuota.take(contextIdentity(ctx), tokens); err != nil {
+			return err
+		}
+	}
+
+	ft := connectionTracker(ctx)
+	if perConnLimiter, ok := getPerConnectionAPIRateLimiter(ctx); ok {
+		var err error
+		if gw.connRateLimitRetryHint {
+			err = waitWithRetryHint(ctx2, perConnLimiter, tokens)
+		} else if err = perConnLimiter.WaitN(ctx2, tokens); err != nil {
+			err = rateLimitRejection{fmt.Errorf("connection limited. %w", err)}
+		}
+		if err != nil {
+			giveBack()
+			if ft != nil {
+				ft.stats.throttled.Add(1)
+			}
+			return err
+		}
+	}
+
+	if id := contextIdentity(ctx); gw.ipRateLimiters != nil && id != "" {
+		if err := gw.ipRateLimiters.limiter(id).WaitN(ctx2, tokens); err != nil {
+			giveBack()
+			if ft != nil {
+				ft.stats.throttled.Add(1)
+			}
+			return rateLimitRejection{fmt.Errorf("IP limited. %w", err)}
+		}
+	}
+
+	if method, limiter, ok := gw.methodRateLimiter(ctx); ok {
+		if err := limiter.WaitN(ctx2, tokens); err != nil {
+			giveBack()
+			if ft != nil {
+				ft.stats.throttled.Add(1)
+			}
+			stats.Record(ctx, metrics.RateLimitCount.M(1))
+			return rateLimitRejection{fmt.Errorf("server busy, too many %s requests. %w", method, err)}
+		}
+	}
+
+	if isBatchCall(ctx) {
+		// the batch request the call is part of was charged against the global rate limit as a whole
+		// by limitBatch, but the call's tokens still count towards its connection's stats
+		if ft != nil {
+			ft.stats.tokens.Add(uint64(tokens))
+		}
+		return nil
+	}
+
+	if reserved != nil && reserved.AllowN(time.Now(), tokens) {
+		if ft != nil {
+			ft.stats.tokens.Add(uint64(tokens))
+		}
+		return nil
+	}
+
+	var err error
+	if gw.queueThreshold > 0 {
+		err = gw.waitQueued(ctx2, tokens)
+	} else {
+		err = gw.rateLimiter.WaitN(ctx2, tokens)
+	}
+	if err != nil {
+		giveBack()
+		if ft != nil {
+			ft.stats.throttled.Add(1)
+		}
+		stats.Record(ctx, metrics.RateLimitCount.M(1))
+		return rateLimitRejection{fmt.Errorf("server busy. %w", err)}
+	}
+	if ft != nil {
+		ft.stats.tokens.Add(uint64(tokens))
+	}
+	return nil
+}
+
+// rateLimitStatus returns the rate limits in effect for the call made with ctx, and the tokens left
+// under each of them. A client without a per IP rate limiter yet hasn't made a call recently, so
+// has all of its burst available.
+func (gw *Node) rateLimitStatus(ctx context.Context) api.GatewayRateLimitStatus {
+	status := api.GatewayRateLimitStatus{
+		TokensPerSecond: tokensPerSecond(gw.rateLimiter.Limit()),
+		TokensAvailable: gw.rateLimiter.Tokens(),
+		Burst:           gw.rateLimiter.Burst(),
+	}
+	if perConnLimiter, ok := getPerConnectionAPIRateLimiter(ctx); ok {
+		status.PerConnectionTokensPerSecond = tokensPerSecond(perConnLimiter.Limit())
+		status.ConnectionTokensAvailable = perConnLimiter.Tokens()
+	}
+	if gw.ipRateLimiters != nil {
+		status.PerIPTokensPerSecond = tokensPerSecond(gw.ipRateLimiters.limit)
+		status.IPTokensAvailable = MaxRateLimitTokens
+		if limiter, ok := gw.ipRateLimiters.peek(contextIdentity(ctx)); ok {
+			status.IPTokensAvailable = limiter.Tokens()
+		}
+	}
+	return status
+}
+
+// tokensPerSecond converts a rate.Limit into tokens per second, where 0 means no limit, the
+// inverse of rateLimit.
+func tokensPerSecond(limit rate.Limit) float64 {
+	if limit == rate.Inf {
+		return 0
+	}
+	return float64(limit)
+}
+
+// waitWithRetryHint is like WaitN on the per-connection limiter, except that a request that can't
+// be allowed before the deadline of ctx is rejected with an api.ErrConnectionRateLimited carrying
+// the wait needed, as given by the limiter's reservation for the tokens.
+func waitWithRetryHint(ctx context.Context, limiter *rate.Limiter, tokens int) error {
+	now := time.Now()
+	r := limiter.ReserveN(now, tokens)
+	if !r.OK() {
+		return rateLimitRejection{fmt.Errorf("connection limited. rate: Wait(n=%d) exceeds limiter's burst %d", tokens, limiter.Burst())}
+	}
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		// give the tokens back so that a retry after the wait is allowed
+		r.CancelAt(now)
+		return &api.ErrConnectionRateLimited{RetryAfter: delay}
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return rateLimitRejection{fmt.Errorf("connection limited. %w", ctx.Err())}
 	}
-	return nil
 }
@@ -1,21 +1,51 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"go.opencensus.io/stats/view"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
+	amt4 "github.com/filecoin-project/go-amt-ipld/v4"
+	"github.com/filecoin-project/go-f3/certs"
+	"github.com/filecoin-project/go-f3/gpbft"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 
 	"github.com/filecoin-project/lotus/api"
 	v1mocks "github.com/filecoin-project/lotus/api/mocks"
 	"github.com/filecoin-project/lotus/api/v2api/v2mocks"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
 	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/metrics"
 )
 
 func TestGatewayAPIChainGetTipSetByHeight(t *testing.T) {
@@ -202,3 +232,4084 @@ func TestGatewayLimitTokensRate(t *testing.T) {
 	require.NoError(t, a.limit(ctx, tokens))
 	require.ErrorContains(t, a.limit(ctx, tokens), "server busy", "API calls should be hard rate limited when they hit limits")
 }
+
+func TestGatewayLimitClientDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// the next call has to wait about a second for tokens, well within the default timeout
+	a := NewNode(mockV1, mockV2, WithRateLimit(1))
+	require.Equal(t, DefaultRateLimitTimeout, a.currentSettings().rateLimitTimeout)
+	require.NoError(t, a.limit(context.Background(), MaxRateLimitTokens))
+
+	// but a client with a shorter deadline gives up by its deadline instead
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.ErrorContains(t, a.limit(ctx, MaxRateLimitTokens), "server busy")
+	require.Less(t, time.Since(start), time.Second)
+
+	// a client that has already given up is turned away without taking any tokens
+	a = NewNode(mockV1, mockV2, WithRateLimit(1))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, a.limit(ctx, MaxRateLimitTokens), context.Canceled)
+	require.NoError(t, a.limit(context.Background(), MaxRateLimitTokens))
+}
+
+func TestGatewayWriteReservedRateLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	msg := &types.SignedMessage{Message: types.Message{To: address.TestAddress, From: address.TestAddress}}
+	mockV1.EXPECT().MpoolPushUntrusted(gomock.Any(), msg).Return(msg.Cid(), nil)
+
+	rateLimitTimeout := 10 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(rateLimitTimeout), WithWriteReservedRateLimit(1))
+
+	// saturate the global rate limit with reads
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	require.ErrorContains(t, a.limit(ctx, MaxRateLimitTokens), "server busy")
+
+	// a write is still served promptly, from the reserved pool
+	start := time.Now()
+	c, err := a.v1Proxy.MpoolPush(ctx, msg)
+	require.NoError(t, err)
+	require.Equal(t, msg.Cid(), c)
+	require.Less(t, time.Since(start), rateLimitTimeout)
+
+	// reads don't get to use the reserved pool
+	require.ErrorContains(t, a.limit(ctx, MaxRateLimitTokens), "server busy")
+
+	// without a reservation, writes are starved by the reads like anything else
+	a = NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(rateLimitTimeout))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	_, err = a.v1Proxy.MpoolPush(ctx, msg)
+	require.ErrorContains(t, err, "server busy")
+
+	// once the reserved pool is exhausted, writes fall back to the global rate limit
+	a = NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(rateLimitTimeout), WithWriteReservedRateLimit(1))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	require.NoError(t, a.limitWrite(ctx, MaxRateLimitTokens))
+	require.ErrorContains(t, a.limitWrite(ctx, MaxRateLimitTokens), "server busy")
+}
+
+func TestGatewayPerIPRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tokens := MaxRateLimitTokens
+	a := NewNode(mockV1, mockV2, WithPerIPRateLimit(1), WithRateLimitTimeout(10*time.Millisecond))
+	defer func() { _ = a.Shutdown(context.Background()) }()
+
+	client := context.WithValue(context.Background(), identityKey, Identity("192.0.2.1"))
+	other := context.WithValue(context.Background(), identityKey, Identity("192.0.2.2"))
+
+	require.NoError(t, a.limit(client, tokens), "burst should be available")
+	err := a.limit(client, tokens)
+	require.ErrorContains(t, err, "IP limited")
+	require.NotContains(t, err.Error(), "server busy")
+
+	// other clients have budgets of their own, and calls not made over HTTP aren't limited per IP
+	require.NoError(t, a.limit(other, tokens))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, a.limit(context.Background(), tokens))
+	}
+
+	// limiters that haven't been used for a while are dropped
+	a.ipRateLimiters.expire(time.Now().Add(-time.Minute))
+	require.Len(t, a.ipRateLimiters.limiters, 2)
+	a.ipRateLimiters.expire(time.Now().Add(time.Minute))
+	require.Empty(t, a.ipRateLimiters.limiters)
+	require.NoError(t, a.limit(client, tokens), "a new limiter should start with a full burst")
+}
+
+func TestGatewayRateLimitStatus(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// without any rate limits
+	a := NewNode(mockV1, mockV2)
+	defer func() { _ = a.Shutdown(ctx) }()
+	status, err := a.v1Proxy.RateLimitStatus(ctx)
+	require.NoError(t, err)
+	require.Equal(t, api.GatewayRateLimitStatus{TokensAvailable: MaxRateLimitTokens, Burst: MaxRateLimitTokens}, status)
+
+	b := NewNode(mockV1, mockV2, WithRateLimit(1), WithPerIPRateLimit(2), WithRateLimitTimeout(10*time.Millisecond))
+	defer func() { _ = b.Shutdown(ctx) }()
+	client := context.WithValue(ctx, identityKey, Identity("192.0.2.1"))
+	client = setPerConnectionAPIRateLimiter(client, rate.NewLimiter(rate.Limit(3), MaxRateLimitTokens))
+
+	status, err = b.v1Proxy.RateLimitStatus(client)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, status.TokensPerSecond)
+	require.InDelta(t, MaxRateLimitTokens, status.TokensAvailable, 0.5)
+	require.Equal(t, 3.0, status.PerConnectionTokensPerSecond)
+	require.InDelta(t, MaxRateLimitTokens, status.ConnectionTokensAvailable, 0.5)
+	require.Equal(t, 2.0, status.PerIPTokensPerSecond)
+	require.Equal(t, float64(MaxRateLimitTokens), status.IPTokensAvailable, "a client without calls yet has its full burst")
+
+	// tokens spent are reflected, and the status itself costs none
+	require.NoError(t, b.limit(client, 2))
+	for i := 0; i < 2; i++ {
+		status, err = b.v1Proxy.RateLimitStatus(client)
+		require.NoError(t, err)
+		require.InDelta(t, MaxRateLimitTokens-2, status.TokensAvailable, 0.5)
+		require.InDelta(t, MaxRateLimitTokens-2, status.ConnectionTokensAvailable, 0.5)
+		require.InDelta(t, MaxRateLimitTokens-2, status.IPTokensAvailable, 0.5)
+	}
+
+	// without a connection, only the global limit applies
+	status, err = b.v1Proxy.RateLimitStatus(ctx)
+	require.NoError(t, err)
+	require.Zero(t, status.PerConnectionTokensPerSecond)
+	require.Zero(t, status.ConnectionTokensAvailable)
+	require.Equal(t, 2.0, status.PerIPTokensPerSecond)
+
+	// the status is served in maintenance mode too
+	b.SetMaintenanceMode(true)
+	_, err = b.V1ReverseProxy().RateLimitStatus(client)
+	require.NoError(t, err)
+}
+
+func TestGatewayVersionInfo(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2,
+		WithMaxLookbackDuration(time.Hour),
+		WithRateLimit(10),
+		WithPerIPRateLimit(2),
+		WithRateLimitTimeout(time.Millisecond),
+		WithRequestCoalescing(),
+	)
+	defer func() { _ = a.Shutdown(ctx) }()
+
+	// the version is served without consulting the target, nor the rate limit
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	info, err := a.V1ReverseProxy().GatewayVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, string(build.NodeUserVersion()), info.Version)
+	require.Equal(t, []string{"lookup-coalescing", "per-ip-rate-limit"}, info.Features)
+	require.Equal(t, time.Hour, info.MaxLookback)
+	require.Equal(t, DefaultMaxMessageLookbackEpochs, info.MaxMessageLookbackEpochs)
+	require.Equal(t, 10, info.RateLimit)
+	require.Equal(t, 2, info.PerIPRateLimit)
+	require.Equal(t, time.Millisecond, info.RateLimitTimeout)
+
+	// reconfigured limits are reported as they're in effect
+	require.NoError(t, a.Reconfigure(WithRateLimit(20), WithMaxLookbackDuration(2*time.Hour)))
+	info, err = a.V1ReverseProxy().GatewayVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 20, info.RateLimit)
+	require.Equal(t, 2*time.Hour, info.MaxLookback)
+}
+
+func TestForwardedForIdentity(t *testing.T) {
+	identify := func(remoteAddr string, forwardedFor ...string) Identity {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/v1", nil)
+		r.RemoteAddr = remoteAddr
+		for _, f := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return ForwardedForIdentity(context.WithValue(context.Background(), httpRequestKey, r))
+	}
+
+	require.Equal(t, Identity("192.0.2.1"), identify("192.0.2.1:1234"))
+	require.Equal(t, Identity("198.51.100.7"), identify("192.0.2.1:1234", "198.51.100.7"))
+	// only the address appended by the proxy can be trusted
+	require.Equal(t, Identity("198.51.100.7"), identify("192.0.2.1:1234", "203.0.113.9, 198.51.100.7"))
+	require.Equal(t, Identity("2001:db8::1"), identify("192.0.2.1:1234", "203.0.113.9", "2001:db8::1"))
+	require.Equal(t, Identity("192.0.2.1"), identify("192.0.2.1:1234", "not-an-ip"))
+	require.Equal(t, Identity(""), ForwardedForIdentity(context.Background()))
+}
+
+func TestGatewayMethodNotSupported(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	notFound := func(method string) error {
+		return &jsonrpc.JSONRPCError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method 'Filecoin.%s' not found", method)}
+	}
+
+	// without the option the target error is passed through untouched
+	a := NewNode(mockV1, mockV2)
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), gomock.Any()).Return(uint64(0), notFound("MpoolGetNonce"))
+	_, err := a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorContains(t, err, "method 'Filecoin.MpoolGetNonce' not found")
+	require.False(t, errors.As(err, new(*api.ErrMethodNotSupported)))
+
+	a = NewNode(mockV1, mockV2, WithMethodNotSupportedErrors(true))
+	mockV1.EXPECT().Version(gomock.Any()).Return(api.APIVersion{Version: "1.2.3"}, nil).Times(2)
+
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), gomock.Any()).Return(uint64(0), notFound("MpoolGetNonce"))
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	var merr *api.ErrMethodNotSupported
+	require.ErrorAs(t, err, &merr)
+	require.Equal(t, "MpoolGetNonce", merr.Method)
+	require.Equal(t, "1.2.3", merr.BackendVersion)
+	require.Equal(t, "method MpoolGetNonce not supported by backend (version 1.2.3)", err.Error())
+
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(0), notFound("EthChainId"))
+	_, err = a.v2Proxy.EthChainId(ctx)
+	require.ErrorAs(t, err, &merr)
+	require.Equal(t, "EthChainId", merr.Method)
+	require.Equal(t, "1.2.3", merr.BackendVersion)
+
+	// other errors from the target are passed through untouched
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(0), xerrors.New("boom"))
+	_, err = a.v2Proxy.EthChainId(ctx)
+	require.EqualError(t, err, "boom")
+
+	// the error survives the round trip to the client
+	jerr, err := merr.ToJSONRPCError()
+	require.NoError(t, err)
+	raw, err := json.Marshal(jerr)
+	require.NoError(t, err)
+	var received jsonrpc.JSONRPCError
+	require.NoError(t, json.Unmarshal(raw, &received))
+	var decoded api.ErrMethodNotSupported
+	require.NoError(t, decoded.FromJSONRPCError(received))
+	require.Equal(t, *merr, decoded)
+
+	// without the backend version
+	a = NewNode(mockV1, mockV2, WithMethodNotSupportedErrors(false))
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), gomock.Any()).Return(uint64(0), notFound("MpoolGetNonce"))
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorAs(t, err, &merr)
+	require.Empty(t, merr.BackendVersion)
+	require.Equal(t, "method MpoolGetNonce not supported by backend", err.Error())
+}
+
+func TestGatewayStateMinerInfoCache(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(10))
+
+	tss := generateTipSets(1, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	otherMiner, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	info := api.MinerInfo{Owner: miner, Worker: miner, SectorSize: abi.SectorSize(32 << 30)}
+
+	// only the first call for a miner and tipset is sent to the target
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), miner, ts.Key()).Return(info, nil).Times(1)
+	for i := 0; i < 3; i++ {
+		got, err := a.v1Proxy.StateMinerInfo(ctx, miner, ts.Key())
+		require.NoError(t, err)
+		require.Equal(t, info, got)
+	}
+
+	// errors are not cached
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), otherMiner, ts.Key()).Return(api.MinerInfo{}, xerrors.New("boom")).Times(1)
+	_, err = a.v1Proxy.StateMinerInfo(ctx, otherMiner, ts.Key())
+	require.EqualError(t, err, "boom")
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), otherMiner, ts.Key()).Return(info, nil).Times(1)
+	got, err := a.v1Proxy.StateMinerInfo(ctx, otherMiner, ts.Key())
+	require.NoError(t, err)
+	require.Equal(t, info, got)
+
+	// calls against the head aren't cached
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), miner, types.EmptyTSK).Return(info, nil).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := a.v1Proxy.StateMinerInfo(ctx, miner, types.EmptyTSK)
+		require.NoError(t, err)
+	}
+}
+
+func TestGatewayActorCache(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(2, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	heads := make(chan []*api.HeadChange, 1)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(heads, nil)
+
+	ttl := 50 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithActorCache(10, ttl))
+	defer func() { _ = a.Shutdown(ctx) }()
+
+	actor, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Code: mock.MkBlock(nil, 1, 1).Cid(), Nonce: 7, Balance: types.NewInt(1)}
+	getActor := func() {
+		got, err := a.v1Proxy.StateGetActor(ctx, actor, ts.Key())
+		require.NoError(t, err)
+		require.Equal(t, act, got)
+	}
+
+	// until the gateway is watching head changes, the cache isn't used
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(act, nil).Times(2)
+	getActor()
+	getActor()
+
+	heads <- []*api.HeadChange{{Type: store.HCCurrent, Val: tss[0]}}
+	require.Eventually(t, a.actorCache.watching.Load, time.Second, time.Millisecond)
+
+	// only the first call for an actor and tipset is sent to the target
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(act, nil).Times(1)
+	for i := 0; i < 3; i++ {
+		getActor()
+	}
+
+	// a head change evicts everything
+	heads <- []*api.HeadChange{{Type: store.HCApply, Val: ts}}
+	require.Eventually(t, func() bool { return a.actorCache.lru.Len() == 0 }, time.Second, time.Millisecond)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(act, nil).Times(1)
+	getActor()
+	getActor()
+
+	// as does the ttl passing
+	time.Sleep(ttl)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(act, nil).Times(1)
+	getActor()
+
+	// and once the subscription ends the cache isn't used until the gateway has resubscribed
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(nil, xerrors.New("boom")).AnyTimes()
+	close(heads)
+	require.Eventually(t, func() bool { return !a.actorCache.watching.Load() }, time.Second, time.Millisecond)
+	require.Zero(t, a.actorCache.lru.Len())
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(act, nil).Times(2)
+	getActor()
+	getActor()
+}
+
+func TestGatewayActorCacheHeadChangeDuringFetch(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(2, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	heads := make(chan []*api.HeadChange, 1)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(heads, nil)
+
+	a := NewNode(mockV1, mockV2, WithActorCache(10, time.Minute))
+	defer func() { _ = a.Shutdown(ctx) }()
+
+	heads <- []*api.HeadChange{{Type: store.HCCurrent, Val: tss[0]}}
+	require.Eventually(t, a.actorCache.watching.Load, time.Second, time.Millisecond)
+
+	actor, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	stale := &types.Actor{Code: mock.MkBlock(nil, 1, 1).Cid(), Nonce: 1, Balance: types.NewInt(1)}
+	fresh := &types.Actor{Code: mock.MkBlock(nil, 1, 1).Cid(), Nonce: 2, Balance: types.NewInt(1)}
+
+	// the first fetch is slow, and completes only after the head has changed
+	fetching, release := make(chan struct{}), make(chan struct{})
+	gomock.InOrder(
+		mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).DoAndReturn(
+			func(context.Context, address.Address, types.TipSetKey) (*types.Actor, error) {
+				close(fetching)
+				<-release
+				return stale, nil
+			}),
+		mockV1.EXPECT().StateGetActor(gomock.Any(), actor, ts.Key()).Return(fresh, nil).Times(1),
+	)
+
+	slow := make(chan *types.Actor, 1)
+	go func() {
+		got, err := a.v1Proxy.StateGetActor(ctx, actor, ts.Key())
+		require.NoError(t, err)
+		slow <- got
+	}()
+	<-fetching
+
+	generation := a.actorCache.generation.Load()
+	heads <- []*api.HeadChange{{Type: store.HCApply, Val: ts}}
+	require.Eventually(t, func() bool { return a.actorCache.generation.Load() > generation }, time.Second, time.Millisecond)
+
+	// a miss after the head change doesn't wait for the fetch from before it
+	got, err := a.v1Proxy.StateGetActor(ctx, actor, ts.Key())
+	require.NoError(t, err)
+	require.Equal(t, fresh, got)
+
+	// whose result is returned to its caller, but doesn't replace the fresh entry
+	close(release)
+	require.Equal(t, stale, <-slow)
+	got, err = a.v1Proxy.StateGetActor(ctx, actor, ts.Key())
+	require.NoError(t, err)
+	require.Equal(t, fresh, got)
+}
+
+func TestGatewayBatchFanoutConcurrency(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const concurrency = 4
+	a := NewNode(mockV1, mockV2, WithBatchFanoutConcurrency(concurrency))
+
+	var inFlight, maxInFlight atomic.Int64
+	subCall := func(ctx context.Context, i int) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	// the bound is shared, so concurrent batches together stay within it
+	const batches, batchSize = 3, 100
+	var wg sync.WaitGroup
+	for b := 0; b < batches; b++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, a.fanout(ctx, batchSize, subCall))
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, maxInFlight.Load(), int64(concurrency))
+	require.Positive(t, maxInFlight.Load())
+
+	// the first error is returned and the remaining sub-calls aren't started
+	var calls atomic.Int64
+	err := a.fanout(ctx, batchSize, func(ctx context.Context, i int) error {
+		calls.Add(1)
+		if i == 0 {
+			return xerrors.New("boom")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.EqualError(t, err, "boom")
+	require.Less(t, calls.Load(), int64(batchSize))
+}
+
+func TestGatewayConnectionTokensInBatch(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2)
+	tracker := newStatefulCallTracker("client", nil)
+	ctx = context.WithValue(ctx, statefulCallTrackerKeyV1, tracker)
+
+	// calls in a batch request count towards the connection's tokens like calls made on their own,
+	// even though the batch was charged against the global rate limit as a whole
+	require.NoError(t, a.limit(ctx, stateRateLimitTokens))
+	require.NoError(t, a.limit(context.WithValue(ctx, batchRequestKey, true), stateRateLimitTokens))
+	require.Equal(t, uint64(2*stateRateLimitTokens), tracker.stats.tokens.Load())
+}
+
+func TestGatewayClientVersion(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// falls back to the target's version
+	a := NewNode(mockV1, mockV2)
+	mockV1.EXPECT().Web3ClientVersion(gomock.Any()).Return("lotus/1.0.0", nil)
+	mockV2.EXPECT().Web3ClientVersion(gomock.Any()).Return("lotus/1.0.0", nil)
+	v, err := a.v1Proxy.Web3ClientVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "lotus/1.0.0", v)
+	v, err = a.v2Proxy.Web3ClientVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "lotus/1.0.0", v)
+
+	// overridden without consulting the target
+	a = NewNode(mockV1, mockV2, WithClientVersion("lotus-gateway/1.2.3"))
+	v, err = a.v1Proxy.Web3ClientVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "lotus-gateway/1.2.3", v)
+	v, err = a.v2Proxy.Web3ClientVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "lotus-gateway/1.2.3", v)
+}
+
+func TestGatewayEthCallMaxBlockAge(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const maxAge = 10
+	a := NewNode(mockV1, mockV2, WithEthCallMaxBlockAge(maxAge))
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	var tx ethtypes.EthCall
+	recent := ethtypes.NewEthBlockNumberOrHashFromNumber(ethtypes.EthUint64(head.Height() - maxAge))
+	old := ethtypes.NewEthBlockNumberOrHashFromNumber(ethtypes.EthUint64(head.Height() - maxAge - 1))
+
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, recent).Return(ethtypes.EthBytes{1}, nil)
+	res, err := a.v1Proxy.EthCall(ctx, tx, recent)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthBytes{1}, res)
+
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).Return(ethtypes.EthBytes{2}, nil)
+	res, err = a.v1Proxy.EthCall(ctx, tx, latest)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthBytes{2}, res)
+
+	// over-age calls are rejected without reaching the target, though they are within the general
+	// lookback limits
+	_, err = a.v1Proxy.EthCall(ctx, tx, old)
+	require.ErrorContains(t, err, "blocks more than 10 epochs behind the head are disallowed")
+	_, err = a.v1Proxy.EthGetCode(ctx, ethtypes.EthAddress{}, old)
+	require.ErrorContains(t, err, "blocks more than 10 epochs behind the head are disallowed")
+	_, err = a.v1Proxy.EthGetStorageAt(ctx, ethtypes.EthAddress{}, nil, old)
+	require.ErrorContains(t, err, "blocks more than 10 epochs behind the head are disallowed")
+
+	// other reads aren't affected
+	mockV1.EXPECT().EthGetBalance(gomock.Any(), ethtypes.EthAddress{}, old).Return(ethtypes.EthBigInt(big.Zero()), nil)
+	_, err = a.v1Proxy.EthGetBalance(ctx, ethtypes.EthAddress{}, old)
+	require.NoError(t, err)
+}
+
+func TestGatewayEthStorageMaxBlockAge(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const maxAge = 10
+	a := NewNode(mockV1, mockV2, WithEthStorageMaxBlockAge(maxAge), WithEthCallMaxBlockAge(50))
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV2.EXPECT().ChainGetTipSet(gomock.Any(), types.TipSetSelectors.Latest).Return(head, nil).AnyTimes()
+
+	recent := ethtypes.NewEthBlockNumberOrHashFromNumber(ethtypes.EthUint64(head.Height() - maxAge))
+	old := ethtypes.NewEthBlockNumberOrHashFromNumber(ethtypes.EthUint64(head.Height() - maxAge - 1))
+
+	// a recent storage read is served
+	mockV1.EXPECT().EthGetStorageAt(gomock.Any(), ethtypes.EthAddress{}, ethtypes.EthBytes(nil), recent).Return(ethtypes.EthBytes{1}, nil)
+	res, err := a.v1Proxy.EthGetStorageAt(ctx, ethtypes.EthAddress{}, nil, recent)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthBytes{1}, res)
+
+	// while an over-age one is rejected without reaching the target, on both APIs
+	_, err = a.v1Proxy.EthGetStorageAt(ctx, ethtypes.EthAddress{}, nil, old)
+	require.ErrorContains(t, err, "blocks more than 10 epochs behind the head are disallowed")
+	_, err = a.v2Proxy.EthGetStorageAt(ctx, ethtypes.EthAddress{}, nil, old)
+	require.ErrorContains(t, err, "blocks more than 10 epochs behind the head are disallowed")
+
+	// and EthCall is still subject only to its own limit
+	var tx ethtypes.EthCall
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, old).Return(ethtypes.EthBytes{2}, nil)
+	_, err = a.v1Proxy.EthCall(ctx, tx, old)
+	require.NoError(t, err)
+}
+
+func TestGatewayEthFeeHistoryMaxBlockAge(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const maxAge = 10
+	lookback := time.Duration(50*buildconstants.BlockDelaySecs) * time.Second
+	a := NewNode(mockV1, mockV2, WithEthFeeHistoryMaxBlockAge(maxAge), WithMaxLookbackDuration(lookback))
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	feeHistoryParams := func(newest string) jsonrpc.RawParams {
+		b, err := json.Marshal([]interface{}{"0x5", newest, []float64{}})
+		require.NoError(t, err)
+		return b
+	}
+	recent := feeHistoryParams(ethtypes.EthUint64(head.Height() - maxAge).Hex())
+	old := feeHistoryParams(ethtypes.EthUint64(head.Height() - maxAge - 1).Hex())
+	ancient := feeHistoryParams(ethtypes.EthUint64(head.Height() - 60).Hex())
+	latest := feeHistoryParams("latest")
+
+	mockV1.EXPECT().EthFeeHistory(gomock.Any(), recent).Return(ethtypes.EthFeeHistory{OldestBlock: 85}, nil)
+	res, err := a.v1Proxy.EthFeeHistory(ctx, recent)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(85), res.OldestBlock)
+
+	mockV1.EXPECT().EthFeeHistory(gomock.Any(), latest).Return(ethtypes.EthFeeHistory{OldestBlock: 95}, nil)
+	res, err = a.v1Proxy.EthFeeHistory(ctx, latest)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(95), res.OldestBlock)
+
+	// over-age newest blocks are rejected without reaching the target, though they are within the
+	// general lookback limits
+	_, err = a.v1Proxy.EthFeeHistory(ctx, old)
+	require.ErrorContains(t, err, "bad newest block: blocks more than 10 epochs behind the head are disallowed")
+
+	// and without a maximum age, the newest block is still subject to the general lookback limits
+	a = NewNode(mockV1, mockV2, WithMaxLookbackDuration(lookback))
+	mockV1.EXPECT().EthFeeHistory(gomock.Any(), old).Return(ethtypes.EthFeeHistory{OldestBlock: 84}, nil)
+	_, err = a.v1Proxy.EthFeeHistory(ctx, old)
+	require.NoError(t, err)
+	_, err = a.v1Proxy.EthFeeHistory(ctx, ancient)
+	require.ErrorContains(t, err, "bad tipset height")
+}
+
+func TestGatewayEthCallAddressAllowlist(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	allowed := ethtypes.EthAddress{0x01}
+	other := ethtypes.EthAddress{0x02}
+	a := NewNode(mockV1, mockV2, WithEthCallAddressAllowlist([]ethtypes.EthAddress{allowed}))
+
+	tss := generateTipSets(10, 0)
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(tss[len(tss)-1], nil).AnyTimes()
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+
+	call := ethtypes.EthCall{To: &allowed}
+	mockV1.EXPECT().EthCall(gomock.Any(), call, latest).Return(ethtypes.EthBytes{1}, nil)
+	res, err := a.v1Proxy.EthCall(ctx, call, latest)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthBytes{1}, res)
+
+	estimateParams := func(tx ethtypes.EthCall) jsonrpc.RawParams {
+		b, err := json.Marshal([]interface{}{tx})
+		require.NoError(t, err)
+		return b
+	}
+	mockV1.EXPECT().EthEstimateGas(gomock.Any(), estimateParams(call)).Return(ethtypes.EthUint64(21000), nil)
+	gas, err := a.v1Proxy.EthEstimateGas(ctx, estimateParams(call))
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(21000), gas)
+
+	// calls to other addresses, or deploying contracts, are rejected without reaching the target
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &other}, latest)
+	require.ErrorIs(t, err, ErrEthCallAddressNotAllowed)
+	require.ErrorContains(t, err, other.String())
+	_, err = a.v1Proxy.EthEstimateGas(ctx, estimateParams(ethtypes.EthCall{To: &other}))
+	require.ErrorIs(t, err, ErrEthCallAddressNotAllowed)
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{Data: ethtypes.EthBytes{0xfe}}, latest)
+	require.ErrorIs(t, err, ErrEthCallAddressNotAllowed)
+	require.ErrorContains(t, err, "contract creation")
+
+	// and v2 is restricted too
+	_, err = a.v2Proxy.EthEstimateGas(ctx, estimateParams(ethtypes.EthCall{To: &other}))
+	require.ErrorIs(t, err, ErrEthCallAddressNotAllowed)
+}
+
+func TestGatewayEthCallGuards(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthCallMaxGas(1_000_000), WithEthCallRejectExpensivePrecompiles())
+
+	tss := generateTipSets(10, 0)
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(tss[len(tss)-1], nil).AnyTimes()
+	mockV2.EXPECT().ChainGetTipSet(gomock.Any(), types.TipSetSelectors.Latest).Return(tss[len(tss)-1], nil).AnyTimes()
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+	to := ethtypes.EthAddress{0x01}
+	estimateParams := func(tx ethtypes.EthCall) jsonrpc.RawParams {
+		b, err := json.Marshal([]interface{}{tx})
+		require.NoError(t, err)
+		return b
+	}
+
+	// calls within the gas limit are forwarded as they are
+	call := ethtypes.EthCall{To: &to, Gas: 500_000}
+	mockV1.EXPECT().EthCall(gomock.Any(), call, latest).Return(ethtypes.EthBytes{1}, nil)
+	_, err := a.v1Proxy.EthCall(ctx, call, latest)
+	require.NoError(t, err)
+
+	// calls without a gas limit are given the maximum
+	capped := ethtypes.EthCall{To: &to, Gas: 1_000_000}
+	mockV1.EXPECT().EthCall(gomock.Any(), capped, latest).Return(ethtypes.EthBytes{1}, nil)
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &to}, latest)
+	require.NoError(t, err)
+	mockV2.EXPECT().EthEstimateGas(gomock.Any(), estimateParams(capped)).Return(ethtypes.EthUint64(21000), nil)
+	_, err = a.v2Proxy.EthEstimateGas(ctx, estimateParams(ethtypes.EthCall{To: &to}))
+	require.NoError(t, err)
+
+	// those above it are rejected without reaching the target
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &to, Gas: 1_000_001}, latest)
+	require.ErrorIs(t, err, ErrEthCallGasTooHigh)
+	_, err = a.v2Proxy.EthCall(ctx, ethtypes.EthCall{To: &to, Gas: 1_000_001}, latest)
+	require.ErrorIs(t, err, ErrEthCallGasTooHigh)
+	_, err = a.v1Proxy.EthEstimateGas(ctx, estimateParams(ethtypes.EthCall{To: &to, Gas: 1_000_001}))
+	require.ErrorIs(t, err, ErrEthCallGasTooHigh)
+
+	// as are direct calls to expensive precompiles, but not to cheap ones
+	modexp := ethtypes.EthAddress{19: 0x05}
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &modexp}, latest)
+	require.ErrorIs(t, err, ErrEthCallPrecompileNotAllowed)
+	require.ErrorContains(t, err, "modexp")
+	sha256 := ethtypes.EthAddress{19: 0x02}
+	mockV1.EXPECT().EthCall(gomock.Any(), ethtypes.EthCall{To: &sha256, Gas: 1_000_000}, latest).Return(ethtypes.EthBytes{1}, nil)
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &sha256}, latest)
+	require.NoError(t, err)
+
+	// without the options calls are forwarded untouched
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().EthCall(gomock.Any(), ethtypes.EthCall{To: &modexp}, latest).Return(ethtypes.EthBytes{1}, nil)
+	_, err = a.v1Proxy.EthCall(ctx, ethtypes.EthCall{To: &modexp}, latest)
+	require.NoError(t, err)
+}
+
+func TestGatewayBlockedAddresses(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	robust, err := address.NewSecp256k1Address([]byte("sanctioned"))
+	require.NoError(t, err)
+	robustID, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	listedID, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+	listedRobust, err := address.NewSecp256k1Address([]byte("abusive"))
+	require.NoError(t, err)
+	notYetCreated, err := address.NewSecp256k1Address([]byte("future"))
+	require.NoError(t, err)
+	allowed, err := address.NewIDAddress(1003)
+	require.NoError(t, err)
+
+	resolved := make(chan struct{})
+	mockV1.EXPECT().StateLookupID(gomock.Any(), robust, types.EmptyTSK).Return(robustID, nil)
+	mockV1.EXPECT().StateLookupRobustAddress(gomock.Any(), listedID, types.EmptyTSK).Return(listedRobust, nil)
+	mockV1.EXPECT().StateLookupID(gomock.Any(), notYetCreated, types.EmptyTSK).DoAndReturn(
+		func(context.Context, address.Address, types.TipSetKey) (address.Address, error) {
+			close(resolved)
+			return address.Undef, xerrors.New("actor not found")
+		})
+
+	a := NewNode(mockV1, mockV2, WithBlockedAddresses([]address.Address{robust, listedID, notYetCreated}))
+	defer func() { _ = a.Shutdown(ctx) }()
+	<-resolved
+	require.Eventually(t, func() bool { return a.blockedAddresses.isBlocked(listedRobust) }, time.Second, time.Millisecond)
+
+	v1, v2 := a.V1ReverseProxy(), a.V2ReverseProxy()
+	for _, addr := range []address.Address{robust, robustID, listedID, listedRobust, notYetCreated} {
+		_, err = v1.StateGetActor(ctx, addr, types.EmptyTSK)
+		require.ErrorIs(t, err, ErrForbiddenAddress, addr)
+		_, err = v1.WalletBalance(ctx, addr)
+		require.ErrorIs(t, err, ErrForbiddenAddress, addr)
+		_, err = v1.StateMinerInfo(ctx, addr, types.EmptyTSK)
+		require.ErrorIs(t, err, ErrForbiddenAddress, addr)
+		_, err = v2.StateGetActor(ctx, addr, types.TipSetSelectors.Latest)
+		require.ErrorIs(t, err, ErrForbiddenAddress, addr)
+	}
+
+	// other addresses are served as usual
+	mockV1.EXPECT().WalletBalance(gomock.Any(), allowed).Return(types.NewInt(42), nil)
+	balance, err := v1.WalletBalance(ctx, allowed)
+	require.NoError(t, err)
+	require.Equal(t, types.NewInt(42), balance)
+}
+
+func TestGatewayReconfigure(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tokens := MaxRateLimitTokens
+	a := NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(time.Millisecond))
+	require.NoError(t, a.limit(ctx, tokens), "burst should be available")
+	require.ErrorContains(t, a.limit(ctx, tokens), "server busy")
+
+	// lift the rate limit at runtime
+	require.NoError(t, a.Reconfigure(WithRateLimit(0)))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, a.limit(ctx, tokens), "requests should not be limited once the rate limit is removed")
+	}
+
+	// and bring it back
+	require.NoError(t, a.Reconfigure(WithRateLimit(1)))
+	require.NoError(t, a.limit(ctx, tokens))
+	require.ErrorContains(t, a.limit(ctx, tokens), "server busy")
+
+	// lookback changes apply to subsequent checks
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, a.checkTimestamp(ctx, twoHoursAgo))
+	require.NoError(t, a.Reconfigure(WithMaxLookbackDuration(time.Hour)))
+	require.ErrorContains(t, a.checkTimestamp(ctx, twoHoursAgo), "lookbacks of more than 1h0m0s are disallowed")
+
+	// options that can't be changed at runtime are rejected, along with the rest of the update
+	require.ErrorContains(t, a.Reconfigure(WithRateLimit(0), WithClientVersion("lotus-gateway/1.2.3")), "without restarting")
+	require.Equal(t, "", a.clientVersion)
+	require.ErrorContains(t, a.limit(ctx, tokens), "server busy", "rate limit should not have been changed")
+	require.Error(t, a.Reconfigure(WithV1EthSubHandler(NewEthSubHandler())))
+}
+
+func TestGatewayReconfigureWriteReservedRateLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tokens := MaxRateLimitTokens
+	interval := 50 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(interval/5))
+
+	// without a reservation, writes wait on the global rate limit along with reads
+	require.NoError(t, a.limit(ctx, tokens))
+	require.ErrorContains(t, a.limitWrite(ctx, tokens), "server busy")
+
+	// reserve a pool for writes at runtime; it fills up at its rate, leaving reads limited
+	require.NoError(t, a.Reconfigure(WithWriteReservedRateLimit(int(time.Second/interval)*tokens)))
+	time.Sleep(interval)
+	require.NoError(t, a.limitWrite(ctx, tokens))
+	require.ErrorContains(t, a.limit(ctx, tokens), "server busy")
+
+	// rebalance the pools: lift the global rate limit and drop the reservation
+	require.NoError(t, a.Reconfigure(WithRateLimit(0), WithWriteReservedRateLimit(0)))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, a.limit(ctx, tokens))
+		require.NoError(t, a.limitWrite(ctx, tokens))
+	}
+
+	// with the global rate limit back, writes without a reservation are limited with reads again
+	require.NoError(t, a.Reconfigure(WithRateLimit(1)))
+	require.NoError(t, a.limit(ctx, tokens))
+	require.ErrorContains(t, a.limitWrite(ctx, tokens), "server busy")
+}
+
+func TestGatewayEthGetBalanceHistory(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthBalanceHistoryMaxSamples(5))
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	// the balance at each block is its height
+	var addr ethtypes.EthAddress
+	mockV1.EXPECT().EthGetBalance(gomock.Any(), addr, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, addr ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBigInt, error) {
+			return ethtypes.EthBigInt(big.NewInt(int64(*blkParam.BlockNumber))), nil
+		}).AnyTimes()
+
+	samples, err := a.v1Proxy.EthGetBalanceHistory(ctx, addr, 90, 100, 3)
+	require.NoError(t, err)
+	var got []api.EthBalanceSample
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	require.Len(t, got, 4)
+	for i, sample := range got {
+		num := ethtypes.EthUint64(90 + 3*i)
+		require.Equal(t, num, sample.BlockNumber)
+		balance, err := a.v1Proxy.EthGetBalance(ctx, addr, ethtypes.NewEthBlockNumberOrHashFromNumber(num))
+		require.NoError(t, err)
+		require.Equal(t, balance, sample.Balance)
+	}
+
+	_, err = a.v1Proxy.EthGetBalanceHistory(ctx, addr, 90, 100, 2)
+	require.ErrorContains(t, err, "too many samples requested: 6, the maximum is 5")
+	_, err = a.v1Proxy.EthGetBalanceHistory(ctx, addr, 90, 100, 0)
+	require.ErrorContains(t, err, "step must be greater than zero")
+	_, err = a.v1Proxy.EthGetBalanceHistory(ctx, addr, 100, 90, 1)
+	require.ErrorContains(t, err, "fromBlock must not be after toBlock")
+	_, err = a.v1Proxy.EthGetBalanceHistory(ctx, addr, 101, 101, 1)
+	require.ErrorContains(t, err, "tipset height in future")
+
+	// a balance that can't be retrieved part way through ends the stream with a sample saying why
+	failingV1 := v1mocks.NewMockFullNode(ctrl)
+	failingV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	failingV1.EXPECT().EthGetBalance(gomock.Any(), addr, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, addr ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBigInt, error) {
+			if *blkParam.BlockNumber == 93 {
+				return ethtypes.EthBigInt{}, xerrors.New("state not found")
+			}
+			return ethtypes.EthBigInt(big.NewInt(int64(*blkParam.BlockNumber))), nil
+		}).Times(2)
+	a = NewNode(failingV1, mockV2, WithEthBalanceHistoryMaxSamples(5))
+	samples, err = a.v1Proxy.EthGetBalanceHistory(ctx, addr, 90, 100, 3)
+	require.NoError(t, err)
+	got = nil
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	require.Len(t, got, 2)
+	require.Equal(t, ethtypes.EthUint64(90), got[0].BlockNumber)
+	require.Empty(t, got[0].Error)
+	require.Equal(t, ethtypes.EthUint64(93), got[1].BlockNumber)
+	require.Contains(t, got[1].Error, "state not found")
+}
+
+func TestGatewayEthRevertReasons(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthRevertReasons())
+	tss := generateTipSets(1, 0)
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(tss[0], nil).AnyTimes()
+
+	word := func(v uint64) []byte {
+		w := make([]byte, 32)
+		w[31] = byte(v)
+		return w
+	}
+	concat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	reverted := func(data []byte) error {
+		return api.NewErrExecutionReverted(33, "vm error", "revert reason", data)
+	}
+
+	var tx ethtypes.EthCall
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		reason *api.EthRevertReason
+	}{
+		{
+			name:   "Error(string)",
+			data:   concat(errorFunctionSelector, word(32), word(4), []byte("nope"), make([]byte, 28)),
+			reason: &api.EthRevertReason{Kind: "Error", Message: "nope"},
+		},
+		{
+			name:   "Panic(uint256)",
+			data:   concat(panicFunctionSelector, word(0x11)),
+			reason: &api.EthRevertReason{Kind: "Panic", Code: "0x11"},
+		},
+		{
+			name: "custom error",
+			data: concat([]byte{0xde, 0xad, 0xbe, 0xef}, word(1)),
+		},
+		{
+			name: "truncated Error(string)",
+			data: concat(errorFunctionSelector, word(32), word(64), []byte("nope")),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).Return(nil, reverted(tc.data))
+			_, err := a.v1Proxy.EthCall(ctx, tx, latest)
+
+			var rerr *api.ErrExecutionReverted
+			require.ErrorAs(t, err, &rerr)
+			require.Equal(t, fmt.Sprintf("0x%x", tc.data), rerr.Data, "raw revert data should always be returned")
+			require.Equal(t, tc.reason, rerr.Reason)
+
+			// the decoded reason survives the trip to the client
+			jerr, err := rerr.ToJSONRPCError()
+			require.NoError(t, err)
+			encoded, err := json.Marshal(jerr)
+			require.NoError(t, err)
+			var received jsonrpc.JSONRPCError
+			require.NoError(t, json.Unmarshal(encoded, &received))
+			var decoded api.ErrExecutionReverted
+			require.NoError(t, decoded.FromJSONRPCError(received))
+			require.Equal(t, *rerr, decoded)
+		})
+	}
+
+	// without the option the target's error is passed through as is
+	a = NewNode(mockV1, mockV2)
+	data := concat(panicFunctionSelector, word(0x11))
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).Return(nil, reverted(data))
+	_, err := a.v1Proxy.EthCall(ctx, tx, latest)
+	var rerr *api.ErrExecutionReverted
+	require.ErrorAs(t, err, &rerr)
+	require.Nil(t, rerr.Reason)
+}
+
+func TestGatewayMpoolPendingMaxMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const maxMsgs = 3
+	a := NewNode(mockV1, mockV2, WithMpoolPendingMaxMessages(maxMsgs))
+
+	pending := make([]*types.SignedMessage, maxMsgs)
+	for i := range pending {
+		pending[i] = &types.SignedMessage{Message: types.Message{Nonce: uint64(i)}}
+	}
+
+	// a mempool within the limit is returned in full
+	mockV1.EXPECT().MpoolPending(gomock.Any(), types.EmptyTSK).Return(pending, nil)
+	msgs, err := a.v1Proxy.MpoolPending(ctx, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, pending, msgs)
+
+	// an oversized one is rejected
+	oversized := append(pending, &types.SignedMessage{Message: types.Message{Nonce: maxMsgs}})
+	mockV1.EXPECT().MpoolPending(gomock.Any(), types.EmptyTSK).Return(oversized, nil)
+	_, err = a.v1Proxy.MpoolPending(ctx, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrTooManyPendingMessages)
+
+	// the tipset key is validated against the lookback limits before reaching the target
+	old := generateTipSets(1, uint64(time.Now().Add(-2*DefaultMaxLookbackDuration).Unix()))[0]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), old.Key()).Return(old, nil)
+	_, err = a.v1Proxy.MpoolPending(ctx, old.Key())
+	require.ErrorContains(t, err, "bad tipset")
+
+	// without a limit every pending message is returned
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().MpoolPending(gomock.Any(), types.EmptyTSK).Return(oversized, nil)
+	msgs, err = a.v1Proxy.MpoolPending(ctx, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Len(t, msgs, maxMsgs+1)
+}
+
+func TestGatewayMpoolPendingByAddress(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithMpoolPendingMaxMessages(2), WithMpoolPendingMaxAddresses(2))
+
+	addrs := make([]address.Address, 4)
+	for i := range addrs {
+		var err error
+		addrs[i], err = address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+	}
+	msg := func(from, to address.Address) *types.SignedMessage {
+		return &types.SignedMessage{Message: types.Message{From: from, To: to}}
+	}
+	pending := []*types.SignedMessage{
+		msg(addrs[0], addrs[1]),
+		msg(addrs[2], addrs[3]),
+		msg(addrs[3], addrs[1]),
+		msg(addrs[2], addrs[0]),
+	}
+
+	// only messages sent from or to the given addresses are returned
+	mockV1.EXPECT().MpoolPending(gomock.Any(), types.EmptyTSK).Return(pending, nil)
+	msgs, err := a.v1Proxy.MpoolPendingByAddress(ctx, []address.Address{addrs[3]}, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, []*types.SignedMessage{pending[1], pending[2]}, msgs)
+
+	// the message limit applies to the matched messages rather than the whole mempool
+	mockV1.EXPECT().MpoolPending(gomock.Any(), types.EmptyTSK).Return(pending, nil)
+	_, err = a.v1Proxy.MpoolPendingByAddress(ctx, []address.Address{addrs[0], addrs[1]}, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrTooManyPendingMessages)
+
+	// and too many addresses are rejected without reaching the target
+	_, err = a.v1Proxy.MpoolPendingByAddress(ctx, addrs[:3], types.EmptyTSK)
+	require.ErrorIs(t, err, ErrTooManyPendingAddresses)
+}
+
+func TestGatewaySlowStart(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const window = 500 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithRateLimit(100), WithSlowStart(window))
+	full := rateLimit(100)
+	require.Equal(t, full, a.rateLimiter.Limit())
+
+	// an outage leaves the rate limit alone
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(0), &jsonrpc.RPCConnectionError{})
+	_, err := a.v1Proxy.EthChainId(ctx)
+	require.Error(t, err)
+	require.Equal(t, full, a.rateLimiter.Limit())
+
+	// but once the target recovers, the rate limit drops and ramps back up over the window
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil).Times(2)
+	_, err = a.v1Proxy.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, full*slowStartInitialFraction, a.rateLimiter.Limit())
+	require.Eventually(t, func() bool {
+		l := a.rateLimiter.Limit()
+		return l > full*slowStartInitialFraction && l < full
+	}, window, 5*time.Millisecond, "rate limit should ramp up gradually")
+	require.Eventually(t, func() bool { return a.rateLimiter.Limit() == full }, 4*window, 10*time.Millisecond)
+
+	// without a further outage, requests don't restart the ramp
+	_, err = a.v1Proxy.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, full, a.rateLimiter.Limit())
+
+	require.Equal(t, full*0.55, rampLimit(full, window/2, window))
+	require.Equal(t, full, rampLimit(full, window, window))
+	require.Equal(t, rate.Inf, rampLimit(rate.Inf, 0, window))
+}
+
+func TestGatewayServeStaleOnOutage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(10), WithServeStaleOnOutage(true))
+
+	cached, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	uncached, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	info := api.MinerInfo{Owner: cached, SectorSize: abi.SectorSize(2048)}
+
+	// while the target is available the result is served fresh, and kept
+	ctx, headers := withResponseHeaders(context.Background())
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), cached, types.EmptyTSK).Return(info, nil)
+	res, err := a.v1Proxy.StateMinerInfo(ctx, cached, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, info, res)
+	require.Empty(t, headers.header().Get(StaleResponseHeader))
+
+	// the target goes away
+	outage := &jsonrpc.RPCConnectionError{}
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{}, outage).AnyTimes()
+	mockV1.EXPECT().StateMinerPower(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, outage).AnyTimes()
+
+	// a cached method is served stale
+	ctx, headers = withResponseHeaders(context.Background())
+	res, err = a.v1Proxy.StateMinerInfo(ctx, cached, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, info, res)
+	require.Equal(t, "true", headers.header().Get(StaleResponseHeader), "response should have been flagged stale")
+
+	// a cache miss, and an uncached method, fail with a clear error
+	ctx, headers = withResponseHeaders(context.Background())
+	_, err = a.v1Proxy.StateMinerInfo(ctx, uncached, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	_, err = a.v1Proxy.StateMinerPower(ctx, cached, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.Empty(t, headers.header().Get(StaleResponseHeader))
+
+	// the flag makes it onto HTTP responses
+	h := responseHeaderHandler{next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := a.v1Proxy.StateMinerInfo(r.Context(), cached, types.EmptyTSK)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte("{}"))
+	})}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc/v1", nil))
+	require.Equal(t, "true", rec.Header().Get(StaleResponseHeader))
+
+	// without the mode the target's error is returned as is
+	a = NewNode(mockV1, mockV2, WithStateMinerInfoCache(10))
+	_, err = a.v1Proxy.StateMinerInfo(context.Background(), cached, types.EmptyTSK)
+	require.ErrorIs(t, err, outage)
+	require.NotErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestGatewayServeStaleOnOutageCaches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	n := policy.ChainFinality + 10
+	tss := generateTipSets(n, uint64(time.Now().Unix())-uint64(n)*buildconstants.BlockDelaySecs)
+	head := tss[len(tss)-1]
+	final := head.Height() - policy.ChainFinality
+	finalNum := ethtypes.EthUint64(final)
+
+	// the target is available until down is set
+	var down atomic.Bool
+	outage := &jsonrpc.RPCConnectionError{}
+	mockV1.EXPECT().ChainHead(gomock.Any()).DoAndReturn(func(context.Context) (*types.TipSet, error) {
+		if down.Load() {
+			return nil, outage
+		}
+		return head, nil
+	}).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, types.TipSetKey) (*types.TipSet, error) {
+		if down.Load() {
+			return nil, outage
+		}
+		return head, nil
+	}).AnyTimes()
+	heads := make(chan []*api.HeadChange, 1)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(heads, nil)
+
+	a := NewNode(mockV1, mockV2, WithActorCache(10, 0), WithEthBlockCache(10), WithTipSetCache(10), WithServeStaleOnOutage(true))
+	defer func() { _ = a.Shutdown(context.Background()) }()
+
+	heads <- []*api.HeadChange{{Type: store.HCCurrent, Val: head}}
+	require.Eventually(t, a.actorCache.watching.Load, time.Second, time.Millisecond)
+
+	actor, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Code: mock.MkBlock(nil, 1, 1).Cid(), Nonce: 7, Balance: types.NewInt(1)}
+	blk := ethtypes.EthBlock{Number: finalNum}
+
+	// responses are cached while the target is available
+	ctx := context.Background()
+	mockV1.EXPECT().StateGetActor(gomock.Any(), actor, head.Key()).Return(act, nil)
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), finalNum.Hex(), false).Return(blk, nil)
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), final, types.EmptyTSK).Return(tss[final], nil)
+	_, err = a.v1Proxy.StateGetActor(ctx, actor, head.Key())
+	require.NoError(t, err)
+	_, err = a.v1Proxy.EthGetBlockByNumber(ctx, finalNum.Hex(), false)
+	require.NoError(t, err)
+	_, err = a.v1Proxy.ChainGetTipSetByHeight(ctx, final, types.EmptyTSK)
+	require.NoError(t, err)
+
+	// the target goes away, taking the actor cache's head change subscription with it
+	down.Store(true)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(nil, outage).AnyTimes()
+	mockV1.EXPECT().StateGetActor(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, outage).AnyTimes()
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), gomock.Any(), gomock.Any()).Return(ethtypes.EthBlock{}, outage).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, outage).AnyTimes()
+	close(heads)
+	require.Eventually(t, func() bool { return !a.actorCache.watching.Load() }, time.Second, time.Millisecond)
+
+	// each of the caches is served stale
+	ctx, headers := withResponseHeaders(context.Background())
+	gotAct, err := a.v1Proxy.StateGetActor(ctx, actor, head.Key())
+	require.NoError(t, err)
+	require.Equal(t, act, gotAct)
+	require.Equal(t, "true", headers.header().Get(StaleResponseHeader))
+
+	ctx, headers = withResponseHeaders(context.Background())
+	gotBlk, err := a.v1Proxy.EthGetBlockByNumber(ctx, finalNum.Hex(), false)
+	require.NoError(t, err)
+	require.Equal(t, blk, gotBlk)
+	require.Equal(t, "true", headers.header().Get(StaleResponseHeader))
+
+	ctx, headers = withResponseHeaders(context.Background())
+	gotTs, err := a.v1Proxy.ChainGetTipSetByHeight(ctx, final, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, tss[final], gotTs)
+	require.Equal(t, "true", headers.header().Get(StaleResponseHeader))
+
+	// and misses fail with a clear error
+	_, err = a.v1Proxy.EthGetBlockByNumber(context.Background(), (finalNum - 1).Hex(), false)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	_, err = a.v1Proxy.ChainGetTipSetByHeight(context.Background(), final-1, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestGatewayFallbackTargets(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	fallbackV1 := v1mocks.NewMockFullNode(ctrl)
+	fallbackV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithFallbackTargets(TargetAPI{V1: fallbackV1, V2: fallbackV2}))
+	outage := &jsonrpc.RPCConnectionError{}
+
+	// a call the target can't be reached for is retried on the fallback
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	fallbackV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(7), nil)
+	nonce, err := a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), nonce)
+
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(0), outage)
+	fallbackV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	chainID, err := a.v2Proxy.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(314), chainID)
+
+	// an error returned by the target isn't retried
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), xerrors.New("actor not found"))
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorContains(t, err, "actor not found")
+
+	// nor is a write, which the target may have accepted
+	msg := &types.SignedMessage{Message: types.Message{To: address.TestAddress, From: address.TestAddress}}
+	mockV1.EXPECT().MpoolPushUntrusted(gomock.Any(), msg).Return(cid.Undef, outage)
+	_, err = a.v1Proxy.MpoolPush(ctx, msg)
+	var cerr *jsonrpc.RPCConnectionError
+	require.ErrorAs(t, err, &cerr)
+
+	// fallbacks are tried in turn until one can be reached
+	second := v1mocks.NewMockFullNode(ctrl)
+	a = NewNode(mockV1, mockV2, WithFallbackTargets(TargetAPI{V1: fallbackV1, V2: fallbackV2}, TargetAPI{V1: second, V2: fallbackV2}))
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	fallbackV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	second.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(9), nil)
+	nonce, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), nonce)
+
+	// a fallback without both versions of the API is reported rather than failing on first use, and
+	// none of the fallbacks are used
+	a = NewNode(mockV1, mockV2, WithFallbackTargets(TargetAPI{V1: fallbackV1, V2: fallbackV2}, TargetAPI{V1: second}))
+	require.ErrorContains(t, a.Err(), "fallback targets without both API versions: 1")
+	_, err = Handler(a)
+	require.ErrorContains(t, err, "fallback targets without both API versions: 1")
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorAs(t, err, &cerr)
+}
+
+func TestGatewayCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const cooldown = 20 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithCircuitBreaker(2, time.Minute, cooldown))
+	defer a.Close() //nolint:errcheck
+	outage := &jsonrpc.RPCConnectionError{}
+
+	// an error returned by the target doesn't count towards opening the breaker
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), xerrors.New("actor not found"))
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	for i := 0; i < 3; i++ {
+		_, err := a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+		require.Error(t, err)
+	}
+
+	// the target is probed while the breaker is open, and keeps failing for now
+	var recovered atomic.Bool
+	mockV1.EXPECT().ChainHead(gomock.Any()).DoAndReturn(func(context.Context) (*types.TipSet, error) {
+		if recovered.Load() {
+			return nil, nil
+		}
+		return nil, outage
+	}).AnyTimes()
+
+	// one more outage opens it, and reads are then short-circuited without reaching the target
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(0), outage)
+	_, err := a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.Error(t, err)
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.ErrorContains(t, err, "short-circuited")
+	_, err = a.v2Proxy.EthChainId(ctx)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+
+	// writes have a breaker of their own
+	msg := &types.SignedMessage{Message: types.Message{To: address.TestAddress, From: address.TestAddress}}
+	mockV1.EXPECT().MpoolPushUntrusted(gomock.Any(), msg).Return(msg.Cid(), nil)
+	c, err := a.v1Proxy.MpoolPush(ctx, msg)
+	require.NoError(t, err)
+	require.Equal(t, msg.Cid(), c)
+
+	// once a probe succeeds the breaker closes
+	time.Sleep(3 * cooldown)
+	_, err = a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	recovered.Store(true)
+	mockV1.EXPECT().MpoolGetNonce(gomock.Any(), address.TestAddress).Return(uint64(7), nil).AnyTimes()
+	require.Eventually(t, func() bool {
+		nonce, err := a.v1Proxy.MpoolGetNonce(ctx, address.TestAddress)
+		return err == nil && nonce == 7
+	}, time.Second, cooldown)
+
+	// failures spread out beyond the window don't open the breaker
+	b := newCircuitBreaker("test", 2, time.Minute, cooldown)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	b.observe(outage)
+	now = now.Add(2 * time.Minute)
+	b.observe(outage)
+	require.False(t, b.isOpen())
+	b.observe(context.DeadlineExceeded)
+	require.True(t, b.isOpen())
+}
+
+func TestGatewayTargetCallTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithTargetCallTimeout(10*time.Millisecond))
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	// a call the target doesn't answer in time
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).DoAndReturn(
+		func(ctx context.Context, _ address.Address, _ types.TipSetKey) (*types.Actor, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	_, err = a.v1Proxy.StateGetActor(ctx, addr, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendTimeout)
+	require.ErrorContains(t, err, "StateGetActor took longer than 10ms")
+
+	// one that is answered in time
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).DoAndReturn(
+		func(ctx context.Context, _ address.Address, _ types.TipSetKey) (*types.Actor, error) {
+			_, ok := ctx.Deadline()
+			require.True(t, ok)
+			return &types.Actor{Nonce: 1}, nil
+		})
+	act, err := a.v1Proxy.StateGetActor(ctx, addr, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), act.Nonce)
+
+	// a request canceled by the client isn't a timeout
+	cctx, cancel := context.WithCancel(ctx)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).DoAndReturn(
+		func(ctx context.Context, _ address.Address, _ types.TipSetKey) (*types.Actor, error) {
+			cancel()
+			return nil, ctx.Err()
+		})
+	_, err = a.v1Proxy.StateGetActor(cctx, addr, types.EmptyTSK)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, ErrBackendTimeout)
+
+	// streaming methods aren't bounded
+	v1 := targetTimeoutV1(mockV1, time.Millisecond)
+	noDeadline := func(ctx context.Context) {
+		_, ok := ctx.Deadline()
+		require.False(t, ok)
+	}
+	mockV1.EXPECT().ChainNotify(gomock.Any()).DoAndReturn(func(ctx context.Context) (<-chan []*api.HeadChange, error) {
+		noDeadline(ctx)
+		return make(chan []*api.HeadChange), nil
+	})
+	_, err = v1.ChainNotify(ctx)
+	require.NoError(t, err)
+	mockV1.EXPECT().SubscribeActorEventsRaw(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ *types.ActorEventFilter) (<-chan *types.ActorEvent, error) {
+		noDeadline(ctx)
+		return make(chan *types.ActorEvent), nil
+	})
+	_, err = v1.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{})
+	require.NoError(t, err)
+}
+
+func TestGatewayMaxStaleServeAge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(10), WithServeStaleOnOutage(true), WithMaxStaleServeAge(time.Hour))
+
+	recent, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	old, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	for _, maddr := range []address.Address{recent, old} {
+		info := api.MinerInfo{Owner: maddr}
+		mockV1.EXPECT().StateMinerInfo(gomock.Any(), maddr, types.EmptyTSK).Return(info, nil)
+		_, err := a.v1Proxy.StateMinerInfo(context.Background(), maddr, types.EmptyTSK)
+		require.NoError(t, err)
+	}
+	// one entry was cached longer ago than the maximum age
+	key := minerInfoCacheKey{miner: old, tsk: types.EmptyTSK}
+	e, ok := a.minerInfoCache.lru.Peek(key)
+	require.True(t, ok)
+	e.added = time.Now().Add(-2 * time.Hour)
+	a.minerInfoCache.lru.Add(key, e)
+
+	// the target goes away
+	outage := &jsonrpc.RPCConnectionError{}
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{}, outage).AnyTimes()
+
+	// the recent entry is served stale
+	ctx, headers := withResponseHeaders(context.Background())
+	res, err := a.v1Proxy.StateMinerInfo(ctx, recent, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, recent, res.Owner)
+	require.Equal(t, "true", headers.header().Get(StaleResponseHeader))
+
+	// while the old one is treated as a miss
+	ctx, headers = withResponseHeaders(context.Background())
+	_, err = a.v1Proxy.StateMinerInfo(ctx, old, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.Empty(t, headers.header().Get(StaleResponseHeader))
+}
+
+func TestGatewayTraceConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const limit = 2
+	a := NewNode(mockV1, mockV2, WithTraceConcurrencyLimit(limit), WithRateLimitTimeout(10*time.Millisecond))
+
+	// saturate the limit with a mix of trace methods across both APIs
+	unblock := make(chan struct{})
+	var inflight sync.WaitGroup
+	inflight.Add(limit)
+	mockV1.EXPECT().EthTraceTransaction(gomock.Any(), "0x1").DoAndReturn(func(context.Context, string) ([]*ethtypes.EthTraceTransaction, error) {
+		inflight.Done()
+		<-unblock
+		return nil, nil
+	})
+	mockV2.EXPECT().EthTraceFilter(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, ethtypes.EthTraceFilterCriteria) ([]*ethtypes.EthTraceFilterResult, error) {
+		inflight.Done()
+		<-unblock
+		return nil, nil
+	})
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := a.v1Proxy.EthTraceTransaction(ctx, "0x1")
+		return err
+	})
+	eg.Go(func() error {
+		_, err := a.v2Proxy.EthTraceFilter(ctx, ethtypes.EthTraceFilterCriteria{})
+		return err
+	})
+	inflight.Wait()
+
+	// any other trace method is rejected without reaching the target
+	_, err := a.v1Proxy.EthTraceFilter(ctx, ethtypes.EthTraceFilterCriteria{})
+	require.ErrorContains(t, err, "too many concurrent trace requests")
+	_, err = a.v2Proxy.EthTraceTransaction(ctx, "0x2")
+	require.ErrorContains(t, err, "too many concurrent trace requests")
+
+	// once the in flight requests complete, the slots are available again
+	close(unblock)
+	require.NoError(t, eg.Wait())
+	mockV2.EXPECT().EthTraceTransaction(gomock.Any(), "0x2").Return(nil, nil)
+	_, err = a.v2Proxy.EthTraceTransaction(ctx, "0x2")
+	require.NoError(t, err)
+}
+
+func TestGatewayEthLogsConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const limit = 2
+	a := NewNode(mockV1, mockV2, WithEthLogsConcurrencyLimit(limit), WithTraceConcurrencyLimit(1), WithRateLimitTimeout(10*time.Millisecond))
+
+	// saturate the limit with log queries across both APIs
+	unblock := make(chan struct{})
+	var inflight sync.WaitGroup
+	inflight.Add(limit)
+	blocked := func(context.Context, *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error) {
+		inflight.Done()
+		<-unblock
+		return &ethtypes.EthFilterResult{}, nil
+	}
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), gomock.Any()).DoAndReturn(blocked)
+	mockV2.EXPECT().EthGetLogs(gomock.Any(), gomock.Any()).DoAndReturn(blocked)
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := a.v1Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+		return err
+	})
+	eg.Go(func() error {
+		_, err := a.v2Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+		return err
+	})
+	inflight.Wait()
+
+	// further log queries are rejected without reaching the target
+	_, err := a.v1Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+	require.ErrorContains(t, err, "too many concurrent log requests")
+	_, err = a.v2Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+	require.ErrorContains(t, err, "too many concurrent log requests")
+
+	// but other methods, including the separately limited trace methods, are unaffected
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	id, err := a.v1Proxy.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(314), id)
+	mockV1.EXPECT().EthTraceTransaction(gomock.Any(), "0x1").Return(nil, nil)
+	_, err = a.v1Proxy.EthTraceTransaction(ctx, "0x1")
+	require.NoError(t, err)
+
+	// once the in flight queries complete, the slots are available again
+	close(unblock)
+	require.NoError(t, eg.Wait())
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), gomock.Any()).Return(&ethtypes.EthFilterResult{}, nil)
+	_, err = a.v1Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+	require.NoError(t, err)
+}
+
+func TestGatewayMessageSearchConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const limit = 2
+	a := NewNode(mockV1, mockV2, WithMessageSearchConcurrencyLimit(limit), WithEthLogsConcurrencyLimit(1), WithRateLimitTimeout(10*time.Millisecond))
+	msg := mock.MkBlock(nil, 1, 1).Cid()
+
+	// saturate the limit with a search and a wait, which share it
+	unblock := make(chan struct{})
+	var inflight sync.WaitGroup
+	inflight.Add(limit)
+	mockV1.EXPECT().StateSearchMsg(gomock.Any(), types.EmptyTSK, msg, gomock.Any(), true).DoAndReturn(
+		func(context.Context, types.TipSetKey, cid.Cid, abi.ChainEpoch, bool) (*api.MsgLookup, error) {
+			inflight.Done()
+			<-unblock
+			return &api.MsgLookup{}, nil
+		})
+	mockV1.EXPECT().StateWaitMsg(gomock.Any(), msg, uint64(1), gomock.Any(), true).DoAndReturn(
+		func(context.Context, cid.Cid, uint64, abi.ChainEpoch, bool) (*api.MsgLookup, error) {
+			inflight.Done()
+			<-unblock
+			return &api.MsgLookup{}, nil
+		})
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		_, err := a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, 10, true)
+		return err
+	})
+	eg.Go(func() error {
+		_, err := a.v1Proxy.StateWaitMsg(ctx, msg, 1, 10, true)
+		return err
+	})
+	inflight.Wait()
+
+	// further searches and waits are rejected without reaching the target
+	_, err := a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, 10, true)
+	require.ErrorContains(t, err, "too many concurrent message search requests")
+	_, err = a.v1Proxy.StateWaitMsg(ctx, msg, 1, 10, true)
+	require.ErrorContains(t, err, "too many concurrent message search requests")
+
+	// but other methods, including the separately limited log queries, are unaffected
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	id, err := a.v1Proxy.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(314), id)
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), gomock.Any()).Return(&ethtypes.EthFilterResult{}, nil)
+	_, err = a.v1Proxy.EthGetLogs(ctx, &ethtypes.EthFilterSpec{})
+	require.NoError(t, err)
+
+	// once the in flight requests complete, the slots are available again
+	close(unblock)
+	require.NoError(t, eg.Wait())
+	mockV1.EXPECT().StateWaitMsg(gomock.Any(), msg, uint64(1), gomock.Any(), true).Return(&api.MsgLookup{}, nil)
+	_, err = a.v1Proxy.StateWaitMsg(ctx, msg, 1, 10, true)
+	require.NoError(t, err)
+}
+
+func TestGatewayDailyTokenQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithDailyTokenQuota(map[Identity]int64{"partner": 6}))
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.dailyQuota.now = func() time.Time { return now }
+
+	head := mock.TipSet(mock.MkBlock(nil, 1, 1))
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	partner := context.WithValue(context.Background(), identityKey, Identity("partner"))
+	other := context.WithValue(context.Background(), identityKey, Identity("other"))
+
+	// ChainHead consumes 2 tokens, spread over two buckets of the window
+	_, err := a.v1Proxy.ChainHead(partner)
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err = a.v1Proxy.ChainHead(partner)
+		require.NoError(t, err)
+	}
+
+	// the quota is used up, and the client is told when enough of it rolls out of the window
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	require.ErrorContains(t, err, "resets at 2026-01-02T12:00:00Z")
+
+	// clients without a quota are unaffected
+	for i := 0; i < 5; i++ {
+		_, err = a.v1Proxy.ChainHead(other)
+		require.NoError(t, err)
+	}
+
+	// the client stays rejected until the window rolls past its first request
+	now = now.Add(dailyQuotaWindow - time.Hour - time.Second)
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	now = now.Add(time.Second)
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.NoError(t, err)
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	require.ErrorContains(t, err, "resets at 2026-01-02T13:00:00Z")
+
+	// tokens for requests that are rejected by the rate limit aren't counted
+	now = now.Add(dailyQuotaWindow)
+	a = NewNode(mockV1, mockV2, WithDailyTokenQuota(map[Identity]int64{"partner": 6}), WithRateLimit(1), WithRateLimitTimeout(time.Millisecond))
+	a.dailyQuota.now = func() time.Time { return now }
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.NoError(t, err)
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.ErrorContains(t, err, "server busy")
+	a.rateLimiter.SetLimit(rate.Inf)
+	for i := 0; i < 2; i++ {
+		_, err = a.v1Proxy.ChainHead(partner)
+		require.NoError(t, err)
+	}
+	_, err = a.v1Proxy.ChainHead(partner)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+}
+
+func TestGatewayMethodRateLimits(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	c := mock.MkBlock(nil, 1, 1).Cid()
+	mockV1.EXPECT().StateReplay(gomock.Any(), types.EmptyTSK, c).Return(&api.InvocResult{}, nil).AnyTimes()
+	mockV1.EXPECT().Version(gomock.Any()).Return(api.APIVersion{}, nil).AnyTimes()
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithRateLimitTimeout(10*time.Millisecond), WithMethodRateLimits(map[string]int{
+		"StateReplay":          1,
+		"eth_chainId":          1,
+		"Filecoin.StateReplya": 1, // a typo, ignored
+	}))
+	require.Len(t, a.methodRateLimiters, 2)
+
+	// the override limits the method to its own rate, although there's no global rate limit
+	_, err := a.v1API.StateReplay(ctx, types.EmptyTSK, c)
+	require.NoError(t, err, "burst should be available")
+	_, err = a.v1API.StateReplay(ctx, types.EmptyTSK, c)
+	require.ErrorContains(t, err, "server busy, too many StateReplay requests")
+
+	// methods without an override aren't affected
+	for i := 0; i < 10; i++ {
+		_, err := a.v1API.Version(ctx)
+		require.NoError(t, err)
+	}
+
+	// Ethereum method names are resolved to the methods they're registered under
+	for i := 0; i < MaxRateLimitTokens/basicRateLimitTokens; i++ {
+		_, err := a.v2API.EthChainId(ctx)
+		require.NoError(t, err)
+	}
+	_, err = a.v2API.EthChainId(ctx)
+	require.ErrorContains(t, err, "server busy, too many EthChainId requests")
+}
+
+func TestGatewayMethodLookbacks(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	epochsPerHour := abi.ChainEpoch(time.Hour / (time.Duration(buildconstants.BlockDelaySecs) * time.Second))
+	head := generateTipSets(3*epochsPerHour, 0)[3*epochsPerHour]
+	twoHoursBack := ethtypes.EthUint64(head.Height() - 2*epochsPerHour).Hex()
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithMaxLookbackDuration(time.Hour), WithMethodLookbackDuration(map[string]time.Duration{
+		"eth_getBlockByNumber":  3 * time.Hour,
+		"EthTraceBlock":         time.Minute,
+		"Filecoin.EthTraceBlok": time.Hour, // a typo, ignored
+	}))
+	require.Len(t, a.methodLookbacks, 2)
+
+	// the override extends the lookback of the method beyond the global one
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), twoHoursBack, false).Return(ethtypes.EthBlock{}, nil)
+	_, err := a.v1API.EthGetBlockByNumber(ctx, twoHoursBack, false)
+	require.NoError(t, err)
+
+	// methods without an override are subject to the global lookback
+	_, err = a.v1API.EthTraceReplayBlockTransactions(ctx, twoHoursBack, []string{"trace"})
+	require.ErrorContains(t, err, "lookbacks of more than 1h0m0s are disallowed")
+
+	// and the rejection names the limit that was exceeded
+	_, err = a.v1API.EthTraceBlock(ctx, ethtypes.EthUint64(head.Height()-epochsPerHour/2).Hex())
+	require.ErrorContains(t, err, "lookbacks of more than 1m0s are disallowed")
+}
+
+func TestGatewayArchiveMethods(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	epochsPerHour := abi.ChainEpoch(time.Hour / (time.Duration(buildconstants.BlockDelaySecs) * time.Second))
+	head := generateTipSets(3*epochsPerHour, 0)[3*epochsPerHour]
+	twoHoursBack := ethtypes.EthUint64(head.Height() - 2*epochsPerHour).Hex()
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2,
+		WithMaxLookbackDuration(time.Hour),
+		WithArchiveMethods([]string{"eth_getBlockByNumber", "EthTraceBlock", "EthTraceBlok"}, 3*time.Hour),
+		WithMethodLookbackDuration(map[string]time.Duration{"EthTraceBlock": time.Minute}),
+	)
+	require.Len(t, a.archiveLookbacks, 2)
+
+	// archive methods reach further back than the global lookback
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), twoHoursBack, false).Return(ethtypes.EthBlock{}, nil)
+	_, err := a.v1API.EthGetBlockByNumber(ctx, twoHoursBack, false)
+	require.NoError(t, err)
+
+	// while other methods keep the global lookback
+	_, err = a.v1API.EthTraceReplayBlockTransactions(ctx, twoHoursBack, []string{"trace"})
+	require.ErrorContains(t, err, "lookbacks of more than 1h0m0s are disallowed")
+
+	// a method's own lookback takes precedence over the archive lookback
+	_, err = a.v1API.EthTraceBlock(ctx, ethtypes.EthUint64(head.Height()-epochsPerHour/2).Hex())
+	require.ErrorContains(t, err, "lookbacks of more than 1m0s are disallowed")
+
+	// the archive lookback never shortens a global lookback reconfigured beyond it
+	require.NoError(t, a.Reconfigure(WithMaxLookbackDuration(4*time.Hour)))
+	lookback, _ := a.maxLookback(context.WithValue(ctx, lookbackMethodKey, "EthGetBlockByNumber"))
+	require.Equal(t, 4*time.Hour, lookback)
+
+	// and is capped
+	a = NewNode(mockV1, mockV2, WithArchiveMethods([]string{"EthGetBlockByNumber"}, 10*MaxArchiveLookbackDuration))
+	lookback, _ = a.maxLookback(context.WithValue(ctx, lookbackMethodKey, "EthGetBlockByNumber"))
+	require.Equal(t, MaxArchiveLookbackDuration, lookback)
+	require.Contains(t, a.features(&a.options), "archive-methods")
+}
+
+func TestGatewayStateDecodeParamsFallback(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateDecodeParamsFallback(true))
+	to, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	// params the target can decode are returned decoded
+	decoded := map[string]interface{}{"Value": "1"}
+	mockV1.EXPECT().StateDecodeParams(gomock.Any(), to, abi.MethodNum(2), []byte{0x81, 0x01}, types.EmptyTSK).Return(decoded, nil)
+	res, err := a.v1Proxy.StateDecodeParams(ctx, to, 2, []byte{0x81, 0x01}, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, decoded, res)
+
+	// while those it can't are returned raw, marked as undecodable
+	mockV1.EXPECT().StateDecodeParams(gomock.Any(), to, abi.MethodNum(99), []byte{0xde, 0xad}, types.EmptyTSK).Return(nil, xerrors.New("method 99 not found on actor"))
+	res, err = a.v1Proxy.StateDecodeParams(ctx, to, 99, []byte{0xde, 0xad}, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, &UndecodedParams{Raw: "dead", Undecodable: true, Error: "method 99 not found on actor"}, res)
+
+	// errors that aren't about the params still fail
+	mockV1.EXPECT().StateDecodeParams(gomock.Any(), to, abi.MethodNum(99), []byte{0xde, 0xad}, types.EmptyTSK).Return(nil, ErrBackendUnavailable)
+	_, err = a.v1Proxy.StateDecodeParams(ctx, to, 99, []byte{0xde, 0xad}, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+
+	// and without the fallback, undecodable params fail
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().StateDecodeParams(gomock.Any(), to, abi.MethodNum(99), []byte{0xde, 0xad}, types.EmptyTSK).Return(nil, xerrors.New("method 99 not found on actor"))
+	_, err = a.v1Proxy.StateDecodeParams(ctx, to, 99, []byte{0xde, 0xad}, types.EmptyTSK)
+	require.ErrorContains(t, err, "method 99 not found on actor")
+}
+
+func TestGatewayTraceReplayMaxResults(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithTraceReplayMaxResults(3))
+
+	tss := generateTipSets(10, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV2.EXPECT().ChainGetTipSet(gomock.Any(), types.TipSetSelectors.Latest).Return(head, nil).AnyTimes()
+
+	replays := func(n int) []*ethtypes.EthTraceReplayBlockTransaction {
+		res := make([]*ethtypes.EthTraceReplayBlockTransaction, n)
+		for i := range res {
+			res[i] = &ethtypes.EthTraceReplayBlockTransaction{TransactionHash: ethtypes.EthHash{byte(i)}}
+		}
+		return res
+	}
+	traceTypes := []string{"trace"}
+
+	sparse := replays(3)
+	mockV1.EXPECT().EthTraceReplayBlockTransactions(gomock.Any(), "latest", traceTypes).Return(sparse, nil)
+	res, err := a.v1Proxy.EthTraceReplayBlockTransactions(ctx, "latest", traceTypes)
+	require.NoError(t, err)
+	require.Equal(t, sparse, res)
+
+	mockV1.EXPECT().EthTraceReplayBlockTransactions(gomock.Any(), "latest", traceTypes).Return(replays(4), nil)
+	_, err = a.v1Proxy.EthTraceReplayBlockTransactions(ctx, "latest", traceTypes)
+	require.ErrorIs(t, err, ErrTooManyTraceResults)
+	require.ErrorContains(t, err, "4 transactions, the maximum is 3")
+
+	mockV2.EXPECT().EthTraceReplayBlockTransactions(gomock.Any(), "latest", traceTypes).Return(replays(4), nil)
+	_, err = a.v2Proxy.EthTraceReplayBlockTransactions(ctx, "latest", traceTypes)
+	require.ErrorIs(t, err, ErrTooManyTraceResults)
+}
+
+func TestGatewayTraceBlockMaxMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithTraceBlockMaxMessages(3))
+
+	tss := generateTipSets(10, 0)
+	head := tss[len(tss)-1]
+	sparse, dense := tss[5], tss[6]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV2.EXPECT().ChainGetTipSet(gomock.Any(), types.TipSetSelectors.Latest).Return(head, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), sparse.Height(), head.Key()).Return(sparse, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), dense.Height(), head.Key()).Return(dense, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetMessagesInTipset(gomock.Any(), sparse.Key()).Return(make([]api.Message, 3), nil).AnyTimes()
+	mockV1.EXPECT().ChainGetMessagesInTipset(gomock.Any(), dense.Key()).Return(make([]api.Message, 4), nil).AnyTimes()
+
+	sparseNum := ethtypes.EthUint64(sparse.Height()).Hex()
+	denseNum := ethtypes.EthUint64(dense.Height()).Hex()
+	traceTypes := []string{"trace"}
+
+	mockV1.EXPECT().EthTraceBlock(gomock.Any(), sparseNum).Return(nil, nil)
+	_, err := a.v1Proxy.EthTraceBlock(ctx, sparseNum)
+	require.NoError(t, err)
+
+	// the dense tipset is rejected without being replayed
+	_, err = a.v1Proxy.EthTraceBlock(ctx, denseNum)
+	require.ErrorIs(t, err, ErrTooManyTraceMessages)
+	require.ErrorContains(t, err, "4 messages, the maximum is 3")
+	require.ErrorContains(t, err, "EthTraceTransaction or StateReplay")
+
+	_, err = a.v1Proxy.EthTraceReplayBlockTransactions(ctx, denseNum, traceTypes)
+	require.ErrorIs(t, err, ErrTooManyTraceMessages)
+
+	_, err = a.v2Proxy.EthTraceBlock(ctx, denseNum)
+	require.ErrorIs(t, err, ErrTooManyTraceMessages)
+
+	_, err = a.v2Proxy.EthTraceReplayBlockTransactions(ctx, denseNum, traceTypes)
+	require.ErrorIs(t, err, ErrTooManyTraceMessages)
+}
+
+func TestGatewayPurgeCaches(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(10))
+
+	tss := generateTipSets(1, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	otherMiner, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	info := api.MinerInfo{Owner: miner, Worker: miner, SectorSize: abi.SectorSize(32 << 30)}
+
+	// populate the cache
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), ts.Key()).Return(info, nil).Times(2)
+	for _, m := range []address.Address{miner, otherMiner, miner} {
+		_, err := a.v1Proxy.StateMinerInfo(ctx, m, ts.Key())
+		require.NoError(t, err)
+	}
+
+	// unknown caches are rejected without purging anything
+	_, err = a.PurgeCaches(ctx, minerInfoCacheName, "nope")
+	require.ErrorContains(t, err, `unknown or disabled cache "nope"`)
+	require.Equal(t, 2, a.minerInfoCache.lru.Len())
+
+	n, err := a.PurgeCaches(ctx, minerInfoCacheName)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// subsequent reads miss and go to the target again
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), miner, ts.Key()).Return(info, nil).Times(1)
+	_, err = a.v1Proxy.StateMinerInfo(ctx, miner, ts.Key())
+	require.NoError(t, err)
+
+	// with no names every cache is purged
+	n, err = a.PurgeCaches(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = a.PurgeCaches(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// disabled caches can't be named
+	_, err = NewNode(mockV1, mockV2).PurgeCaches(ctx, minerInfoCacheName)
+	require.Error(t, err)
+}
+
+func TestGatewayHeadAgeMetric(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	require.NoError(t, view.Register(metrics.GatewayHeadAgeView))
+	defer view.Unregister(metrics.GatewayHeadAgeView)
+	headAge := func() (float64, bool) {
+		rows, err := view.RetrieveData(metrics.GatewayHeadAgeView.Name)
+		require.NoError(t, err)
+		if len(rows) == 0 {
+			return 0, false
+		}
+		return rows[0].Data.(*view.LastValueData).Value, true
+	}
+
+	// a backend that has stalled an hour ago
+	tss := generateTipSets(10, uint64(time.Now().Add(-time.Hour).Unix()))
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).MinTimes(1)
+
+	a := NewNode(mockV1, mockV2, WithHeadAgeSampleInterval(time.Minute))
+	defer func() { require.NoError(t, a.Shutdown(context.Background())) }()
+
+	require.Eventually(t, func() bool {
+		_, ok := headAge()
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	age, _ := headAge()
+	expected := time.Since(time.Unix(int64(head.MinTimestamp()), 0)).Seconds()
+	require.Greater(t, age, 30*time.Minute.Seconds())
+	require.InDelta(t, expected, age, 5)
+}
+
+func TestGatewayDeprecatedMethods(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	require.NoError(t, view.Register(metrics.GatewayDeprecatedMethodCallsView))
+	defer view.Unregister(metrics.GatewayDeprecatedMethodCallsView)
+	calls := func() int64 {
+		rows, err := view.RetrieveData(metrics.GatewayDeprecatedMethodCallsView.Name)
+		require.NoError(t, err)
+		var n int64
+		for _, row := range rows {
+			n += row.Data.(*view.CountData).Value
+		}
+		return n
+	}
+
+	a := NewNode(mockV1, mockV2, WithDeprecatedMethods(map[string]string{"Web3ClientVersion": "use Version"}))
+
+	// a deprecated method still works, but is counted and warned about
+	ctx, headers := withResponseHeaders(context.Background())
+	mockV1.EXPECT().Web3ClientVersion(gomock.Any()).Return("lotus/1.0.0", nil)
+	v, err := a.V1ReverseProxy().Web3ClientVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "lotus/1.0.0", v)
+	require.Equal(t, int64(1), calls())
+	require.Equal(t, `299 lotus-gateway "Web3ClientVersion is deprecated: use Version"`, headers.header().Get("Warning"))
+
+	// as it is on v2
+	mockV2.EXPECT().Web3ClientVersion(gomock.Any()).Return("lotus/1.0.0", nil)
+	_, err = a.V2ReverseProxy().Web3ClientVersion(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), calls())
+
+	// other methods aren't affected
+	ctx, headers = withResponseHeaders(context.Background())
+	mockV1.EXPECT().Version(gomock.Any()).Return(api.APIVersion{Version: "1.0.0"}, nil)
+	_, err = a.V1ReverseProxy().Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), calls())
+	require.Empty(t, headers.header().Get("Warning"))
+}
+
+func TestGatewayMessageLookbackEpochs(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const override = 500
+	a := NewNode(mockV1, mockV2, WithMaxMessageLookbackEpochs(20), WithMessageLookbackEpochs(override))
+
+	msg := mock.MkBlock(nil, 1, 1).Cid()
+	txHash := ethtypes.EthHash{1}
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+
+	// every message search method searches back as far as the override, and no further
+	mockV1.EXPECT().StateSearchMsg(gomock.Any(), types.EmptyTSK, msg, abi.ChainEpoch(override), false).Return(nil, nil)
+	_, err := a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, api.LookbackNoLimit, false)
+	require.NoError(t, err)
+	mockV1.EXPECT().StateWaitMsg(gomock.Any(), msg, uint64(1), abi.ChainEpoch(override), false).Return(nil, nil)
+	_, err = a.v1Proxy.StateWaitMsg(ctx, msg, 1, 1000, false)
+	require.NoError(t, err)
+
+	mockV1.EXPECT().EthGetTransactionByHashLimited(gomock.Any(), &txHash, abi.ChainEpoch(override)).Return(nil, nil)
+	_, err = a.v1Proxy.EthGetTransactionByHash(ctx, &txHash)
+	require.NoError(t, err)
+	mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, abi.ChainEpoch(override)).Return(nil, nil)
+	_, err = a.v1Proxy.EthGetTransactionReceipt(ctx, txHash)
+	require.NoError(t, err)
+	mockV1.EXPECT().EthGetBlockReceiptsLimited(gomock.Any(), latest, abi.ChainEpoch(override)).Return(nil, nil)
+	_, err = a.v1Proxy.EthGetBlockReceipts(ctx, latest)
+	require.NoError(t, err)
+
+	mockV2.EXPECT().EthGetTransactionByHashLimited(gomock.Any(), &txHash, abi.ChainEpoch(override)).Return(nil, nil).Times(2)
+	_, err = a.v2Proxy.EthGetTransactionByHash(ctx, &txHash)
+	require.NoError(t, err)
+	_, err = a.v2Proxy.EthGetTransactionByHashLimited(ctx, &txHash, api.LookbackNoLimit)
+	require.NoError(t, err)
+	mockV2.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, abi.ChainEpoch(override)).Return(nil, nil).Times(2)
+	_, err = a.v2Proxy.EthGetTransactionReceipt(ctx, txHash)
+	require.NoError(t, err)
+	_, err = a.v2Proxy.EthGetTransactionReceiptLimited(ctx, txHash, 1000)
+	require.NoError(t, err)
+	mockV2.EXPECT().EthGetBlockReceiptsLimited(gomock.Any(), latest, abi.ChainEpoch(override)).Return(nil, nil).Times(2)
+	_, err = a.v2Proxy.EthGetBlockReceipts(ctx, latest)
+	require.NoError(t, err)
+	_, err = a.v2Proxy.EthGetBlockReceiptsLimited(ctx, latest, api.LookbackNoLimit)
+	require.NoError(t, err)
+
+	// while shallower searches are left as requested
+	mockV2.EXPECT().EthGetBlockReceiptsLimited(gomock.Any(), latest, abi.ChainEpoch(10)).Return(nil, nil)
+	_, err = a.v2Proxy.EthGetBlockReceiptsLimited(ctx, latest, 10)
+	require.NoError(t, err)
+
+	// and the override can be reconfigured, falling back to the maximum message lookback once unset
+	require.NoError(t, a.Reconfigure(WithMessageLookbackEpochs(0)))
+	mockV1.EXPECT().StateSearchMsg(gomock.Any(), types.EmptyTSK, msg, abi.ChainEpoch(20), false).Return(nil, nil)
+	_, err = a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, api.LookbackNoLimit, false)
+	require.NoError(t, err)
+}
+
+func TestGatewayMaxReplacedMessageLookback(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithMaxMessageLookbackEpochs(20), WithMaxReplacedMessageLookbackEpochs(5))
+
+	msg := mock.MkBlock(nil, 1, 1).Cid()
+	for _, tc := range []struct {
+		limit         abi.ChainEpoch
+		allowReplaced bool
+		expected      abi.ChainEpoch
+	}{
+		{limit: api.LookbackNoLimit, allowReplaced: false, expected: 20},
+		{limit: 30, allowReplaced: false, expected: 20},
+		{limit: 10, allowReplaced: false, expected: 10},
+		{limit: 0, allowReplaced: false, expected: 0}, // 0 only searches the starting tipset
+		{limit: api.LookbackNoLimit, allowReplaced: true, expected: 5},
+		{limit: 10, allowReplaced: true, expected: 5},
+		{limit: 3, allowReplaced: true, expected: 3},
+		{limit: 0, allowReplaced: true, expected: 0},
+	} {
+		mockV1.EXPECT().StateSearchMsg(gomock.Any(), types.EmptyTSK, msg, tc.expected, tc.allowReplaced).Return(nil, nil)
+		_, err := a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, tc.limit, tc.allowReplaced)
+		require.NoError(t, err)
+
+		mockV1.EXPECT().StateWaitMsg(gomock.Any(), msg, uint64(1), tc.expected, tc.allowReplaced).Return(nil, nil)
+		_, err = a.v1Proxy.StateWaitMsg(ctx, msg, 1, tc.limit, tc.allowReplaced)
+		require.NoError(t, err)
+	}
+
+	// the replaced search limit never loosens the normal one
+	require.NoError(t, a.Reconfigure(WithMaxReplacedMessageLookbackEpochs(50)))
+	mockV1.EXPECT().StateSearchMsg(gomock.Any(), types.EmptyTSK, msg, abi.ChainEpoch(20), true).Return(nil, nil)
+	_, err := a.v1Proxy.StateSearchMsg(ctx, types.EmptyTSK, msg, api.LookbackNoLimit, true)
+	require.NoError(t, err)
+}
+
+func TestGatewayActorStateMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithActorStateMaxEntries(10))
+
+	tss := generateTipSets(1, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil).AnyTimes()
+
+	// a small actor state is returned
+	small, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	state := map[string]interface{}{"Owner": "f01001", "Beneficiaries": []interface{}{"f01002", "f01003"}}
+	mockV1.EXPECT().StateReadState(gomock.Any(), small, head.Key()).Return(&api.ActorState{State: state}, nil)
+	res, err := a.v1Proxy.StateReadState(ctx, small, head.Key())
+	require.NoError(t, err)
+	require.Equal(t, state, res.State)
+
+	// while a huge one, with entries nested beneath a few fields, is rejected
+	huge, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	proposals := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		proposals[fmt.Sprint(i)] = map[string]interface{}{"Client": "f01002"}
+	}
+	mockV1.EXPECT().StateReadState(gomock.Any(), huge, head.Key()).Return(&api.ActorState{State: map[string]interface{}{"Proposals": proposals}}, nil)
+	_, err = a.v1Proxy.StateReadState(ctx, huge, head.Key())
+	require.ErrorIs(t, err, ErrActorStateTooComplex)
+
+	// byte strings count as single values
+	require.False(t, exceedsEntries(struct{ Data []byte }{Data: make([]byte, 100)}, 10))
+	require.True(t, exceedsEntries(&struct{ Sectors []uint64 }{Sectors: make([]uint64, 11)}, 10))
+}
+
+func TestGatewayStateListMinersWithPower(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const concurrency = 2
+	a := NewNode(mockV1, mockV2, WithMinerPowerConcurrency(concurrency))
+
+	tss := generateTipSets(1, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil)
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil)
+
+	miners := make([]address.Address, 10)
+	for i := range miners {
+		var err error
+		miners[i], err = address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+	}
+	mockV1.EXPECT().StateListMiners(gomock.Any(), head.Key()).Return(miners, nil)
+
+	// every other miner has power, and each check takes a while so that they overlap
+	var inFlight, maxInFlight atomic.Int32
+	mockV1.EXPECT().StateMinerPower(gomock.Any(), gomock.Any(), head.Key()).DoAndReturn(func(_ context.Context, m address.Address, _ types.TipSetKey) (*api.MinerPower, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			prev := maxInFlight.Load()
+			if n <= prev || maxInFlight.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		id, _ := address.IDFromAddress(m)
+		qaPower := big.Zero()
+		if id%2 == 0 {
+			qaPower = big.NewInt(1 << 40)
+		}
+		return &api.MinerPower{MinerPower: power.Claim{RawBytePower: qaPower, QualityAdjPower: qaPower}}, nil
+	}).Times(len(miners))
+
+	withPower, err := a.v1Proxy.StateListMinersWithPower(ctx, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{miners[0], miners[2], miners[4], miners[6], miners[8]}, withPower)
+	require.Equal(t, int32(concurrency), maxInFlight.Load(), "power checks should be bounded by the concurrency limit")
+}
+
+func TestGatewayStateInspectActor(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2)
+
+	tss := generateTipSets(1, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil).AnyTimes()
+
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Code: mock.MkBlock(nil, 1, 1).Cid(), Nonce: 3, Balance: big.NewInt(100)}
+	state := map[string]interface{}{"Owner": "f01001"}
+
+	// both calls are made against the same, pinned, head
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, head.Key()).Return(act, nil)
+	mockV1.EXPECT().StateReadState(gomock.Any(), addr, head.Key()).Return(&api.ActorState{Balance: act.Balance, Code: act.Code, State: state}, nil)
+	res, err := a.v1Proxy.StateInspectActor(ctx, addr, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, &api.ActorInspection{Actor: act, State: state}, res)
+
+	// a state that fails to decode is flagged, and the actor still returned
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, head.Key()).Return(act, nil)
+	mockV1.EXPECT().StateReadState(gomock.Any(), addr, head.Key()).Return(nil, xerrors.New("failed to decode actor state"))
+	res, err = a.v1Proxy.StateInspectActor(ctx, addr, head.Key())
+	require.NoError(t, err)
+	require.Equal(t, act, res.Actor)
+	require.Nil(t, res.State)
+	require.Equal(t, "failed to decode actor state", res.StateError)
+
+	// a missing actor is an error
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, head.Key()).Return(nil, xerrors.New("actor not found"))
+	mockV1.EXPECT().StateReadState(gomock.Any(), addr, head.Key()).Return(nil, xerrors.New("actor not found")).AnyTimes()
+	_, err = a.v1Proxy.StateInspectActor(ctx, addr, head.Key())
+	require.ErrorContains(t, err, "actor not found")
+}
+
+func TestGatewayEthSimulationTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	const timeout = 50 * time.Millisecond
+	a := NewNode(mockV1, mockV2, WithEthSimulationTimeout(timeout))
+	tss := generateTipSets(1, 0)
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(tss[0], nil).AnyTimes()
+
+	var tx ethtypes.EthCall
+	latest := ethtypes.NewEthBlockNumberOrHashFromPredefined("latest")
+
+	// a slow simulation is cancelled at the timeout
+	slowCall := func(ctx context.Context, _ ethtypes.EthCall, _ ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Second):
+			return ethtypes.EthBytes{1}, nil
+		}
+	}
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).DoAndReturn(slowCall)
+	start := time.Now()
+	_, err := a.v1Proxy.EthCall(ctx, tx, latest)
+	require.ErrorIs(t, err, ErrSimulationTimedOut)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	mockV2.EXPECT().EthEstimateGas(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ jsonrpc.RawParams) (ethtypes.EthUint64, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	params, err := json.Marshal(ethtypes.EthEstimateGasParams{Tx: tx})
+	require.NoError(t, err)
+	_, err = a.v2Proxy.EthEstimateGas(ctx, params)
+	require.ErrorIs(t, err, ErrSimulationTimedOut)
+
+	// a fast one isn't affected
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).Return(ethtypes.EthBytes{2}, nil)
+	res, err := a.v1Proxy.EthCall(ctx, tx, latest)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthBytes{2}, res)
+
+	// nor is a call cancelled by the client
+	cctx, cancel := context.WithCancel(ctx)
+	mockV1.EXPECT().EthCall(gomock.Any(), tx, latest).DoAndReturn(func(ctx context.Context, tx ethtypes.EthCall, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
+		cancel()
+		return slowCall(ctx, tx, blkParam)
+	})
+	_, err = a.v1Proxy.EthCall(cctx, tx, latest)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, ErrSimulationTimedOut)
+}
+
+func TestGatewayDefaultFinalizedReads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithDefaultFinalizedReads(true))
+
+	tss := generateTipSets(2, 0)
+	finalized := tss[1]
+	cert := &certs.FinalityCertificate{
+		ECChain: &gpbft.ECChain{
+			TipSets: []*gpbft.TipSet{{
+				Epoch: int64(finalized.Height()),
+				Key:   finalized.Key().Bytes(),
+			}},
+		},
+	}
+
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Nonce: 3, Balance: big.NewInt(100)}
+
+	// an empty tipset key reads from the finalized tipset
+	ctx, headers := withResponseHeaders(context.Background())
+	mockV1.EXPECT().F3GetLatestCertificate(gomock.Any()).Return(cert, nil)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, finalized.Key()).Return(act, nil)
+	res, err := a.v1Proxy.StateGetActor(ctx, addr, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, act, res)
+	require.Empty(t, headers.header().Get(UnfinalizedResponseHeader))
+
+	// an explicit tipset key is left alone
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), tss[0].Key()).Return(tss[0], nil)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, tss[0].Key()).Return(act, nil)
+	_, err = a.v1Proxy.StateGetActor(ctx, addr, tss[0].Key())
+	require.NoError(t, err)
+
+	// without F3 the read falls back to the head, and says so
+	ctx, headers = withResponseHeaders(context.Background())
+	mockV1.EXPECT().F3GetLatestCertificate(gomock.Any()).Return(nil, xerrors.New("f3 is not running"))
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).Return(act, nil)
+	_, err = a.v1Proxy.StateGetActor(ctx, addr, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, "true", headers.header().Get(UnfinalizedResponseHeader))
+
+	// when disabled, the head is read without consulting F3
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).Return(act, nil)
+	_, err = a.v1Proxy.StateGetActor(context.Background(), addr, types.EmptyTSK)
+	require.NoError(t, err)
+}
+
+func TestGatewayActorEventSubscriptionMaxBackfill(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithActorEventSubscriptionMaxBackfill(10))
+
+	tss := generateTipSets(20, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	for _, ts := range tss {
+		mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+	}
+
+	// more history than allowed is rejected, whether requested by height or by tipset
+	tooFar := abi.ChainEpoch(5)
+	_, err := a.v1Proxy.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{FromHeight: &tooFar})
+	require.ErrorIs(t, err, ErrActorEventBackfillTooLong)
+	tsk := tss[5].Key()
+	_, err = a.v1Proxy.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{TipSetKey: &tsk})
+	require.ErrorIs(t, err, ErrActorEventBackfillTooLong)
+
+	// a bounded backfill, or none at all, is passed through
+	ch := make(<-chan *types.ActorEvent)
+	bounded := abi.ChainEpoch(15)
+	mockV1.EXPECT().SubscribeActorEventsRaw(gomock.Any(), gomock.Any()).Return(ch, nil).Times(3)
+	res, err := a.v1Proxy.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{FromHeight: &bounded})
+	require.NoError(t, err)
+	require.Equal(t, ch, res)
+	tsk = tss[15].Key()
+	_, err = a.v1Proxy.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{TipSetKey: &tsk})
+	require.NoError(t, err)
+	_, err = a.v1Proxy.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{})
+	require.NoError(t, err)
+}
+
+func TestGatewayDeepHealth(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// the check isn't held up by rate limiting
+	a := NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(time.Millisecond))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+
+	tss := generateTipSets(2, 0)
+	head, parent := tss[2], tss[1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).Times(2)
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), parent.Key()).Return(parent, nil).Times(2)
+
+	// a failing intermediate stage is reported, and the stages after it skipped
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), parent.ParentState()).Return(nil, xerrors.New("blockstore: not found"))
+	res, err := a.v1Proxy.GatewayDeepHealth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &api.GatewayHealth{FailedStage: "object", Error: "blockstore: not found"}, res)
+
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), parent.ParentState()).Return([]byte{0x80}, nil)
+	mockV1.EXPECT().StateNetworkVersion(gomock.Any(), parent.Key()).Return(buildconstants.TestNetworkVersion, nil)
+	res, err = a.v1Proxy.GatewayDeepHealth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &api.GatewayHealth{Healthy: true}, res)
+}
+
+func TestGatewayHealth(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithMaxLookbackDuration(time.Hour))
+
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(nil, xerrors.New("connection refused"))
+	res, err := a.v1Proxy.GatewayHealth(ctx)
+	require.NoError(t, err)
+	require.False(t, res.Healthy)
+	require.Equal(t, "head", res.FailedStage)
+	require.Equal(t, "connection refused", res.Error)
+
+	// a head timestamped within the lookback
+	genesis := uint64(time.Now().Add(-50*time.Minute).Unix()) - 10*buildconstants.BlockDelaySecs
+	head := generateTipSets(10, genesis)[10]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil)
+	res, err = a.v1Proxy.GatewayHealth(ctx)
+	require.NoError(t, err)
+	require.True(t, res.Healthy, res.Error)
+	require.Equal(t, abi.ChainEpoch(10), res.HeadHeight)
+	require.Less(t, res.HeadAge, time.Hour)
+
+	// a head older than the lookback
+	head = generateTipSets(10, genesis-uint64(time.Hour/time.Second))[10]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil)
+	res, err = a.v1Proxy.GatewayHealth(ctx)
+	require.NoError(t, err)
+	require.False(t, res.Healthy)
+	require.Equal(t, "head-age", res.FailedStage)
+	require.Equal(t, abi.ChainEpoch(10), res.HeadHeight)
+	require.Greater(t, res.HeadAge, time.Hour)
+
+	// the check is rate limited
+	a = NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(time.Millisecond))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	_, err = a.v1Proxy.GatewayHealth(ctx)
+	require.ErrorContains(t, err, "server busy")
+}
+
+func TestGatewayMaintenanceMode(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2)
+	v1, v2 := a.V1ReverseProxy(), a.V2ReverseProxy()
+
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err := v1.EthChainId(ctx)
+	require.NoError(t, err)
+
+	// proxied methods are rejected without reaching the target
+	a.SetMaintenanceMode(true)
+	_, err = v1.EthChainId(ctx)
+	require.ErrorIs(t, err, ErrUnderMaintenance)
+	_, err = v1.ChainHead(ctx)
+	require.ErrorIs(t, err, ErrUnderMaintenance)
+	_, err = v2.EthBlockNumber(ctx)
+	require.ErrorIs(t, err, ErrUnderMaintenance)
+
+	// while the health and status methods respond
+	tss := generateTipSets(2, 0)
+	head, parent := tss[2], tss[1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil)
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), parent.Key()).Return(parent, nil)
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), parent.ParentState()).Return([]byte{0x80}, nil)
+	mockV1.EXPECT().StateNetworkVersion(gomock.Any(), parent.Key()).Return(buildconstants.TestNetworkVersion, nil)
+	health, err := v1.GatewayDeepHealth(ctx)
+	require.NoError(t, err)
+	require.True(t, health.Healthy)
+	_, err = v1.Discover(ctx)
+	require.NoError(t, err)
+	_, err = v2.Discover(ctx)
+	require.NoError(t, err)
+
+	// and once maintenance is over, requests are served again
+	a.SetMaintenanceMode(false)
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err = v1.EthChainId(ctx)
+	require.NoError(t, err)
+}
+
+func TestGatewayClose(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// a subscription that the target never ends by itself
+	heads := make(chan []*api.HeadChange)
+	subscribed := make(chan struct{})
+	mockV1.EXPECT().ChainNotify(gomock.Any()).DoAndReturn(func(context.Context) (<-chan []*api.HeadChange, error) {
+		close(subscribed)
+		return heads, nil
+	})
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(nil, xerrors.New("boom")).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithActorCache(10, 0), WithPerIPRateLimit(1), WithHeadAgeSampleInterval(time.Second))
+	v1, v2 := a.V1ReverseProxy(), a.V2ReverseProxy()
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err := v2.EthChainId(ctx)
+	require.NoError(t, err)
+	<-subscribed
+
+	// Close returns once the background work has stopped
+	closed := make(chan struct{})
+	go func() {
+		require.NoError(t, a.Close())
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return")
+	}
+	require.NoError(t, a.Close(), "closing again should be safe")
+
+	// and every method is rejected from then on, including the status methods
+	_, err = v2.EthChainId(ctx)
+	require.ErrorIs(t, err, ErrGatewayClosed)
+	_, err = v1.ChainHead(ctx)
+	require.ErrorIs(t, err, ErrGatewayClosed)
+	_, err = v1.Version(ctx)
+	require.ErrorIs(t, err, ErrGatewayClosed)
+	_, err = v1.ChainNotify(ctx)
+	require.ErrorIs(t, err, ErrGatewayClosed)
+}
+
+func TestGatewayProfiles(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// the public profile disables tracing, without reaching the target, and sets the safety caps
+	public := NewNode(mockV1, mockV2, PublicProfile()...)
+	_, err := public.V1ReverseProxy().EthTraceBlock(ctx, "latest")
+	requireMethodDisabled(t, err)
+	_, err = public.V2ReverseProxy().EthTraceFilter(ctx, ethtypes.EthTraceFilterCriteria{})
+	requireMethodDisabled(t, err)
+	require.True(t, public.options.sanitizeErrors)
+	require.NotEqual(t, rate.Inf, public.rateLimiter.Limit())
+	require.Positive(t, public.writeRateLimiter.Burst())
+	require.Equal(t, DefaultMaxLookbackDuration, public.currentSettings().maxLookbackDuration)
+	require.Positive(t, public.ethLogsMaxAddresses)
+	require.Positive(t, public.ethTxMaxSize)
+	require.Positive(t, public.replayMaxResultSize)
+	require.Positive(t, public.actorStateMaxEntries)
+	require.NotNil(t, public.logsConcurrency)
+
+	// the partner profile falls back to the public caps, but enables tracing with limits
+	partner := NewNode(mockV1, mockV2, PartnerProfile()...)
+	require.Empty(t, partner.disabledMethods)
+	require.NotNil(t, partner.traceConcurrency)
+	require.Positive(t, partner.traceReplayMaxResults)
+	require.Positive(t, partner.traceBlockMaxMessages)
+	require.Equal(t, 7*24*time.Hour, partner.currentSettings().maxLookbackDuration)
+	require.True(t, partner.options.sanitizeErrors)
+	require.Equal(t, public.ethLogsMaxAddresses, partner.ethLogsMaxAddresses)
+
+	// the internal profile removes the rate limits and sanitization, keeping the concurrency limits
+	internal := NewNode(mockV1, mockV2, InternalProfile()...)
+	require.Equal(t, rate.Inf, internal.rateLimiter.Limit())
+	require.Zero(t, internal.writeRateLimiter.Burst(), "nothing should be reserved for writes")
+	require.False(t, internal.options.sanitizeErrors)
+	require.NotNil(t, internal.traceConcurrency)
+	require.NotNil(t, internal.logsConcurrency)
+	require.Positive(t, internal.ethLogsMaxAddresses)
+
+	// and options appended to a profile take precedence
+	tweaked := NewNode(mockV1, mockV2, append(PublicProfile(), WithRateLimit(500), WithEthLogsMaxAddresses(0))...)
+	require.Equal(t, rateLimit(500), tweaked.rateLimiter.Limit())
+	require.Zero(t, tweaked.ethLogsMaxAddresses)
+	require.True(t, tweaked.disabledMethods["EthTraceBlock"])
+}
+
+func TestGatewayAllowedMethods(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// a read-only gateway
+	a := NewNode(mockV1, mockV2, WithAllowedMethods("ChainHead", "EthChainId"))
+	require.NoError(t, a.Err())
+
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(nil, nil)
+	_, err := a.V1ReverseProxy().ChainHead(ctx)
+	require.NoError(t, err)
+	mockV2.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err = a.V2ReverseProxy().EthChainId(ctx)
+	require.NoError(t, err)
+
+	_, err = a.V1ReverseProxy().MpoolPush(ctx, &types.SignedMessage{})
+	requireMethodDisabled(t, err)
+	_, err = a.V1ReverseProxy().EthSendRawTransaction(ctx, nil)
+	requireMethodDisabled(t, err)
+	_, err = a.V2ReverseProxy().EthSendRawTransaction(ctx, nil)
+	requireMethodDisabled(t, err)
+
+	// methods can be denied outright instead, leaving the rest allowed
+	a = NewNode(mockV1, mockV2, WithDeniedMethods("MpoolPush"))
+	_, err = a.V1ReverseProxy().MpoolPush(ctx, &types.SignedMessage{})
+	requireMethodDisabled(t, err)
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(nil, nil)
+	_, err = a.V1ReverseProxy().ChainHead(ctx)
+	require.NoError(t, err)
+
+	// but not both allowed and denied, which is reported as soon as the node is created
+	a = NewNode(mockV1, mockV2, WithAllowedMethods("ChainHead", "MpoolPush"), WithDeniedMethods("MpoolPush"))
+	require.ErrorContains(t, a.Err(), "methods both allowed and denied: MpoolPush")
+	_, err = Handler(a)
+	require.ErrorContains(t, err, "methods both allowed and denied: MpoolPush")
+	_, err = a.V1ReverseProxy().MpoolPush(ctx, &types.SignedMessage{})
+	requireMethodDisabled(t, err)
+
+	// disabled methods are rejected with the registered method not supported error, which survives
+	// the round trip to the client
+	var merr *api.ErrMethodNotSupported
+	require.ErrorAs(t, err, &merr)
+	jerr, err := merr.ToJSONRPCError()
+	require.NoError(t, err)
+	require.Equal(t, jsonrpc.ErrorCode(api.EMethodNotSupported), jerr.Code)
+	raw, err := json.Marshal(jerr)
+	require.NoError(t, err)
+	var received jsonrpc.JSONRPCError
+	require.NoError(t, json.Unmarshal(raw, &received))
+	var decoded api.ErrMethodNotSupported
+	require.NoError(t, decoded.FromJSONRPCError(received))
+	require.Equal(t, api.ErrMethodNotSupported{Method: "MpoolPush", Disabled: true}, decoded)
+	require.Equal(t, "method MpoolPush is disabled on this gateway", decoded.Error())
+}
+
+// requireMethodDisabled asserts that err rejects a call to a method disabled on the gateway.
+func requireMethodDisabled(t *testing.T, err error) {
+	t.Helper()
+	var merr *api.ErrMethodNotSupported
+	require.ErrorAs(t, err, &merr)
+	require.True(t, merr.Disabled)
+}
+
+func TestGatewayRequestOutcomes(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	require.NoError(t, view.Register(metrics.GatewayRequestOutcomesView))
+	defer view.Unregister(metrics.GatewayRequestOutcomesView)
+	outcomes := func(method string) map[string]int64 {
+		rows, err := view.RetrieveData(metrics.GatewayRequestOutcomesView.Name)
+		require.NoError(t, err)
+		n := make(map[string]int64)
+		for _, row := range rows {
+			var endpoint, outcome string
+			for _, tg := range row.Tags {
+				switch tg.Key {
+				case metrics.Endpoint:
+					endpoint = tg.Value
+				case metrics.Outcome:
+					outcome = tg.Value
+				}
+			}
+			if endpoint == method {
+				n[outcome] += row.Data.(*view.CountData).Value
+			}
+		}
+		return n
+	}
+
+	a := NewNode(mockV1, mockV2, WithEthLogsMaxAddresses(1))
+	v1, v2 := a.V1ReverseProxy(), a.V2ReverseProxy()
+
+	// a success
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err := v1.EthChainId(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"success": 1}, outcomes("EthChainId"))
+
+	// an error returned by the backend is counted as an error
+	mockV2.EXPECT().EthBlockNumber(gomock.Any()).Return(ethtypes.EthUint64(0), xerrors.New("backend failure"))
+	_, err = v2.EthBlockNumber(ctx)
+	require.Error(t, err)
+	require.Equal(t, map[string]int64{"error": 1}, outcomes("EthBlockNumber"))
+
+	// while a request rejected by the gateway's limits isn't
+	_, err = v1.EthGetLogs(ctx, &ethtypes.EthFilterSpec{Address: ethtypes.EthAddressList{{1}, {2}}})
+	require.ErrorIs(t, err, ErrTooManyLogAddresses)
+	require.Equal(t, map[string]int64{"rejected": 1}, outcomes("EthGetLogs"))
+}
+
+func TestRequestOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		err          error
+		targetFailed bool
+		expected     string
+	}{
+		{name: "success", expected: outcomeSuccess},
+		{name: "target error", err: xerrors.New("actor not found"), targetFailed: true, expected: outcomeError},
+		{name: "gateway rejection", err: xerrors.Errorf("%w: 2 addresses, the maximum is 1", ErrTooManyLogAddresses), expected: outcomeRejected},
+		{name: "rate limited", err: &api.ErrConnectionRateLimited{}, expected: outcomeRejected},
+		{name: "backend unavailable", err: xerrors.Errorf("%w: dial failed", ErrBackendUnavailable), expected: outcomeError},
+		{name: "under maintenance", err: ErrUnderMaintenance, expected: outcomeError},
+		{name: "reverted", err: &api.ErrExecutionReverted{Message: "execution reverted"}, targetFailed: true, expected: outcomeRejected},
+		{name: "canceled", err: context.Canceled, targetFailed: true, expected: outcomeRejected},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, requestOutcome(tc.err, tc.targetFailed))
+		})
+	}
+}
+
+func TestGatewayRequestMetrics(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	requestViews := []*view.View{metrics.GatewayRequestsView, metrics.GatewayRequestDurationView, metrics.GatewayRequestErrorsView}
+	require.NoError(t, view.Register(requestViews...))
+	defer view.Unregister(requestViews...)
+	rows := func(v *view.View, method string) map[string]view.AggregationData {
+		rows, err := view.RetrieveData(v.Name)
+		require.NoError(t, err)
+		data := make(map[string]view.AggregationData)
+		for _, row := range rows {
+			var endpoint, class string
+			for _, tg := range row.Tags {
+				switch tg.Key {
+				case metrics.Endpoint:
+					endpoint = tg.Value
+				case metrics.ErrorClass:
+					class = tg.Value
+				}
+			}
+			if endpoint == method {
+				data[class] = row.Data
+			}
+		}
+		return data
+	}
+
+	a := NewNode(mockV1, mockV2, WithRateLimit(1), WithRateLimitTimeout(time.Millisecond))
+	v1 := a.V1ReverseProxy()
+
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(314), nil)
+	_, err := v1.EthChainId(ctx)
+	require.NoError(t, err)
+	mockV1.EXPECT().EthChainId(gomock.Any()).Return(ethtypes.EthUint64(0), xerrors.New("backend failure"))
+	_, err = v1.EthChainId(ctx)
+	require.Error(t, err)
+
+	for a.limit(ctx, 1) == nil { // use up the rate limit
+	}
+	_, err = v1.EthChainId(ctx)
+	require.ErrorContains(t, err, "server busy")
+
+	require.Equal(t, int64(3), rows(metrics.GatewayRequestsView, "EthChainId")[""].(*view.CountData).Value)
+	require.Equal(t, int64(3), rows(metrics.GatewayRequestDurationView, "EthChainId")[""].(*view.DistributionData).Count)
+	errs := rows(metrics.GatewayRequestErrorsView, "EthChainId")
+	require.Len(t, errs, 2)
+	require.Equal(t, int64(1), errs[errorClassTarget].(*view.CountData).Value)
+	require.Equal(t, int64(1), errs[errorClassRateLimited].(*view.CountData).Value)
+}
+
+func TestRequestErrorClass(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := NewNode(v1mocks.NewMockFullNode(ctrl), v2mocks.NewMockFullNode(ctrl), WithMaxLookbackDuration(time.Hour))
+	lookbackErr := a.checkTimestamp(context.Background(), time.Now().Add(-2*time.Hour))
+	require.Error(t, lookbackErr)
+	blockAgeErr := a.checkEthBlockAge(generateTipSets(10, 0)[10], 0, 5)
+	require.Error(t, blockAgeErr)
+
+	for _, tc := range []struct {
+		name         string
+		err          error
+		targetFailed bool
+		expected     string
+	}{
+		{name: "target error", err: xerrors.New("actor not found"), targetFailed: true, expected: errorClassTarget},
+		{name: "backend unavailable", err: xerrors.Errorf("%w: dial failed", ErrBackendUnavailable), expected: errorClassTarget},
+		{name: "rate limited", err: rateLimitRejection{xerrors.New("server busy")}, expected: errorClassRateLimited},
+		{name: "connection rate limited", err: &api.ErrConnectionRateLimited{}, expected: errorClassRateLimited},
+		{name: "quota exceeded", err: xerrors.Errorf("%w: used up", ErrQuotaExceeded), expected: errorClassRateLimited},
+		{name: "lookback", err: xerrors.Errorf("bad tipset: %w", lookbackErr), expected: errorClassLookback},
+		{name: "lookback after a target error", err: lookbackErr, targetFailed: true, expected: errorClassLookback},
+		{name: "block age", err: blockAgeErr, expected: errorClassLookback},
+		{name: "gateway rejection", err: xerrors.Errorf("%w: 2 addresses, the maximum is 1", ErrTooManyLogAddresses), expected: errorClassOther},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, requestErrorClass(tc.err, tc.targetFailed))
+		})
+	}
+}
+
+func TestGatewayEthGetBlockRange(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthBlockCache(100), WithEthBlockRangeMaxSpan(10))
+
+	tss := generateTipSets(policy.ChainFinality+10, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	finalized := ethtypes.EthUint64(head.Height() - policy.ChainFinality)
+
+	var fetchesLk sync.Mutex
+	fetches := make(map[ethtypes.EthUint64]int)
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), gomock.Any(), false).DoAndReturn(
+		func(_ context.Context, blkNum string, _ bool) (ethtypes.EthBlock, error) {
+			num, err := ethtypes.EthUint64FromHex(blkNum)
+			require.NoError(t, err)
+			fetchesLk.Lock()
+			fetches[num]++
+			fetchesLk.Unlock()
+			if num == finalized+2 {
+				return ethtypes.EthBlock{}, api.NewErrNullRound(abi.ChainEpoch(num))
+			}
+			return ethtypes.EthBlock{Number: num}, nil
+		}).AnyTimes()
+	numbers := func(blks []ethtypes.EthBlock) []ethtypes.EthUint64 {
+		var nums []ethtypes.EthUint64
+		for _, blk := range blks {
+			nums = append(nums, blk.Number)
+		}
+		return nums
+	}
+
+	res, err := a.v1Proxy.EthGetBlockRange(ctx, finalized-4, finalized+3, false)
+	require.NoError(t, err)
+	require.Equal(t, []ethtypes.EthUint64{finalized - 4, finalized - 3, finalized - 2, finalized - 1, finalized, finalized + 1, finalized + 3}, numbers(res), "null rounds are omitted")
+
+	// an overlapping range serves the finalized blocks from cache, and fetches the rest again
+	res, err = a.v1Proxy.EthGetBlockRange(ctx, finalized-2, finalized+4, false)
+	require.NoError(t, err)
+	require.Equal(t, []ethtypes.EthUint64{finalized - 2, finalized - 1, finalized, finalized + 1, finalized + 3, finalized + 4}, numbers(res))
+	for num := finalized - 4; num <= finalized; num++ {
+		require.Equal(t, 1, fetches[num], "finalized block %d should have been fetched once", num)
+	}
+	for num := finalized + 1; num <= finalized+3; num++ {
+		require.Equal(t, 2, fetches[num], "unfinalized block %d should have been fetched each time", num)
+	}
+
+	// the span is bounded
+	_, err = a.v1Proxy.EthGetBlockRange(ctx, finalized-10, finalized, false)
+	require.ErrorContains(t, err, "block range too large")
+	_, err = a.v1Proxy.EthGetBlockRange(ctx, finalized, finalized-1, false)
+	require.ErrorContains(t, err, "fromBlock must not be after toBlock")
+}
+
+func TestGatewayEthBlockCache(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthBlockCache(100))
+
+	tss := generateTipSets(policy.ChainFinality+10, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	finalized := ethtypes.EthUint64(head.Height() - policy.ChainFinality)
+
+	// a finalized block is fetched once, for each of fullTxInfo false and true
+	for _, fullTxInfo := range []bool{false, true} {
+		mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), finalized.Hex(), fullTxInfo).Return(ethtypes.EthBlock{Number: finalized}, nil)
+		for i := 0; i < 2; i++ {
+			blk, err := a.v1Proxy.EthGetBlockByNumber(ctx, finalized.Hex(), fullTxInfo)
+			require.NoError(t, err)
+			require.Equal(t, finalized, blk.Number)
+		}
+	}
+
+	// and the cache is shared with EthGetBlockRange
+	res, err := a.v1Proxy.EthGetBlockRange(ctx, finalized, finalized, false)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	// while blocks that aren't final yet, and block tags, are fetched each time
+	unfinalized := finalized + 1
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), unfinalized.Hex(), false).Return(ethtypes.EthBlock{Number: unfinalized}, nil).Times(2)
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), "latest", false).Return(ethtypes.EthBlock{Number: ethtypes.EthUint64(head.Height())}, nil).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := a.v1Proxy.EthGetBlockByNumber(ctx, unfinalized.Hex(), false)
+		require.NoError(t, err)
+		_, err = a.v1Proxy.EthGetBlockByNumber(ctx, "latest", false)
+		require.NoError(t, err)
+	}
+
+	// blocks requested by hash are cached once final too
+	old := tss[finalized]
+	var tskBytes bytes.Buffer
+	require.NoError(t, old.Key().MarshalCBOR(&tskBytes))
+	hash := ethtypes.EthHash{1}
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), hash.ToCid()).Return(tskBytes.Bytes(), nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), old.Key()).Return(old, nil).AnyTimes()
+	mockV1.EXPECT().EthGetBlockByHash(gomock.Any(), hash, false).Return(ethtypes.EthBlock{Hash: hash, Number: finalized}, nil)
+	for i := 0; i < 2; i++ {
+		blk, err := a.v1Proxy.EthGetBlockByHash(ctx, hash, false)
+		require.NoError(t, err)
+		require.Equal(t, hash, blk.Hash)
+	}
+}
+
+func TestGatewayTipSetCache(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	require.NoError(t, view.Register(metrics.GatewayCacheHitRatioView))
+	defer view.Unregister(metrics.GatewayCacheHitRatioView)
+	hitRatio := func() float64 {
+		rows, err := view.RetrieveData(metrics.GatewayCacheHitRatioView.Name)
+		require.NoError(t, err)
+		for _, row := range rows {
+			for _, tg := range row.Tags {
+				if tg.Key == metrics.CacheName && tg.Value == tipSetCacheName {
+					return row.Data.(*view.LastValueData).Value
+				}
+			}
+		}
+		return -1
+	}
+
+	a := NewNode(mockV1, mockV2, WithTipSetCache(100))
+
+	n := policy.ChainFinality + 10
+	tss := generateTipSets(n, uint64(time.Now().Unix())-uint64(n)*buildconstants.BlockDelaySecs)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil).AnyTimes()
+	final := head.Height() - policy.ChainFinality
+
+	// a final tipset is fetched once for each anchor, and separately for each method
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), final, types.EmptyTSK).Return(tss[final], nil)
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), final, head.Key()).Return(tss[final], nil)
+	mockV1.EXPECT().ChainGetTipSetAfterHeight(gomock.Any(), final, types.EmptyTSK).Return(tss[final], nil)
+	for i := 0; i < 2; i++ {
+		ts, err := a.v1Proxy.ChainGetTipSetByHeight(ctx, final, types.EmptyTSK)
+		require.NoError(t, err)
+		require.Equal(t, final, ts.Height())
+		_, err = a.v1Proxy.ChainGetTipSetByHeight(ctx, final, head.Key())
+		require.NoError(t, err)
+		_, err = a.v1Proxy.ChainGetTipSetAfterHeight(ctx, final, types.EmptyTSK)
+		require.NoError(t, err)
+	}
+	require.InDelta(t, 0.5, hitRatio(), 0.001)
+
+	// while tipsets that could still be reorged are fetched each time
+	recent := final + 1
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), recent, types.EmptyTSK).Return(tss[recent], nil).Times(2)
+	for i := 0; i < 2; i++ {
+		ts, err := a.v1Proxy.ChainGetTipSetByHeight(ctx, recent, types.EmptyTSK)
+		require.NoError(t, err)
+		require.Equal(t, recent, ts.Height())
+	}
+	require.InDelta(t, 3.0/8, hitRatio(), 0.001)
+
+	// the cache can be purged like the others
+	evicted, err := a.PurgeCaches(ctx, tipSetCacheName)
+	require.NoError(t, err)
+	require.Equal(t, 3, evicted)
+}
+
+func TestGatewayRequireExplicitTipset(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithRequireExplicitTipset(true))
+
+	tss := generateTipSets(1, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil).AnyTimes()
+
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Nonce: 3, Balance: big.NewInt(100)}
+
+	// reads that would default to the head are rejected
+	_, err = a.V1ReverseProxy().StateGetActor(ctx, addr, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrExplicitTipSetRequired)
+	_, err = a.V1ReverseProxy().ChainGetTipSetByHeight(ctx, 0, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrExplicitTipSetRequired)
+
+	// an explicit tipset, or a method without one, is served
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, head.Key()).Return(act, nil)
+	res, err := a.V1ReverseProxy().StateGetActor(ctx, addr, head.Key())
+	require.NoError(t, err)
+	require.Equal(t, act, res)
+	ts, err := a.V1ReverseProxy().ChainHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, ts)
+
+	// without strict mode an empty tipset key reads from the head
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).Return(act, nil)
+	res, err = a.V1ReverseProxy().StateGetActor(ctx, addr, types.EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, act, res)
+}
+
+func TestGatewayEthLogsMaxAddresses(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthLogsMaxAddresses(2))
+
+	addresses := ethtypes.EthAddressList{{1}, {2}, {3}}
+	tooMany := &ethtypes.EthFilterSpec{Address: addresses}
+	_, err := a.v1Proxy.EthGetLogs(ctx, tooMany)
+	require.ErrorIs(t, err, ErrTooManyLogAddresses)
+	_, err = a.v2Proxy.EthGetLogs(ctx, tooMany)
+	require.ErrorIs(t, err, ErrTooManyLogAddresses)
+	_, err = a.v1Proxy.EthNewFilter(ctx, tooMany)
+	require.ErrorIs(t, err, ErrTooManyLogAddresses)
+
+	ok := &ethtypes.EthFilterSpec{Address: addresses[:2]}
+	res := &ethtypes.EthFilterResult{}
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), ok).Return(res, nil)
+	logs, err := a.v1Proxy.EthGetLogs(ctx, ok)
+	require.NoError(t, err)
+	require.Equal(t, res, logs)
+}
+
+func TestGatewayEthLogsMaxTopicEntries(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthLogsMaxTopicEntries(3))
+	require.Equal(t, DefaultEthLogsMaxAddresses, a.ethLogsMaxAddresses, "the address limit should apply by default")
+
+	tooMany := &ethtypes.EthFilterSpec{Topics: ethtypes.EthTopicSpec{{{1}, {2}}, {{3}, {4}}}}
+	_, err := a.v1Proxy.EthGetLogs(ctx, tooMany)
+	require.ErrorIs(t, err, ErrTooManyLogTopicEntries)
+	_, err = a.v2Proxy.EthNewFilter(ctx, tooMany)
+	require.ErrorIs(t, err, ErrTooManyLogTopicEntries)
+
+	// null positions are wildcards that count as one entry each
+	wildcards := &ethtypes.EthFilterSpec{Topics: ethtypes.EthTopicSpec{nil, {{1}}, nil, {{2}}}}
+	_, err = a.v1Proxy.EthGetLogs(ctx, wildcards)
+	require.ErrorIs(t, err, ErrTooManyLogTopicEntries)
+
+	res := &ethtypes.EthFilterResult{}
+	for _, ok := range []*ethtypes.EthFilterSpec{
+		{Topics: ethtypes.EthTopicSpec{nil, {{1}, {2}}}},
+		{}, // an empty filter
+	} {
+		mockV1.EXPECT().EthGetLogs(gomock.Any(), ok).Return(res, nil)
+		logs, err := a.v1Proxy.EthGetLogs(ctx, ok)
+		require.NoError(t, err)
+		require.Equal(t, res, logs)
+	}
+}
+
+func TestGatewayEthMaxLogsBlockRange(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	require.Equal(t, abi.ChainEpoch(100), head.Height())
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	mockV2.EXPECT().ChainGetTipSet(gomock.Any(), types.TipSetSelectors.Latest).Return(head, nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithEthMaxLogsBlockRange(10))
+	blk := func(s string) *string { return &s }
+
+	for _, tc := range []struct {
+		from, to *string
+		ok       bool
+	}{
+		{from: blk("0x5b"), to: blk("latest"), ok: true},
+		{from: blk("0x5b"), ok: true}, // a missing toBlock is the head
+		{ok: true},
+		{from: blk("0x5a"), to: blk("latest")},
+		{from: blk("0x5a"), to: blk("0x64")},
+		{from: blk("safe"), to: blk("pending")},
+		{from: blk("finalized"), to: blk("safe")},
+		{from: blk("0x5a"), to: blk("0x50"), ok: true}, // an inverted range is left to the target
+	} {
+		filter := &ethtypes.EthFilterSpec{FromBlock: tc.from, ToBlock: tc.to}
+		if tc.ok {
+			mockV1.EXPECT().EthGetLogs(gomock.Any(), filter).Return(&ethtypes.EthFilterResult{}, nil)
+			_, err := a.v1Proxy.EthGetLogs(ctx, filter)
+			require.NoError(t, err)
+			continue
+		}
+		_, err := a.v1Proxy.EthGetLogs(ctx, filter)
+		require.ErrorIs(t, err, ErrLogsBlockRangeTooLarge)
+		_, err = a.v2Proxy.EthGetLogs(ctx, filter)
+		require.ErrorIs(t, err, ErrLogsBlockRangeTooLarge)
+	}
+
+	// "earliest" is the genesis, and a block hash is always a single block
+	err := a.checkEthLogsBlockRange(&ethtypes.EthFilterSpec{FromBlock: blk("earliest")}, head)
+	require.ErrorContains(t, err, "101 epochs, the maximum is 10")
+	require.NoError(t, a.checkEthLogsBlockRange(&ethtypes.EthFilterSpec{BlockHash: &ethtypes.EthHash{1}}, head))
+
+	// without the option there's no limit
+	a = NewNode(mockV1, mockV2)
+	filter := &ethtypes.EthFilterSpec{FromBlock: blk("0x0"), ToBlock: blk("latest")}
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), filter).Return(&ethtypes.EthFilterResult{}, nil)
+	_, err = a.v1Proxy.EthGetLogs(ctx, filter)
+	require.NoError(t, err)
+}
+
+func TestGatewayEthGetLogsPaged(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(100, 0)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithEthLogsPageSpan(10), WithMaxResponseBytes(1000))
+	blk := func(s string) *string { return &s }
+	addr := ethtypes.EthAddressList{{1}}
+	logsFor := func(n int) *ethtypes.EthFilterResult {
+		res := &ethtypes.EthFilterResult{}
+		for i := 0; i < n; i++ {
+			res.Results = append(res.Results, ethtypes.EthLog{LogIndex: ethtypes.EthUint64(i)})
+		}
+		return res
+	}
+	page := func(from, to string) *ethtypes.EthFilterSpec {
+		return &ethtypes.EthFilterSpec{Address: addr, FromBlock: blk(from), ToBlock: blk(to)}
+	}
+
+	// "latest" is resolved on the first page, and the cursor carries the filter to the next
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x50", "0x59")).Return(logsFor(1), nil)
+	res, err := a.v1Proxy.EthGetLogsPaged(ctx, &ethtypes.EthFilterSpec{Address: addr, FromBlock: blk("0x50"), ToBlock: blk("latest")}, "")
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(0x50), res.FromBlock)
+	require.Equal(t, ethtypes.EthUint64(0x59), res.ToBlock)
+	require.Len(t, res.Logs.Results, 1)
+	require.NotEmpty(t, res.Cursor)
+
+	// a page with too many logs for the response is narrowed
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x5a", "0x63")).Return(logsFor(100), nil)
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x5a", "0x5e")).Return(logsFor(2), nil)
+	res, err = a.v1Proxy.EthGetLogsPaged(ctx, nil, res.Cursor)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(0x5a), res.FromBlock)
+	require.Equal(t, ethtypes.EthUint64(0x5e), res.ToBlock)
+	require.NotEmpty(t, res.Cursor)
+
+	// the last page has no cursor
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x5f", "0x64")).Return(logsFor(0), nil)
+	res, err = a.v1Proxy.EthGetLogsPaged(ctx, nil, res.Cursor)
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(0x64), res.ToBlock)
+	require.Empty(t, res.Cursor)
+
+	// a single block is never narrowed further
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x64", "0x64")).Return(logsFor(100), nil)
+	_, err = a.v1Proxy.EthGetLogsPaged(ctx, page("0x64", "0x64"), "")
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	_, err = a.v1Proxy.EthGetLogsPaged(ctx, nil, "not a cursor")
+	require.ErrorIs(t, err, ErrInvalidLogsCursor)
+	_, err = a.v1Proxy.EthGetLogsPaged(ctx, page("0x60", "0x50"), "")
+	require.ErrorContains(t, err, "fromBlock must not be after toBlock")
+
+	// pages are capped by the maximum block range of EthGetLogs
+	a = NewNode(mockV1, mockV2, WithEthMaxLogsBlockRange(5))
+	mockV1.EXPECT().EthGetLogs(gomock.Any(), page("0x0", "0x4")).Return(logsFor(0), nil)
+	res, err = a.v1Proxy.EthGetLogsPaged(ctx, page("earliest", "latest"), "")
+	require.NoError(t, err)
+	require.Equal(t, ethtypes.EthUint64(4), res.ToBlock)
+}
+
+func TestGatewayCacheMissJitter(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(10), WithCacheMissJitter(20*time.Millisecond))
+
+	tss := generateTipSets(1, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	info := api.MinerInfo{Owner: miner, SectorSize: abi.SectorSize(2048)}
+
+	// a herd of requests for a key that's just been missed results in a single target call
+	mockV1.EXPECT().StateMinerInfo(gomock.Any(), miner, ts.Key()).Return(info, nil).Times(1)
+	var eg errgroup.Group
+	for i := 0; i < 50; i++ {
+		eg.Go(func() error {
+			res, err := a.v1Proxy.StateMinerInfo(ctx, miner, ts.Key())
+			if err != nil {
+				return err
+			}
+			if res.Owner != info.Owner {
+				return xerrors.Errorf("unexpected miner info: %v", res)
+			}
+			return nil
+		})
+	}
+	require.NoError(t, eg.Wait())
+
+	// as do herds for final Ethereum blocks and tipsets
+	b := NewNode(mockV1, mockV2, WithEthBlockCache(10), WithTipSetCache(10), WithCacheMissJitter(20*time.Millisecond))
+
+	n := policy.ChainFinality + 10
+	tss = generateTipSets(n, uint64(time.Now().Unix())-uint64(n)*buildconstants.BlockDelaySecs)
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainHead(gomock.Any()).Return(head, nil).AnyTimes()
+	final := head.Height() - policy.ChainFinality
+	finalNum := ethtypes.EthUint64(final)
+
+	mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), finalNum.Hex(), false).Return(ethtypes.EthBlock{Number: finalNum}, nil).Times(1)
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), final, types.EmptyTSK).Return(tss[final], nil).Times(1)
+	for i := 0; i < 50; i++ {
+		eg.Go(func() error {
+			blk, err := b.v1Proxy.EthGetBlockByNumber(ctx, finalNum.Hex(), false)
+			if err != nil {
+				return err
+			}
+			if blk.Number != finalNum {
+				return xerrors.Errorf("unexpected block: %v", blk.Number)
+			}
+			ts, err := b.v1Proxy.ChainGetTipSetByHeight(ctx, final, types.EmptyTSK)
+			if err != nil {
+				return err
+			}
+			if ts.Height() != final {
+				return xerrors.Errorf("unexpected tipset: %v", ts.Height())
+			}
+			return nil
+		})
+	}
+	require.NoError(t, eg.Wait())
+}
+
+func TestGatewayReadCoalescing(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithReadCoalescing(true))
+
+	tss := generateTipSets(1, 0)
+	ts := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), ts.Key()).Return(ts, nil).AnyTimes()
+
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	act := &types.Actor{Nonce: 3, Balance: big.NewInt(100)}
+	slowRead := func(context.Context, address.Address, types.TipSetKey) (*types.Actor, error) {
+		time.Sleep(200 * time.Millisecond) // long enough for every read to arrive while it's in flight
+		return act, nil
+	}
+
+	read := func(n int, tsk types.TipSetKey) error {
+		var eg errgroup.Group
+		for i := 0; i < n; i++ {
+			eg.Go(func() error {
+				res, err := a.v1Proxy.StateGetActor(ctx, addr, tsk)
+				if err != nil {
+					return err
+				}
+				if res.Nonce != act.Nonce {
+					return xerrors.Errorf("unexpected actor: %v", res)
+				}
+				return nil
+			})
+		}
+		return eg.Wait()
+	}
+
+	// identical reads at a tipset are made once
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, ts.Key()).DoAndReturn(slowRead).Times(1)
+	require.NoError(t, read(50, ts.Key()))
+
+	// reads of the head aren't deterministic, so each is made separately
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).DoAndReturn(slowRead).Times(3)
+	require.NoError(t, read(3, types.EmptyTSK))
+}
+
+func TestGatewayRequestCoalescing(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithRequestCoalescing())
+	limit := DefaultMaxMessageLookbackEpochs
+
+	txHash := ethtypes.EthHash{1}
+	receipt := &ethtypes.EthTxReceipt{TransactionHash: txHash, Status: 1}
+	slowReceipt := func(context.Context, ethtypes.EthHash, abi.ChainEpoch) (*ethtypes.EthTxReceipt, error) {
+		time.Sleep(200 * time.Millisecond) // long enough for every lookup to arrive while it's in flight
+		return receipt, nil
+	}
+	lookup := func(n int, lookup func() error) error {
+		var eg errgroup.Group
+		for i := 0; i < n; i++ {
+			eg.Go(lookup)
+		}
+		return eg.Wait()
+	}
+	checkReceipt := func(res *ethtypes.EthTxReceipt, err error) error {
+		if err != nil {
+			return err
+		}
+		if res.TransactionHash != txHash {
+			return xerrors.Errorf("unexpected receipt: %v", res)
+		}
+		return nil
+	}
+
+	// identical receipt lookups are made once, across both API versions' own targets
+	mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, limit).DoAndReturn(slowReceipt).Times(1)
+	require.NoError(t, lookup(50, func() error { return checkReceipt(a.v1Proxy.EthGetTransactionReceipt(ctx, txHash)) }))
+	mockV2.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, limit).DoAndReturn(slowReceipt).Times(1)
+	require.NoError(t, lookup(50, func() error { return checkReceipt(a.v2Proxy.EthGetTransactionReceipt(ctx, txHash)) }))
+
+	// lookups with a different limit aren't identical
+	mockV2.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, limit).DoAndReturn(slowReceipt).Times(1)
+	mockV2.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, abi.ChainEpoch(5)).DoAndReturn(slowReceipt).Times(1)
+	var n atomic.Int64
+	require.NoError(t, lookup(20, func() error {
+		l := limit
+		if n.Add(1)%2 == 0 {
+			l = 5
+		}
+		return checkReceipt(a.v2Proxy.EthGetTransactionReceiptLimited(ctx, txHash, l))
+	}))
+
+	// actor lookups are coalesced even against the head
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	mockV1.EXPECT().StateGetActor(gomock.Any(), addr, types.EmptyTSK).DoAndReturn(func(context.Context, address.Address, types.TipSetKey) (*types.Actor, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &types.Actor{Nonce: 3}, nil
+	}).Times(1)
+	require.NoError(t, lookup(20, func() error {
+		_, err := a.v1Proxy.StateGetActor(ctx, addr, types.EmptyTSK)
+		return err
+	}))
+
+	// a caller giving up on the call it shares doesn't fail the others waiting on it
+	started := make(chan struct{})
+	mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, limit).DoAndReturn(func(ctx context.Context, _ ethtypes.EthHash, _ abi.ChainEpoch) (*ethtypes.EthTxReceipt, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, limit).Return(receipt, nil)
+	cctx, cancel := context.WithCancel(ctx)
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := a.v1Proxy.EthGetTransactionReceipt(cctx, txHash)
+		firstErr <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() { second <- checkReceipt(a.v1Proxy.EthGetTransactionReceipt(ctx, txHash)) }()
+	time.Sleep(50 * time.Millisecond) // let the second lookup join the first
+	cancel()
+	require.ErrorIs(t, <-firstErr, context.Canceled)
+	require.NoError(t, <-second)
+}
+
+func TestGatewayEthTxLimits(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthTxMaxSize(1024), WithEthTxMaxGas(10_000_000))
+
+	rawTx := func(gasLimit int, input []byte) ethtypes.EthBytes {
+		to := ethtypes.EthAddress{1}
+		tx := &ethtypes.Eth1559TxArgs{
+			ChainID:              buildconstants.Eip155ChainId,
+			To:                   &to,
+			Value:                big.Zero(),
+			MaxFeePerGas:         big.NewInt(100),
+			MaxPriorityFeePerGas: big.NewInt(1),
+			GasLimit:             gasLimit,
+			Input:                input,
+		}
+		sig := make([]byte, 65)
+		sig[0], sig[32] = 1, 1
+		require.NoError(t, tx.InitialiseSignature(crypto.Signature{Type: crypto.SigTypeDelegated, Data: sig}))
+		raw, err := tx.ToRlpSignedMsg()
+		require.NoError(t, err)
+		return raw
+	}
+
+	_, err := a.v1Proxy.EthSendRawTransaction(ctx, rawTx(1_000_000, make([]byte, 2048)))
+	require.ErrorIs(t, err, ErrEthTxTooLarge)
+	_, err = a.v2Proxy.EthSendRawTransaction(ctx, rawTx(20_000_000, nil))
+	require.ErrorIs(t, err, ErrEthTxGasTooHigh)
+
+	tx := rawTx(1_000_000, []byte{1, 2, 3})
+	hash := ethtypes.EthHash{1}
+	mockV1.EXPECT().EthSendRawTransactionUntrusted(gomock.Any(), tx).Return(hash, nil)
+	res, err := a.v1Proxy.EthSendRawTransaction(ctx, tx)
+	require.NoError(t, err)
+	require.Equal(t, hash, res)
+}
+
+func TestGatewayStartupGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// the target's head is well beyond the lookback limit, as though it's still syncing
+	tss := generateTipSets(5, uint64(time.Now().Add(-2*DefaultMaxLookbackDuration).Unix()))
+	head := tss[len(tss)-1]
+	mockV1.EXPECT().ChainGetTipSet(gomock.Any(), head.Key()).Return(head, nil).AnyTimes()
+	mockV1.EXPECT().ChainGetTipSetByHeight(gomock.Any(), head.Height(), head.Key()).Return(head, nil).AnyTimes()
+
+	tolerant := NewNode(mockV1, mockV2, WithStartupGracePeriod(time.Hour))
+	rejecting := NewNode(mockV1, mockV2, WithStartupGracePeriod(time.Hour), WithStartupGraceError(true))
+
+	// during the grace period the stale head is either served or rejected as starting up
+	ts, err := tolerant.v1Proxy.ChainGetTipSetByHeight(ctx, head.Height(), head.Key())
+	require.NoError(t, err)
+	require.Equal(t, head, ts)
+	_, err = rejecting.v1Proxy.ChainGetTipSetByHeight(ctx, head.Height(), head.Key())
+	require.ErrorIs(t, err, ErrStartingUp)
+
+	// afterwards the lookback limit applies as normal
+	for _, gw := range []*Node{tolerant, rejecting} {
+		gw.started = time.Now().Add(-2 * time.Hour)
+		_, err = gw.v1Proxy.ChainGetTipSetByHeight(ctx, head.Height(), head.Key())
+		require.ErrorContains(t, err, "lookbacks of more than")
+		require.NotErrorIs(t, err, ErrStartingUp)
+	}
+}
+
+func TestGatewayEthReceiptEffectiveGasPrice(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthReceiptEffectiveGasPrice())
+
+	blkHash := ethtypes.EthHash{2}
+	mockV1.EXPECT().EthGetBlockByHash(gomock.Any(), blkHash, false).Return(ethtypes.EthBlock{
+		BaseFeePerGas: ethtypes.EthBigInt(big.NewInt(100)),
+	}, nil).AnyTimes()
+
+	for _, tc := range []struct {
+		name                 string
+		maxFee, maxPriority  int64
+		gasLimit             ethtypes.EthUint64
+		expEffectiveGasPrice int64
+	}{
+		// the full premium is paid on top of the base fee
+		{name: "premium", maxFee: 1000, maxPriority: 50, gasLimit: 10_000, expEffectiveGasPrice: 150},
+		// the premium is capped by the fee cap
+		{name: "capped premium", maxFee: 120, maxPriority: 50, gasLimit: 10_000, expEffectiveGasPrice: 120},
+		// overestimating the gas limit adds the overestimation burn, of 9,000 gas at the base fee, and
+		// the premium for the unused gas: (100*10,000 + 100*9,000 + 50*20,000) / 10,000
+		{name: "overestimated", maxFee: 1000, maxPriority: 50, gasLimit: 20_000, expEffectiveGasPrice: 290},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			txHash := ethtypes.EthHash{1}
+			maxFee, maxPriority := ethtypes.EthBigInt(big.NewInt(tc.maxFee)), ethtypes.EthBigInt(big.NewInt(tc.maxPriority))
+			mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, gomock.Any()).Return(&ethtypes.EthTxReceipt{
+				TransactionHash: txHash,
+				BlockHash:       blkHash,
+				GasUsed:         10_000,
+			}, nil)
+			mockV1.EXPECT().EthGetTransactionByHashLimited(gomock.Any(), &txHash, gomock.Any()).Return(&ethtypes.EthTx{
+				Hash:                 txHash,
+				Gas:                  tc.gasLimit,
+				MaxFeePerGas:         &maxFee,
+				MaxPriorityFeePerGas: &maxPriority,
+			}, nil)
+
+			receipt, err := a.v1Proxy.EthGetTransactionReceipt(ctx, txHash)
+			require.NoError(t, err)
+			require.Equal(t, big.NewInt(tc.expEffectiveGasPrice), big.Int(receipt.EffectiveGasPrice))
+		})
+	}
+
+	// a receipt with an effective gas price is returned as it is
+	txHash := ethtypes.EthHash{3}
+	mockV1.EXPECT().EthGetTransactionReceiptLimited(gomock.Any(), txHash, gomock.Any()).Return(&ethtypes.EthTxReceipt{
+		TransactionHash:   txHash,
+		BlockHash:         blkHash,
+		GasUsed:           10_000,
+		EffectiveGasPrice: ethtypes.EthBigInt(big.NewInt(7)),
+	}, nil)
+	receipt, err := a.v1Proxy.EthGetTransactionReceipt(ctx, txHash)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(7), big.Int(receipt.EffectiveGasPrice))
+}
+
+func TestGatewayGlobalCacheMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	miner := func(i int) minerInfoCacheKey {
+		addr, err := address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+		return minerInfoCacheKey{miner: addr}
+	}
+	block := func(i int) ethBlockCacheKey { return ethBlockCacheKey{number: ethtypes.EthUint64(i)} }
+	info := api.MinerInfo{Owner: miner(0).miner, Worker: miner(0).miner, SectorSize: abi.SectorSize(32 << 30)}
+	blk := ethtypes.EthBlock{Number: 1}
+	minerSize, blockSize := cacheEntrySize(info), cacheEntrySize(blk)
+	require.Greater(t, blockSize, minerSize)
+
+	// the budget fits four miner infos and two blocks, while each cache could hold far more
+	budget := 4*minerSize + 2*blockSize
+	a := NewNode(mockV1, mockV2, WithStateMinerInfoCache(100), WithEthBlockCache(100), WithGlobalCacheMemoryBudget(budget))
+
+	for i := 0; i < 4; i++ {
+		a.minerInfoCache.add(miner(i), info)
+	}
+	_, ok := a.minerInfoCache.get(ctx, miner(0)) // now the most recently used miner info
+	require.True(t, ok)
+	a.ethBlockCache.add(block(0), blk)
+	a.ethBlockCache.add(block(1), blk)
+	require.Equal(t, budget, a.minerInfoCache.budget.usedBytes())
+	require.Equal(t, 4, a.minerInfoCache.lru.Len())
+
+	// another block takes the caches over budget, evicting as many of the least recently used miner
+	// infos as it takes to make room, which leaves only the most recently used one
+	a.ethBlockCache.add(block(2), blk)
+	require.LessOrEqual(t, a.minerInfoCache.budget.usedBytes(), budget)
+	for i, cached := range []bool{true, false, false, false} {
+		require.Equal(t, cached, a.minerInfoCache.lru.Contains(miner(i)), "miner %d", i)
+	}
+	require.Equal(t, 3, a.ethBlockCache.lru.Len())
+
+	// filling the block cache eventually evicts everything else, and never exceeds the budget
+	for i := 3; i < 20; i++ {
+		a.ethBlockCache.add(block(i), blk)
+		require.LessOrEqual(t, a.ethBlockCache.budget.usedBytes(), budget)
+	}
+	require.Equal(t, 0, a.minerInfoCache.lru.Len())
+	require.Equal(t, int(budget/blockSize), a.ethBlockCache.lru.Len())
+
+	// purged entries are released from the budget
+	_, err := a.PurgeCaches(ctx)
+	require.NoError(t, err)
+	require.Zero(t, a.ethBlockCache.budget.usedBytes())
+}
+
+func TestGatewayChainNotifyCoalescing(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(1, 0)
+	heads := make(chan []*api.HeadChange)
+	var backendCtx context.Context
+	mockV1.EXPECT().ChainNotify(gomock.Any()).DoAndReturn(func(ctx context.Context) (<-chan []*api.HeadChange, error) {
+		backendCtx = ctx
+		return heads, nil
+	})
+
+	a := NewNode(mockV1, mockV2, WithChainNotifyMaxSubscribers(3))
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	subscribe := func() <-chan []*api.HeadChange {
+		sub, err := a.v1Proxy.ChainNotify(subCtx)
+		require.NoError(t, err)
+		return sub
+	}
+	subs := []<-chan []*api.HeadChange{subscribe(), subscribe()}
+
+	// the target's first notification reaches the subscribers that were waiting for it
+	current := []*api.HeadChange{{Type: store.HCCurrent, Val: tss[0]}}
+	heads <- current
+	for _, sub := range subs {
+		require.Equal(t, current, <-sub)
+	}
+
+	// a later subscriber starts with the current head too, without another target subscription
+	subs = append(subs, subscribe())
+	require.Equal(t, current, <-subs[2])
+
+	_, err := a.v1Proxy.ChainNotify(subCtx)
+	require.ErrorIs(t, err, ErrTooManyChainNotifySubscribers)
+
+	// head changes fan out to every subscriber
+	apply := []*api.HeadChange{{Type: store.HCApply, Val: tss[1]}}
+	heads <- apply
+	for _, sub := range subs {
+		require.Equal(t, apply, <-sub)
+	}
+
+	// once every subscriber has gone the target subscription is closed
+	cancel()
+	for _, sub := range subs {
+		_, ok := <-sub
+		require.False(t, ok)
+	}
+	require.Eventually(t, func() bool { return backendCtx.Err() != nil }, 5*time.Second, 10*time.Millisecond)
+	close(heads)
+}
+
+func TestGatewayChainNotifySlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	tss := generateTipSets(4, 0)
+	heads := make(chan []*api.HeadChange)
+	defer close(heads)
+	mockV1.EXPECT().ChainNotify(gomock.Any()).Return(heads, nil)
+
+	const bufferSize = 2
+	a := NewNode(mockV1, mockV2, WithChainNotifySubscriberBuffer(bufferSize))
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fast, err := a.v1Proxy.ChainNotify(subCtx)
+	require.NoError(t, err)
+	slow, err := a.v1Proxy.ChainNotify(subCtx)
+	require.NoError(t, err)
+
+	// the slow subscriber stops reading, and is dropped once its buffer is full
+	sent := make([][]*api.HeadChange, 0, bufferSize+1)
+	for i := 0; i <= bufferSize; i++ {
+		changes := []*api.HeadChange{{Type: store.HCApply, Val: tss[i]}}
+		heads <- changes
+		require.Equal(t, changes, <-fast)
+		sent = append(sent, changes)
+	}
+	for _, changes := range sent[:bufferSize] {
+		require.Equal(t, changes, <-slow)
+	}
+	_, ok := <-slow
+	require.False(t, ok, "slow subscriber should have been dropped")
+
+	// while the other subscriber carries on receiving head changes
+	changes := []*api.HeadChange{{Type: store.HCApply, Val: tss[bufferSize+1]}}
+	heads <- changes
+	require.Equal(t, changes, <-fast)
+}
+
+func TestGatewayEthTxFieldPolicy(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithEthTxFieldPolicy("input", "maxFeePerGas", "nope"))
+
+	maxFee := ethtypes.EthBigInt(big.NewInt(100))
+	tx := ethtypes.EthTx{
+		Hash:         ethtypes.EthHash{1},
+		From:         ethtypes.EthAddress{2},
+		Value:        ethtypes.EthBigInt(big.NewInt(3)),
+		Input:        ethtypes.EthBytes{4, 5, 6},
+		MaxFeePerGas: &maxFee,
+	}
+	requireRedacted := func(t *testing.T, got ethtypes.EthTx) {
+		require.Empty(t, got.Input)
+		require.Nil(t, got.MaxFeePerGas)
+		require.Equal(t, tx.Hash, got.Hash)
+		require.Equal(t, tx.From, got.From)
+		require.Equal(t, tx.Value, got.Value)
+	}
+
+	t.Run("by hash", func(t *testing.T) {
+		mockV1.EXPECT().EthGetTransactionByHashLimited(gomock.Any(), &tx.Hash, gomock.Any()).Return(&tx, nil)
+		got, err := a.v1API.EthGetTransactionByHash(ctx, &tx.Hash)
+		require.NoError(t, err)
+		requireRedacted(t, *got)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		// a remote target's transactions are decoded as JSON objects rather than EthTx
+		b, err := json.Marshal(tx)
+		require.NoError(t, err)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		blk := ethtypes.EthBlock{Transactions: []interface{}{tx, decoded}}
+
+		mockV1.EXPECT().ChainHead(gomock.Any()).Return(generateTipSets(1, 0)[1], nil)
+		mockV1.EXPECT().EthGetBlockByNumber(gomock.Any(), "latest", true).Return(blk, nil)
+		got, err := a.v1API.EthGetBlockByNumber(ctx, "latest", true)
+		require.NoError(t, err)
+		require.Len(t, got.Transactions, 2)
+		requireRedacted(t, got.Transactions[0].(ethtypes.EthTx))
+
+		// the JSON object is left without the optional field, and round trips as redacted
+		obj := got.Transactions[1].(map[string]interface{})
+		require.NotContains(t, obj, "maxFeePerGas")
+		b, err = json.Marshal(obj)
+		require.NoError(t, err)
+		var roundTripped ethtypes.EthTx
+		require.NoError(t, json.Unmarshal(b, &roundTripped))
+		requireRedacted(t, roundTripped)
+
+		// the target's block, which may be shared with a cache, is left as it is
+		require.Equal(t, tx, blk.Transactions[0])
+		require.Contains(t, decoded, "maxFeePerGas")
+	})
+}
+
+func TestGatewayStateReplayMaxResultSize(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithStateReplayMaxResultSize(1024))
+
+	small, large := cid.NewCidV1(cid.Raw, []byte{1}), cid.NewCidV1(cid.Raw, []byte{2})
+	mockV1.EXPECT().StateReplay(gomock.Any(), types.EmptyTSK, small).Return(&api.InvocResult{
+		MsgCid: small,
+		Error:  "ok",
+	}, nil)
+	mockV1.EXPECT().StateReplay(gomock.Any(), types.EmptyTSK, large).Return(&api.InvocResult{
+		MsgCid: large,
+		ExecutionTrace: types.ExecutionTrace{
+			Msg: types.MessageTrace{Params: make([]byte, 2048)},
+		},
+	}, nil)
+
+	res, err := a.v1Proxy.StateReplay(ctx, types.EmptyTSK, small)
+	require.NoError(t, err)
+	require.Equal(t, small, res.MsgCid)
+
+	_, err = a.v1Proxy.StateReplay(ctx, types.EmptyTSK, large)
+	require.ErrorIs(t, err, ErrReplayResultTooLarge)
+}
+
+func TestGatewayMaxResponseBytes(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	a := NewNode(mockV1, mockV2, WithMaxResponseBytes(1024))
+
+	small, large := cid.NewCidV1(cid.Raw, []byte{1}), cid.NewCidV1(cid.Raw, []byte{2})
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), small).Return(make([]byte, 512), nil)
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), large).Return(make([]byte, 1024), nil) // larger once encoded
+	obj, err := a.v1Proxy.ChainReadObj(ctx, small)
+	require.NoError(t, err)
+	require.Len(t, obj, 512)
+	_, err = a.v1Proxy.ChainReadObj(ctx, large)
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	mockV1.EXPECT().ChainGetNode(gomock.Any(), "small").Return(&api.IpldObject{Cid: small, Obj: "ok"}, nil)
+	mockV1.EXPECT().ChainGetNode(gomock.Any(), "large").Return(&api.IpldObject{Cid: large, Obj: strings.Repeat("x", 2048)}, nil)
+	node, err := a.v1Proxy.ChainGetNode(ctx, "small")
+	require.NoError(t, err)
+	require.Equal(t, small, node.Cid)
+	_, err = a.v1Proxy.ChainGetNode(ctx, "large")
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	mockV1.EXPECT().StateReadState(gomock.Any(), address.TestAddress, types.EmptyTSK).Return(&api.ActorState{State: strings.Repeat("x", 2048)}, nil)
+	_, err = a.v1Proxy.StateReadState(ctx, address.TestAddress, types.EmptyTSK)
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	mockV1.EXPECT().ChainGetEvents(gomock.Any(), large).Return([]types.Event{{Entries: []types.EventEntry{{Key: "k", Value: make([]byte, 2048)}}}}, nil)
+	_, err = a.v1Proxy.ChainGetEvents(ctx, large)
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	// without a limit objects of any size are returned
+	a = NewNode(mockV1, mockV2)
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), large).Return(make([]byte, 4096), nil)
+	obj, err = a.v1Proxy.ChainReadObj(ctx, large)
+	require.NoError(t, err)
+	require.Len(t, obj, 4096)
+}
+
+func TestGatewayChainGetEventsStream(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	// an events AMT in a blockstore that the target reads objects from
+	bs := blockstore.NewMemory()
+	var events []types.Event
+	var vals []cbg.CBORMarshaler
+	for i := 0; i < 10; i++ {
+		evt := types.Event{
+			Emitter: abi.ActorID(1000 + i),
+			Entries: []types.EventEntry{{Flags: 0x03, Key: "t1", Codec: 0x55, Value: []byte{byte(i)}}},
+		}
+		events = append(events, evt)
+		vals = append(vals, &evt)
+	}
+	root, err := amt4.FromArray(ctx, cbor.NewCborStore(bs), vals, amt4.UseTreeBitWidth(types.EventAMTBitwidth))
+	require.NoError(t, err)
+
+	mockV1.EXPECT().ChainReadObj(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}).AnyTimes()
+	mockV1.EXPECT().ChainGetEvents(gomock.Any(), root).Return(events, nil).AnyTimes()
+
+	a := NewNode(mockV1, mockV2, WithChainEventsChunkSize(3), WithChainEventsMax(10))
+
+	single, err := a.v1Proxy.ChainGetEvents(ctx, root)
+	require.NoError(t, err)
+
+	chunks, err := a.v1Proxy.ChainGetEventsStream(ctx, root)
+	require.NoError(t, err)
+	var sizes []int
+	var streamed []types.Event
+	for chunk := range chunks {
+		sizes = append(sizes, len(chunk))
+		streamed = append(streamed, chunk...)
+	}
+	require.Equal(t, []int{3, 3, 3, 1}, sizes)
+	require.Equal(t, single, streamed)
+
+	// the overall maximum applies to both
+	a = NewNode(mockV1, mockV2, WithChainEventsChunkSize(3), WithChainEventsMax(9))
+	_, err = a.v1Proxy.ChainGetEvents(ctx, root)
+	require.ErrorIs(t, err, ErrTooManyEvents)
+	_, err = a.v1Proxy.ChainGetEventsStream(ctx, root)
+	require.ErrorIs(t, err, ErrTooManyEvents)
+	require.ErrorContains(t, err, "10 events, the maximum is 9")
+}
+
+func TestGatewayConnectionRateLimitRetryHint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockV1 := v1mocks.NewMockFullNode(ctrl)
+	mockV2 := v2mocks.NewMockFullNode(ctrl)
+	defer ctrl.Finish()
+
+	interval := 100 * time.Millisecond
+	rateLimitTimeout := 10 * time.Millisecond
+	ctx := setPerConnectionAPIRateLimiter(context.Background(), rate.NewLimiter(rate.Every(interval), MaxRateLimitTokens))
+	a := NewNode(mockV1, mockV2, WithRateLimitTimeout(rateLimitTimeout), WithConnectionRateLimitRetryHint(true))
+
+	// the burst is used up, so the next request needs to wait for all of its tokens
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	err := a.limit(ctx, MaxRateLimitTokens)
+	var limited *api.ErrConnectionRateLimited
+	require.ErrorAs(t, err, &limited)
+	require.InDelta(t, float64(interval*MaxRateLimitTokens), float64(limited.RetryAfter), float64(rateLimitTimeout))
+
+	// the hint survives the trip to the client
+	jerr, err := limited.ToJSONRPCError()
+	require.NoError(t, err)
+	b, err := json.Marshal(jerr)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &jerr))
+	var decoded api.ErrConnectionRateLimited
+	require.NoError(t, decoded.FromJSONRPCError(jerr))
+	require.Equal(t, limited.RetryAfter.Round(time.Millisecond), decoded.RetryAfter.Round(time.Millisecond))
+
+	// and waiting for it is enough for a retry to be allowed
+	time.Sleep(limited.RetryAfter)
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+
+	// a wait within the timeout is still waited out rather than rejected
+	ctx = setPerConnectionAPIRateLimiter(context.Background(), rate.NewLimiter(rate.Every(time.Millisecond), MaxRateLimitTokens))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+
+	// without the hint, the rejection is unstructured
+	ctx = setPerConnectionAPIRateLimiter(context.Background(), rate.NewLimiter(rate.Every(interval), MaxRateLimitTokens))
+	a = NewNode(mockV1, mockV2, WithRateLimitTimeout(rateLimitTimeout))
+	require.NoError(t, a.limit(ctx, MaxRateLimitTokens))
+	err = a.limit(ctx, MaxRateLimitTokens)
+	require.ErrorContains(t, err, "connection limited")
+	require.False(t, errors.As(err, &limited))
+}
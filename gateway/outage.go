@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// StaleResponseHeader is set on the HTTP response to a request for which at least one result was
+// served stale from cache because the target was unavailable.
+const StaleResponseHeader = "X-Lotus-Gateway-Stale"
+
+// ErrBackendUnavailable is returned when the target can't be reached and the request can't be
+// served from cache.
+var ErrBackendUnavailable = errors.New("backend unavailable")
+
+// isBackendUnavailable returns true if err indicates that the target couldn't be reached, as
+// opposed to the target returning an error for the request.
+func isBackendUnavailable(err error) bool {
+	var cerr *jsonrpc.RPCConnectionError
+	return errors.As(err, &cerr) || errors.Is(err, ErrBackendUnavailable)
+}
+
+// backendUnavailableV1 wraps the v1 target such that errors from failing to reach the target are
+// translated into ErrBackendUnavailable.
+func backendUnavailableV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	translateErrors(server, &out, translateBackendUnavailable)
+	return &out
+}
+
+// backendUnavailableV2 wraps the v2 target such that errors from failing to reach the target are
+// translated into ErrBackendUnavailable.
+func backendUnavailableV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	translateErrors(server, &out, translateBackendUnavailable)
+	return &out
+}
+
+func translateBackendUnavailable(_ context.Context, method string, err error) error {
+	if !isBackendUnavailable(err) || errors.Is(err, ErrBackendUnavailable) {
+		return err
+	}
+	log.Debugw("backend unavailable", "method", method, "error", err)
+	return xerrors.Errorf("%w: %s", ErrBackendUnavailable, err.Error())
+}
+
+// serveStale returns the cached value for key if the gateway is configured to serve stale data
+// while the target is unavailable and err indicates that it is. Otherwise, or if there is no
+// cached value for key recent enough to serve, err is returned.
+func serveStale[K comparable, V any](ctx context.Context, gw *Node, c *cache[K, V], key K, err error) (V, error) {
+	if !gw.serveStaleOnOutage || c == nil || !isBackendUnavailable(err) {
+		var zero V
+		return zero, err
+	}
+	v, added, ok := c.getStale(ctx, key, gw.maxStaleServeAge)
+	if !ok {
+		return v, err
+	}
+	log.Debugw("serving stale cached response", "cache", c.name, "added", added, "error", err)
+	markStale(ctx)
+	return v, nil
+}
+
+func markStale(ctx context.Context) {
+	setResponseHeader(ctx, StaleResponseHeader, "true")
+}
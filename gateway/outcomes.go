@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+const (
+	outcomeSuccess  = "success"
+	outcomeError    = "error"
+	outcomeRejected = "rejected"
+)
+
+const (
+	errorClassRateLimited = "rate-limited"
+	errorClassLookback    = "lookback-rejected"
+	errorClassTarget      = "target-error"
+	errorClassOther       = "other"
+)
+
+// rateLimitRejection marks an error rejecting a request for exceeding a rate or concurrency limit,
+// for requestErrorClass. Its message is that of the error it wraps, so clients see no difference.
+type rateLimitRejection struct{ error }
+
+func (e rateLimitRejection) Unwrap() error { return e.error }
+
+// lookbackRejection is like rateLimitRejection, but for requests reaching further back in the chain
+// than a lookback limit allows.
+type lookbackRejection struct{ error }
+
+func (e lookbackRejection) Unwrap() error { return e.error }
+
+// requestOutcome classifies the result of a gateway request for the request outcome metrics.
+// targetFailed is whether any call the request made to the target returned an error. Errors
+// returned by the target are counted as errors, while errors the gateway returns itself, such as
+// rate limits and lookback or parameter limits, are rejections caused by the client. The
+// exceptions are that the gateway being unavailable is always an error, and that a reverted
+// execution or a request canceled by the client is always a rejection.
+func requestOutcome(err error, targetFailed bool) string {
+	var reverted *api.ErrExecutionReverted
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case errors.Is(err, ErrBackendUnavailable), errors.Is(err, ErrBackendTimeout), errors.Is(err, ErrStartingUp), errors.Is(err, ErrUnderMaintenance), errors.Is(err, ErrGatewayClosed):
+		return outcomeError
+	case errors.As(err, &reverted), errors.Is(err, context.Canceled):
+		return outcomeRejected
+	case targetFailed:
+		return outcomeError
+	default:
+		return outcomeRejected
+	}
+}
+
+// requestErrorClass classifies an error returned for a gateway request for the request error
+// metrics, where targetFailed is as for requestOutcome. Errors rejecting the request for a rate,
+// concurrency or quota limit, or for a lookback limit, are classified as such even if the target
+// failed along the way, errors from an unavailable target are target errors, and anything else
+// the gateway rejected itself is classified as other.
+func requestErrorClass(err error, targetFailed bool) string {
+	var rateLimited rateLimitRejection
+	var connLimited *api.ErrConnectionRateLimited
+	var lookback lookbackRejection
+	switch {
+	case errors.As(err, &rateLimited), errors.As(err, &connLimited), errors.Is(err, ErrQuotaExceeded):
+		return errorClassRateLimited
+	case errors.As(err, &lookback):
+		return errorClassLookback
+	case targetFailed, errors.Is(err, ErrBackendUnavailable), errors.Is(err, ErrBackendTimeout):
+		return errorClassTarget
+	default:
+		return errorClassOther
+	}
+}
+
+// trackTargetErrorsV1 wraps the v1 target such that errors it returns are noted on the request
+// they're made for, for requestOutcome.
+func trackTargetErrorsV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	translateErrors(server, &out, markTargetFailed)
+	return &out
+}
+
+// trackTargetErrorsV2 wraps the v2 target such that errors it returns are noted on the request
+// they're made for, for requestOutcome.
+func trackTargetErrorsV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	translateErrors(server, &out, markTargetFailed)
+	return &out
+}
+
+func markTargetFailed(ctx context.Context, _ string, err error) error {
+	if failed, ok := ctx.Value(targetFailedKey).(*atomic.Bool); ok {
+		failed.Store(true)
+	}
+	return err
+}
+
+// recordOutcomesV1 wraps the v1 gateway API such that the outcome and duration of each request, and
+// the class of any error it returns, are recorded, tagged with the method name. Errors are also
+// noted on the HTTP request, for the LoggingHandler.
+func recordOutcomesV1(v1 api.Gateway) api.Gateway {
+	var out api.GatewayStruct
+	recordOutcomes(v1, &out)
+	return &out
+}
+
+// recordOutcomesV2 wraps the v2 gateway API such that the outcome and duration of each request, and
+// the class of any error it returns, are recorded, tagged with the method name. Errors are also
+// noted on the HTTP request, for the LoggingHandler.
+func recordOutcomesV2(v2 v2api.Gateway) v2api.Gateway {
+	var out v2api.GatewayStruct
+	recordOutcomes(v2, &out)
+	return &out
+}
+
+func recordOutcomes(in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if fn.Type().NumIn() == 0 || fn.Type().In(0) != contextType || errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			var targetFailed atomic.Bool
+			ctx := context.WithValue(contextArg(args), targetFailedKey, &targetFailed)
+			args[0] = reflect.ValueOf(ctx)
+			start := time.Now()
+			results := fn.Call(args)
+			duration := metrics.SinceInMilliseconds(start)
+
+			err, _ := results[errOut].Interface().(error)
+			if failed, ok := ctx.Value(requestFailedKey).(*atomic.Bool); ok && err != nil {
+				failed.Store(true)
+			}
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{
+				tag.Upsert(metrics.Endpoint, method),
+				tag.Upsert(metrics.Outcome, requestOutcome(err, targetFailed.Load())),
+			}, metrics.GatewayRequestOutcomes.M(1))
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.Endpoint, method)}, metrics.GatewayRequestDuration.M(duration))
+			if err != nil {
+				_ = stats.RecordWithTags(ctx, []tag.Mutator{
+					tag.Upsert(metrics.Endpoint, method),
+					tag.Upsert(metrics.ErrorClass, requestErrorClass(err, targetFailed.Load())),
+				}, metrics.GatewayRequestErrors.M(1))
+			}
+			return results
+		})
+	})
+}
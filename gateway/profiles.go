@@ -0,0 +1,62 @@
+package gateway
+
+import "time"
+
+// traceMethods replay the execution of whole blocks and transactions, making them the most
+// expensive methods the gateway serves.
+var traceMethods = []string{"EthTraceBlock", "EthTraceReplayBlockTransactions", "EthTraceTransaction", "EthTraceFilter"}
+
+// PublicProfile returns the options for a gateway open to anonymous clients: target errors are
+// sanitized, the trace methods are disabled, and every request and response size cap is set.
+//
+// Profiles are a starting point rather than a fixed configuration. Options are applied in order,
+// so options appended to a profile take precedence over the profile's own, e.g.
+//
+//	gateway.NewNode(v1, v2, append(gateway.PublicProfile(), gateway.WithRateLimit(500))...)
+func PublicProfile() []Option {
+	return []Option{
+		WithMaxLookbackDuration(DefaultMaxLookbackDuration),
+		WithMaxMessageLookbackEpochs(DefaultMaxMessageLookbackEpochs),
+		WithRateLimit(1000),
+		WithWriteReservedRateLimit(50),
+		WithEthMaxFiltersPerHost(4 * DefaultEthMaxFiltersPerConn),
+		WithEthLogsMaxAddresses(32),
+		WithEthLogsConcurrencyLimit(8),
+		WithEthBatchMaxBlockParams(16),
+		WithEthTxMaxSize(128 << 10),
+		WithStateReplayMaxResultSize(4 << 20),
+		WithActorStateMaxEntries(10000),
+		WithChainEventsMax(10000),
+		WithMpoolPendingMaxMessages(1000),
+		WithMpoolPendingMaxAddresses(32),
+		WithDisabledMethods(traceMethods...),
+		WithErrorSanitization(true),
+	}
+}
+
+// PartnerProfile returns the options for a gateway serving known partners. It falls back to
+// PublicProfile for anything it doesn't set, but allows a week of lookback and a higher rate limit,
+// and enables the trace methods with their concurrency, result size and tipset size limited.
+func PartnerProfile() []Option {
+	return append(PublicProfile(),
+		WithMaxLookbackDuration(7*24*time.Hour),
+		WithRateLimit(5000),
+		WithDisabledMethods(),
+		WithTraceConcurrencyLimit(4),
+		WithTraceReplayMaxResults(1000),
+		WithTraceBlockMaxMessages(10000),
+	)
+}
+
+// InternalProfile returns the options for a gateway serving trusted internal services. It falls
+// back to PartnerProfile for anything it doesn't set, but removes the rate limits, allows a month
+// of lookback and passes target errors through unsanitized. The concurrency limits protecting the
+// target are kept.
+func InternalProfile() []Option {
+	return append(PartnerProfile(),
+		WithMaxLookbackDuration(30*24*time.Hour),
+		WithRateLimit(0),
+		WithWriteReservedRateLimit(0),
+		WithErrorSanitization(false),
+	)
+}
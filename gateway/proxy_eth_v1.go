@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/ipfs/go-cid"
@@ -24,6 +25,59 @@ import (
 )
 
 var ErrTooManyFilters = errors.New("too many subscriptions and filters per connection")
+var ErrTooManyFiltersPerHost = errors.New("too many subscriptions and filters per host")
+
+// ErrFilterExpired is returned by EthGetFilterChanges, when the gateway is configured to clean up
+// expired filters, for a filter the target no longer has.
+var ErrFilterExpired = errors.New("filter expired, please recreate it")
+
+// ErrSimulationTimedOut is returned when an EthCall or EthEstimateGas takes longer than the
+// configured simulation timeout.
+var ErrSimulationTimedOut = errors.New("simulation timed out")
+
+// ErrEthCallAddressNotAllowed is returned by EthCall and EthEstimateGas when the gateway is
+// restricted to calls to an allowlist of contract addresses, and the call is to any other address.
+var ErrEthCallAddressNotAllowed = errors.New("calls to this address are not allowed")
+
+// ErrEthCallGasTooHigh and ErrEthCallPrecompileNotAllowed are returned by EthCall and
+// EthEstimateGas when the call has a higher gas limit than the gateway is configured to allow, or is
+// to an expensive precompile the gateway is configured to reject calls to.
+var (
+	ErrEthCallGasTooHigh           = errors.New("call gas limit too high")
+	ErrEthCallPrecompileNotAllowed = errors.New("calls to this precompile are not allowed")
+)
+
+// ErrTooManyTraceResults is returned by EthTraceReplayBlockTransactions when the block has more
+// transactions to replay than the gateway is configured to return.
+var ErrTooManyTraceResults = errors.New("too many trace results")
+
+// ErrTooManyTraceMessages is returned by EthTraceBlock and EthTraceReplayBlockTransactions, before
+// anything is replayed, when the tipset has more messages than the gateway is configured to replay
+// in a single request.
+var ErrTooManyTraceMessages = errors.New("too many messages to trace in tipset, trace them one at a time with EthTraceTransaction or StateReplay")
+
+// ErrTooManyLogAddresses is returned by EthGetLogs and EthNewFilter when the filter names more
+// contract addresses than the gateway is configured to allow.
+var ErrTooManyLogAddresses = errors.New("too many addresses in log filter")
+
+// ErrTooManyLogTopics is returned by EthGetLogs and EthNewFilter when the filter has more topic
+// positions than the gateway is configured to allow.
+var ErrTooManyLogTopics = errors.New("too many topic positions in log filter")
+
+// ErrTooManyLogTopicEntries is returned by EthGetLogs and EthNewFilter when the filter has more
+// topics across its topic positions than the gateway is configured to allow.
+var ErrTooManyLogTopicEntries = errors.New("too many topics in log filter")
+
+// ErrLogsBlockRangeTooLarge is returned by EthGetLogs when the filter's block range spans more
+// epochs than the gateway is configured to allow.
+var ErrLogsBlockRangeTooLarge = errors.New("log filter block range too large")
+
+// ErrEthTxTooLarge and ErrEthTxGasTooHigh are returned by EthSendRawTransaction when the
+// transaction is larger, or has a higher gas limit, than the gateway is configured to allow.
+var (
+	ErrEthTxTooLarge   = errors.New("transaction too large")
+	ErrEthTxGasTooHigh = errors.New("transaction gas limit too high")
+)
 
 func (pv1 *reverseProxyV1) EthAccounts(context.Context) ([]ethtypes.EthAddress, error) {
 	// gateway provides a public API, so it can't hold user accounts
@@ -92,6 +146,12 @@ func (pv1 *reverseProxyV1) checkBlkHash(ctx context.Context, blkHash ethtypes.Et
 }
 
 func (pv1 *reverseProxyV1) checkEthBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash, lookback ethtypes.EthUint64) error {
+	if b := blockParamBatchFrom(ctx); b != nil {
+		return b.check(ctx, ethBlockParamKey(blkParam), lookback, func(ctx context.Context) error {
+			return pv1.checkEthBlockParam(ctx, blkParam, lookback)
+		})
+	}
+
 	// first check if it's a predefined block or a block number
 	if blkParam.PredefinedBlock != nil || blkParam.BlockNumber != nil {
 		head, err := pv1.ChainHead(ctx)
@@ -117,7 +177,7 @@ func (pv1 *reverseProxyV1) checkEthBlockParam(ctx context.Context, blkParam etht
 			num = *blkParam.BlockNumber
 		}
 
-		return pv1.gateway.checkTipSetHeight(head, abi.ChainEpoch(num))
+		return pv1.gateway.checkTipSetHeight(ctx, head, abi.ChainEpoch(num))
 	}
 
 	// otherwise its a block hash
@@ -128,7 +188,71 @@ func (pv1 *reverseProxyV1) checkEthBlockParam(ctx context.Context, blkParam etht
 	return xerrors.New("invalid block param")
 }
 
+// checkEthCallBlockParam enforces the maximum block age for methods that execute against the state
+// of the referenced block.
+func (pv1 *reverseProxyV1) checkEthCallBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash) error {
+	return pv1.checkEthBlockParamAge(ctx, blkParam, pv1.gateway.currentSettings().ethCallMaxBlockAge)
+}
+
+// checkEthStorageBlockParam enforces the maximum block age for EthGetStorageAt.
+func (pv1 *reverseProxyV1) checkEthStorageBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash) error {
+	return pv1.checkEthBlockParamAge(ctx, blkParam, pv1.gateway.ethStorageBlockAgeLimit())
+}
+
+// checkEthBlockParamAge rejects block params referencing a block more than maxAge epochs behind the
+// head. A maxAge of 0 removes the limit.
+func (pv1 *reverseProxyV1) checkEthBlockParamAge(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash, maxAge abi.ChainEpoch) error {
+	if maxAge == 0 {
+		return nil
+	}
+
+	head, err := pv1.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+
+	h := head.Height()
+	switch {
+	case blkParam.BlockNumber != nil:
+		h = abi.ChainEpoch(*blkParam.BlockNumber)
+	case blkParam.BlockHash != nil:
+		tsk, err := pv1.tskByEthHash(ctx, *blkParam.BlockHash)
+		if err != nil {
+			return err
+		}
+		ts, err := pv1.ChainGetTipSet(ctx, tsk)
+		if err != nil {
+			return err
+		}
+		h = ts.Height()
+	case blkParam.PredefinedBlock != nil && *blkParam.PredefinedBlock == "safe":
+		h -= ethtypes.SafeEpochDelay
+	case blkParam.PredefinedBlock != nil && *blkParam.PredefinedBlock == "finalized":
+		h -= policy.ChainFinality
+	}
+
+	return pv1.gateway.checkEthBlockAge(head, h, maxAge)
+}
+
+func (pv1 *reverseProxyV1) checkEthFeeHistoryBlockAge(ctx context.Context, blkParam string) error {
+	if pv1.gateway.ethFeeHistoryMaxBlockAge <= 0 {
+		return nil
+	}
+
+	head, err := pv1.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+	return pv1.gateway.checkEthFeeHistoryBlockAge(head, blkParam)
+}
+
 func (pv1 *reverseProxyV1) checkBlkParam(ctx context.Context, blkParam string, lookback ethtypes.EthUint64) error {
+	if b := blockParamBatchFrom(ctx); b != nil {
+		return b.check(ctx, blkParamKey(blkParam), lookback, func(ctx context.Context) error {
+			return pv1.checkBlkParam(ctx, blkParam, lookback)
+		})
+	}
+
 	if blkParam == "earliest" {
 		// also not supported in node impl
 		return xerrors.New("block param \"earliest\" is not supported")
@@ -165,7 +289,34 @@ func (pv1 *reverseProxyV1) checkBlkParam(ctx context.Context, blkParam string, l
 		}
 
 	}
-	return pv1.gateway.checkTipSetHeight(head, abi.ChainEpoch(num))
+	return pv1.gateway.checkTipSetHeight(ctx, head, abi.ChainEpoch(num))
+}
+
+// checkTraceBlockMessages enforces the maximum number of messages in the tipset EthTraceBlock and
+// EthTraceReplayBlockTransactions replay, by counting them before the node executes the tipset.
+// Block params it can't resolve are left for the node to reject.
+func (pv1 *reverseProxyV1) checkTraceBlockMessages(ctx context.Context, blkNum string) error {
+	if pv1.gateway.traceBlockMaxMessages <= 0 {
+		return nil
+	}
+
+	head, err := pv1.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+	h, ok := resolveLogsBlockParam(&blkNum, head)
+	if !ok {
+		return nil
+	}
+	ts, err := pv1.server.ChainGetTipSetByHeight(ctx, h, head.Key())
+	if err != nil {
+		return err
+	}
+	msgs, err := pv1.server.ChainGetMessagesInTipset(ctx, ts.Key())
+	if err != nil {
+		return err
+	}
+	return pv1.gateway.checkTraceBlockMessages(len(msgs))
 }
 
 func (pv1 *reverseProxyV1) EthGetBlockTransactionCountByHash(ctx context.Context, blkHash ethtypes.EthHash) (ethtypes.EthUint64, error) {
@@ -181,11 +332,15 @@ func (pv1 *reverseProxyV1) EthGetBlockByHash(ctx context.Context, blkHash ethtyp
 		return ethtypes.EthBlock{}, err
 	}
 
+	key := ethBlockCacheKey{hash: blkHash, fullTxInfo: fullTxInfo}
 	if err := pv1.checkBlkHash(ctx, blkHash); err != nil {
-		return ethtypes.EthBlock{}, err
+		return serveStale(ctx, pv1.gateway, pv1.gateway.ethBlockCache, key, err)
 	}
 
-	return pv1.server.EthGetBlockByHash(ctx, blkHash, fullTxInfo)
+	fetch := func() (ethtypes.EthBlock, error) { return pv1.server.EthGetBlockByHash(ctx, blkHash, fullTxInfo) }
+	return pv1.gateway.ethFinalBlock(ctx, key, fetch, func() (*types.TipSet, error) {
+		return pv1.ChainHead(ctx)
+	})
 }
 
 func (pv1 *reverseProxyV1) EthGetBlockByNumber(ctx context.Context, blkNum string, fullTxInfo bool) (ethtypes.EthBlock, error) {
@@ -193,11 +348,85 @@ func (pv1 *reverseProxyV1) EthGetBlockByNumber(ctx context.Context, blkNum strin
 		return ethtypes.EthBlock{}, err
 	}
 
+	key, cacheable := ethBlockNumberKey(blkNum, fullTxInfo)
 	if err := pv1.checkBlkParam(ctx, blkNum, 0); err != nil {
+		if cacheable {
+			return serveStale(ctx, pv1.gateway, pv1.gateway.ethBlockCache, key, err)
+		}
 		return ethtypes.EthBlock{}, err
 	}
 
-	return pv1.server.EthGetBlockByNumber(ctx, blkNum, fullTxInfo)
+	fetch := func() (ethtypes.EthBlock, error) { return pv1.server.EthGetBlockByNumber(ctx, blkNum, fullTxInfo) }
+	if !cacheable {
+		return fetch()
+	}
+	return pv1.gateway.ethFinalBlock(ctx, key, fetch, func() (*types.TipSet, error) {
+		return pv1.ChainHead(ctx)
+	})
+}
+
+func (pv1 *reverseProxyV1) EthGetBlockRange(ctx context.Context, fromBlock, toBlock ethtypes.EthUint64, fullTxInfo bool) ([]ethtypes.EthBlock, error) {
+	if fromBlock > toBlock {
+		return nil, xerrors.New("fromBlock must not be after toBlock")
+	}
+	maxSpan := pv1.gateway.ethBlockRangeMaxSpan
+	if maxSpan > 0 && toBlock-fromBlock >= ethtypes.EthUint64(maxSpan) {
+		return nil, xerrors.Errorf("block range too large: %d blocks, the maximum is %d", toBlock-fromBlock+1, maxSpan)
+	}
+
+	head, err := pv1.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, num := range []ethtypes.EthUint64{fromBlock, toBlock} {
+		if err := pv1.gateway.checkTipSetHeight(ctx, head, abi.ChainEpoch(num)); err != nil {
+			return nil, err
+		}
+	}
+
+	// blocks that are final can be served from cache, only the tail of the range that could still
+	// be reorged is fetched each time
+	finalized := head.Height() - policy.ChainFinality
+	c := pv1.gateway.ethBlockCache
+	blocks := make([]*ethtypes.EthBlock, toBlock-fromBlock+1)
+	if err := pv1.gateway.fanout(ctx, len(blocks), func(ctx context.Context, i int) error {
+		num := fromBlock + ethtypes.EthUint64(i)
+		fetch := func() (ethtypes.EthBlock, error) {
+			if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+				return ethtypes.EthBlock{}, err
+			}
+			return pv1.server.EthGetBlockByNumber(ctx, num.Hex(), fullTxInfo)
+		}
+
+		var (
+			blk ethtypes.EthBlock
+			err error
+		)
+		if c != nil && abi.ChainEpoch(num) <= finalized {
+			blk, err = c.getOrFetch(ctx, ethBlockCacheKey{number: num, fullTxInfo: fullTxInfo}, fetch)
+		} else {
+			blk, err = fetch()
+		}
+		if err != nil {
+			var nullRound *api.ErrNullRound
+			if errors.As(err, &nullRound) {
+				return nil
+			}
+			return err
+		}
+		blocks[i] = &blk
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	res := make([]ethtypes.EthBlock, 0, len(blocks))
+	for _, blk := range blocks {
+		if blk != nil {
+			res = append(res, *blk)
+		}
+	}
+	return res, nil
 }
 
 func (pv1 *reverseProxyV1) EthGetTransactionByBlockHashAndIndex(ctx context.Context, blkHash ethtypes.EthHash, txIndex ethtypes.EthUint64) (*ethtypes.EthTx, error) {
@@ -228,7 +457,7 @@ func (pv1 *reverseProxyV1) EthGetTransactionByHash(ctx context.Context, txHash *
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.EthGetTransactionByHashLimited(ctx, txHash, pv1.gateway.maxMessageLookbackEpochs)
+	return pv1.server.EthGetTransactionByHashLimited(ctx, txHash, pv1.gateway.currentSettings().maxMessageLookbackEpochs)
 }
 
 func (pv1 *reverseProxyV1) EthGetTransactionHashByCid(ctx context.Context, cid cid.Cid) (*ethtypes.EthHash, error) {
@@ -263,7 +492,12 @@ func (pv1 *reverseProxyV1) EthGetTransactionReceipt(ctx context.Context, txHash
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.EthGetTransactionReceiptLimited(ctx, txHash, pv1.gateway.maxMessageLookbackEpochs)
+	limit := pv1.gateway.currentSettings().maxMessageLookbackEpochs
+	receipt, err := pv1.server.EthGetTransactionReceiptLimited(ctx, txHash, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pv1.gateway.enrichEthReceipt(ctx, pv1.server, receipt, limit), nil
 }
 
 func (pv1 *reverseProxyV1) EthGetCode(ctx context.Context, address ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
@@ -274,6 +508,9 @@ func (pv1 *reverseProxyV1) EthGetCode(ctx context.Context, address ethtypes.EthA
 	if err := pv1.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv1.checkEthCallBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
 
 	return pv1.server.EthGetCode(ctx, address, blkParam)
 }
@@ -286,6 +523,9 @@ func (pv1 *reverseProxyV1) EthGetStorageAt(ctx context.Context, address ethtypes
 	if err := pv1.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv1.checkEthStorageBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
 
 	return pv1.server.EthGetStorageAt(ctx, address, position, blkParam)
 }
@@ -302,6 +542,51 @@ func (pv1 *reverseProxyV1) EthGetBalance(ctx context.Context, address ethtypes.E
 	return pv1.server.EthGetBalance(ctx, address, blkParam)
 }
 
+func (pv1 *reverseProxyV1) EthGetBalanceHistory(ctx context.Context, address ethtypes.EthAddress, fromBlock, toBlock, step ethtypes.EthUint64) (<-chan api.EthBalanceSample, error) {
+	if step == 0 {
+		return nil, xerrors.New("step must be greater than zero")
+	}
+	if fromBlock > toBlock {
+		return nil, xerrors.New("fromBlock must not be after toBlock")
+	}
+	maxSamples := pv1.gateway.ethBalanceHistoryMaxSamples
+	if samples := (toBlock-fromBlock)/step + 1; maxSamples > 0 && samples > ethtypes.EthUint64(maxSamples) {
+		return nil, xerrors.Errorf("too many samples requested: %d, the maximum is %d", samples, maxSamples)
+	}
+
+	// the oldest block is the one most likely to fall outside the lookback limits, so check it up
+	// front where we can still return an error
+	if err := pv1.checkEthBlockParam(ctx, ethtypes.NewEthBlockNumberOrHashFromNumber(fromBlock), 0); err != nil {
+		return nil, err
+	}
+
+	out := make(chan api.EthBalanceSample)
+	go func() {
+		defer close(out)
+
+		for num := fromBlock; num <= toBlock; num += step {
+			balance, err := pv1.EthGetBalance(ctx, address, ethtypes.NewEthBlockNumberOrHashFromNumber(num))
+			sample := api.EthBalanceSample{BlockNumber: num, Balance: balance}
+			if err != nil {
+				log.Warnw("failed to get balance for balance history", "address", address, "block", num, "error", err)
+				sample.Balance, sample.Error = ethtypes.EthBigInt(big.Zero()), err.Error()
+			}
+			select {
+			case out <- sample:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			if toBlock-num < step {
+				break // don't overflow
+			}
+		}
+	}()
+	return out, nil
+}
+
 func (pv1 *reverseProxyV1) EthChainId(ctx context.Context) (ethtypes.EthUint64, error) {
 	if err := pv1.gateway.limit(ctx, basicRateLimitTokens); err != nil {
 		return 0, err
@@ -365,6 +650,9 @@ func (pv1 *reverseProxyV1) EthFeeHistory(ctx context.Context, jparams jsonrpc.Ra
 	if err := pv1.checkBlkParam(ctx, params.NewestBlkNum, params.BlkCount); err != nil {
 		return ethtypes.EthFeeHistory{}, err
 	}
+	if err := pv1.checkEthFeeHistoryBlockAge(ctx, params.NewestBlkNum); err != nil {
+		return ethtypes.EthFeeHistory{}, err
+	}
 
 	if params.BlkCount > ethtypes.EthUint64(EthFeeHistoryMaxBlockCount) {
 		return ethtypes.EthFeeHistory{}, xerrors.New("block count too high")
@@ -383,7 +671,7 @@ func (pv1 *reverseProxyV1) EthMaxPriorityFeePerGas(ctx context.Context) (ethtype
 
 func (pv1 *reverseProxyV1) EthEstimateGas(ctx context.Context, jparams jsonrpc.RawParams) (ethtypes.EthUint64, error) {
 	// validate params
-	_, err := jsonrpc.DecodeParams[ethtypes.EthEstimateGasParams](jparams)
+	params, err := jsonrpc.DecodeParams[ethtypes.EthEstimateGasParams](jparams)
 	if err != nil {
 		return ethtypes.EthUint64(0), xerrors.Errorf("decoding params: %w", err)
 	}
@@ -392,8 +680,20 @@ func (pv1 *reverseProxyV1) EthEstimateGas(ctx context.Context, jparams jsonrpc.R
 		return 0, err
 	}
 
-	// todo limit gas? to what?
-	return pv1.server.EthEstimateGas(ctx, jparams)
+	if err := pv1.gateway.checkEthCallAddress(params.Tx); err != nil {
+		return 0, err
+	}
+	if capped, err := pv1.gateway.guardEthCall(&params.Tx); err != nil {
+		return 0, err
+	} else if capped {
+		if jparams, err = json.Marshal(params); err != nil {
+			return 0, xerrors.Errorf("encoding params: %w", err)
+		}
+	}
+
+	return simulate(ctx, pv1.gateway, func(ctx context.Context) (ethtypes.EthUint64, error) {
+		return pv1.server.EthEstimateGas(ctx, jparams)
+	})
 }
 
 func (pv1 *reverseProxyV1) EthCall(ctx context.Context, tx ethtypes.EthCall, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
@@ -404,13 +704,30 @@ func (pv1 *reverseProxyV1) EthCall(ctx context.Context, tx ethtypes.EthCall, blk
 	if err := pv1.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv1.checkEthCallBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkEthCallAddress(tx); err != nil {
+		return nil, err
+	}
+	if _, err := pv1.gateway.guardEthCall(&tx); err != nil {
+		return nil, err
+	}
 
-	// todo limit gas? to what?
-	return pv1.server.EthCall(ctx, tx, blkParam)
+	res, err := simulate(ctx, pv1.gateway, func(ctx context.Context) (ethtypes.EthBytes, error) {
+		return pv1.server.EthCall(ctx, tx, blkParam)
+	})
+	if err != nil && pv1.gateway.ethRevertReasons {
+		err = decodeRevertReason(err)
+	}
+	return res, err
 }
 
 func (pv1 *reverseProxyV1) EthSendRawTransaction(ctx context.Context, rawTx ethtypes.EthBytes) (ethtypes.EthHash, error) {
-	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+	if err := pv1.gateway.limitWrite(ctx, stateRateLimitTokens); err != nil {
+		return ethtypes.EthHash{}, err
+	}
+	if err := pv1.gateway.checkEthRawTx(rawTx); err != nil {
 		return ethtypes.EthHash{}, err
 	}
 
@@ -422,6 +739,9 @@ func (pv1 *reverseProxyV1) EthGetLogs(ctx context.Context, filter *ethtypes.EthF
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
+	if err := pv1.gateway.checkEthFilter(filter); err != nil {
+		return nil, err
+	}
 
 	if filter.FromBlock != nil {
 		if err := pv1.checkBlkParam(ctx, *filter.FromBlock, 0); err != nil {
@@ -438,6 +758,21 @@ func (pv1 *reverseProxyV1) EthGetLogs(ctx context.Context, filter *ethtypes.EthF
 			return nil, err
 		}
 	}
+	if pv1.gateway.ethMaxLogsBlockRange > 0 {
+		head, err := pv1.ChainHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := pv1.gateway.checkEthLogsBlockRange(filter, head); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := pv1.gateway.acquireLogsSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	return pv1.server.EthGetLogs(ctx, filter)
 }
@@ -456,7 +791,11 @@ func (pv1 *reverseProxyV1) EthGetFilterChanges(ctx context.Context, id ethtypes.
 		return nil, filter.ErrFilterNotFound
 	}
 
-	return pv1.server.EthGetFilterChanges(ctx, id)
+	res, err := pv1.server.EthGetFilterChanges(ctx, id)
+	if err != nil {
+		return nil, pv1.gateway.expireFilter(ft, id, err)
+	}
+	return res, nil
 }
 
 func (pv1 *reverseProxyV1) EthGetFilterLogs(ctx context.Context, id ethtypes.EthFilterID) (*ethtypes.EthFilterResult, error) {
@@ -473,6 +812,12 @@ func (pv1 *reverseProxyV1) EthGetFilterLogs(ctx context.Context, id ethtypes.Eth
 		return nil, nil
 	}
 
+	release, err := pv1.gateway.acquireLogsSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv1.server.EthGetFilterLogs(ctx, id)
 }
 
@@ -480,6 +825,9 @@ func (pv1 *reverseProxyV1) EthNewFilter(ctx context.Context, filter *ethtypes.Et
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return ethtypes.EthFilterID{}, err
 	}
+	if err := pv1.gateway.checkEthFilter(filter); err != nil {
+		return ethtypes.EthFilterID{}, err
+	}
 
 	return pv1.addUserFilterLimited(ctx, "EthNewFilter", func() (ethtypes.EthFilterID, error) {
 		return pv1.server.EthNewFilter(ctx, filter)
@@ -532,12 +880,13 @@ func (pv1 *reverseProxyV1) EthUninstallFilter(ctx context.Context, id ethtypes.E
 	}
 
 	delete(ft.userFilters, id)
+	ft.hostFilters.release(ft.host, 1)
 	return ok, nil
 }
 
 func (pv1 *reverseProxyV1) EthSubscribe(ctx context.Context, jparams jsonrpc.RawParams) (ethtypes.EthSubscriptionID, error) {
 	// validate params
-	_, err := jsonrpc.DecodeParams[ethtypes.EthSubscribeParams](jparams)
+	params, err := jsonrpc.DecodeParams[ethtypes.EthSubscribeParams](jparams)
 	if err != nil {
 		return ethtypes.EthSubscriptionID{}, xerrors.Errorf("decoding params: %w", err)
 	}
@@ -562,28 +911,69 @@ func (pv1 *reverseProxyV1) EthSubscribe(ctx context.Context, jparams jsonrpc.Raw
 	ft.lk.Lock()
 	defer ft.lk.Unlock()
 
-	if len(ft.userSubscriptions)+len(ft.userFilters) >= pv1.gateway.ethMaxFiltersPerConn {
-		return ethtypes.EthSubscriptionID{}, ErrTooManyFilters
+	replayable := pv1.gateway.subscriptionReplayBuffer > 0 && params.EventType == ethSubscribeNewHeads
+	var resume *ethSubscribeResume
+	if replayable {
+		if resume, err = decodeSubscribeResume(jparams); err != nil {
+			return ethtypes.EthSubscriptionID{}, err
+		}
 	}
 
-	sub, err := pv1.server.EthSubscribe(ctx, jparams)
+	evict, release, err := pv1.gateway.reserveSubscription(ft, pv1.subscriptions, params.EventType, resume != nil)
 	if err != nil {
 		return ethtypes.EthSubscriptionID{}, err
 	}
 
-	err = pv1.subscriptions.AddSub(ctx, sub, func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+	var sub ethtypes.EthSubscriptionID
+	if resume != nil {
+		sub = *resume.Resume
+	} else if sub, err = pv1.server.EthSubscribe(ctx, jparams); err != nil {
+		release()
+		return ethtypes.EthSubscriptionID{}, err
+	}
+
+	sink := func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
 		outParam, err := json.Marshal(response)
 		if err != nil {
 			return err
 		}
 
 		return ethCb.EthSubscription(ctx, outParam)
-	})
+	}
+	var replay *subscriptionReplay
+	if resume != nil {
+		replay, err = pv1.subscriptions.resumeSub(ctx, sub, ft.host, resume.SinceSequence, ethCb)
+	} else if replayable {
+		replay, err = pv1.subscriptions.addReplayableSub(ctx, sub, pv1.gateway.subscriptionReplayBuffer, ft.host, ethCb)
+	} else if pv1.gateway.subscriptionBufferSize > 0 {
+		err = pv1.subscriptions.AddBufferedSub(ctx, sub, pv1.gateway.subscriptionBufferSize, sink, func() {
+			pv1.dropSubscription(ft, sub, ethCb)
+		})
+	} else {
+		err = pv1.subscriptions.AddSub(ctx, sub, sink)
+	}
 	if err != nil {
+		release()
 		return ethtypes.EthSubscriptionID{}, err
 	}
 
+	if evict != nil {
+		evictSubscription(ctx, ft, pv1.subscriptions, *evict, pv1.server.EthUnsubscribe, ethCb)
+		if resume != nil {
+			// the resumed subscription brought its own place in the host's count
+			ft.hostFilters.release(ft.host, 1)
+		}
+	}
+	ft.subscriptionTypes[sub] = params.EventType
 	ft.userSubscriptions[sub] = func() {
+		if replay != nil {
+			pv1.gateway.detachSubscription(ft, pv1.subscriptions, sub, replay, pv1.server.EthUnsubscribe)
+			return
+		}
+		pv1.subscriptions.RemoveSub(sub)
+		// the request that subscribed has long since returned, and its context with it
+		ctx, cancel := context.WithTimeout(context.Background(), subscriptionCleanupTimeout)
+		defer cancel()
 		if _, err := pv1.server.EthUnsubscribe(ctx, sub); err != nil {
 			log.Warnf("error unsubscribing after connection end: %v", err)
 		}
@@ -617,6 +1007,8 @@ func (pv1 *reverseProxyV1) EthUnsubscribe(ctx context.Context, id ethtypes.EthSu
 	}
 
 	delete(ft.userSubscriptions, id)
+	delete(ft.subscriptionTypes, id)
+	ft.hostFilters.release(ft.host, 1)
 
 	if pv1.subscriptions != nil {
 		pv1.subscriptions.RemoveSub(id)
@@ -625,11 +1017,38 @@ func (pv1 *reverseProxyV1) EthUnsubscribe(ctx context.Context, id ethtypes.EthSu
 	return ok, nil
 }
 
+// dropSubscription removes a subscription that the gateway has given up delivering notifications
+// for because the client wasn't keeping up with them, telling the client through ethCb.
+func (pv1 *reverseProxyV1) dropSubscription(ft *statefulCallTracker, id ethtypes.EthSubscriptionID, ethCb api.EthSubscriberMethods) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+
+	if _, ok := ft.userSubscriptions[id]; !ok {
+		return
+	}
+
+	// the request that subscribed has long since returned, and its context with it
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionCleanupTimeout)
+	defer cancel()
+
+	if _, err := pv1.server.EthUnsubscribe(ctx, id); err != nil {
+		log.Warnf("error unsubscribing dropped subscription: %v", err)
+	}
+	delete(ft.userSubscriptions, id)
+	delete(ft.subscriptionTypes, id)
+	ft.hostFilters.release(ft.host, 1)
+
+	notifyUnsubscribed(ctx, ethCb, id, ErrSubscriptionBufferFull.Error())
+}
+
 func (pv1 *reverseProxyV1) Web3ClientVersion(ctx context.Context) (string, error) {
 	if err := pv1.gateway.limit(ctx, basicRateLimitTokens); err != nil {
 		return "", err
 	}
 
+	if pv1.gateway.clientVersion != "" {
+		return pv1.gateway.clientVersion, nil
+	}
 	return pv1.server.Web3ClientVersion(ctx)
 }
 
@@ -642,6 +1061,16 @@ func (pv1 *reverseProxyV1) EthTraceBlock(ctx context.Context, blkNum string) ([]
 		return nil, err
 	}
 
+	if err := pv1.checkTraceBlockMessages(ctx, blkNum); err != nil {
+		return nil, err
+	}
+
+	release, err := pv1.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv1.server.EthTraceBlock(ctx, blkNum)
 }
 
@@ -654,7 +1083,24 @@ func (pv1 *reverseProxyV1) EthTraceReplayBlockTransactions(ctx context.Context,
 		return nil, err
 	}
 
-	return pv1.server.EthTraceReplayBlockTransactions(ctx, blkNum, traceTypes)
+	if err := pv1.checkTraceBlockMessages(ctx, blkNum); err != nil {
+		return nil, err
+	}
+
+	release, err := pv1.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := pv1.server.EthTraceReplayBlockTransactions(ctx, blkNum, traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkTraceReplayResults(res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (pv1 *reverseProxyV1) EthTraceTransaction(ctx context.Context, txHash string) ([]*ethtypes.EthTraceTransaction, error) {
@@ -662,6 +1108,12 @@ func (pv1 *reverseProxyV1) EthTraceTransaction(ctx context.Context, txHash strin
 		return nil, err
 	}
 
+	release, err := pv1.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv1.server.EthTraceTransaction(ctx, txHash)
 }
 
@@ -682,6 +1134,12 @@ func (pv1 *reverseProxyV1) EthTraceFilter(ctx context.Context, filter ethtypes.E
 		}
 	}
 
+	release, err := pv1.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv1.server.EthTraceFilter(ctx, filter)
 }
 
@@ -689,7 +1147,7 @@ func (pv1 *reverseProxyV1) EthGetBlockReceipts(ctx context.Context, blkParam eth
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.EthGetBlockReceiptsLimited(ctx, blkParam, pv1.gateway.maxMessageLookbackEpochs)
+	return pv1.server.EthGetBlockReceiptsLimited(ctx, blkParam, pv1.gateway.currentSettings().maxMessageLookbackEpochs)
 }
 
 func (pv1 *reverseProxyV1) addUserFilterLimited(
@@ -705,12 +1163,16 @@ func (pv1 *reverseProxyV1) addUserFilterLimited(
 	ft.lk.Lock()
 	defer ft.lk.Unlock()
 
-	if len(ft.userSubscriptions)+len(ft.userFilters) >= pv1.gateway.ethMaxFiltersPerConn {
+	if ft.active() >= pv1.gateway.currentSettings().ethMaxFiltersPerConn {
 		return ethtypes.EthFilterID{}, ErrTooManyFilters
 	}
+	if !ft.hostFilters.reserve(ft.host) {
+		return ethtypes.EthFilterID{}, ErrTooManyFiltersPerHost
+	}
 
 	id, err := install()
 	if err != nil {
+		ft.hostFilters.release(ft.host, 1)
 		return id, err
 	}
 
@@ -742,6 +1204,22 @@ type statefulCallTracker struct {
 
 	userFilters       map[ethtypes.EthFilterID]cleanup
 	userSubscriptions map[ethtypes.EthSubscriptionID]cleanup
+	// subscriptionTypes holds the EthSubscribe event type of each of userSubscriptions
+	subscriptionTypes map[ethtypes.EthSubscriptionID]string
+	// userChannels counts the subscriptions delivered over a channel, such as
+	// SubscribeVerifiedClientStatus, which end along with the connection's context rather than
+	// being cleaned up here
+	userChannels int
+
+	// host is the remote host of the connection, whose filters and subscriptions across all of its
+	// connections are counted by hostFilters, which is nil if there is no per host limit
+	host        string
+	hostFilters *hostFilterCounter
+	// detached counts the userSubscriptions left running once the connection has ended, which keep
+	// their place in the host's count until they expire or are resumed
+	detached int
+
+	stats connectionStats
 }
 
 func (ft *statefulCallTracker) cleanup() {
@@ -754,6 +1232,34 @@ func (ft *statefulCallTracker) cleanup() {
 	for _, cleanup := range ft.userSubscriptions {
 		cleanup()
 	}
+	ft.hostFilters.release(ft.host, len(ft.userFilters)+len(ft.userSubscriptions)-ft.detached)
+}
+
+// active returns the number of filters and subscriptions held by the connection. ft.lk must be held.
+func (ft *statefulCallTracker) active() int {
+	return len(ft.userFilters) + len(ft.userSubscriptions) + ft.userChannels
+}
+
+// reserveChannel counts a new channel subscription against the per connection limit of maxPerConn
+// and the per host limit, returning a function to stop counting it once it has ended.
+func (ft *statefulCallTracker) reserveChannel(maxPerConn int) (func(), error) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+
+	if ft.active() >= maxPerConn {
+		return nil, ErrTooManyFilters
+	}
+	if !ft.hostFilters.reserve(ft.host) {
+		return nil, ErrTooManyFiltersPerHost
+	}
+	ft.userChannels++
+
+	return func() {
+		ft.lk.Lock()
+		defer ft.lk.Unlock()
+		ft.userChannels--
+		ft.hostFilters.release(ft.host, 1)
+	}, nil
 }
 
 func (ft *statefulCallTracker) hasFilter(id ethtypes.EthFilterID) bool {
@@ -764,10 +1270,90 @@ func (ft *statefulCallTracker) hasFilter(id ethtypes.EthFilterID) bool {
 	return ok
 }
 
+// removeFilter stops tracking a filter without uninstalling it, for a filter the target no longer
+// has.
+func (ft *statefulCallTracker) removeFilter(id ethtypes.EthFilterID) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+
+	if _, ok := ft.userFilters[id]; ok {
+		delete(ft.userFilters, id)
+		ft.hostFilters.release(ft.host, 1)
+	}
+}
+
+// expireFilter stops tracking filter id if err, returned by the target for a call on the filter,
+// shows that the target no longer has it and the gateway is configured to clean up expired
+// filters, in which case err is translated into ErrFilterExpired.
+func (gw *Node) expireFilter(ft *statefulCallTracker, id ethtypes.EthFilterID, err error) error {
+	if !gw.ethExpiredFilterCleanup || !isFilterNotFound(err) {
+		return err
+	}
+	log.Debugw("target no longer has filter, removing it", "filter", id)
+	ft.removeFilter(id)
+	return xerrors.Errorf("%w: %s", ErrFilterExpired, err.Error())
+}
+
+// isFilterNotFound returns true if err shows that the filter a call was made on doesn't exist. The
+// target's error loses its identity over RPC, so it's recognized by its message.
+func isFilterNotFound(err error) bool {
+	return errors.Is(err, filter.ErrFilterNotFound) || strings.Contains(err.Error(), filter.ErrFilterNotFound.Error())
+}
+
 // called per request (ws connection)
-func newStatefulCallTracker() *statefulCallTracker {
+func newStatefulCallTracker(host string, hostFilters *hostFilterCounter) *statefulCallTracker {
 	return &statefulCallTracker{
 		userFilters:       make(map[ethtypes.EthFilterID]cleanup),
 		userSubscriptions: make(map[ethtypes.EthSubscriptionID]cleanup),
+		subscriptionTypes: make(map[ethtypes.EthSubscriptionID]string),
+		host:              host,
+		hostFilters:       hostFilters,
+	}
+}
+
+// hostFilterCounter counts the filters and subscriptions held by each remote host across all of its
+// connections, so that the per connection limit can't be evaded by opening more connections.
+type hostFilterCounter struct {
+	lk     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+func newHostFilterCounter(max int) *hostFilterCounter {
+	return &hostFilterCounter{
+		max:    max,
+		counts: make(map[string]int),
+	}
+}
+
+// reserve counts a new filter or subscription for host, returning false if the host is already at
+// the limit.
+func (c *hostFilterCounter) reserve(host string) bool {
+	if c == nil {
+		return true
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.counts[host] >= c.max {
+		return false
+	}
+	c.counts[host]++
+	return true
+}
+
+// release stops counting n filters or subscriptions for host.
+func (c *hostFilterCounter) release(host string, n int) {
+	if c == nil || n == 0 {
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.counts[host] -= n
+	if c.counts[host] <= 0 {
+		delete(c.counts, host)
 	}
 }
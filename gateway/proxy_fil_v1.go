@@ -2,6 +2,8 @@ package gateway
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -22,6 +24,7 @@ import (
 	"github.com/filecoin-project/lotus/api/v1api"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/sigs"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
@@ -29,6 +32,30 @@ import (
 
 var _ api.Gateway = (*reverseProxyV1)(nil)
 
+// ErrTooManyPendingMessages is returned by MpoolPending when the mempool holds more messages than
+// the gateway is configured to return in a single response.
+var ErrTooManyPendingMessages = errors.New("too many pending messages")
+
+// ErrTooManyPendingAddresses is returned by MpoolPendingByAddress when more addresses are given
+// than the gateway is configured to filter by.
+var ErrTooManyPendingAddresses = errors.New("too many addresses to filter pending messages by")
+
+// ErrActorEventBackfillTooLong is returned by SubscribeActorEventsRaw when the filter requests more
+// historical events than the gateway is configured to backfill.
+var ErrActorEventBackfillTooLong = errors.New("subscription requests too much event history")
+
+// ErrActorStateTooComplex is returned by StateReadState when the decoded state has more entries
+// than the gateway is configured to return.
+var ErrActorStateTooComplex = errors.New("actor state too complex")
+
+// ErrReplayResultTooLarge is returned by StateReplay when the serialized result is larger than the
+// gateway is configured to return.
+var ErrReplayResultTooLarge = errors.New("replay result too large")
+
+// ErrResponseTooLarge is returned by ChainReadObj, ChainGetNode, ChainGetEvents and StateReadState
+// when the serialized object is larger than the gateway is configured to return.
+var ErrResponseTooLarge = errors.New("response too large")
+
 type reverseProxyV1 struct {
 	gateway       *Node
 	server        v1api.FullNode
@@ -42,7 +69,47 @@ func (pv1 *reverseProxyV1) MpoolPending(ctx context.Context, tsk types.TipSetKey
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
 		return nil, err
 	}
-	return pv1.server.MpoolPending(ctx, tsk)
+	msgs, err := pv1.server.MpoolPending(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	if maxMsgs := pv1.gateway.mpoolPendingMaxMessages; maxMsgs > 0 && len(msgs) > maxMsgs {
+		return nil, xerrors.Errorf("%w: the mempool holds %d messages, more than the maximum of %d", ErrTooManyPendingMessages, len(msgs), maxMsgs)
+	}
+	return msgs, nil
+}
+
+func (pv1 *reverseProxyV1) MpoolPendingByAddress(ctx context.Context, addrs []address.Address, tsk types.TipSetKey) ([]*types.SignedMessage, error) {
+	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+		return nil, err
+	}
+	if maxAddrs := pv1.gateway.mpoolPendingMaxAddresses; maxAddrs > 0 && len(addrs) > maxAddrs {
+		return nil, xerrors.Errorf("%w: %d addresses, the maximum is %d", ErrTooManyPendingAddresses, len(addrs), maxAddrs)
+	}
+	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
+		return nil, err
+	}
+	msgs, err := pv1.server.MpoolPending(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	match := make(map[address.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		match[addr] = struct{}{}
+	}
+	filtered := make([]*types.SignedMessage, 0)
+	for _, msg := range msgs {
+		_, from := match[msg.Message.From]
+		_, to := match[msg.Message.To]
+		if from || to {
+			filtered = append(filtered, msg)
+		}
+	}
+	if maxMsgs := pv1.gateway.mpoolPendingMaxMessages; maxMsgs > 0 && len(filtered) > maxMsgs {
+		return nil, xerrors.Errorf("%w: %d pending messages match, more than the maximum of %d", ErrTooManyPendingMessages, len(filtered), maxMsgs)
+	}
+	return filtered, nil
 }
 
 func (pv1 *reverseProxyV1) ChainGetBlock(ctx context.Context, c cid.Cid) (*types.BlockHeader, error) {
@@ -69,7 +136,14 @@ func (pv1 *reverseProxyV1) StateReplay(ctx context.Context, tsk types.TipSetKey,
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
 		return nil, err
 	}
-	return pv1.server.StateReplay(ctx, tsk, c)
+	res, err := pv1.server.StateReplay(ctx, tsk, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkReplayResultSize(res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (pv1 *reverseProxyV1) GasEstimateGasPremium(ctx context.Context, nblocksincl uint64, sender address.Address, gaslimit int64, tsk types.TipSetKey) (types.BigInt, error) {
@@ -103,6 +177,26 @@ func (pv1 *reverseProxyV1) Version(ctx context.Context) (api.APIVersion, error)
 	return pv1.server.Version(ctx)
 }
 
+func (pv1 *reverseProxyV1) GatewayDeepHealth(ctx context.Context) (*api.GatewayHealth, error) {
+	// not rate limited, so that readiness checks keep working while the gateway is busy
+	return deepHealth(ctx, pv1.server), nil
+}
+
+func (pv1 *reverseProxyV1) GatewayHealth(ctx context.Context) (*api.GatewayHealth, error) {
+	if err := pv1.gateway.limit(ctx, basicRateLimitTokens); err != nil {
+		return nil, err
+	}
+	return pv1.gateway.health(ctx, pv1.server), nil
+}
+
+func (pv1 *reverseProxyV1) RateLimitStatus(ctx context.Context) (api.GatewayRateLimitStatus, error) {
+	return pv1.gateway.rateLimitStatus(ctx), nil
+}
+
+func (pv1 *reverseProxyV1) GatewayVersion(ctx context.Context) (api.GatewayInfo, error) {
+	return pv1.gateway.info(), nil
+}
+
 func (pv1 *reverseProxyV1) ChainGetParentMessages(ctx context.Context, c cid.Cid) ([]api.Message, error) {
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
@@ -164,34 +258,48 @@ func (pv1 *reverseProxyV1) ChainGetTipSetByHeight(ctx context.Context, h abi.Cha
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
+	key := tipSetCacheKey{height: h, anchor: tsk, after: false}
 	if err := pv1.gateway.checkKeyedTipSetHeight(ctx, h, tsk); err != nil {
-		return nil, err
+		return serveStale(ctx, pv1.gateway, pv1.gateway.tipSetCache, key, err)
 	}
-	return pv1.server.ChainGetTipSetByHeight(ctx, h, tsk)
+	return pv1.gateway.finalTipSetAtHeight(ctx, key, func() (*types.TipSet, error) {
+		return pv1.server.ChainGetTipSetByHeight(ctx, h, tsk)
+	})
 }
 
 func (pv1 *reverseProxyV1) ChainGetTipSetAfterHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
+	key := tipSetCacheKey{height: h, anchor: tsk, after: true}
 	if err := pv1.gateway.checkKeyedTipSetHeight(ctx, h, tsk); err != nil {
-		return nil, err
+		return serveStale(ctx, pv1.gateway, pv1.gateway.tipSetCache, key, err)
 	}
-	return pv1.server.ChainGetTipSetAfterHeight(ctx, h, tsk)
+	return pv1.gateway.finalTipSetAtHeight(ctx, key, func() (*types.TipSet, error) {
+		return pv1.server.ChainGetTipSetAfterHeight(ctx, h, tsk)
+	})
 }
 
 func (pv1 *reverseProxyV1) ChainGetNode(ctx context.Context, param string) (*api.IpldObject, error) {
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.ChainGetNode(ctx, param)
+	obj, err := pv1.server.ChainGetNode(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkResponseSize(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 func (pv1 *reverseProxyV1) ChainNotify(ctx context.Context) (<-chan []*api.HeadChange, error) {
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.ChainNotify(ctx)
+	// all clients are served from a single subscription on the target
+	return pv1.gateway.chainNotify.subscribe(ctx)
 }
 
 func (pv1 *reverseProxyV1) ChainGetPath(ctx context.Context, from, to types.TipSetKey) ([]*api.HeadChange, error) {
@@ -218,7 +326,14 @@ func (pv1 *reverseProxyV1) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte,
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.ChainReadObj(ctx, c)
+	obj, err := pv1.server.ChainReadObj(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkResponseSize(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 func (pv1 *reverseProxyV1) ChainPutObj(context.Context, blocks.Block) error {
@@ -243,7 +358,7 @@ func (pv1 *reverseProxyV1) MpoolGetNonce(ctx context.Context, addr address.Addre
 }
 
 func (pv1 *reverseProxyV1) MpoolPush(ctx context.Context, sm *types.SignedMessage) (cid.Cid, error) {
-	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+	if err := pv1.gateway.limitWrite(ctx, stateRateLimitTokens); err != nil {
 		return cid.Cid{}, err
 	}
 	// TODO: additional anti-spam checks
@@ -320,6 +435,18 @@ func (pv1 *reverseProxyV1) StateDealProviderCollateralBounds(ctx context.Context
 	return pv1.server.StateDealProviderCollateralBounds(ctx, size, verified, tsk)
 }
 
+// UndecodedParams is returned by StateDecodeParams in place of the decoded params, when the gateway
+// is configured to fall back to it (see WithStateDecodeParamsFallback), for params the target
+// couldn't decode, e.g. because the actor or method isn't known to it.
+type UndecodedParams struct {
+	// Raw is the hex encoding of the params as given.
+	Raw string
+	// Undecodable is always true, marking the params as ones that couldn't be decoded.
+	Undecodable bool
+	// Error is the error the target returned when decoding the params.
+	Error string
+}
+
 func (pv1 *reverseProxyV1) StateDecodeParams(ctx context.Context, toAddr address.Address, method abi.MethodNum, params []byte, tsk types.TipSetKey) (interface{}, error) {
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
@@ -327,17 +454,32 @@ func (pv1 *reverseProxyV1) StateDecodeParams(ctx context.Context, toAddr address
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
 		return nil, err
 	}
-	return pv1.server.StateDecodeParams(ctx, toAddr, method, params, tsk)
+	decoded, err := pv1.server.StateDecodeParams(ctx, toAddr, method, params, tsk)
+	if err != nil && pv1.gateway.decodeParamsFallback && !isBackendUnavailable(err) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		log.Debugw("falling back to raw params", "to", toAddr, "method", method, "error", err)
+		return &UndecodedParams{Raw: hex.EncodeToString(params), Undecodable: true, Error: err.Error()}, nil
+	}
+	return decoded, err
 }
 
 func (pv1 *reverseProxyV1) StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
+	key := actorCacheKey{actor: actor, tsk: tsk}
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
-		return nil, err
+		return pv1.gateway.actorCache.serveStale(ctx, pv1.gateway, key, err)
+	}
+	if pv1.gateway.actorCache == nil {
+		return pv1.server.StateGetActor(ctx, actor, tsk)
+	}
+	act, err := pv1.gateway.actorCache.getOrFetch(ctx, key, func() (*types.Actor, error) {
+		return pv1.server.StateGetActor(ctx, actor, tsk)
+	})
+	if err != nil {
+		return pv1.gateway.actorCache.serveStale(ctx, pv1.gateway, key, err)
 	}
-	return pv1.server.StateGetActor(ctx, actor, tsk)
+	return act, nil
 }
 
 func (pv1 *reverseProxyV1) StateListMiners(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error) {
@@ -350,6 +492,47 @@ func (pv1 *reverseProxyV1) StateListMiners(ctx context.Context, tsk types.TipSet
 	return pv1.server.StateListMiners(ctx, tsk)
 }
 
+func (pv1 *reverseProxyV1) StateListMinersWithPower(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+		return nil, err
+	}
+	if tsk.IsEmpty() {
+		// pin the head so every miner's power is checked at the same tipset
+		head, err := pv1.server.ChainHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tsk = head.Key()
+	}
+	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
+		return nil, err
+	}
+	miners, err := pv1.server.StateListMiners(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	hasPower := make([]bool, len(miners))
+	if err := fanoutWith(ctx, pv1.gateway.minerPowerFanout, len(miners), func(ctx context.Context, i int) error {
+		power, err := pv1.server.StateMinerPower(ctx, miners[i], tsk)
+		if err != nil {
+			return err
+		}
+		hasPower[i] = power != nil && power.MinerPower.QualityAdjPower.Int != nil && power.MinerPower.QualityAdjPower.Sign() > 0
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	withPower := make([]address.Address, 0, len(miners))
+	for i, miner := range miners {
+		if hasPower[i] {
+			withPower = append(withPower, miner)
+		}
+	}
+	return withPower, nil
+}
+
 func (pv1 *reverseProxyV1) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return address.Address{}, err
@@ -401,15 +584,15 @@ func (pv1 *reverseProxyV1) StateSearchMsg(ctx context.Context, from types.TipSet
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	if limit == api.LookbackNoLimit {
-		limit = pv1.gateway.maxMessageLookbackEpochs
-	}
-	if pv1.gateway.maxMessageLookbackEpochs != api.LookbackNoLimit && limit > pv1.gateway.maxMessageLookbackEpochs {
-		limit = pv1.gateway.maxMessageLookbackEpochs
-	}
+	limit = pv1.gateway.messageLookbackLimit(limit, allowReplaced)
 	if err := pv1.gateway.checkTipSetKey(ctx, from); err != nil {
 		return nil, err
 	}
+	release, err := pv1.gateway.acquireMessageSearchSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return pv1.server.StateSearchMsg(ctx, from, msg, limit, allowReplaced)
 }
 
@@ -417,12 +600,12 @@ func (pv1 *reverseProxyV1) StateWaitMsg(ctx context.Context, msg cid.Cid, confid
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	if limit == api.LookbackNoLimit {
-		limit = pv1.gateway.maxMessageLookbackEpochs
-	}
-	if pv1.gateway.maxMessageLookbackEpochs != api.LookbackNoLimit && limit > pv1.gateway.maxMessageLookbackEpochs {
-		limit = pv1.gateway.maxMessageLookbackEpochs
+	limit = pv1.gateway.messageLookbackLimit(limit, allowReplaced)
+	release, err := pv1.gateway.acquireMessageSearchSlot(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 	return pv1.server.StateWaitMsg(ctx, msg, confidence, limit, allowReplaced)
 }
 
@@ -447,6 +630,9 @@ func (pv1 *reverseProxyV1) SubscribeActorEventsRaw(ctx context.Context, filter *
 			return nil, err
 		}
 	}
+	if err := pv1.gateway.checkActorEventBackfill(ctx, filter); err != nil {
+		return nil, err
+	}
 	return pv1.server.SubscribeActorEventsRaw(ctx, filter)
 }
 
@@ -454,7 +640,24 @@ func (pv1 *reverseProxyV1) ChainGetEvents(ctx context.Context, eventsRoot cid.Ci
 	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv1.server.ChainGetEvents(ctx, eventsRoot)
+	events, err := pv1.server.ChainGetEvents(ctx, eventsRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkChainEventsCount(uint64(len(events))); err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkResponseSize(events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (pv1 *reverseProxyV1) ChainGetEventsStream(ctx context.Context, eventsRoot cid.Cid) (<-chan []types.Event, error) {
+	if err := pv1.gateway.limit(ctx, chainRateLimitTokens); err != nil {
+		return nil, err
+	}
+	return streamChainEvents(ctx, pv1.gateway, pv1.server, eventsRoot)
 }
 
 func (pv1 *reverseProxyV1) StateReadState(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*api.ActorState, error) {
@@ -464,7 +667,69 @@ func (pv1 *reverseProxyV1) StateReadState(ctx context.Context, actor address.Add
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
 		return nil, err
 	}
-	return pv1.server.StateReadState(ctx, actor, tsk)
+	state, err := pv1.server.StateReadState(ctx, actor, tsk)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkActorStateEntries(state); err != nil {
+		return nil, err
+	}
+	if err := pv1.gateway.checkResponseSize(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (pv1 *reverseProxyV1) StateInspectActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*api.ActorInspection, error) {
+	// charged as a StateGetActor and a StateReadState
+	for i := 0; i < 2; i++ {
+		if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+			return nil, err
+		}
+	}
+	if tsk.IsEmpty() && pv1.gateway.defaultFinalizedReads {
+		tsk = finalizedTipSetKey(ctx, pv1.server)
+	}
+	if tsk.IsEmpty() {
+		// pin the head so both calls see the same state
+		head, err := pv1.server.ChainHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tsk = head.Key()
+	}
+	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
+		return nil, err
+	}
+
+	var (
+		act      *types.Actor
+		state    *api.ActorState
+		stateErr error
+	)
+	if err := pv1.gateway.fanout(ctx, 2, func(ctx context.Context, i int) error {
+		var err error
+		if i == 0 {
+			act, err = pv1.server.StateGetActor(ctx, actor, tsk)
+			return err
+		}
+		// a state that can't be decoded is reported alongside the actor rather than failing
+		state, stateErr = pv1.server.StateReadState(ctx, actor, tsk)
+		if stateErr == nil {
+			stateErr = pv1.gateway.checkActorStateEntries(state)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	res := &api.ActorInspection{Actor: act}
+	if stateErr != nil {
+		res.StateError = stateErr.Error()
+	} else if state != nil {
+		res.State = state.State
+	}
+	return res, nil
 }
 
 func (pv1 *reverseProxyV1) StateMinerPower(ctx context.Context, m address.Address, tsk types.TipSetKey) (*api.MinerPower, error) {
@@ -501,10 +766,32 @@ func (pv1 *reverseProxyV1) StateMinerInfo(ctx context.Context, m address.Address
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return api.MinerInfo{}, err
 	}
+	key := minerInfoCacheKey{miner: m, tsk: tsk}
 	if err := pv1.gateway.checkTipSetKey(ctx, tsk); err != nil {
-		return api.MinerInfo{}, err
+		return serveStale(ctx, pv1.gateway, pv1.gateway.minerInfoCache, key, err)
+	}
+	if pv1.gateway.minerInfoCache == nil {
+		return pv1.server.StateMinerInfo(ctx, m, tsk)
 	}
-	return pv1.server.StateMinerInfo(ctx, m, tsk)
+	if tsk.IsEmpty() {
+		info, err := pv1.server.StateMinerInfo(ctx, m, tsk)
+		if err != nil {
+			return serveStale(ctx, pv1.gateway, pv1.gateway.minerInfoCache, key, err)
+		}
+		if pv1.gateway.serveStaleOnOutage {
+			// the result for the current head can't be reused while the target is available, but
+			// is kept to be served stale if it becomes unavailable
+			pv1.gateway.minerInfoCache.add(key, info)
+		}
+		return info, nil
+	}
+	info, err := pv1.gateway.minerInfoCache.getOrFetch(ctx, key, func() (api.MinerInfo, error) {
+		return pv1.server.StateMinerInfo(ctx, m, tsk)
+	})
+	if err != nil {
+		return serveStale(ctx, pv1.gateway, pv1.gateway.minerInfoCache, key, err)
+	}
+	return info, nil
 }
 
 func (pv1 *reverseProxyV1) StateMinerDeadlines(ctx context.Context, m address.Address, tsk types.TipSetKey) ([]api.Deadline, error) {
@@ -567,6 +854,85 @@ func (pv1 *reverseProxyV1) StateVerifiedClientStatus(ctx context.Context, addr a
 	return pv1.server.StateVerifiedClientStatus(ctx, addr, tsk)
 }
 
+func (pv1 *reverseProxyV1) SubscribeVerifiedClientStatus(ctx context.Context, addr address.Address) (<-chan api.VerifiedClientStatusChange, error) {
+	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+		return nil, err
+	}
+
+	ft, err := getStatefulTrackerV1(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("SubscribeVerifiedClientStatus not supported: %w", err)
+	}
+	release, err := ft.reserveChannel(pv1.gateway.currentSettings().ethMaxFiltersPerConn)
+	if err != nil {
+		return nil, err
+	}
+
+	notifs, err := pv1.server.ChainNotify(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	out := make(chan api.VerifiedClientStatusChange)
+	go func() {
+		defer release()
+		defer close(out)
+
+		var (
+			sent    bool
+			dataCap *abi.StoragePower
+		)
+		for {
+			var changes []*api.HeadChange
+			select {
+			case c, ok := <-notifs:
+				if !ok {
+					return
+				}
+				changes = c
+			case <-ctx.Done():
+				return
+			}
+
+			var ts *types.TipSet
+			for _, change := range changes {
+				if change.Type == store.HCCurrent || change.Type == store.HCApply {
+					ts = change.Val
+				}
+			}
+			if ts == nil {
+				continue // only reverts, the status will be read at the tipset applied in their place
+			}
+
+			// read directly from the target, the subscription has already been charged for
+			dc, err := pv1.server.StateVerifiedClientStatus(ctx, addr, ts.Key())
+			if err != nil {
+				log.Warnw("failed to read verified client status for subscription", "address", addr, "tipset", ts.Key(), "error", err)
+				continue
+			}
+			if sent && equalDataCap(dc, dataCap) {
+				continue
+			}
+
+			select {
+			case out <- api.VerifiedClientStatusChange{Height: ts.Height(), TipSetKey: ts.Key(), DataCap: dc}:
+				sent, dataCap = true, dc
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func equalDataCap(a, b *abi.StoragePower) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equals(*b)
+}
+
 func (pv1 *reverseProxyV1) StateVerifierStatus(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*abi.StoragePower, error) {
 	if err := pv1.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
@@ -73,6 +73,9 @@ func (pv2 *reverseProxyV2) Web3ClientVersion(ctx context.Context) (string, error
 		return "", err
 	}
 
+	if pv2.gateway.clientVersion != "" {
+		return pv2.gateway.clientVersion, nil
+	}
 	return pv2.server.Web3ClientVersion(ctx)
 }
 
@@ -122,7 +125,10 @@ func (pv2 *reverseProxyV2) EthAccounts(context.Context) ([]ethtypes.EthAddress,
 }
 
 func (pv2 *reverseProxyV2) EthSendRawTransaction(ctx context.Context, rawTx ethtypes.EthBytes) (ethtypes.EthHash, error) {
-	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+	if err := pv2.gateway.limitWrite(ctx, stateRateLimitTokens); err != nil {
+		return ethtypes.EthHash{}, err
+	}
+	if err := pv2.gateway.checkEthRawTx(rawTx); err != nil {
 		return ethtypes.EthHash{}, err
 	}
 
@@ -131,7 +137,7 @@ func (pv2 *reverseProxyV2) EthSendRawTransaction(ctx context.Context, rawTx etht
 }
 
 func (pv2 *reverseProxyV2) EthSendRawTransactionUntrusted(ctx context.Context, rawTx ethtypes.EthBytes) (ethtypes.EthHash, error) {
-	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
+	if err := pv2.gateway.limitWrite(ctx, stateRateLimitTokens); err != nil {
 		return ethtypes.EthHash{}, err
 	}
 	return pv2.server.EthSendRawTransactionUntrusted(ctx, rawTx)
@@ -170,11 +176,15 @@ func (pv2 *reverseProxyV2) EthGetBlockByHash(ctx context.Context, blkHash ethtyp
 		return ethtypes.EthBlock{}, err
 	}
 
+	key := ethBlockCacheKey{hash: blkHash, fullTxInfo: fullTxInfo}
 	if err := pv2.checkBlkHash(ctx, blkHash); err != nil {
-		return ethtypes.EthBlock{}, err
+		return serveStale(ctx, pv2.gateway, pv2.gateway.ethBlockCache, key, err)
 	}
 
-	return pv2.server.EthGetBlockByHash(ctx, blkHash, fullTxInfo)
+	fetch := func() (ethtypes.EthBlock, error) { return pv2.server.EthGetBlockByHash(ctx, blkHash, fullTxInfo) }
+	return pv2.gateway.ethFinalBlock(ctx, key, fetch, func() (*types.TipSet, error) {
+		return pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
+	})
 }
 
 func (pv2 *reverseProxyV2) EthGetBlockByNumber(ctx context.Context, blkNum string, fullTxInfo bool) (ethtypes.EthBlock, error) {
@@ -182,25 +192,35 @@ func (pv2 *reverseProxyV2) EthGetBlockByNumber(ctx context.Context, blkNum strin
 		return ethtypes.EthBlock{}, err
 	}
 
+	key, cacheable := ethBlockNumberKey(blkNum, fullTxInfo)
 	if err := pv2.checkBlkParam(ctx, blkNum, 0); err != nil {
+		if cacheable {
+			return serveStale(ctx, pv2.gateway, pv2.gateway.ethBlockCache, key, err)
+		}
 		return ethtypes.EthBlock{}, err
 	}
 
-	return pv2.server.EthGetBlockByNumber(ctx, blkNum, fullTxInfo)
+	fetch := func() (ethtypes.EthBlock, error) { return pv2.server.EthGetBlockByNumber(ctx, blkNum, fullTxInfo) }
+	if !cacheable {
+		return fetch()
+	}
+	return pv2.gateway.ethFinalBlock(ctx, key, fetch, func() (*types.TipSet, error) {
+		return pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
+	})
 }
 
 func (pv2 *reverseProxyV2) EthGetTransactionByHash(ctx context.Context, txHash *ethtypes.EthHash) (*ethtypes.EthTx, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetTransactionByHashLimited(ctx, txHash, pv2.gateway.maxMessageLookbackEpochs)
+	return pv2.server.EthGetTransactionByHashLimited(ctx, txHash, pv2.gateway.currentSettings().maxMessageLookbackEpochs)
 }
 
 func (pv2 *reverseProxyV2) EthGetTransactionByHashLimited(ctx context.Context, txHash *ethtypes.EthHash, limit abi.ChainEpoch) (*ethtypes.EthTx, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetTransactionByHashLimited(ctx, txHash, limit)
+	return pv2.server.EthGetTransactionByHashLimited(ctx, txHash, pv2.gateway.messageLookbackLimit(limit, false))
 }
 
 func (pv2 *reverseProxyV2) EthGetTransactionByBlockHashAndIndex(ctx context.Context, blkHash ethtypes.EthHash, txIndex ethtypes.EthUint64) (*ethtypes.EthTx, error) {
@@ -259,28 +279,38 @@ func (pv2 *reverseProxyV2) EthGetTransactionReceipt(ctx context.Context, txHash
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetTransactionReceiptLimited(ctx, txHash, pv2.gateway.maxMessageLookbackEpochs)
+	limit := pv2.gateway.currentSettings().maxMessageLookbackEpochs
+	receipt, err := pv2.server.EthGetTransactionReceiptLimited(ctx, txHash, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pv2.gateway.enrichEthReceipt(ctx, pv2.server, receipt, limit), nil
 }
 
 func (pv2 *reverseProxyV2) EthGetTransactionReceiptLimited(ctx context.Context, txHash ethtypes.EthHash, limit abi.ChainEpoch) (*ethtypes.EthTxReceipt, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetTransactionReceiptLimited(ctx, txHash, limit)
+	limit = pv2.gateway.messageLookbackLimit(limit, false)
+	receipt, err := pv2.server.EthGetTransactionReceiptLimited(ctx, txHash, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pv2.gateway.enrichEthReceipt(ctx, pv2.server, receipt, limit), nil
 }
 
 func (pv2 *reverseProxyV2) EthGetBlockReceipts(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash) ([]*ethtypes.EthTxReceipt, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetBlockReceiptsLimited(ctx, blkParam, pv2.gateway.maxMessageLookbackEpochs)
+	return pv2.server.EthGetBlockReceiptsLimited(ctx, blkParam, pv2.gateway.currentSettings().maxMessageLookbackEpochs)
 }
 
 func (pv2 *reverseProxyV2) EthGetBlockReceiptsLimited(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash, limit abi.ChainEpoch) ([]*ethtypes.EthTxReceipt, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
-	return pv2.server.EthGetBlockReceiptsLimited(ctx, blkParam, limit)
+	return pv2.server.EthGetBlockReceiptsLimited(ctx, blkParam, pv2.gateway.messageLookbackLimit(limit, false))
 }
 
 func (pv2 *reverseProxyV2) EthGetCode(ctx context.Context, address ethtypes.EthAddress, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
@@ -291,6 +321,9 @@ func (pv2 *reverseProxyV2) EthGetCode(ctx context.Context, address ethtypes.EthA
 	if err := pv2.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv2.checkEthCallBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
 
 	return pv2.server.EthGetCode(ctx, address, blkParam)
 }
@@ -303,6 +336,9 @@ func (pv2 *reverseProxyV2) EthGetStorageAt(ctx context.Context, address ethtypes
 	if err := pv2.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv2.checkEthStorageBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
 
 	return pv2.server.EthGetStorageAt(ctx, address, position, blkParam)
 }
@@ -328,6 +364,16 @@ func (pv2 *reverseProxyV2) EthTraceBlock(ctx context.Context, blkNum string) ([]
 		return nil, err
 	}
 
+	if err := pv2.gateway.v1Proxy.checkTraceBlockMessages(ctx, blkNum); err != nil {
+		return nil, err
+	}
+
+	release, err := pv2.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv2.server.EthTraceBlock(ctx, blkNum)
 }
 
@@ -340,7 +386,24 @@ func (pv2 *reverseProxyV2) EthTraceReplayBlockTransactions(ctx context.Context,
 		return nil, err
 	}
 
-	return pv2.server.EthTraceReplayBlockTransactions(ctx, blkNum, traceTypes)
+	if err := pv2.gateway.v1Proxy.checkTraceBlockMessages(ctx, blkNum); err != nil {
+		return nil, err
+	}
+
+	release, err := pv2.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := pv2.server.EthTraceReplayBlockTransactions(ctx, blkNum, traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	if err := pv2.gateway.checkTraceReplayResults(res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (pv2 *reverseProxyV2) EthTraceTransaction(ctx context.Context, txHash string) ([]*ethtypes.EthTraceTransaction, error) {
@@ -348,6 +411,12 @@ func (pv2 *reverseProxyV2) EthTraceTransaction(ctx context.Context, txHash strin
 		return nil, err
 	}
 
+	release, err := pv2.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv2.server.EthTraceTransaction(ctx, txHash)
 }
 
@@ -368,6 +437,12 @@ func (pv2 *reverseProxyV2) EthTraceFilter(ctx context.Context, filter ethtypes.E
 		}
 	}
 
+	release, err := pv2.gateway.acquireTraceSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv2.server.EthTraceFilter(ctx, filter)
 }
 
@@ -392,6 +467,9 @@ func (pv2 *reverseProxyV2) EthFeeHistory(ctx context.Context, p jsonrpc.RawParam
 	if err := pv2.checkBlkParam(ctx, params.NewestBlkNum, params.BlkCount); err != nil {
 		return ethtypes.EthFeeHistory{}, err
 	}
+	if err := pv2.checkEthFeeHistoryBlockAge(ctx, params.NewestBlkNum); err != nil {
+		return ethtypes.EthFeeHistory{}, err
+	}
 
 	if params.BlkCount > ethtypes.EthUint64(EthFeeHistoryMaxBlockCount) {
 		return ethtypes.EthFeeHistory{}, xerrors.New("block count too high")
@@ -410,7 +488,7 @@ func (pv2 *reverseProxyV2) EthMaxPriorityFeePerGas(ctx context.Context) (ethtype
 
 func (pv2 *reverseProxyV2) EthEstimateGas(ctx context.Context, p jsonrpc.RawParams) (ethtypes.EthUint64, error) {
 	// validate params
-	_, err := jsonrpc.DecodeParams[ethtypes.EthEstimateGasParams](p)
+	params, err := jsonrpc.DecodeParams[ethtypes.EthEstimateGasParams](p)
 	if err != nil {
 		return ethtypes.EthUint64(0), xerrors.Errorf("decoding params: %w", err)
 	}
@@ -419,8 +497,20 @@ func (pv2 *reverseProxyV2) EthEstimateGas(ctx context.Context, p jsonrpc.RawPara
 		return 0, err
 	}
 
-	// todo limit gas? to what?
-	return pv2.server.EthEstimateGas(ctx, p)
+	if err := pv2.gateway.checkEthCallAddress(params.Tx); err != nil {
+		return 0, err
+	}
+	if capped, err := pv2.gateway.guardEthCall(&params.Tx); err != nil {
+		return 0, err
+	} else if capped {
+		if p, err = json.Marshal(params); err != nil {
+			return 0, xerrors.Errorf("encoding params: %w", err)
+		}
+	}
+
+	return simulate(ctx, pv2.gateway, func(ctx context.Context) (ethtypes.EthUint64, error) {
+		return pv2.server.EthEstimateGas(ctx, p)
+	})
 }
 
 func (pv2 *reverseProxyV2) EthCall(ctx context.Context, tx ethtypes.EthCall, blkParam ethtypes.EthBlockNumberOrHash) (ethtypes.EthBytes, error) {
@@ -431,15 +521,32 @@ func (pv2 *reverseProxyV2) EthCall(ctx context.Context, tx ethtypes.EthCall, blk
 	if err := pv2.checkEthBlockParam(ctx, blkParam, 0); err != nil {
 		return nil, err
 	}
+	if err := pv2.checkEthCallBlockParam(ctx, blkParam); err != nil {
+		return nil, err
+	}
+	if err := pv2.gateway.checkEthCallAddress(tx); err != nil {
+		return nil, err
+	}
+	if _, err := pv2.gateway.guardEthCall(&tx); err != nil {
+		return nil, err
+	}
 
-	// todo limit gas? to what?
-	return pv2.server.EthCall(ctx, tx, blkParam)
+	res, err := simulate(ctx, pv2.gateway, func(ctx context.Context) (ethtypes.EthBytes, error) {
+		return pv2.server.EthCall(ctx, tx, blkParam)
+	})
+	if err != nil && pv2.gateway.ethRevertReasons {
+		err = decodeRevertReason(err)
+	}
+	return res, err
 }
 
 func (pv2 *reverseProxyV2) EthGetLogs(ctx context.Context, filter *ethtypes.EthFilterSpec) (*ethtypes.EthFilterResult, error) {
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return nil, err
 	}
+	if err := pv2.gateway.checkEthFilter(filter); err != nil {
+		return nil, err
+	}
 
 	if filter.FromBlock != nil {
 		if err := pv2.checkBlkParam(ctx, *filter.FromBlock, 0); err != nil {
@@ -456,6 +563,21 @@ func (pv2 *reverseProxyV2) EthGetLogs(ctx context.Context, filter *ethtypes.EthF
 			return nil, err
 		}
 	}
+	if pv2.gateway.ethMaxLogsBlockRange > 0 {
+		head, err := pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
+		if err != nil {
+			return nil, err
+		}
+		if err := pv2.gateway.checkEthLogsBlockRange(filter, head); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := pv2.gateway.acquireLogsSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	return pv2.server.EthGetLogs(ctx, filter)
 }
@@ -484,6 +606,9 @@ func (pv2 *reverseProxyV2) EthNewFilter(ctx context.Context, filter *ethtypes.Et
 	if err := pv2.gateway.limit(ctx, stateRateLimitTokens); err != nil {
 		return ethtypes.EthFilterID{}, err
 	}
+	if err := pv2.gateway.checkEthFilter(filter); err != nil {
+		return ethtypes.EthFilterID{}, err
+	}
 
 	return pv2.addUserFilterLimited(ctx, "EthNewFilter", func() (ethtypes.EthFilterID, error) {
 		return pv2.server.EthNewFilter(ctx, filter)
@@ -516,6 +641,7 @@ func (pv2 *reverseProxyV2) EthUninstallFilter(ctx context.Context, id ethtypes.E
 	}
 
 	delete(ft.userFilters, id)
+	ft.hostFilters.release(ft.host, 1)
 	return ok, nil
 }
 
@@ -533,7 +659,11 @@ func (pv2 *reverseProxyV2) EthGetFilterChanges(ctx context.Context, id ethtypes.
 		return nil, filter.ErrFilterNotFound
 	}
 
-	return pv2.server.EthGetFilterChanges(ctx, id)
+	res, err := pv2.server.EthGetFilterChanges(ctx, id)
+	if err != nil {
+		return nil, pv2.gateway.expireFilter(ft, id, err)
+	}
+	return res, nil
 }
 
 func (pv2 *reverseProxyV2) EthGetFilterLogs(ctx context.Context, id ethtypes.EthFilterID) (*ethtypes.EthFilterResult, error) {
@@ -550,12 +680,18 @@ func (pv2 *reverseProxyV2) EthGetFilterLogs(ctx context.Context, id ethtypes.Eth
 		return nil, nil
 	}
 
+	release, err := pv2.gateway.acquireLogsSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	return pv2.server.EthGetFilterLogs(ctx, id)
 }
 
 func (pv2 *reverseProxyV2) EthSubscribe(ctx context.Context, p jsonrpc.RawParams) (ethtypes.EthSubscriptionID, error) {
 	// validate params
-	_, err := jsonrpc.DecodeParams[ethtypes.EthSubscribeParams](p)
+	params, err := jsonrpc.DecodeParams[ethtypes.EthSubscribeParams](p)
 	if err != nil {
 		return ethtypes.EthSubscriptionID{}, xerrors.Errorf("decoding params: %w", err)
 	}
@@ -580,28 +716,69 @@ func (pv2 *reverseProxyV2) EthSubscribe(ctx context.Context, p jsonrpc.RawParams
 	ft.lk.Lock()
 	defer ft.lk.Unlock()
 
-	if len(ft.userSubscriptions)+len(ft.userFilters) >= pv2.gateway.ethMaxFiltersPerConn {
-		return ethtypes.EthSubscriptionID{}, ErrTooManyFilters
+	replayable := pv2.gateway.subscriptionReplayBuffer > 0 && params.EventType == ethSubscribeNewHeads
+	var resume *ethSubscribeResume
+	if replayable {
+		if resume, err = decodeSubscribeResume(p); err != nil {
+			return ethtypes.EthSubscriptionID{}, err
+		}
 	}
 
-	sub, err := pv2.server.EthSubscribe(ctx, p)
+	evict, release, err := pv2.gateway.reserveSubscription(ft, pv2.subscriptions, params.EventType, resume != nil)
 	if err != nil {
 		return ethtypes.EthSubscriptionID{}, err
 	}
 
-	err = pv2.subscriptions.AddSub(ctx, sub, func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
+	var sub ethtypes.EthSubscriptionID
+	if resume != nil {
+		sub = *resume.Resume
+	} else if sub, err = pv2.server.EthSubscribe(ctx, p); err != nil {
+		release()
+		return ethtypes.EthSubscriptionID{}, err
+	}
+
+	sink := func(ctx context.Context, response *ethtypes.EthSubscriptionResponse) error {
 		outParam, err := json.Marshal(response)
 		if err != nil {
 			return err
 		}
 
 		return ethCb.EthSubscription(ctx, outParam)
-	})
+	}
+	var replay *subscriptionReplay
+	if resume != nil {
+		replay, err = pv2.subscriptions.resumeSub(ctx, sub, ft.host, resume.SinceSequence, ethCb)
+	} else if replayable {
+		replay, err = pv2.subscriptions.addReplayableSub(ctx, sub, pv2.gateway.subscriptionReplayBuffer, ft.host, ethCb)
+	} else if pv2.gateway.subscriptionBufferSize > 0 {
+		err = pv2.subscriptions.AddBufferedSub(ctx, sub, pv2.gateway.subscriptionBufferSize, sink, func() {
+			pv2.dropSubscription(ft, sub, ethCb)
+		})
+	} else {
+		err = pv2.subscriptions.AddSub(ctx, sub, sink)
+	}
 	if err != nil {
+		release()
 		return ethtypes.EthSubscriptionID{}, err
 	}
 
+	if evict != nil {
+		evictSubscription(ctx, ft, pv2.subscriptions, *evict, pv2.server.EthUnsubscribe, ethCb)
+		if resume != nil {
+			// the resumed subscription brought its own place in the host's count
+			ft.hostFilters.release(ft.host, 1)
+		}
+	}
+	ft.subscriptionTypes[sub] = params.EventType
 	ft.userSubscriptions[sub] = func() {
+		if replay != nil {
+			pv2.gateway.detachSubscription(ft, pv2.subscriptions, sub, replay, pv2.server.EthUnsubscribe)
+			return
+		}
+		pv2.subscriptions.RemoveSub(sub)
+		// the request that subscribed has long since returned, and its context with it
+		ctx, cancel := context.WithTimeout(context.Background(), subscriptionCleanupTimeout)
+		defer cancel()
 		if _, err := pv2.server.EthUnsubscribe(ctx, sub); err != nil {
 			log.Warnf("error unsubscribing after connection end: %v", err)
 		}
@@ -635,6 +812,8 @@ func (pv2 *reverseProxyV2) EthUnsubscribe(ctx context.Context, id ethtypes.EthSu
 	}
 
 	delete(ft.userSubscriptions, id)
+	delete(ft.subscriptionTypes, id)
+	ft.hostFilters.release(ft.host, 1)
 
 	if pv2.subscriptions != nil {
 		pv2.subscriptions.RemoveSub(id)
@@ -643,6 +822,30 @@ func (pv2 *reverseProxyV2) EthUnsubscribe(ctx context.Context, id ethtypes.EthSu
 	return ok, nil
 }
 
+// dropSubscription removes a subscription that the gateway has given up delivering notifications
+// for because the client wasn't keeping up with them, telling the client through ethCb.
+func (pv2 *reverseProxyV2) dropSubscription(ft *statefulCallTracker, id ethtypes.EthSubscriptionID, ethCb api.EthSubscriberMethods) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+
+	if _, ok := ft.userSubscriptions[id]; !ok {
+		return
+	}
+
+	// the request that subscribed has long since returned, and its context with it
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionCleanupTimeout)
+	defer cancel()
+
+	if _, err := pv2.server.EthUnsubscribe(ctx, id); err != nil {
+		log.Warnf("error unsubscribing dropped subscription: %v", err)
+	}
+	delete(ft.userSubscriptions, id)
+	delete(ft.subscriptionTypes, id)
+	ft.hostFilters.release(ft.host, 1)
+
+	notifyUnsubscribed(ctx, ethCb, id, ErrSubscriptionBufferFull.Error())
+}
+
 func (pv2 *reverseProxyV2) Discover(context.Context) (apitypes.OpenRPCDocument, error) {
 	return build.OpenRPCDiscoverJSON_GatewayV2(), nil
 }
@@ -672,12 +875,16 @@ func (pv2 *reverseProxyV2) addUserFilterLimited(
 	ft.lk.Lock()
 	defer ft.lk.Unlock()
 
-	if len(ft.userSubscriptions)+len(ft.userFilters) >= pv2.gateway.ethMaxFiltersPerConn {
+	if ft.active() >= pv2.gateway.currentSettings().ethMaxFiltersPerConn {
 		return ethtypes.EthFilterID{}, ErrTooManyFilters
 	}
+	if !ft.hostFilters.reserve(ft.host) {
+		return ethtypes.EthFilterID{}, ErrTooManyFiltersPerHost
+	}
 
 	id, err := install()
 	if err != nil {
+		ft.hostFilters.release(ft.host, 1)
 		return id, err
 	}
 
@@ -708,6 +915,12 @@ func (pv2 *reverseProxyV2) checkBlkHash(ctx context.Context, blkHash ethtypes.Et
 }
 
 func (pv2 *reverseProxyV2) checkEthBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash, lookback ethtypes.EthUint64) error {
+	if b := blockParamBatchFrom(ctx); b != nil {
+		return b.check(ctx, ethBlockParamKey(blkParam), lookback, func(ctx context.Context) error {
+			return pv2.checkEthBlockParam(ctx, blkParam, lookback)
+		})
+	}
+
 	// first check if it's a predefined block or a block number
 	if blkParam.PredefinedBlock != nil || blkParam.BlockNumber != nil {
 		head, err := pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
@@ -733,7 +946,7 @@ func (pv2 *reverseProxyV2) checkEthBlockParam(ctx context.Context, blkParam etht
 			num = *blkParam.BlockNumber
 		}
 
-		return pv2.gateway.checkTipSetHeight(head, abi.ChainEpoch(num))
+		return pv2.gateway.checkTipSetHeight(ctx, head, abi.ChainEpoch(num))
 	}
 
 	// otherwise its a block hash
@@ -744,7 +957,71 @@ func (pv2 *reverseProxyV2) checkEthBlockParam(ctx context.Context, blkParam etht
 	return xerrors.New("invalid block param")
 }
 
+// checkEthCallBlockParam enforces the maximum block age for methods that execute against the state
+// of the referenced block.
+func (pv2 *reverseProxyV2) checkEthCallBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash) error {
+	return pv2.checkEthBlockParamAge(ctx, blkParam, pv2.gateway.currentSettings().ethCallMaxBlockAge)
+}
+
+// checkEthStorageBlockParam enforces the maximum block age for EthGetStorageAt.
+func (pv2 *reverseProxyV2) checkEthStorageBlockParam(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash) error {
+	return pv2.checkEthBlockParamAge(ctx, blkParam, pv2.gateway.ethStorageBlockAgeLimit())
+}
+
+// checkEthBlockParamAge rejects block params referencing a block more than maxAge epochs behind the
+// head. A maxAge of 0 removes the limit.
+func (pv2 *reverseProxyV2) checkEthBlockParamAge(ctx context.Context, blkParam ethtypes.EthBlockNumberOrHash, maxAge abi.ChainEpoch) error {
+	if maxAge == 0 {
+		return nil
+	}
+
+	head, err := pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
+	if err != nil {
+		return err
+	}
+
+	h := head.Height()
+	switch {
+	case blkParam.BlockNumber != nil:
+		h = abi.ChainEpoch(*blkParam.BlockNumber)
+	case blkParam.BlockHash != nil:
+		tsk, err := pv2.tskByEthHash(ctx, *blkParam.BlockHash)
+		if err != nil {
+			return err
+		}
+		ts, err := pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Key(tsk))
+		if err != nil {
+			return err
+		}
+		h = ts.Height()
+	case blkParam.PredefinedBlock != nil && *blkParam.PredefinedBlock == "safe":
+		h -= ethtypes.SafeEpochDelay
+	case blkParam.PredefinedBlock != nil && *blkParam.PredefinedBlock == "finalized":
+		h -= policy.ChainFinality
+	}
+
+	return pv2.gateway.checkEthBlockAge(head, h, maxAge)
+}
+
+func (pv2 *reverseProxyV2) checkEthFeeHistoryBlockAge(ctx context.Context, blkParam string) error {
+	if pv2.gateway.ethFeeHistoryMaxBlockAge <= 0 {
+		return nil
+	}
+
+	head, err := pv2.ChainGetTipSet(ctx, types.TipSetSelectors.Latest)
+	if err != nil {
+		return err
+	}
+	return pv2.gateway.checkEthFeeHistoryBlockAge(head, blkParam)
+}
+
 func (pv2 *reverseProxyV2) checkBlkParam(ctx context.Context, blkParam string, lookback ethtypes.EthUint64) error {
+	if b := blockParamBatchFrom(ctx); b != nil {
+		return b.check(ctx, blkParamKey(blkParam), lookback, func(ctx context.Context) error {
+			return pv2.checkBlkParam(ctx, blkParam, lookback)
+		})
+	}
+
 	if blkParam == "earliest" {
 		// also not supported in node impl
 		return xerrors.New(`block param "earliest" is not supported`)
@@ -781,5 +1058,5 @@ func (pv2 *reverseProxyV2) checkBlkParam(ctx context.Context, blkParam string, l
 		}
 
 	}
-	return pv2.gateway.checkTipSetHeight(head, abi.ChainEpoch(num))
+	return pv2.gateway.checkTipSetHeight(ctx, head, abi.ChainEpoch(num))
 }
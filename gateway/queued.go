@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueuePositionHeader is set on the informational 102 (Processing) response sent for a request
+// queued by the global rate limit for longer than the queue threshold, see WithQueuedResponses. Its
+// value is the request's position in the queue, from 1.
+const QueuePositionHeader = "X-Lotus-Gateway-Queue-Position"
+
+// waitQueued is like WaitN on the global rate limiter, except that a request that has to wait more
+// than the queue threshold is told its position in the queue before it waits, on transports that
+// support it.
+func (gw *Node) waitQueued(ctx context.Context, tokens int) error {
+	now := time.Now()
+	r := gw.rateLimiter.ReserveN(now, tokens)
+	if !r.OK() {
+		return fmt.Errorf("rate: Wait(n=%d) exceeds limiter's burst %d", tokens, gw.rateLimiter.Burst())
+	}
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		r.CancelAt(now)
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", tokens)
+	}
+
+	position := gw.rateLimitQueue.Add(1)
+	defer gw.rateLimitQueue.Add(-1)
+	if delay > gw.queueThreshold {
+		notifyQueued(ctx, position)
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+func notifyQueued(ctx context.Context, position int64) {
+	if w, ok := ctx.Value(queueNotifyKey).(*queueNotifyWriter); ok {
+		w.notifyQueued(position)
+	}
+}
+
+// queueNotifyHandler lets requests queued by the global rate limit send their queue position in an
+// informational response ahead of the final response. Websocket connections are passed through
+// untouched as JSON-RPC over websocket has no informational responses.
+type queueNotifyHandler struct {
+	next http.Handler
+}
+
+func (h queueNotifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	qw := &queueNotifyWriter{ResponseWriter: w}
+	h.next.ServeHTTP(qw, r.WithContext(context.WithValue(r.Context(), queueNotifyKey, qw)))
+}
+
+func (h queueNotifyHandler) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h.next)
+}
+
+// queueNotifyWriter sends informational responses for a request until its final response is
+// started.
+type queueNotifyWriter struct {
+	http.ResponseWriter
+
+	lk          sync.Mutex
+	wroteHeader bool
+}
+
+func (w *queueNotifyWriter) notifyQueued(position int64) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.wroteHeader {
+		return
+	}
+	// headers set for an informational response are also sent with the final response unless
+	// they're removed
+	w.Header().Set(QueuePositionHeader, strconv.FormatInt(position, 10))
+	w.ResponseWriter.WriteHeader(http.StatusProcessing)
+	w.Header().Del(QueuePositionHeader)
+}
+
+func (w *queueNotifyWriter) WriteHeader(statusCode int) {
+	w.lk.Lock()
+	w.wroteHeader = true
+	w.lk.Unlock()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *queueNotifyWriter) Write(b []byte) (int, error) {
+	w.lk.Lock()
+	w.wroteHeader = true
+	w.lk.Unlock()
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// dailyQuotaWindow is the rolling window over which token consumption is counted against a
+	// daily quota.
+	dailyQuotaWindow = 24 * time.Hour
+	// dailyQuotaBucket is the granularity with which token consumption is counted; tokens roll out
+	// of the window a bucket at a time.
+	dailyQuotaBucket = 15 * time.Minute
+)
+
+// ErrQuotaExceeded is returned for requests from a client that has consumed its daily quota of
+// rate limit tokens, see WithDailyTokenQuota.
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// dailyQuota counts the rate limit tokens consumed by each client with a quota over a rolling
+// window, in buckets.
+type dailyQuota struct {
+	quotas map[Identity]int64
+	now    func() time.Time
+
+	lk    sync.Mutex
+	usage map[Identity][]quotaBucket // oldest first
+}
+
+type quotaBucket struct {
+	start  time.Time
+	tokens int64
+}
+
+func newDailyQuota(quotas map[Identity]int64) *dailyQuota {
+	q := &dailyQuota{quotas: make(map[Identity]int64, len(quotas)), now: time.Now, usage: make(map[Identity][]quotaBucket)}
+	for id, quota := range quotas {
+		q.quotas[id] = quota
+	}
+	return q
+}
+
+// take counts tokens against the quota of id, returning a function to give them back if the
+// request they're for isn't served after all, or ErrQuotaExceeded along with the time at which
+// enough tokens will have rolled out of the window for the request to be allowed. Clients without
+// a quota are never rejected.
+func (q *dailyQuota) take(id Identity, tokens int) (func(), error) {
+	quota, ok := q.quotas[id]
+	if !ok {
+		return func() {}, nil
+	}
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	now := q.now()
+	buckets := q.usage[id]
+	for len(buckets) > 0 && !buckets[0].start.Add(dailyQuotaWindow).After(now) {
+		buckets = buckets[1:]
+	}
+	var used int64
+	for _, b := range buckets {
+		used += b.tokens
+	}
+
+	if used+int64(tokens) > quota {
+		q.usage[id] = buckets
+		reset := now.Add(dailyQuotaWindow) // if the request can never be allowed, e.g. for a zero quota
+		for _, b := range buckets {
+			used -= b.tokens
+			if used+int64(tokens) <= quota {
+				reset = b.start.Add(dailyQuotaWindow)
+				break
+			}
+		}
+		return nil, xerrors.Errorf("%w: the quota of %d tokens a day is used up, it resets at %s", ErrQuotaExceeded, quota, reset.UTC().Format(time.RFC3339))
+	}
+
+	start := now.Truncate(dailyQuotaBucket)
+	if n := len(buckets); n == 0 || !buckets[n-1].start.Equal(start) {
+		buckets = append(buckets, quotaBucket{start: start})
+	}
+	buckets[len(buckets)-1].tokens += int64(tokens)
+	q.usage[id] = buckets
+
+	return func() {
+		q.lk.Lock()
+		defer q.lk.Unlock()
+		for i := range q.usage[id] {
+			if b := &q.usage[id][i]; b.start.Equal(start) {
+				b.tokens -= int64(tokens)
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// ErrRequestFailed is returned in place of a target error when error sanitization is enabled. It's
+// accompanied by an error ID with which the full error can be found in the gateway's logs.
+var ErrRequestFailed = errors.New("request failed")
+
+// sanitizeErrorsV1 wraps the v1 target such that its errors are replaced with ErrRequestFailed.
+func sanitizeErrorsV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	translateErrors(server, &out, sanitizeError)
+	return &out
+}
+
+// sanitizeErrorsV2 wraps the v2 target such that its errors are replaced with ErrRequestFailed.
+func sanitizeErrorsV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	translateErrors(server, &out, sanitizeError)
+	return &out
+}
+
+// sanitizeError replaces a target error, which may include internal details such as file paths or
+// peer IDs, with ErrRequestFailed and an error ID, logging the full error under that ID. Errors the
+// gateway itself annotates, execution reverts, whose data belongs to the client's contract call,
+// and filters not being found are returned unchanged.
+func sanitizeError(ctx context.Context, method string, err error) error {
+	var (
+		unsupported *api.ErrMethodNotSupported
+		reverted    *api.ErrExecutionReverted
+	)
+	switch {
+	case errors.As(err, &unsupported), errors.As(err, &reverted),
+		errors.Is(err, ErrBackendUnavailable), errors.Is(err, ErrBackendTimeout), isFilterNotFound(err),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+
+	id := uuid.New().String()
+	log.Warnw("target error", "method", method, "errorId", id, "error", err)
+	return xerrors.Errorf("%w (error id %s)", ErrRequestFailed, id)
+}
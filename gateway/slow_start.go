@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+const (
+	// slowStartInitialFraction is the fraction of the configured rate limit allowed as soon as the
+	// target recovers, from which the limit ramps up linearly over the slow start window.
+	slowStartInitialFraction = 0.1
+	// slowStartSteps is the number of times the rate limit is raised over the slow start window.
+	slowStartSteps = 10
+)
+
+// slowStart watches the results of calls to the target and, when it recovers from an outage, ramps
+// the global rate limit back up to its configured rate over a window, rather than letting the
+// traffic held back during the outage hit the target all at once.
+type slowStart struct {
+	window  time.Duration
+	limiter *rate.Limiter
+	down    atomic.Bool // whether the last call to the target found it unavailable
+
+	lk      sync.Mutex
+	full    rate.Limit // the configured rate limit
+	ramping bool
+	ramp    uint64 // incremented by each ramp, so that a superseded ramp stops
+}
+
+func newSlowStart(window time.Duration, limiter *rate.Limiter) *slowStart {
+	return &slowStart{window: window, limiter: limiter, full: limiter.Limit()}
+}
+
+// observeV1 wraps the v1 target such that the slow start ramp starts once the target recovers.
+func (s *slowStart) observeV1(server v1api.FullNode) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	s.observeCalls(server, &out)
+	return &out
+}
+
+// observeV2 wraps the v2 target such that the slow start ramp starts once the target recovers.
+func (s *slowStart) observeV2(server v2api.FullNode) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	s.observeCalls(server, &out)
+	return &out
+}
+
+func (s *slowStart) observeCalls(in interface{}, outstr interface{}) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			results := fn.Call(args)
+			err, _ := results[errOut].Interface().(error)
+			s.observe(err)
+			return results
+		})
+	})
+}
+
+// observe notes the result of a call to the target. Any result other than the target being
+// unavailable, including an error returned by the target, shows that it's reachable again.
+func (s *slowStart) observe(err error) {
+	switch {
+	case isBackendUnavailable(err):
+		if !s.down.Swap(true) {
+			log.Warnw("backend unavailable, the rate limit will ramp up once it recovers", "window", s.window)
+		}
+	case errors.Is(err, context.Canceled):
+		// says nothing about the target
+	case s.down.Load() && s.down.CompareAndSwap(true, false):
+		s.startRamp()
+	}
+}
+
+func (s *slowStart) startRamp() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.full == rate.Inf {
+		return // there is no rate to ramp up to
+	}
+	log.Infow("backend recovered, ramping up the rate limit", "window", s.window)
+	s.ramp++
+	s.ramping = true
+	s.limiter.SetLimit(rampLimit(s.full, 0, s.window))
+
+	ramp, started := s.ramp, time.Now()
+	go func() {
+		ticker := time.NewTicker(s.window / slowStartSteps)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !s.step(ramp, time.Since(started)) {
+				return
+			}
+		}
+	}()
+}
+
+// step raises the rate limit for the given ramp, returning false once the ramp has finished or
+// been superseded by another.
+func (s *slowStart) step(ramp uint64, elapsed time.Duration) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if ramp != s.ramp {
+		return false
+	}
+	s.limiter.SetLimit(rampLimit(s.full, elapsed, s.window))
+	if elapsed >= s.window {
+		s.ramping = false
+		return false
+	}
+	return true
+}
+
+// setLimit sets the configured rate limit, which takes effect immediately unless the rate limit is
+// ramping up, in which case the ramp continues towards it.
+func (s *slowStart) setLimit(full rate.Limit) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.full = full
+	if !s.ramping {
+		s.limiter.SetLimit(full)
+	}
+}
+
+// rampLimit returns the rate limit elapsed into a slow start ramp of the given window towards the
+// full rate limit.
+func rampLimit(full rate.Limit, elapsed, window time.Duration) rate.Limit {
+	if full == rate.Inf || elapsed >= window {
+		return full
+	}
+	fraction := slowStartInitialFraction + (1-slowStartInitialFraction)*float64(elapsed)/float64(window)
+	return full * rate.Limit(fraction)
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/api/v2api"
+)
+
+// ErrBackendTimeout is returned when a call to the target takes longer than the target call
+// timeout, see WithTargetCallTimeout.
+var ErrBackendTimeout = errors.New("backend timeout")
+
+// targetTimeoutV1 wraps the v1 target such that each call to it is bounded by timeout, except for
+// methods that stream their results over a channel, such as ChainNotify and
+// SubscribeActorEventsRaw, which last for as long as the client keeps them open.
+func targetTimeoutV1(server v1api.FullNode, timeout time.Duration) v1api.FullNode {
+	var out v1api.FullNodeStruct
+	boundTargetCalls(server, &out, timeout)
+	return &out
+}
+
+// targetTimeoutV2 wraps the v2 target such that each call to it is bounded by timeout, except for
+// streaming methods, as for targetTimeoutV1.
+func targetTimeoutV2(server v2api.FullNode, timeout time.Duration) v2api.FullNode {
+	var out v2api.FullNodeStruct
+	boundTargetCalls(server, &out, timeout)
+	return &out
+}
+
+func boundTargetCalls(in interface{}, outstr interface{}, timeout time.Duration) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType || fn.Type().NumIn() == 0 || fn.Type().In(0) != contextType || streams(fn.Type()) {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx := contextArg(args)
+			tctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			args[0] = reflect.ValueOf(tctx)
+			results := fn.Call(args)
+			if err, _ := results[errOut].Interface().(error); err != nil && errors.Is(tctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				log.Debugw("target call timed out", "method", method, "timeout", timeout, "error", err)
+				return errorResults(fn.Type(), xerrors.Errorf("%w: %s took longer than %s", ErrBackendTimeout, method, timeout))
+			}
+			return results
+		})
+	})
+}
+
+// streams returns true if a method of type t returns a channel its results are streamed over.
+func streams(t reflect.Type) bool {
+	for i := 0; i < t.NumOut(); i++ {
+		if t.Out(i).Kind() == reflect.Chan {
+			return true
+		}
+	}
+	return false
+}
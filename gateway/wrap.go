@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// wrapMethods fills the internal structs of outstr with the matching methods of in, as returned by
+// wrap. wrap is given the name of each method and the method itself, and returns the method to use
+// in its place, which must be of the same type.
+func wrapMethods(in interface{}, outstr interface{}, wrap func(method string, fn reflect.Value) reflect.Value) {
+	outs := api.GetInternalStructs(outstr)
+	for _, out := range outs {
+		rint := reflect.ValueOf(out).Elem()
+		ra := reflect.ValueOf(in)
+
+		for f := 0; f < rint.NumField(); f++ {
+			field := rint.Type().Field(f)
+			rint.Field(f).Set(wrap(field.Name, ra.MethodByName(field.Name)))
+		}
+	}
+}
+
+// translateErrors fills the internal structs of outstr with methods that call the matching method
+// of in, passing any error returned through translate.
+func translateErrors(in interface{}, outstr interface{}, translate func(ctx context.Context, method string, err error) error) {
+	wrapMethods(in, outstr, func(method string, fn reflect.Value) reflect.Value {
+		errOut := fn.Type().NumOut() - 1
+		if errOut < 0 || fn.Type().Out(errOut) != errorType {
+			return fn
+		}
+
+		return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) (results []reflect.Value) {
+			results = fn.Call(args)
+			err, _ := results[errOut].Interface().(error)
+			if err == nil {
+				return results
+			}
+			err = translate(contextArg(args), method, err)
+			results[errOut] = reflect.ValueOf(&err).Elem()
+			return results
+		})
+	})
+}
+
+// contextArg returns the context a method was called with, or context.Background() if the method
+// doesn't take a context.
+func contextArg(args []reflect.Value) context.Context {
+	if len(args) > 0 {
+		if ctx, ok := args[0].Interface().(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// errorResults returns the results of a method of type t that failed with err.
+func errorResults(t reflect.Type, err error) []reflect.Value {
+	results := make([]reflect.Value, t.NumOut())
+	for o := range results {
+		results[o] = reflect.Zero(t.Out(o))
+	}
+	results[len(results)-1] = reflect.ValueOf(&err).Elem()
+	return results
+}
@@ -76,6 +76,13 @@ var (
 	// piecereader
 	PRReadType, _ = tag.NewKey("pr_type") // seq / rand
 	PRReadSize, _ = tag.NewKey("pr_size") // small / big
+
+	// gateway
+	CacheName, _      = tag.NewKey("cache")
+	Outcome, _        = tag.NewKey("outcome")         // success / error / rejected
+	ErrorClass, _     = tag.NewKey("error_class")     // rate-limited / lookback-rejected / target-error / other
+	Backend, _        = tag.NewKey("backend")         // fallback-1 / fallback-2 / ...
+	CircuitBreaker, _ = tag.NewKey("circuit_breaker") // reads / writes
 )
 
 // Measures
@@ -199,6 +206,20 @@ var (
 
 	// gateway rate limit
 	RateLimitCount = stats.Int64("ratelimit/limited", "rate limited connections", stats.UnitDimensionless)
+
+	// gateway
+	GatewayEthSubscriptionsDropped = stats.Int64("gateway/eth_subscriptions_dropped", "Number of eth subscriptions dropped because the client could not keep up", stats.UnitDimensionless)
+	GatewayCacheHit                = stats.Int64("gateway/cache_hit", "Number of gateway requests served from cache", stats.UnitDimensionless)
+	GatewayCacheMiss               = stats.Int64("gateway/cache_miss", "Number of gateway requests that missed the cache", stats.UnitDimensionless)
+	GatewayCacheHitRatio           = stats.Float64("gateway/cache_hit_ratio", "Fraction of gateway cache lookups served from cache since the gateway started", stats.UnitDimensionless)
+	GatewayCacheStaleHit           = stats.Int64("gateway/cache_stale_hit", "Number of gateway requests served stale from cache while the backend was unavailable", stats.UnitDimensionless)
+	GatewayDeprecatedMethodCalls   = stats.Int64("gateway/deprecated_method_calls", "Number of calls to deprecated gateway methods", stats.UnitDimensionless)
+	GatewayHeadAge                 = stats.Float64("gateway/head_age", "Time since the timestamp of the backend's head tipset, as last sampled by the gateway", stats.UnitSeconds)
+	GatewayRequestOutcomes         = stats.Int64("gateway/request_outcomes", "Number of gateway requests by outcome, where requests rejected as the client's fault are not counted as errors", stats.UnitDimensionless)
+	GatewayRequestDuration         = stats.Float64("gateway/request_duration_ms", "Duration of gateway requests, including time spent waiting on rate limits", stats.UnitMilliseconds)
+	GatewayRequestErrors           = stats.Int64("gateway/request_errors", "Number of gateway requests that returned an error, by error class", stats.UnitDimensionless)
+	GatewayCircuitBreakerOpen      = stats.Int64("gateway/circuit_breaker_open", "Whether a circuit breaker on calls to the backend is open (1), short-circuiting them, or closed (0)", stats.UnitDimensionless)
+	GatewayFailovers               = stats.Int64("gateway/failovers", "Number of target calls retried on a fallback backend because the previous backend couldn't be reached", stats.UnitDimensionless)
 )
 
 var (
@@ -741,6 +762,73 @@ var (
 		Aggregation: view.Count(),
 		TagKeys:     []tag.Key{Network},
 	}
+	GatewayEthSubscriptionsDroppedView = &view.View{
+		Measure:     GatewayEthSubscriptionsDropped,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network},
+	}
+	GatewayCacheHitView = &view.View{
+		Measure:     GatewayCacheHit,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, CacheName},
+	}
+	GatewayCacheMissView = &view.View{
+		Measure:     GatewayCacheMiss,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, CacheName},
+	}
+	GatewayCacheHitRatioView = &view.View{
+		Measure:     GatewayCacheHitRatio,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Network, CacheName},
+	}
+	GatewayCacheStaleHitView = &view.View{
+		Measure:     GatewayCacheStaleHit,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, CacheName},
+	}
+	GatewayDeprecatedMethodCallsView = &view.View{
+		Measure:     GatewayDeprecatedMethodCalls,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, Endpoint},
+	}
+	GatewayHeadAgeView = &view.View{
+		Measure:     GatewayHeadAge,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Network},
+	}
+	GatewayRequestOutcomesView = &view.View{
+		Measure:     GatewayRequestOutcomes,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, Endpoint, Outcome},
+	}
+	GatewayRequestsView = &view.View{
+		Name:        "gateway/requests",
+		Description: "Number of gateway requests",
+		Measure:     GatewayRequestDuration,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, Endpoint},
+	}
+	GatewayRequestDurationView = &view.View{
+		Measure:     GatewayRequestDuration,
+		Aggregation: defaultMillisecondsDistribution,
+		TagKeys:     []tag.Key{Network, Endpoint},
+	}
+	GatewayRequestErrorsView = &view.View{
+		Measure:     GatewayRequestErrors,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, Endpoint, ErrorClass},
+	}
+	GatewayCircuitBreakerOpenView = &view.View{
+		Measure:     GatewayCircuitBreakerOpen,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Network, CircuitBreaker},
+	}
+	GatewayFailoversView = &view.View{
+		Measure:     GatewayFailovers,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Network, Endpoint, Backend},
+	}
 )
 
 var views = []*view.View{
@@ -873,6 +961,19 @@ var MinerNodeViews = append([]*view.View{
 
 var GatewayNodeViews = append([]*view.View{
 	RateLimitedView,
+	GatewayEthSubscriptionsDroppedView,
+	GatewayCacheHitView,
+	GatewayCacheMissView,
+	GatewayCacheHitRatioView,
+	GatewayCacheStaleHitView,
+	GatewayDeprecatedMethodCallsView,
+	GatewayHeadAgeView,
+	GatewayRequestOutcomesView,
+	GatewayRequestsView,
+	GatewayRequestDurationView,
+	GatewayRequestErrorsView,
+	GatewayFailoversView,
+	GatewayCircuitBreakerOpenView,
 }, ChainNodeViews...)
 
 // SinceInMilliseconds returns the duration of time since the provide time as a float64.